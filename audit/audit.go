@@ -0,0 +1,322 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// SeverityInfo entry is written asynchronously and may be dropped
+	// under overload.
+	SeverityInfo = "info"
+
+	// SeverityHigh entry is written synchronously before the caller
+	// proceeds.
+	SeverityHigh = "high"
+
+	// ActionTokenRead token read by client.
+	ActionTokenRead = "token.read"
+
+	// ActionTokenCreated token created by code exchange.
+	ActionTokenCreated = "token.created"
+
+	// ActionTokenRefreshed token refreshed at provider.
+	ActionTokenRefreshed = "token.refreshed"
+
+	// ActionTokenRefreshRejected provider rejected refresh token.
+	ActionTokenRefreshRejected = "token.refresh_rejected"
+
+	// ActionTokenDead refresh failed too many times in a row.
+	ActionTokenDead = "token.dead"
+
+	// ActionTokenDeleted token removed, revoked at provider if requested.
+	ActionTokenDeleted = "token.deleted"
+
+	// ActionUserForgotten every token of user revoked and deleted.
+	ActionUserForgotten = "user.forgotten"
+
+	// ActionTokenExchanged token exchanged for derived one (RFC 8693).
+	ActionTokenExchanged = "token.exchanged"
+
+	// ActionTokensExported every token exported to transfer archive.
+	ActionTokensExported = "tokens.exported"
+
+	// ActionTokensImported tokens imported from transfer archive.
+	ActionTokensImported = "tokens.imported"
+
+	// ActionAppRejected provider rejected app credentials on refresh.
+	ActionAppRejected = "app.rejected"
+
+	// ActionStateReplayed consumed connect flow state presented again.
+	ActionStateReplayed = "state.replayed"
+
+	// ActionAppCreated app created.
+	ActionAppCreated = "app.created"
+
+	// ActionAppUpdated app replaced.
+	ActionAppUpdated = "app.updated"
+
+	// ActionAppDeleted app removed.
+	ActionAppDeleted = "app.deleted"
+
+	// ActionAppSecretRotated app secret replaced, the old one kept valid
+	// for grace window.
+	ActionAppSecretRotated = "app.secret_rotated"
+
+	// ActionAppSecretRevealed app secrets read in plain.
+	ActionAppSecretRevealed = "app.secret_revealed"
+
+	// ActionAppStatus app status changed.
+	ActionAppStatus = "app.status"
+
+	// ActionAppScopes app scopes replaced.
+	ActionAppScopes = "app.scopes"
+
+	// ActionAppMigrationStarted service moved to new client.
+	ActionAppMigrationStarted = "app.migration_started"
+
+	// ActionAppMigrationFinished old client of service no longer accepted.
+	ActionAppMigrationFinished = "app.migration_finished"
+
+	// ActionExportCreated data export job requested.
+	ActionExportCreated = "export.created"
+
+	// ActionScriptCreated event script attached.
+	ActionScriptCreated = "script.created"
+
+	// ActionScriptDeleted event script removed.
+	ActionScriptDeleted = "script.deleted"
+
+	// ActionPushTargetCreated token push target registered.
+	ActionPushTargetCreated = "push_target.created"
+
+	// ActionPushTargetDeleted token push target removed.
+	ActionPushTargetDeleted = "push_target.deleted"
+
+	// ActionOperationCreated long-running admin operation submitted.
+	ActionOperationCreated = "operation.created"
+
+	// ActionOperationCancelled cancel of admin operation requested.
+	ActionOperationCancelled = "operation.cancelled"
+
+	defaultQueueSize     = 4096
+	defaultBatchSize     = 256
+	defaultFlushInterval = 1
+	flushTimeout         = 10 * time.Second
+)
+
+var (
+	// ErrQueueFull audit queue is full, entry dropped.
+	ErrQueueFull = errors.New("audit queue is full")
+
+	dropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: metrics.Namespace + "_audit_dropped_total",
+			Help: "Total number of audit entries dropped on queue overflow.",
+		},
+	)
+
+	failed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: metrics.Namespace + "_audit_write_failures_total",
+			Help: "Total number of audit entries lost on storage errors.",
+		},
+	)
+
+	depth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: metrics.Namespace + "_audit_queue_depth",
+			Help: "Number of audit entries waiting to be written.",
+		},
+	)
+)
+
+// Config type represents writer configuration. FlushInterval is in seconds.
+type Config struct {
+	QueueSize     int `yaml:"queueSize"`
+	BatchSize     int `yaml:"batchSize"`
+	FlushInterval int `yaml:"flushInterval"`
+}
+
+// Entry type represents single audit record. Fingerprint identifies the
+// secret the action involved, see dblog.Fingerprint.
+type Entry struct {
+	Action      string
+	Severity    string
+	Tenant      string
+	UserID      string
+	Service     string
+	Fingerprint string
+	IP          string
+	CreatedAt   time.Time
+}
+
+// Store is the storage of audit entries.
+type Store interface {
+	Insert(ctx context.Context, entries []Entry) error
+}
+
+// Writer type represents buffered audit writer. Entries are batched and
+// flushed by single background worker, so writes add no storage round trip
+// to the request path. High severity entries bypass the buffer.
+type Writer struct {
+	config Config
+	store  Store
+	queue  chan Entry
+	quit   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func init() {
+	metrics.Registry.MustRegister(dropped, failed, depth)
+}
+
+// NewWriter method creates new writer instance.
+func NewWriter(config Config, store Store) *Writer {
+	setDefault(&config.QueueSize, defaultQueueSize)
+	setDefault(&config.BatchSize, defaultBatchSize)
+	setDefault(&config.FlushInterval, defaultFlushInterval)
+
+	return &Writer{
+		config: config,
+		store:  store,
+		queue:  make(chan Entry, config.QueueSize),
+		quit:   make(chan struct{}),
+	}
+}
+
+// Start method runs flush worker.
+func (w *Writer) Start() {
+	w.wg.Add(1)
+
+	go func() {
+		defer w.wg.Done()
+		w.work()
+	}()
+}
+
+// Stop method stops flush worker. Queued entries are flushed.
+func (w *Writer) Stop() {
+	w.once.Do(func() { close(w.quit) })
+	w.wg.Wait()
+}
+
+// Write method records entry. Info entries are enqueued without blocking
+// and ErrQueueFull is returned when the buffer is saturated. High severity
+// entries are stored before returning.
+func (w *Writer) Write(ctx context.Context, entry Entry) error {
+	if w == nil {
+		return nil
+	}
+
+	if entry.Severity == "" {
+		entry.Severity = SeverityInfo
+	}
+
+	if entry.Tenant == "" {
+		entry.Tenant = helpers.GetTenant(ctx)
+	}
+
+	if entry.IP == "" {
+		entry.IP = helpers.GetClientIP(ctx)
+	}
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	if entry.Severity == SeverityHigh {
+		err := w.store.Insert(ctx, []Entry{entry})
+
+		if err != nil {
+			failed.Inc()
+			log.Println(err)
+		}
+
+		return err
+	}
+
+	select {
+	case w.queue <- entry:
+		depth.Inc()
+		return nil
+	default:
+		dropped.Inc()
+		return ErrQueueFull
+	}
+}
+
+func (w *Writer) work() {
+	ticker := time.NewTicker(
+		time.Duration(w.config.FlushInterval) * time.Second,
+	)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, w.config.BatchSize)
+
+	for {
+		select {
+		case <-w.quit:
+			w.drain(batch)
+			return
+		case entry := <-w.queue:
+			batch = append(batch, entry)
+
+			if len(batch) >= w.config.BatchSize {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+		}
+	}
+}
+
+func (w *Writer) drain(batch []Entry) {
+	for {
+		select {
+		case entry := <-w.queue:
+			batch = append(batch, entry)
+
+			if len(batch) >= w.config.BatchSize {
+				batch = w.flush(batch)
+			}
+		default:
+			w.flush(batch)
+			return
+		}
+	}
+}
+
+func (w *Writer) flush(batch []Entry) []Entry {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	err := w.store.Insert(ctx, batch)
+
+	if err != nil {
+		failed.Add(float64(len(batch)))
+		log.Println(err)
+	}
+
+	depth.Sub(float64(len(batch)))
+
+	return batch[:0]
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}
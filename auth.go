@@ -3,46 +3,200 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/cache"
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/Zetkolink/auth/demo"
+	"github.com/Zetkolink/auth/deprecation"
+	"github.com/Zetkolink/auth/exports"
+	"github.com/Zetkolink/auth/hooks"
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/jwks"
+	"github.com/Zetkolink/auth/keyring"
+	"github.com/Zetkolink/auth/migrations"
+	"github.com/Zetkolink/auth/mirror"
+	"github.com/Zetkolink/auth/models/analytics"
 	"github.com/Zetkolink/auth/models/apps"
+	auditmodel "github.com/Zetkolink/auth/models/audit"
+	"github.com/Zetkolink/auth/models/deadletters"
 	"github.com/Zetkolink/auth/models/exchanges"
+	exportsmodel "github.com/Zetkolink/auth/models/exports"
+	hooksmodel "github.com/Zetkolink/auth/models/hooks"
+	"github.com/Zetkolink/auth/models/identities"
+	opsmodel "github.com/Zetkolink/auth/models/operations"
+	"github.com/Zetkolink/auth/models/providers"
+	pushmodel "github.com/Zetkolink/auth/models/push"
+	"github.com/Zetkolink/auth/models/scripts"
+	"github.com/Zetkolink/auth/models/servicetokens"
+	"github.com/Zetkolink/auth/models/tokenevents"
 	"github.com/Zetkolink/auth/models/tokens"
-	_ "github.com/lib/pq"
+	"github.com/Zetkolink/auth/objectstore"
+	"github.com/Zetkolink/auth/oidc"
+	"github.com/Zetkolink/auth/operations"
+	"github.com/Zetkolink/auth/partners"
+	"github.com/Zetkolink/auth/pii"
+	"github.com/Zetkolink/auth/planguard"
+	"github.com/Zetkolink/auth/proxy"
+	"github.com/Zetkolink/auth/proxyproto"
+	"github.com/Zetkolink/auth/push"
+	"github.com/Zetkolink/auth/reconcile"
+	"github.com/Zetkolink/auth/refresher"
+	"github.com/Zetkolink/auth/retention"
+	"github.com/Zetkolink/auth/schema"
+	"github.com/Zetkolink/auth/scripting"
+	"github.com/Zetkolink/auth/tokenuse"
+	"github.com/Zetkolink/auth/webhooks"
+	"github.com/lib/pq"
+	"golang.org/x/oauth2"
 )
 
+const prewarmTimeout = 30 * time.Second
+
 type auth struct {
 	db         *sql.DB
-	httpServer *http.Server
+	listeners  []*listener
 	models     modelSet
+	cache      cache.Cache
+	pii        *pii.Minimizer
+	webhooks   *webhooks.Dispatcher
+	audit      *audit.Writer
+	tokenUsage *tokenuse.Recorder
+	objects    objectstore.Store
+	exports    *exports.Runner
+	hooks      *hooks.Runner
+	push       *push.Pusher
+	operations *operations.Runner
+	scripts    *scripting.Engine
+	partners   *partners.Gate
+	reconciler *reconcile.Reconciler
+	refresher  *refresher.Refresher
+	retention  *retention.Enforcer
+	mirror     *mirror.Mirror
+	proxy      *proxy.Proxy
+	usage      *deprecation.Tracker
+	demo       *demo.Provider
 	wg         sync.WaitGroup
 }
 
 type modelSet struct {
-	Exchanges *exchanges.Model
-	Apps      *apps.Model
-	Tokens    *tokens.Model
+	Analytics     *analytics.Model
+	Exchanges     *exchanges.Model
+	Identities    *identities.Model
+	Exports       *exportsmodel.Model
+	Operations    *opsmodel.Model
+	Scripts       *scripts.Model
+	Push          *pushmodel.Model
+	Apps          *apps.Model
+	Providers     *providers.Model
+	Tokens        *tokens.Model
+	ServiceTokens *servicetokens.Model
+	Events        *tokenevents.Model
 }
 
 type config struct {
-	Db   dbConfig
-	Http httpConfig
+	Db          dbConfig
+	Http        httpConfig
+	Pii         pii.Config
+	Webhooks    webhooks.Config
+	Audit       audit.Config
+	Encryption  keyring.Config
+	Transfer    keyring.Config
+	Tokens      tokensConfig
+	Apps        appsConfig
+	TokenUsage  tokenuse.Config  `yaml:"tokenUsage"`
+	PlanGuard   planguard.Config `yaml:"planGuard"`
+	Exports     exports.Config
+	ObjectStore objectstore.Config `yaml:"objectStore"`
+	Jwks        jwks.Config
+	Hooks       hooks.Config
+	Push        push.Config
+	Operations  operations.Config
+	Scripting   scripting.Config
+	Partners    partners.Config
+	Cache       cacheConfig
+	Schema      schema.Config
+	ReadOnly    bool `yaml:"readOnly"`
+	Replication reconcile.Config
+	Refresher   refresher.Config
+	Retention   retention.Config
+	Mirror      mirror.Config
+	Proxy       proxy.Config
+	Deprecation deprecation.Config
+	Demo        demo.Config
 }
 
 type dbConfig struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Database string
+	Host       string
+	Port       int
+	User       string
+	Password   string
+	Database   string
+	LogQueries bool `yaml:"logQueries"`
+}
+
+type tokensConfig struct {
+	AccessTokenMode string        `yaml:"accessTokenMode"`
+	FetchProfiles   bool          `yaml:"fetchProfiles"`
+	CacheSize       int           `yaml:"cacheSize"`
+	CacheTTL        time.Duration `yaml:"cacheTTL"`
+	Prewarm         int
+	PrewarmWindow   time.Duration `yaml:"prewarmWindow"`
+
+	StateReplayWindow time.Duration `yaml:"stateReplayWindow"`
+
+	RefreshBreakerThreshold int           `yaml:"refreshBreakerThreshold"`
+	RefreshBreakerCooldown  time.Duration `yaml:"refreshBreakerCooldown"`
+
+	RefreshMaxFailures int           `yaml:"refreshMaxFailures"`
+	RefreshBaseBackoff time.Duration `yaml:"refreshBaseBackoff"`
+	RefreshMaxBackoff  time.Duration `yaml:"refreshMaxBackoff"`
+}
+
+// appsConfig type represents apps configuration. Strategies maps service
+// to selection strategy of its enabled apps: first, round_robin, weighted
+// or tenant. SecretGrace is the time rotated secret stays valid for, in
+// seconds.
+type appsConfig struct {
+	Strategies  map[string]string
+	SecretGrace time.Duration `yaml:"secretGrace"`
+}
+
+type cacheConfig struct {
+	cache.Config `yaml:",inline"`
+	AppTTL       time.Duration `yaml:"appTTL"`
 }
 
 type httpConfig struct {
-	Bind              string
+	// Bind is the single public address used when Listeners is empty.
+	Bind string
+
+	// Listeners are public addresses, Admin addresses serving admin
+	// routes, whose requests have admin role. Without admin listeners
+	// admin routes are served on non-partner public listeners to requests
+	// carrying admin key whose hex sha256 is AdminKeyHash, and refused
+	// with neither configured.
+	Listeners    []listenerConfig
+	Admin        []listenerConfig
+	AdminKeyHash string `yaml:"adminKeyHash"`
+
+	// TrustedProxies are CIDRs of proxies whose X-Forwarded-For and
+	// PROXY protocol headers are believed.
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// BasePath is the path API is served under, "/api" by default.
+	// BaseURL is the external address of service, scheme and host
+	// absolute URLs are built from, e.g. connect callback.
+	BasePath string `yaml:"basePath"`
+	BaseURL  string `yaml:"baseURL"`
+
 	ReadTimeout       time.Duration
 	ReadHeaderTimeout time.Duration
 	WriteTimeout      time.Duration
@@ -50,8 +204,26 @@ type httpConfig struct {
 	MaxHeaderBytes    int
 }
 
+type listenerConfig struct {
+	Bind          string
+	TLS           tlsConfig
+	ProxyProtocol bool `yaml:"proxyProtocol"`
+
+	// Partner listener serves requests with partner API key only, as
+	// its profile allows.
+	Partner bool
+}
+
+type tlsConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// ClientCAFile enables client certificate verification.
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
 func newAuth() (*auth, error) {
-	db, err := sql.Open("postgres", cfg.Db.GetConn())
+	db, err := openDB(cfg.Db)
 
 	if err != nil {
 		return nil, err
@@ -63,22 +235,287 @@ func newAuth() (*auth, error) {
 		return nil, err
 	}
 
+	helpers.SetReadOnly(cfg.ReadOnly)
+
+	if *demoMode {
+		err = checkDisposable(db)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = migrate(db)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = checkSchema(db, cfg.Schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	appCache, err := cache.New(cfg.Cache.Config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	analyticsModel, err := analytics.NewModel(
+		analytics.ModelConfig{
+			Db:    db,
+			Guard: planguard.New(cfg.PlanGuard, db),
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	auditModel, err := auditmodel.NewModel(
+		auditmodel.ModelConfig{Db: db},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	auditWriter := audit.NewWriter(cfg.Audit, auditModel)
+
 	exchangesModel, err := exchanges.NewModel(
-		exchanges.ModelConfig{Db: db},
+		exchanges.ModelConfig{
+			Db:           db,
+			ReplayWindow: cfg.Tokens.StateReplayWindow * time.Second,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	providersModel, err := providers.NewModel(
+		providers.ModelConfig{
+			Db:       db,
+			Cache:    appCache,
+			CacheTTL: cfg.Cache.AppTTL * time.Second,
+		},
 	)
 
+	if err != nil {
+		return nil, err
+	}
+
+	tokenKeyring, err := keyring.New(cfg.Encryption)
+
+	if err != nil {
+		return nil, err
+	}
+
 	appsModel, err := apps.NewModel(
 		apps.ModelConfig{
 			Db:        db,
 			Exchanges: exchangesModel,
+			Analytics: analyticsModel,
+			Providers: providersModel,
+			Discovery: oidc.NewDiscovery(oidc.DiscoveryConfig{
+				Keys: jwks.NewCache(cfg.Jwks, nil),
+			}),
+			Audit:       auditWriter,
+			Keyring:     tokenKeyring,
+			Cache:       appCache,
+			CacheTTL:    cfg.Cache.AppTTL * time.Second,
+			CallbackURL: cfg.Http.callbackURL(cfg.Http.BaseURL),
+			Strategies:  cfg.Apps.Strategies,
+			SecretGrace: cfg.Apps.SecretGrace * time.Second,
 		},
 	)
 
+	if err != nil {
+		return nil, err
+	}
+
+	deadLettersModel, err := deadletters.NewModel(
+		deadletters.ModelConfig{Db: db},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := objectstore.New(cfg.ObjectStore)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var archive webhooks.Archive
+
+	if objects != nil {
+		archive = objectstore.WithPrefix(objects, "webhooks/")
+	}
+
+	dispatcher := webhooks.NewDispatcher(cfg.Webhooks, deadLettersModel,
+		archive)
+
+	transferKeyring, err := keyring.New(cfg.Transfer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	minimizer, err := pii.NewMinimizer(cfg.Pii)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenCache cache.Cache
+
+	if cfg.Tokens.CacheSize > 0 {
+		tokenCache = cache.NewMemory(cfg.Tokens.CacheSize)
+	}
+
+	hooksModel, err := hooksmodel.NewModel(
+		hooksmodel.ModelConfig{Db: db},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hookRunner := hooks.NewRunner(cfg.Hooks, hooksModel)
+
+	pushModel, err := pushmodel.NewModel(
+		pushmodel.ModelConfig{
+			Db:      db,
+			Keyring: tokenKeyring,
+			Audit:   auditWriter,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pusher := push.NewPusher(cfg.Push, pushModel)
+
+	scriptsModel, err := scripts.NewModel(
+		scripts.ModelConfig{
+			Db:    db,
+			Audit: auditWriter,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scriptEngine := scripting.NewEngine(cfg.Scripting, scriptsModel)
+
+	identitiesModel, err := identities.NewModel(
+		identities.ModelConfig{Db: db},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	eventsModel, err := tokenevents.NewModel(
+		tokenevents.ModelConfig{Db: db},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	usageRecorder := tokenuse.NewRecorder(cfg.TokenUsage, db)
+
 	tokensModel, err := tokens.NewModel(
 		tokens.ModelConfig{
-			Db:        db,
-			Exchanges: exchangesModel,
-			Apps:      appsModel,
+			Db:         db,
+			Exchanges:  exchangesModel,
+			Apps:       appsModel,
+			Analytics:  analyticsModel,
+			Identities: identitiesModel,
+			Webhooks:   dispatcher,
+			Hooks:      hookRunner,
+			Push:       pusher,
+			Scripts:    scriptEngine,
+			Audit:      auditWriter,
+			Keyring:    tokenKeyring,
+			Transfer:   transferKeyring,
+			Usage:      usageRecorder,
+			Events:     eventsModel,
+			Pii:        minimizer,
+			Region:     cfg.Replication.Region,
+
+			AccessTokenMode: cfg.Tokens.AccessTokenMode,
+			FetchProfiles:   cfg.Tokens.FetchProfiles,
+			Cache:           tokenCache,
+			CacheTTL:        cfg.Tokens.CacheTTL * time.Second,
+			Prewarm:         cfg.Tokens.Prewarm,
+			PrewarmWindow:   cfg.Tokens.PrewarmWindow * time.Second,
+
+			BreakerThreshold: cfg.Tokens.RefreshBreakerThreshold,
+			BreakerCooldown:  cfg.Tokens.RefreshBreakerCooldown * time.Second,
+
+			MaxRefreshFailures: cfg.Tokens.RefreshMaxFailures,
+			BaseBackoff:        cfg.Tokens.RefreshBaseBackoff * time.Second,
+			MaxBackoff:         cfg.Tokens.RefreshMaxBackoff * time.Second,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	loadToken := func(ctx context.Context, userID string, service string, account string) (*oauth2.Token, error) {
+		token, err := tokensModel.Get(ctx, userID, service, account)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return token.Token, nil
+	}
+
+	hookRunner.SetLoader(
+		func(ctx context.Context, userID string, service string) (*oauth2.Token, error) {
+			return loadToken(ctx, userID, service, "")
+		},
+	)
+	pusher.SetLoader(loadToken)
+	scriptEngine.SetMetadataWriter(tokensModel.SetMetadata)
+
+	serviceTokensModel, err := servicetokens.NewModel(
+		servicetokens.ModelConfig{
+			Db:      db,
+			Apps:    appsModel,
+			Keyring: tokenKeyring,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exportsModel, err := exportsmodel.NewModel(
+		exportsmodel.ModelConfig{
+			Db:    db,
+			Audit: auditWriter,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	operationsModel, err := opsmodel.NewModel(
+		opsmodel.ModelConfig{
+			Db:    db,
+			Audit: auditWriter,
 		},
 	)
 
@@ -86,15 +523,98 @@ func newAuth() (*auth, error) {
 		return nil, err
 	}
 
+	runner := operations.NewRunner(cfg.Operations, operationsModel)
+	runner.Register(tokens.OperationRewrap, tokensModel.Rewrap)
+	runner.Register(apps.OperationRewrap, appsModel.Rewrap)
+
 	a := auth{
-		db: db,
+		db:         db,
+		cache:      appCache,
+		pii:        minimizer,
+		webhooks:   dispatcher,
+		audit:      auditWriter,
+		tokenUsage: usageRecorder,
+		objects:    objects,
+		hooks:      hookRunner,
+		push:       pusher,
+		operations: runner,
+		scripts:    scriptEngine,
 		models: modelSet{
-			Exchanges: exchangesModel,
-			Apps:      appsModel,
-			Tokens:    tokensModel,
+			Analytics:     analyticsModel,
+			Exchanges:     exchangesModel,
+			Identities:    identitiesModel,
+			Exports:       exportsModel,
+			Operations:    operationsModel,
+			Scripts:       scriptsModel,
+			Push:          pushModel,
+			Apps:          appsModel,
+			Providers:     providersModel,
+			Tokens:        tokensModel,
+			ServiceTokens: serviceTokensModel,
+			Events:        eventsModel,
 		},
 	}
 
+	a.partners, err = partners.New(cfg.Partners)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if objects != nil {
+		a.exports = exports.NewRunner(cfg.Exports, exportsModel, objects)
+	}
+
+	if *demoMode {
+		a.demo, err = startDemo(cfg.Demo, cfg.Http, providersModel, appsModel)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Replication.PeerDsn != "" {
+		a.reconciler, err = reconcile.NewReconciler(cfg.Replication, db)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Refresher.Enabled {
+		a.refresher = refresher.NewRefresher(cfg.Refresher, tokensModel)
+	}
+
+	if cfg.Retention.Enabled {
+		a.retention, err = retention.NewEnforcer(cfg.Retention, tokensModel)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.usage, err = deprecation.New(cfg.Deprecation)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Proxy.Enabled {
+		a.proxy, err = proxy.New(cfg.Proxy, tokensModel)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Mirror.URL != "" {
+		a.mirror, err = mirror.New(cfg.Mirror)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = a.setupHTTPServer(cfg.Http)
 
 	if err != nil {
@@ -105,33 +625,250 @@ func newAuth() (*auth, error) {
 }
 
 func (s *auth) Run() error {
-	s.runHTTPServer()
+	s.prewarm()
+	s.webhooks.Start()
+	s.audit.Start()
+	s.tokenUsage.Start()
+	s.hooks.Start()
+	s.push.Start()
+	s.operations.Start()
 
-	return nil
+	if s.exports != nil {
+		s.exports.Start()
+	}
+
+	if s.reconciler != nil {
+		s.reconciler.Start()
+	}
+
+	if s.refresher != nil {
+		s.refresher.Start()
+	}
+
+	if s.retention != nil {
+		s.retention.Start()
+	}
+
+	if s.mirror != nil {
+		s.mirror.Start()
+	}
+
+	return s.runHTTPServer()
 }
 
-func (s *auth) runHTTPServer() {
-	s.wg.Add(1)
+// prewarm loads tokens likely read first after start into token cache.
+func (s *auth) prewarm() {
+	ctx, cancel := context.WithTimeout(context.Background(), prewarmTimeout)
+	defer cancel()
 
-	go func() {
-		defer s.wg.Done()
+	n, err := s.models.Tokens.Prewarm(ctx)
 
-		err := s.httpServer.ListenAndServe()
+	if err != nil {
+		log.Println("token cache prewarm: " + err.Error())
+	}
+
+	if n > 0 {
+		log.Printf("token cache prewarmed with %d tokens", n)
+	}
+}
 
-		if err != http.ErrServerClosed {
-			s.Stop()
+// runHTTPServer method binds every listener before serving on any, so
+// unavailable address fails start.
+func (s *auth) runHTTPServer() error {
+	bound := make([]net.Listener, 0, len(s.listeners))
+
+	for _, l := range s.listeners {
+		ln, err := net.Listen(l.network, l.server.Addr)
+
+		if err != nil {
+			for _, ln := range bound {
+				_ = ln.Close()
+			}
+
+			return err
 		}
-	}()
+
+		if l.proxies != nil {
+			ln = proxyproto.NewListener(ln, l.proxies)
+		}
+
+		bound = append(bound, ln)
+	}
+
+	for i, l := range s.listeners {
+		s.wg.Add(1)
+
+		go func(l *listener, ln net.Listener) {
+			defer s.wg.Done()
+
+			var err error
+
+			if l.server.TLSConfig != nil {
+				err = l.server.ServeTLS(ln, "", "")
+			} else {
+				err = l.server.Serve(ln)
+			}
+
+			if err != http.ErrServerClosed {
+				log.Println(err)
+				s.Stop()
+			}
+		}(l, bound[i])
+	}
+
+	return nil
 }
 
 func (s *auth) Stop() {
-	err := s.httpServer.Shutdown(context.Background())
+	for _, l := range s.listeners {
+		err := l.server.Shutdown(context.Background())
 
-	if err != nil {
-		log.Println(err)
+		if err != nil {
+			log.Println(err)
+		}
 	}
 
 	s.wg.Wait()
+	s.webhooks.Stop()
+	s.audit.Stop()
+	s.tokenUsage.Stop()
+	s.hooks.Stop()
+	s.push.Stop()
+	s.operations.Stop()
+	s.scripts.Stop()
+
+	if s.exports != nil {
+		s.exports.Stop()
+	}
+
+	if s.reconciler != nil {
+		s.reconciler.Stop()
+	}
+
+	if s.refresher != nil {
+		s.refresher.Stop()
+	}
+
+	if s.retention != nil {
+		s.retention.Stop()
+	}
+
+	if s.mirror != nil {
+		s.mirror.Stop()
+	}
+}
+
+// checkDisposable function refuses database holding apps or tokens of
+// services other than demo one, so demo mode never migrates real database
+// nor seeds demo credentials into it.
+func checkDisposable(db *sql.DB) error {
+	var foreign bool
+
+	err := db.QueryRow(`SELECT to_regclass('auth.tokens') IS NOT NULL
+								AND (EXISTS (SELECT 1 FROM auth.apps WHERE service <> $1)
+									OR EXISTS (SELECT 1 FROM auth.tokens WHERE service <> $1))`,
+		demo.Service,
+	).Scan(&foreign)
+
+	if err != nil {
+		return err
+	}
+
+	if foreign {
+		return errors.New("demo mode requires empty database, " +
+			"configured one holds data of other services")
+	}
+
+	return nil
+}
+
+// migrate function applies pending migrations, so demo mode runs on
+// empty database.
+func migrate(db *sql.DB) error {
+	applied, err := migrations.Apply(context.Background(), db)
+
+	for _, m := range applied {
+		log.Printf("applied %s", m.Name)
+	}
+
+	return err
+}
+
+// startDemo function registers mock provider, reached at base URL or
+// at address the service binds.
+func startDemo(config demo.Config, httpConf httpConfig, registry *providers.Model, appsModel *apps.Model) (*demo.Provider, error) {
+	if config.BaseURL == "" {
+		config.BaseURL = httpConf.BaseURL
+	}
+
+	bind := httpConf.Bind
+
+	if bind == "" && len(httpConf.Listeners) > 0 {
+		bind = httpConf.Listeners[0].Bind
+	}
+
+	if config.BaseURL == "" {
+		_, port, err := net.SplitHostPort(bind)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.BaseURL = "http://localhost:" + port
+	}
+
+	provider := demo.NewProvider(config)
+	err := provider.Register(context.Background(), registry, appsModel,
+		httpConf.callbackURL(config.BaseURL))
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("demo mode: service %q connects through %s", demo.Service,
+		provider.URL("authorize"))
+
+	return provider, nil
+}
+
+func checkSchema(db *sql.DB, config schema.Config) error {
+	report, err := schema.Check(context.Background(), db)
+
+	if err != nil {
+		return err
+	}
+
+	if !report.Drifted() {
+		return nil
+	}
+
+	switch config.OnDrift {
+	case schema.OnDriftWarn:
+		log.Println("schema drift detected: " + report.String())
+		return nil
+	case schema.OnDriftReadOnly:
+		log.Println("schema drift detected, read-only mode: " +
+			report.String())
+		helpers.SetReadOnly(true)
+		return nil
+	}
+
+	return errors.New("schema drift detected: " + report.String())
+}
+
+// openDB opens database, wrapped with redacting query log when enabled.
+func openDB(config dbConfig) (*sql.DB, error) {
+	if !config.LogQueries {
+		return sql.Open("postgres", config.GetConn())
+	}
+
+	connector, err := pq.NewConnector(config.GetConn())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(dblog.NewConnector(connector)), nil
 }
 
 func (d *dbConfig) GetConn() string {
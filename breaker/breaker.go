@@ -0,0 +1,69 @@
+// Package breaker provides circuit breaker guarding calls to a single
+// remote destination. After threshold consecutive failures the breaker
+// opens for cooldown, then lets a single probe through; its outcome
+// closes the breaker or opens it again.
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker type represents circuit breaker of a single destination.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// New method creates new breaker instance.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow method reports whether call may be attempted now and, if not,
+// when the destination should be tried again.
+func (b *Breaker) Allow(now time.Time) (bool, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true, time.Time{}
+	}
+
+	if now.Before(b.openUntil) || b.probing {
+		return false, b.openUntil
+	}
+
+	b.probing = true
+
+	return true, time.Time{}
+}
+
+// Success method records successful call.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure method records failed call.
+func (b *Breaker) Failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.failures >= b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
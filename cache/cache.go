@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// DriverMemory in-process LRU cache.
+	DriverMemory = "memory"
+
+	// DriverRedis Redis backed cache.
+	DriverRedis = "redis"
+)
+
+var (
+	// ErrMiss key not found in cache.
+	ErrMiss = errors.New("cache miss")
+
+	// ErrDriver unknown cache driver.
+	ErrDriver = errors.New("unknown cache driver")
+)
+
+// Cache is the key/value cache shared by subsystems.
+type Cache interface {
+	// Get returns value stored under key or ErrMiss.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value under key. Zero ttl means no expiration.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// SetNX stores value only if key is absent and reports whether it did.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+
+	// Incr increments counter under key, setting ttl when it is created.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+
+	// Delete removes key.
+	Delete(ctx context.Context, key string) error
+}
+
+// Config type represents cache configuration.
+type Config struct {
+	Driver string
+	Size   int
+	Redis  RedisConfig
+}
+
+// New method creates cache selected by config driver.
+func New(config Config) (Cache, error) {
+	switch config.Driver {
+	case "", DriverMemory:
+		return NewMemory(config.Size), nil
+	case DriverRedis:
+		return NewRedis(config.Redis)
+	default:
+		return nil, ErrDriver
+	}
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultSize = 10000
+
+// Memory type represents in-process LRU cache.
+type Memory struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	lru   *list.List
+}
+
+type item struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory method creates new in-process cache holding up to size keys.
+func NewMemory(size int) *Memory {
+	if size <= 0 {
+		size = defaultSize
+	}
+
+	return &Memory{
+		size:  size,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// Get method returns value stored under key.
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it := m.lookup(key)
+
+	if it == nil {
+		return nil, ErrMiss
+	}
+
+	return it.value, nil
+}
+
+// Set method stores value under key.
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.store(key, value, ttl)
+
+	return nil
+}
+
+// SetNX method stores value only if key is absent.
+func (m *Memory) SetNX(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lookup(key) != nil {
+		return false, nil
+	}
+
+	m.store(key, value, ttl)
+
+	return true, nil
+}
+
+// Incr method increments counter under key.
+func (m *Memory) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it := m.lookup(key)
+
+	if it == nil {
+		m.store(key, []byte("1"), ttl)
+		return 1, nil
+	}
+
+	n, err := strconv.ParseInt(string(it.value), 10, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n++
+	it.value = []byte(strconv.FormatInt(n, 10))
+
+	return n, nil
+}
+
+// Delete method removes key.
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+
+	return nil
+}
+
+func (m *Memory) lookup(key string) *item {
+	el, ok := m.items[key]
+
+	if !ok {
+		return nil
+	}
+
+	it := el.Value.(*item)
+
+	if !it.expires.IsZero() && time.Now().After(it.expires) {
+		m.remove(el)
+		return nil
+	}
+
+	m.lru.MoveToFront(el)
+
+	return it
+}
+
+func (m *Memory) store(key string, value []byte, ttl time.Duration) {
+	it := &item{key: key, value: value}
+
+	if ttl > 0 {
+		it.expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := m.items[key]; ok {
+		el.Value = it
+		m.lru.MoveToFront(el)
+		return
+	}
+
+	m.items[key] = m.lru.PushFront(it)
+
+	for m.lru.Len() > m.size {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *Memory) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.items, el.Value.(*item).key)
+}
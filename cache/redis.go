@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig type represents Redis connection configuration.
+type RedisConfig struct {
+	Addr     string
+	Password string
+	Db       int
+	Prefix   string
+}
+
+// Redis type represents Redis backed cache.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis method creates new Redis cache instance.
+func NewRedis(config RedisConfig) (*Redis, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Addr,
+		Password: config.Password,
+		DB:       config.Db,
+	})
+
+	err := client.Ping(context.Background()).Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redis{client: client, prefix: config.Prefix}, nil
+}
+
+// Get method returns value stored under key.
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+
+	return value, err
+}
+
+// Set method stores value under key.
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+// SetNX method stores value only if key is absent.
+func (r *Redis) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, r.prefix+key, value, ttl).Result()
+}
+
+// Incr method increments counter under key.
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := r.client.Incr(ctx, r.prefix+key).Result()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n == 1 && ttl > 0 {
+		err = r.client.Expire(ctx, r.prefix+key, ttl).Err()
+	}
+
+	return n, err
+}
+
+// Delete method removes key.
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
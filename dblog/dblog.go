@@ -0,0 +1,323 @@
+// Package dblog provides query logging driver wrapper. Arguments wrapped
+// with Secret are classified as sensitive and logged as fingerprints only,
+// so access tokens, refresh tokens and client secrets never reach the log.
+package dblog
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	fingerprintPrefix = "sha256:"
+	fingerprintLength = 8
+)
+
+// Sensitive type represents query argument never logged in clear.
+type Sensitive struct {
+	value interface{}
+}
+
+// Connector type represents logging driver connector.
+type Connector struct {
+	connector driver.Connector
+}
+
+type conn struct {
+	driver.Conn
+}
+
+type stmt struct {
+	driver.Stmt
+	query string
+}
+
+// Secret method classifies query argument as sensitive.
+func Secret(v interface{}) Sensitive {
+	return Sensitive{value: v}
+}
+
+// Value method returns underlying argument value.
+func (s Sensitive) Value() (driver.Value, error) {
+	if valuer, ok := s.value.(driver.Valuer); ok {
+		return valuer.Value()
+	}
+
+	return driver.DefaultParameterConverter.ConvertValue(s.value)
+}
+
+// String method returns argument fingerprint.
+func (s Sensitive) String() string {
+	if b, ok := s.value.([]byte); ok {
+		return Fingerprint(string(b))
+	}
+
+	return Fingerprint(fmt.Sprint(s.value))
+}
+
+// Fingerprint method returns hash prefix identifying value.
+func Fingerprint(v string) string {
+	if v == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(v))
+
+	return fingerprintPrefix + hex.EncodeToString(sum[:])[:fingerprintLength]
+}
+
+// NewConnector method wraps connector with query logging.
+func NewConnector(connector driver.Connector) *Connector {
+	return &Connector{connector: connector}
+}
+
+// Connect method returns logging connection.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.connector.Connect(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: cn}, nil
+}
+
+// Driver method returns underlying driver.
+func (c *Connector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(Sensitive); ok {
+		return nil
+	}
+
+	return checkNamedValue(c.Conn, nv)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	values, err := reveal(c.Conn, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := execer.ExecContext(ctx, query, values)
+	logQuery(query, args, start, err)
+
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	values, err := reveal(c.Conn, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := queryer.QueryContext(ctx, query, values)
+	logQuery(query, args, start, err)
+
+	return rows, err
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var st driver.Stmt
+	var err error
+
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		st, err = preparer.PrepareContext(ctx, query)
+	} else {
+		st, err = c.Conn.Prepare(query)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &stmt{Stmt: st, query: query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if _, ok := nv.Value.(Sensitive); ok {
+		return nil
+	}
+
+	return checkNamedValue(s.Stmt, nv)
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	values, err := reveal(s.Stmt, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var res driver.Result
+
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = execer.ExecContext(ctx, values)
+	} else {
+		res, err = s.Stmt.Exec(plain(values))
+	}
+
+	logQuery(s.query, args, start, err)
+
+	return res, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	values, err := reveal(s.Stmt, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rows driver.Rows
+
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, values)
+	} else {
+		rows, err = s.Stmt.Query(plain(values))
+	}
+
+	logQuery(s.query, args, start, err)
+
+	return rows, err
+}
+
+// checkNamedValue applies checker of underlying driver, if any.
+func checkNamedValue(v interface{}, nv *driver.NamedValue) error {
+	if checker, ok := v.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+// reveal replaces sensitive arguments with their values for the driver.
+func reveal(checker interface{}, args []driver.NamedValue) ([]driver.NamedValue, error) {
+	values := make([]driver.NamedValue, len(args))
+
+	for i, arg := range args {
+		values[i] = arg
+
+		secret, ok := arg.Value.(Sensitive)
+
+		if !ok {
+			continue
+		}
+
+		values[i].Value = secret.value
+		err := checkNamedValue(checker, &values[i])
+
+		if err == driver.ErrSkip {
+			values[i].Value, err = secret.Value()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func plain(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	return values
+}
+
+func logQuery(query string, args []driver.NamedValue, start time.Time, err error) {
+	parts := make([]string, 0, len(args))
+
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprintf("$%d=%s", arg.Ordinal,
+			formatArg(arg.Value)))
+	}
+
+	line := fmt.Sprintf("sql %s [%s] %s", strings.Join(strings.Fields(query), " "),
+		strings.Join(parts, " "), time.Since(start))
+
+	if err != nil {
+		line += " error: " + err.Error()
+	}
+
+	log.Println(line)
+}
+
+func formatArg(v interface{}) string {
+	switch v := v.(type) {
+	case Sensitive:
+		return v.String()
+	case []byte:
+		return fmt.Sprintf("<%d bytes>", len(v))
+	case string:
+		return fmt.Sprintf("%q", v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case nil:
+		return "NULL"
+	}
+
+	return fmt.Sprintf("%v", v)
+}
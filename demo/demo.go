@@ -0,0 +1,369 @@
+// Package demo runs the service for local exploration with a mock OAuth2
+// provider, so integrators can try the API and the connect flow without
+// real provider credentials.
+//
+// Started with -demo on a database holding no data of other services, the
+// service applies migrations, serves the mock provider under /demo/provider and registers it as service "demo" with
+// an enabled app. The provider approves every authorization request at
+// once, for user named by login_hint or "demo-user", and accepts the
+// demo client only.
+package demo
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/providers"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const (
+	// Service is the service name mock provider is registered under.
+	Service = "demo"
+
+	// ClientID is the client id of demo app.
+	ClientID = "demo-client"
+
+	// ClientSecret is the client secret of demo app.
+	ClientSecret = "demo-secret"
+
+	// Path is the path mock provider is served under.
+	Path = "/demo/provider"
+
+	defaultSubject  = "demo-user"
+	defaultLifetime = 3600
+	codeTTL         = 10 * time.Minute
+)
+
+// Config type represents demo mode configuration. BaseURL is the address
+// the service is reached at from browser; Lifetime is access token
+// lifetime in seconds.
+type Config struct {
+	BaseURL  string `yaml:"baseURL"`
+	Lifetime int
+}
+
+// Provider type represents mock OAuth2 provider keeping its grants in
+// memory.
+type Provider struct {
+	config Config
+	mu     sync.Mutex
+	codes  map[string]*grant
+	access map[string]*grant
+	renew  map[string]*grant
+}
+
+type grant struct {
+	subject     string
+	scope       string
+	redirectURI string
+	challenge   string
+	expiry      time.Time
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type userinfoResponse struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+}
+
+// NewProvider method creates new mock provider instance.
+func NewProvider(config Config) *Provider {
+	if config.Lifetime <= 0 {
+		config.Lifetime = defaultLifetime
+	}
+
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return &Provider{
+		config: config,
+		codes:  make(map[string]*grant),
+		access: make(map[string]*grant),
+		renew:  make(map[string]*grant),
+	}
+}
+
+// URL method returns absolute URL of provider endpoint.
+func (p *Provider) URL(endpoint string) string {
+	return p.config.BaseURL + Path + "/" + endpoint
+}
+
+// NewRouter method returns HTTP-router of provider endpoints.
+func (p *Provider) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/authorize", p.Authorize)
+	r.Post("/token", p.Token)
+	r.Get("/userinfo", p.Userinfo)
+	r.Post("/revoke", p.Revoke)
+
+	return r
+}
+
+// Authorize handler approves authorization request and redirects back
+// with code.
+func (p *Provider) Authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("client_id") != ClientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	subject := q.Get("login_hint")
+
+	if subject == "" {
+		subject = defaultSubject
+	}
+
+	code, err := p.issue(p.codes, &grant{
+		subject:     subject,
+		scope:       q.Get("scope"),
+		redirectURI: redirect.String(),
+		challenge:   q.Get("code_challenge"),
+		expiry:      time.Now().Add(codeTTL),
+	})
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// Token handler serves authorization_code and refresh_token grants.
+func (p *Provider) Token(w http.ResponseWriter, r *http.Request) {
+	if !authenticated(r) {
+		p.fail(w, r, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	var g *grant
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		g = p.take(p.codes, r.PostFormValue("code"))
+
+		if g == nil || g.redirectURI != r.PostFormValue("redirect_uri") ||
+			!verified(g.challenge, r.PostFormValue("code_verifier")) {
+
+			p.fail(w, r, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	case "refresh_token":
+		g = p.take(p.renew, r.PostFormValue("refresh_token"))
+
+		if g == nil {
+			p.fail(w, r, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	default:
+		p.fail(w, r, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	lifetime := time.Duration(p.config.Lifetime) * time.Second
+
+	accessToken, err := p.issue(p.access, &grant{
+		subject: g.subject,
+		scope:   g.scope,
+		expiry:  time.Now().Add(lifetime),
+	})
+
+	if err != nil {
+		p.fail(w, r, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	refreshToken, err := p.issue(p.renew, &grant{
+		subject: g.subject,
+		scope:   g.scope,
+	})
+
+	if err != nil {
+		p.fail(w, r, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	render.JSON(w, r, &tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		ExpiresIn:    p.config.Lifetime,
+		Scope:        g.scope,
+	})
+}
+
+// Userinfo handler renders user access token was issued for.
+func (p *Provider) Userinfo(w http.ResponseWriter, r *http.Request) {
+	raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	p.mu.Lock()
+	g, ok := p.access[raw]
+	p.mu.Unlock()
+
+	if !ok || time.Now().After(g.expiry) {
+		p.fail(w, r, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+
+	render.JSON(w, r, &userinfoResponse{
+		Subject: g.subject,
+		Name:    g.subject,
+		Email:   g.subject + "@demo.invalid",
+	})
+}
+
+// Revoke handler revokes access or refresh token (RFC 7009).
+func (p *Provider) Revoke(w http.ResponseWriter, r *http.Request) {
+	if !authenticated(r) {
+		p.fail(w, r, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	token := r.PostFormValue("token")
+
+	p.mu.Lock()
+	delete(p.access, token)
+	delete(p.renew, token)
+	p.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Register method stores mock provider in provider registry and its
+// enabled app redirecting to callbackURL, replacing earlier ones.
+func (p *Provider) Register(ctx context.Context, registry *providers.Model, appsModel *apps.Model, callbackURL string) error {
+	err := registry.Save(ctx, &providers.Provider{
+		Service:  Service,
+		AuthURL:  p.URL("authorize"),
+		TokenURL: p.URL("token"),
+		Scopes:   []string{"profile"},
+		Capabilities: providers.Capabilities{
+			SupportsRefresh: true,
+			SupportsRevoke:  true,
+			RevocationURL:   p.URL("revoke"),
+			ValidationURL:   p.URL("userinfo"),
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = appsModel.Put(ctx, &apps.App{
+		ID:          ClientID,
+		Service:     Service,
+		Password:    ClientSecret,
+		CallbackURL: callbackURL,
+		AuthParams:  map[string]string{},
+		Status:      apps.StatusEnable,
+	}, func(_ *apps.App) error { return nil })
+
+	return err
+}
+
+// issue method stores grant under new random token and returns token.
+func (p *Provider) issue(store map[string]*grant, g *grant) (string, error) {
+	token, err := helpers.RandomStr(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	store[token] = g
+	p.mu.Unlock()
+
+	return token, nil
+}
+
+// take method removes grant of single use token and returns it, nil when
+// token is unknown or expired.
+func (p *Provider) take(store map[string]*grant, token string) *grant {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	g, ok := store[token]
+
+	if !ok {
+		return nil
+	}
+
+	delete(store, token)
+
+	if !g.expiry.IsZero() && time.Now().After(g.expiry) {
+		return nil
+	}
+
+	return g
+}
+
+func (p *Provider) fail(w http.ResponseWriter, r *http.Request, status int, code string) {
+	render.Status(r, status)
+	render.JSON(w, r, &errorResponse{Error: code})
+}
+
+// authenticated function reports whether request carries demo client
+// credentials, in basic auth or in params.
+func authenticated(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+
+	return id == ClientID &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(ClientSecret)) == 1
+}
+
+// verified function checks PKCE verifier against S256 challenge. Grants
+// without challenge need no verifier.
+func verified(challenge string, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
@@ -0,0 +1,336 @@
+// Package deprecation counts route invocations by API version and client,
+// so routes slated for removal are removed only once nobody calls them.
+//
+// Every routed request is counted by route pattern, method, API version
+// and client, the partner name or tenant of request. Counts are kept per
+// instance and exported as auth_route_invocations_total. Routes listed in
+// config are deprecated:
+//
+//	deprecation:
+//	  warnings: true
+//	  routes:
+//	    - method: "GET"
+//	      pattern: "/api/v1/tokens/{userID}"
+//	      sunset: "2027-01-01"
+//	      replacement: "/api/v1/users/{userID}/tokens"
+//
+// With warnings on, responses of deprecated routes carry Deprecation,
+// Sunset (RFC 8594), Link to replacement and Warning headers.
+package deprecation
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/Zetkolink/auth/partners"
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ErrSunset sunset date of deprecated route is malformed.
+	ErrSunset = errors.New("deprecated route sunset must be a date")
+
+	invocations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metrics.Namespace + "_route_invocations_total",
+			Help: "Total number of routed requests, by API version and client.",
+		},
+		[]string{metrics.LabelRoute, metrics.LabelMethod, "version", "client"},
+	)
+)
+
+// Config type represents deprecation configuration.
+type Config struct {
+	Warnings bool
+	Routes   []Route
+}
+
+// Route type represents deprecated route. Pattern is the full route
+// pattern, Sunset the date route is removed.
+type Route struct {
+	Method      string
+	Pattern     string
+	Sunset      string
+	Replacement string
+}
+
+// Usage type represents invocations of route by client.
+type Usage struct {
+	Method   string    `json:"method"`
+	Route    string    `json:"route"`
+	Version  string    `json:"version"`
+	Client   string    `json:"client"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Deprecation type represents deprecated route and its remaining callers.
+type Deprecation struct {
+	Method      string     `json:"method"`
+	Route       string     `json:"route"`
+	Sunset      *time.Time `json:"sunset,omitempty"`
+	Replacement string     `json:"replacement,omitempty"`
+	Count       int64      `json:"count"`
+	Callers     []*Usage   `json:"callers"`
+}
+
+// Tracker type represents route usage tracking middleware.
+type Tracker struct {
+	warnings   bool
+	deprecated map[key]*deprecated
+	mu         sync.Mutex
+	usage      map[usageKey]*Usage
+}
+
+type key struct {
+	method  string
+	pattern string
+}
+
+type usageKey struct {
+	key
+	version string
+	client  string
+}
+
+type deprecated struct {
+	route  Route
+	sunset *time.Time
+}
+
+// warnWriter type represents response writer adding deprecation headers
+// once routing has resolved route pattern.
+type warnWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	t       *Tracker
+	written bool
+}
+
+func init() {
+	metrics.Registry.MustRegister(invocations)
+}
+
+// New method creates new tracker instance.
+func New(config Config) (*Tracker, error) {
+	t := &Tracker{
+		warnings:   config.Warnings,
+		deprecated: make(map[key]*deprecated, len(config.Routes)),
+		usage:      make(map[usageKey]*Usage),
+	}
+
+	for _, route := range config.Routes {
+		d := &deprecated{route: route}
+
+		if route.Sunset != "" {
+			sunset, err := helpers.ParseDate(route.Sunset)
+
+			if err != nil {
+				return nil, ErrSunset
+			}
+
+			d.sunset = &sunset
+		}
+
+		t.deprecated[key{strings.ToUpper(route.Method), route.Pattern}] = d
+	}
+
+	return t, nil
+}
+
+// Middleware method counts request by route it was routed to.
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if t.warnings && len(t.deprecated) > 0 {
+				w = &warnWriter{ResponseWriter: w, r: r, t: t}
+			}
+
+			next.ServeHTTP(w, r)
+
+			pattern := routePattern(r)
+
+			if pattern == "" {
+				return
+			}
+
+			version, _ := r.Context().Value(helpers.APIVersionContextKey).(string)
+			client := partners.GetPartner(r.Context())
+
+			if client == "" {
+				client = helpers.GetTenant(r.Context())
+			}
+
+			invocations.WithLabelValues(pattern, r.Method, version, client).Inc()
+			t.record(usageKey{key{r.Method, pattern}, version, client})
+		},
+	)
+}
+
+// Usage method returns invocations of every route called since start,
+// ordered by route, method and client.
+func (t *Tracker) Usage() []*Usage {
+	t.mu.Lock()
+	list := make([]*Usage, 0, len(t.usage))
+
+	for _, u := range t.usage {
+		c := *u
+		list = append(list, &c)
+	}
+
+	t.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+
+		if a.Client != b.Client {
+			return a.Client < b.Client
+		}
+
+		return a.Version < b.Version
+	})
+
+	return list
+}
+
+// Deprecations method returns deprecated routes with clients still
+// calling them, soonest sunset first.
+func (t *Tracker) Deprecations() []*Deprecation {
+	list := make([]*Deprecation, 0, len(t.deprecated))
+	index := make(map[key]*Deprecation, len(t.deprecated))
+
+	for k, d := range t.deprecated {
+		dep := &Deprecation{
+			Method:      k.method,
+			Route:       k.pattern,
+			Sunset:      d.sunset,
+			Replacement: d.route.Replacement,
+			Callers:     make([]*Usage, 0),
+		}
+
+		index[k] = dep
+		list = append(list, dep)
+	}
+
+	for _, u := range t.Usage() {
+		if dep, ok := index[key{u.Method, u.Route}]; ok {
+			dep.Count += u.Count
+			dep.Callers = append(dep.Callers, u)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+
+		if (a.Sunset == nil) != (b.Sunset == nil) {
+			return a.Sunset != nil
+		}
+
+		if a.Sunset != nil && !a.Sunset.Equal(*b.Sunset) {
+			return a.Sunset.Before(*b.Sunset)
+		}
+
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+
+		return a.Method < b.Method
+	})
+
+	return list
+}
+
+func (t *Tracker) record(k usageKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.usage[k]
+
+	if !ok {
+		u = &Usage{
+			Method:  k.method,
+			Route:   k.pattern,
+			Version: k.version,
+			Client:  k.client,
+		}
+
+		t.usage[k] = u
+	}
+
+	u.Count++
+	u.LastSeen = time.Now()
+}
+
+// warn method adds deprecation headers when request was routed to
+// deprecated route.
+func (ww *warnWriter) warn() {
+	if ww.written {
+		return
+	}
+
+	ww.written = true
+	d, ok := ww.t.deprecated[key{ww.r.Method, routePattern(ww.r)}]
+
+	if !ok {
+		return
+	}
+
+	h := ww.Header()
+	h.Set("Deprecation", "true")
+	msg := "route is deprecated"
+
+	if d.sunset != nil {
+		h.Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+		msg += " and will be removed on " + d.sunset.Format(helpers.RFC339Short)
+	}
+
+	if d.route.Replacement != "" {
+		h.Add("Link", "<"+d.route.Replacement+`>; rel="successor-version"`)
+		msg += ", use " + d.route.Replacement
+	}
+
+	h.Add("Warning", `299 - "`+msg+`"`)
+}
+
+func (ww *warnWriter) WriteHeader(status int) {
+	ww.warn()
+	ww.ResponseWriter.WriteHeader(status)
+}
+
+func (ww *warnWriter) Write(p []byte) (int, error) {
+	ww.warn()
+	return ww.ResponseWriter.Write(p)
+}
+
+func (ww *warnWriter) Flush() {
+	ww.warn()
+
+	if f, ok := ww.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// routePattern function returns pattern request was routed to, complete
+// once routing reached handler.
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+
+	return ""
+}
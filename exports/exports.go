@@ -0,0 +1,216 @@
+// Package exports runs large data exports as background jobs.
+//
+// Jobs are queued in the export_jobs table and claimed by a Runner
+// polling it, so any instance may pick up a job requested on another.
+// The Runner streams the export into a temporary file, uploads it to the
+// object store and records when the artifact expires.
+// Clients poll job status and, once done, receive a signed, expiring
+// download URL. Artifacts past retention are removed by the Runner.
+package exports
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	exportsmodel "github.com/Zetkolink/auth/models/exports"
+	"github.com/Zetkolink/auth/objectstore"
+)
+
+const (
+	defaultPollInterval = 5
+	defaultURLTTL       = 900
+	defaultRetention    = 86400
+	defaultStaleAfter   = 3600
+	sweepBatch          = 100
+	artifactExt         = ".ndjson"
+	keyPrefix           = "exports/"
+)
+
+// Config type represents export runner configuration. Durations are in
+// seconds.
+type Config struct {
+	PollInterval int `yaml:"pollInterval"`
+	URLTTL       int `yaml:"urlTTL"`
+	Retention    int
+	StaleAfter   int `yaml:"staleAfter"`
+}
+
+// Runner type represents background export worker.
+type Runner struct {
+	model      *exportsmodel.Model
+	store      objectstore.Store
+	interval   time.Duration
+	urlTTL     time.Duration
+	retention  time.Duration
+	staleAfter time.Duration
+	quit       chan struct{}
+	once       sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewRunner method creates new runner instance. Artifacts are kept in
+// store under exports/ prefix.
+func NewRunner(config Config, model *exportsmodel.Model, store objectstore.Store) *Runner {
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+
+	if config.URLTTL <= 0 {
+		config.URLTTL = defaultURLTTL
+	}
+
+	if config.Retention <= 0 {
+		config.Retention = defaultRetention
+	}
+
+	if config.StaleAfter <= 0 {
+		config.StaleAfter = defaultStaleAfter
+	}
+
+	return &Runner{
+		model:      model,
+		store:      objectstore.WithPrefix(store, keyPrefix),
+		interval:   time.Duration(config.PollInterval) * time.Second,
+		urlTTL:     time.Duration(config.URLTTL) * time.Second,
+		retention:  time.Duration(config.Retention) * time.Second,
+		staleAfter: time.Duration(config.StaleAfter) * time.Second,
+		quit:       make(chan struct{}),
+	}
+}
+
+// Start method runs export jobs as they are queued.
+func (r *Runner) Start() {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+				r.sweep(context.Background())
+				r.drain(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop method stops runner, waiting for running job.
+func (r *Runner) Stop() {
+	r.once.Do(func() { close(r.quit) })
+	r.wg.Wait()
+}
+
+// URL method returns download URL of done job.
+func (r *Runner) URL(job *exportsmodel.Job) (string, error) {
+	return r.store.URL(key(job.ID), r.urlTTL)
+}
+
+// drain method runs queued jobs until none is left or runner stops.
+func (r *Runner) drain(ctx context.Context) {
+	for {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+
+		job, err := r.model.Claim(ctx, r.staleAfter)
+
+		if err != nil {
+			log.Println("exports: " + err.Error())
+			return
+		}
+
+		if job == nil {
+			return
+		}
+
+		err = r.run(ctx, job)
+
+		if err != nil {
+			log.Printf("exports: job %s: %s", job.ID, err)
+
+			err = r.model.Fail(ctx, job.ID, err.Error())
+
+			if err != nil {
+				log.Println("exports: " + err.Error())
+			}
+		}
+	}
+}
+
+// run method exports job into temporary file and uploads it.
+func (r *Runner) run(ctx context.Context, job *exportsmodel.Job) error {
+	f, err := os.CreateTemp("", "export-*"+artifactExt)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	rows, err := r.model.Write(ctx, job, f)
+
+	if err != nil {
+		return err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+
+	if err != nil {
+		return err
+	}
+
+	err = r.store.Put(ctx, key(job.ID), f, size)
+
+	if err != nil {
+		return err
+	}
+
+	return r.model.Finish(ctx, job.ID, rows, time.Now().Add(r.retention))
+}
+
+// sweep method removes artifacts past retention.
+func (r *Runner) sweep(ctx context.Context) {
+	ids, err := r.model.Expired(ctx, sweepBatch)
+
+	if err != nil {
+		log.Println("exports: " + err.Error())
+		return
+	}
+
+	for _, id := range ids {
+		err = r.store.Delete(ctx, key(id))
+
+		if err == nil {
+			err = r.model.Expire(ctx, id)
+		}
+
+		if err != nil {
+			log.Printf("exports: expire %s: %s", id, err)
+		}
+	}
+}
+
+func key(id string) string {
+	return id + artifactExt
+}
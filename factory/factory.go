@@ -0,0 +1,136 @@
+// Package factory produces deterministic App, Exchange, Token and user
+// fixtures for tests. Fixture values derive from a sequence, so a given
+// factory always yields the same data in the same order. Fields are
+// overridden with option functions.
+package factory
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/exchanges"
+	"github.com/Zetkolink/auth/models/tokens"
+	"golang.org/x/oauth2"
+)
+
+// Epoch is the reference time fixture timestamps are derived from.
+var Epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Factory type represents fixture generator.
+type Factory struct {
+	mu  sync.Mutex
+	seq int
+}
+
+// New method creates new factory instance.
+func New() *Factory {
+	return &Factory{}
+}
+
+// UserID method returns next user identifier.
+func (f *Factory) UserID() int {
+	return 1000 + f.next()
+}
+
+// App method returns enabled Google app fixture.
+func (f *Factory) App(opts ...func(*apps.App)) *apps.App {
+	n := f.next()
+	created := Epoch.Add(time.Duration(n) * time.Hour)
+
+	app := &apps.App{
+		ID:          fmt.Sprintf("app-%d", n),
+		Service:     apps.Google,
+		Password:    fmt.Sprintf("secret-%d", n),
+		CallbackURL: fmt.Sprintf("https://example.com/callback/%d", n),
+		Scopes:      []string{"openid", "email"},
+		AuthParams:  map[string]string{},
+		CreatedAt:   &created,
+		Status:      apps.StatusEnable,
+		AuthMethod:  apps.AuthMethodSecret,
+		Weight:      1,
+	}
+
+	for _, opt := range opts {
+		opt(app)
+	}
+
+	app.Fingerprint = dblog.Fingerprint(app.Password)
+
+	return app
+}
+
+// Exchange method returns exchange fixture for Google.
+func (f *Factory) Exchange(opts ...func(*exchanges.Exchange)) *exchanges.Exchange {
+	n := f.next()
+
+	exchange := &exchanges.Exchange{
+		ID:           fmt.Sprintf("state-%d", n),
+		Service:      apps.Google,
+		UserID:       1000 + n,
+		CodeVerifier: fmt.Sprintf("verifier-%043d", n),
+		Nonce:        fmt.Sprintf("nonce-%d", n),
+	}
+
+	for _, opt := range opts {
+		opt(exchange)
+	}
+
+	return exchange
+}
+
+// Token method returns active Google token fixture, expiring hour after
+// its creation.
+func (f *Factory) Token(opts ...func(*tokens.Token)) *tokens.Token {
+	n := f.next()
+	created := Epoch.Add(time.Duration(n) * time.Hour)
+
+	token := &tokens.Token{
+		Token: &oauth2.Token{
+			TokenType:    "Bearer",
+			AccessToken:  fmt.Sprintf("access-%d", n),
+			RefreshToken: fmt.Sprintf("refresh-%d", n),
+			Expiry:       created.Add(time.Hour),
+		},
+		UserID:    1000 + n,
+		Service:   apps.Google,
+		Extra:     map[string]interface{}{},
+		Labels:    map[string]string{},
+		CreatedAt: created,
+		Status:    tokens.StatusActive,
+	}
+
+	for _, opt := range opts {
+		opt(token)
+	}
+
+	token.Fingerprint = dblog.Fingerprint(token.AccessToken)
+
+	return token
+}
+
+// Expired method is token option moving expiry to the past.
+func Expired(token *tokens.Token) {
+	token.Expiry = Epoch
+}
+
+// Disabled method is app option disabling app.
+func Disabled(app *apps.App) {
+	app.Status = apps.StatusDisable
+}
+
+// Deprecated method is app option deprecating app.
+func Deprecated(app *apps.App) {
+	app.Status = apps.StatusDeprecated
+}
+
+func (f *Factory) next() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+
+	return f.seq
+}
@@ -0,0 +1,113 @@
+package factory
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/tokens"
+)
+
+func TestFactoryDeterministic(t *testing.T) {
+	a, b := New(), New()
+
+	if !reflect.DeepEqual(a.App(), b.App()) {
+		t.Error("apps of fresh factories differ")
+	}
+
+	if !reflect.DeepEqual(a.Exchange(), b.Exchange()) {
+		t.Error("exchanges of fresh factories differ")
+	}
+
+	if !reflect.DeepEqual(a.Token(), b.Token()) {
+		t.Error("tokens of fresh factories differ")
+	}
+
+	if a.UserID() != b.UserID() {
+		t.Error("user ids of fresh factories differ")
+	}
+}
+
+func TestFactorySequence(t *testing.T) {
+	f := New()
+	first, second := f.App(), f.App()
+
+	if first.ID == second.ID || first.Password == second.Password {
+		t.Errorf("apps repeat: %q, %q", first.ID, second.ID)
+	}
+
+	if !second.CreatedAt.After(*first.CreatedAt) {
+		t.Errorf("second app created at %v, not after %v",
+			second.CreatedAt, first.CreatedAt)
+	}
+}
+
+func TestToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []func(*tokens.Token)
+		expiry func(*tokens.Token) time.Time
+		status string
+	}{
+		{
+			name:   "default",
+			expiry: func(tk *tokens.Token) time.Time { return tk.CreatedAt.Add(time.Hour) },
+			status: tokens.StatusActive,
+		},
+		{
+			name:   "expired",
+			opts:   []func(*tokens.Token){Expired},
+			expiry: func(*tokens.Token) time.Time { return Epoch },
+			status: tokens.StatusActive,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := New().Token(tt.opts...)
+
+			if want := tt.expiry(token); !token.Expiry.Equal(want) {
+				t.Errorf("expiry = %v, want %v", token.Expiry, want)
+			}
+
+			if token.Status != tt.status {
+				t.Errorf("status = %q, want %q", token.Status, tt.status)
+			}
+
+			if token.Fingerprint == "" {
+				t.Error("fingerprint is empty")
+			}
+		})
+	}
+}
+
+func TestAppOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []func(*apps.App)
+		status string
+	}{
+		{name: "default", status: apps.StatusEnable},
+		{name: "disabled", opts: []func(*apps.App){Disabled}, status: apps.StatusDisable},
+		{name: "deprecated", opts: []func(*apps.App){Deprecated}, status: apps.StatusDeprecated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := New().App(tt.opts...)
+
+			if app.Status != tt.status {
+				t.Errorf("status = %q, want %q", app.Status, tt.status)
+			}
+
+			if !apps.ValidStatus(app.Status) {
+				t.Errorf("status %q is not valid", app.Status)
+			}
+
+			if app.Fingerprint == "" {
+				t.Error("fingerprint is empty")
+			}
+		})
+	}
+}
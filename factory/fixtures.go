@@ -0,0 +1,192 @@
+package factory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/Zetkolink/auth/keyring"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/exchanges"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/lib/pq"
+)
+
+// Cleaner is the part of testing.TB fixtures register cleanup with.
+type Cleaner interface {
+	Cleanup(func())
+}
+
+// Fixtures type represents fixtures stored in database. Secrets are
+// stored sealed with keyring, the way models store them, while returned
+// fixtures carry them in plain. Every inserted row is deleted on Cleanup,
+// in reverse order of insertion.
+type Fixtures struct {
+	*Factory
+	db      *sql.DB
+	keyring *keyring.Keyring
+	cleanup []func(ctx context.Context) error
+}
+
+// NewFixtures method creates new fixtures instance. Nil keyring stores
+// secrets as is. When cleaner is given, Cleanup is registered with it.
+func NewFixtures(db *sql.DB, ring *keyring.Keyring, cleaner Cleaner) *Fixtures {
+	x := &Fixtures{
+		Factory: New(),
+		db:      db,
+		keyring: ring,
+	}
+
+	if cleaner != nil {
+		cleaner.Cleanup(func() { _ = x.Cleanup(context.Background()) })
+	}
+
+	return x
+}
+
+// InsertApp method stores app fixture.
+func (x *Fixtures) InsertApp(ctx context.Context, opts ...func(*apps.App)) (*apps.App, error) {
+	app := x.App(opts...)
+
+	authParams, err := json.Marshal(app.AuthParams)
+
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := x.keyring.Seal(app.Password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := x.keyring.Seal(app.SigningKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = x.db.ExecContext(ctx, `INSERT INTO auth.apps
+									( "id", "service", "password",
+									 "password_fingerprint", "callback_URL",
+									 "scopes", "environment", "issuer",
+									 "auth_params", "expiry", "created_at",
+									 "status", "auth_method", "signing_key",
+									 "signing_key_id", "offline_access",
+									 "weight", "tenant")
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+									$13, $14, $15, $16, $17, $18)`,
+		app.ID, app.Service, dblog.Secret(password), app.Fingerprint,
+		app.CallbackURL, pq.Array(app.Scopes), app.Environment, app.Issuer,
+		authParams, app.Expiry, app.CreatedAt, app.Status, app.AuthMethod,
+		dblog.Secret(signingKey), app.SigningKeyID, app.OfflineAccess,
+		app.Weight, app.Tenant,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	x.onCleanup(`DELETE FROM auth.apps WHERE id = $1`, app.ID)
+
+	return app, nil
+}
+
+// InsertExchange method stores exchange fixture.
+func (x *Fixtures) InsertExchange(ctx context.Context, opts ...func(*exchanges.Exchange)) (*exchanges.Exchange, error) {
+	exchange := x.Exchange(opts...)
+
+	_, err := x.db.ExecContext(ctx, `INSERT INTO auth.exchanges
+									( "id", "service", "user_id", "tenant",
+									 "code_verifier", "nonce")
+								VALUES ($1, $2, $3, $4, $5, $6)`,
+		exchange.ID, exchange.Service, exchange.UserID, exchange.Tenant,
+		exchange.CodeVerifier, exchange.Nonce,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	x.onCleanup(`DELETE FROM auth.exchanges WHERE id = $1`, exchange.ID)
+
+	return exchange, nil
+}
+
+// InsertToken method stores token fixture.
+func (x *Fixtures) InsertToken(ctx context.Context, opts ...func(*tokens.Token)) (*tokens.Token, error) {
+	token := x.Token(opts...)
+
+	extra, err := json.Marshal(token.Extra)
+
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := json.Marshal(token.Labels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := x.keyring.Seal(token.AccessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := x.keyring.Seal(token.RefreshToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = x.db.ExecContext(ctx, `INSERT INTO auth.tokens
+									( "user_id", "token_type", "access_token",
+									 "expiry", "refresh_token", "created_at",
+									 "service", "instance_url", "extra",
+									 "updated_at", "access_token_fingerprint",
+									 "app_id", "status", "account", "labels")
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $6, $10, $11,
+									$12, $13, $14)`,
+		token.UserID, token.TokenType, dblog.Secret(accessToken),
+		token.Expiry, dblog.Secret(refreshToken), token.CreatedAt,
+		token.Service, token.InstanceURL, extra, token.Fingerprint,
+		token.AppID, token.Status, token.Account, labels,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	x.onCleanup(`DELETE FROM auth.tokens
+								WHERE user_id = $1 AND service = $2
+								AND account = $3`,
+		token.UserID, token.Service, token.Account)
+
+	return token, nil
+}
+
+// Cleanup method deletes inserted fixtures. It keeps going on errors and
+// returns the first one.
+func (x *Fixtures) Cleanup(ctx context.Context) error {
+	var first error
+
+	for i := len(x.cleanup) - 1; i >= 0; i-- {
+		if err := x.cleanup[i](ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	x.cleanup = nil
+
+	return first
+}
+
+func (x *Fixtures) onCleanup(query string, args ...interface{}) {
+	x.cleanup = append(x.cleanup, func(ctx context.Context) error {
+		_, err := x.db.ExecContext(ctx, query, args...)
+		return err
+	})
+}
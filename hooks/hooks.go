@@ -0,0 +1,312 @@
+// Package hooks runs Go code of embedders after token events.
+//
+// Embedders building their own binary register hooks from init, e.g. to
+// push tokens into their secret manager or start initial sync:
+//
+//	func init() {
+//		hooks.Register("vault", pushToVault, hooks.EventTokenCreated,
+//			hooks.EventTokenRefreshed)
+//	}
+//
+// Events are queued in the hook_jobs table, one job per subscribed hook,
+// and run by a Runner polling it, so hooks never slow token requests
+// down and survive restarts. Failed runs are retried with exponential
+// backoff until MaxAttempts, then the job is kept as failed. Hooks load
+// the token themselves when run, so no secret is ever written to the
+// queue.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	hooksmodel "github.com/Zetkolink/auth/models/hooks"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// EventTokenCreated token created by code exchange.
+	EventTokenCreated = "token.created"
+
+	// EventTokenRefreshed token refreshed at provider.
+	EventTokenRefreshed = "token.refreshed"
+
+	defaultPollInterval = 5
+	defaultMaxAttempts  = 8
+	defaultBaseBackoff  = 10
+	defaultMaxBackoff   = 3600
+	defaultTimeout      = 60
+)
+
+var (
+	// ErrNotRegistered job names hook missing from this binary.
+	ErrNotRegistered = errors.New("hook not registered")
+
+	registryMu sync.RWMutex
+	registry   = make(map[string]*registration)
+)
+
+// Hook is the function run after subscribed event.
+type Hook func(ctx context.Context, event *Event) error
+
+// Loader loads current token of user for service.
+type Loader func(ctx context.Context, userID string, service string) (*oauth2.Token, error)
+
+// Event type represents token event passed to hook. Attempt counts runs
+// of the hook for this event, starting at 1.
+type Event struct {
+	Type    string
+	UserID  int
+	Service string
+	Tenant  string
+	Attempt int
+
+	loader Loader
+}
+
+type registration struct {
+	hook   Hook
+	events map[string]struct{}
+}
+
+// Config type represents hook runner configuration. Durations are in
+// seconds, Timeout bounds single hook run.
+type Config struct {
+	PollInterval int `yaml:"pollInterval"`
+	MaxAttempts  int `yaml:"maxAttempts"`
+	BaseBackoff  int `yaml:"baseBackoff"`
+	MaxBackoff   int `yaml:"maxBackoff"`
+	Timeout      int
+}
+
+// Runner type represents background hook worker.
+type Runner struct {
+	config Config
+	model  *hooksmodel.Model
+	loader Loader
+	quit   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// Register method registers hook under unique name for events. It
+// panics when name is taken, as registration happens on init.
+func Register(name string, hook Hook, events ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic("hooks: hook " + name + " registered twice")
+	}
+
+	r := &registration{hook: hook, events: make(map[string]struct{})}
+
+	for _, event := range events {
+		r.events[event] = struct{}{}
+	}
+
+	registry[name] = r
+}
+
+// Subscribed method returns names of hooks registered for event.
+func Subscribed(event string) []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var names []string
+
+	for name, r := range registry {
+		if _, ok := r.events[event]; ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func lookup(name string) (Hook, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	r, ok := registry[name]
+
+	if !ok {
+		return nil, false
+	}
+
+	return r.hook, true
+}
+
+// Token method loads current token of event user.
+func (e *Event) Token(ctx context.Context) (*oauth2.Token, error) {
+	return e.loader(ctx, strconv.Itoa(e.UserID), e.Service)
+}
+
+// NewRunner method creates new runner instance.
+func NewRunner(config Config, model *hooksmodel.Model) *Runner {
+	setDefault(&config.PollInterval, defaultPollInterval)
+	setDefault(&config.MaxAttempts, defaultMaxAttempts)
+	setDefault(&config.BaseBackoff, defaultBaseBackoff)
+	setDefault(&config.MaxBackoff, defaultMaxBackoff)
+	setDefault(&config.Timeout, defaultTimeout)
+
+	return &Runner{
+		config: config,
+		model:  model,
+		quit:   make(chan struct{}),
+	}
+}
+
+// SetLoader method sets loader hooks read tokens with. Tokens model
+// queues events, so it is created after runner.
+func (r *Runner) SetLoader(loader Loader) {
+	r.loader = loader
+}
+
+// Enqueue method queues event for hooks subscribed to it. Nil runner
+// queues nothing.
+func (r *Runner) Enqueue(ctx context.Context, event string, userID int, service string) {
+	if r == nil {
+		return
+	}
+
+	names := Subscribed(event)
+
+	if len(names) == 0 {
+		return
+	}
+
+	err := r.model.Create(ctx, names, event, userID, service,
+		helpers.GetTenant(ctx))
+
+	if err != nil {
+		log.Printf("hooks: enqueue %s: %s", event, err)
+	}
+}
+
+// Start method runs queued hooks as they become due.
+func (r *Runner) Start() {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(r.config.PollInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+				r.drain(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop method stops runner, waiting for running hook.
+func (r *Runner) Stop() {
+	r.once.Do(func() { close(r.quit) })
+	r.wg.Wait()
+}
+
+// drain method runs due jobs until none is left or runner stops.
+func (r *Runner) drain(ctx context.Context) {
+	timeout := time.Duration(r.config.Timeout) * time.Second
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+
+		// Lease outlives hook timeout, so running job is not claimed
+		// twice.
+		job, err := r.model.Claim(ctx, 2*timeout)
+
+		if err != nil {
+			log.Println("hooks: " + err.Error())
+			return
+		}
+
+		if job == nil {
+			return
+		}
+
+		err = r.run(ctx, job, timeout)
+
+		switch {
+		case err == nil:
+			err = r.model.Done(ctx, job.ID)
+		case err == ErrNotRegistered || job.Attempts >= r.config.MaxAttempts:
+			log.Printf("hooks: %s job %d failed: %s", job.Hook, job.ID, err)
+			err = r.model.Fail(ctx, job.ID, err.Error())
+		default:
+			err = r.model.Retry(ctx, job.ID, err.Error(),
+				time.Now().Add(r.backoff(job.Attempts)))
+		}
+
+		if err != nil {
+			log.Println("hooks: " + err.Error())
+		}
+	}
+}
+
+func (r *Runner) run(ctx context.Context, job *hooksmodel.Job, timeout time.Duration) (err error) {
+	hook, ok := lookup(job.Hook)
+
+	if !ok {
+		return ErrNotRegistered
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New("hook panicked")
+			log.Printf("hooks: %s panicked: %v", job.Hook, p)
+		}
+	}()
+
+	return hook(ctx, &Event{
+		Type:    job.Event,
+		UserID:  job.UserID,
+		Service: job.Service,
+		Tenant:  job.Tenant,
+		Attempt: job.Attempts,
+		loader:  r.loader,
+	})
+}
+
+// backoff method returns jittered exponential delay before next attempt.
+func (r *Runner) backoff(attempt int) time.Duration {
+	base := time.Duration(r.config.BaseBackoff) * time.Second
+	max := time.Duration(r.config.MaxBackoff) * time.Second
+	backoff := max
+
+	if attempt < 32 && base<<uint(attempt-1) < max {
+		backoff = base << uint(attempt-1)
+	}
+
+	half := backoff / 2
+
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}
@@ -1,13 +1,37 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/Zetkolink/auth/demo"
+	"github.com/Zetkolink/auth/http/contollers/admin"
+	"github.com/Zetkolink/auth/http/contollers/analytics"
 	"github.com/Zetkolink/auth/http/contollers/apps"
+	"github.com/Zetkolink/auth/http/contollers/exports"
+	"github.com/Zetkolink/auth/http/contollers/identities"
+	"github.com/Zetkolink/auth/http/contollers/objects"
+	"github.com/Zetkolink/auth/http/contollers/operations"
+	"github.com/Zetkolink/auth/http/contollers/providers"
+	"github.com/Zetkolink/auth/http/contollers/push"
+	"github.com/Zetkolink/auth/http/contollers/retention"
+	"github.com/Zetkolink/auth/http/contollers/scripts"
+	"github.com/Zetkolink/auth/http/contollers/simulate"
 	"github.com/Zetkolink/auth/http/contollers/tokens"
+	"github.com/Zetkolink/auth/http/contollers/transfer"
+	"github.com/Zetkolink/auth/http/contollers/usage"
 	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/Zetkolink/auth/proxyproto"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -20,10 +44,32 @@ func (s *auth) setupHTTPServer(config httpConfig) error {
 
 	apiVersion := "v1"
 
+	trusted, err := proxyproto.ParseNetworks(config.TrustedProxies)
+
+	if err != nil {
+		return err
+	}
+
 	r := chi.NewRouter()
 	r.Use(middleware.WithValue(helpers.APIVersionContextKey, apiVersion))
 	r.Use(middleware.StripSlashes)
 	r.Use(middleware.Recoverer)
+	r.Use(metrics.Middleware)
+	r.Use(helpers.Tenant)
+	r.Use(helpers.ClientIP(trusted))
+	r.Use(s.usage.Middleware)
+
+	if s.mirror != nil {
+		r.Use(s.mirror.Middleware)
+	}
+
+	r.Handle("/metrics", metrics.Handler())
+
+	adminOnly := helpers.AccessController(helpers.RoleAdmin)
+
+	if s.demo != nil {
+		r.Mount(demo.Path, s.demo.NewRouter())
+	}
 
 	r.Route(
 		fmt.Sprintf("%s/%s", helpers.APIPathSuffix, apiVersion),
@@ -31,9 +77,32 @@ func (s *auth) setupHTTPServer(config httpConfig) error {
 		func(r chi.Router) {
 			r.Group(
 				func(r chi.Router) {
+					adminController := admin.NewController()
+
+					r.With(adminOnly).Mount(
+						"/admin/system",
+						adminController.NewRouter(),
+					)
+
+					// Proxied requests change provider state only,
+					// so they are served in read-only mode too.
+					if s.proxy != nil {
+						r.Mount(
+							"/proxy",
+							s.proxy.NewRouter(),
+						)
+					}
+				},
+			)
+
+			r.Group(
+				func(r chi.Router) {
+					r.Use(helpers.ReadOnly)
+
 					appsController := apps.NewController(
 						apps.ModelSet{
-							Apps: s.models.Apps,
+							Apps:   s.models.Apps,
+							Tokens: s.models.Tokens,
 						},
 					)
 
@@ -42,9 +111,16 @@ func (s *auth) setupHTTPServer(config httpConfig) error {
 						appsController.NewRouter(),
 					)
 
+					r.With(adminOnly).Mount(
+						"/admin/apps",
+						appsController.NewAdminRouter(),
+					)
+
 					tokensController := tokens.NewController(
 						tokens.ModelSet{
-							Tokens: s.models.Tokens,
+							Tokens:        s.models.Tokens,
+							ServiceTokens: s.models.ServiceTokens,
+							Events:        s.models.Events,
 						},
 					)
 
@@ -52,20 +128,353 @@ func (s *auth) setupHTTPServer(config httpConfig) error {
 						"/tokens",
 						tokensController.NewRouter(),
 					)
+
+					r.Mount(
+						"/users",
+						tokensController.NewUsersRouter(),
+					)
+
+					identitiesController := identities.NewController(
+						identities.ModelSet{
+							Identities: s.models.Identities,
+						},
+					)
+
+					r.Mount(
+						"/identities",
+						identitiesController.NewRouter(),
+					)
+
+					analyticsController := analytics.NewController(
+						analytics.ModelSet{
+							Analytics: s.models.Analytics,
+						},
+					)
+
+					r.Mount(
+						"/analytics",
+						analyticsController.NewRouter(),
+					)
+
+					providersController := providers.NewController(
+						providers.ModelSet{
+							Apps:      s.models.Apps,
+							Providers: s.models.Providers,
+						},
+					)
+
+					r.With(adminOnly).Mount(
+						"/admin/providers",
+						providersController.NewRouter(),
+					)
+
+					r.Mount(
+						"/providers",
+						providersController.NewCatalogRouter(),
+					)
+
+					scriptsController := scripts.NewController(
+						scripts.ModelSet{
+							Scripts: s.models.Scripts,
+						},
+					)
+
+					r.With(adminOnly).Mount(
+						"/admin/scripts",
+						scriptsController.NewRouter(),
+					)
+
+					pushController := push.NewController(
+						push.ModelSet{
+							Push: s.models.Push,
+						},
+					)
+
+					r.With(adminOnly).Mount(
+						"/admin/push",
+						pushController.NewRouter(),
+					)
+
+					simulateController := simulate.NewController(
+						simulate.ModelSet{
+							Tokens: s.models.Tokens,
+						},
+					)
+
+					r.With(adminOnly).Mount(
+						"/admin/simulate",
+						simulateController.NewRouter(),
+					)
+
+					operationsController := operations.NewController(
+						operations.ModelSet{
+							Operations: s.models.Operations,
+							Runner:     s.operations,
+						},
+					)
+
+					r.With(adminOnly).Mount(
+						"/admin/operations",
+						operationsController.NewRouter(),
+					)
+
+					transferController := transfer.NewController(
+						transfer.ModelSet{
+							Tokens: s.models.Tokens,
+						},
+					)
+
+					r.With(adminOnly).Mount(
+						"/admin/transfer",
+						transferController.NewRouter(),
+					)
+
+					usageController := usage.NewController(
+						usage.ModelSet{
+							Tracker: s.usage,
+						},
+					)
+
+					r.With(adminOnly).Mount(
+						"/admin/usage",
+						usageController.NewRouter(),
+					)
+
+					if s.exports != nil {
+						exportsController := exports.NewController(
+							exports.ModelSet{
+								Exports: s.models.Exports,
+								Runner:  s.exports,
+							},
+						)
+
+						r.With(adminOnly).Mount(
+							"/admin/exports",
+							exportsController.NewRouter(),
+						)
+					}
+
+					if s.retention != nil {
+						retentionController := retention.NewController(
+							retention.ModelSet{
+								Enforcer: s.retention,
+							},
+						)
+
+						r.With(adminOnly).Mount(
+							"/admin/retention",
+							retentionController.NewRouter(),
+						)
+					}
+
+					if s.objects != nil {
+						objectsController := objects.NewController(
+							objects.ModelSet{
+								Store: s.objects,
+							},
+						)
+
+						r.Mount(
+							"/objects",
+							objectsController.NewRouter(),
+						)
+					}
 				},
 			)
 		},
 	)
 
-	s.httpServer = &http.Server{
-		Addr:              config.Bind,
-		Handler:           r,
-		ReadTimeout:       config.ReadTimeout,
-		ReadHeaderTimeout: config.ReadHeaderTimeout,
-		WriteTimeout:      config.WriteTimeout,
-		IdleTimeout:       config.IdleTimeout,
-		MaxHeaderBytes:    config.MaxHeaderBytes,
+	public := config.Listeners
+
+	if len(public) == 0 {
+		public = []listenerConfig{{Bind: config.Bind}}
+	}
+
+	adminPrefix := fmt.Sprintf("%s/%s/admin/", helpers.APIPathSuffix, apiVersion)
+
+	var adminKey []byte
+
+	if config.AdminKeyHash != "" {
+		adminKey, err = hex.DecodeString(config.AdminKeyHash)
+
+		if err != nil || len(adminKey) != sha256.Size {
+			return errors.New("http adminKeyHash must be hex sha256")
+		}
+	}
+
+	base := helpers.BasePath(config.basePath(), apiVersion)
+
+	for _, lc := range public {
+		var handler http.Handler = r
+
+		switch {
+		case len(config.Admin) > 0:
+			handler = scope(r, adminPrefix, false)
+		case adminKey != nil && !lc.Partner:
+			handler = helpers.KeyRole(helpers.RoleAdmin, adminKey)(r)
+		}
+
+		if lc.Partner {
+			handler = s.partners.Middleware(handler)
+		}
+
+		l, err := newListener(lc, base(handler), config, trusted)
+
+		if err != nil {
+			return err
+		}
+
+		s.listeners = append(s.listeners, l)
+	}
+
+	for _, lc := range config.Admin {
+		handler := helpers.Role(helpers.RoleAdmin)(scope(r, adminPrefix, true))
+		l, err := newListener(lc, base(handler), config, trusted)
+
+		if err != nil {
+			return err
+		}
+
+		s.listeners = append(s.listeners, l)
 	}
 
 	return nil
 }
+
+// listener type represents HTTP server bound to single address. Proxies
+// are set when connections carry PROXY protocol header.
+type listener struct {
+	server  *http.Server
+	network string
+	proxies proxyproto.Networks
+}
+
+func newListener(lc listenerConfig, handler http.Handler, config httpConfig,
+	trusted proxyproto.Networks) (*listener, error) {
+
+	tlsConf, err := lc.TLS.load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var proxies proxyproto.Networks
+
+	if lc.ProxyProtocol {
+		if len(trusted) == 0 {
+			return nil, errors.New("PROXY protocol on " + lc.Bind +
+				" requires trustedProxies")
+		}
+
+		proxies = trusted
+	}
+
+	return &listener{
+		network: network(lc.Bind),
+		proxies: proxies,
+		server: &http.Server{
+			Addr:              lc.Bind,
+			Handler:           handler,
+			TLSConfig:         tlsConf,
+			ReadTimeout:       config.ReadTimeout,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxHeaderBytes,
+		},
+	}, nil
+}
+
+// basePath method returns path API is served under.
+func (c httpConfig) basePath() string {
+	if c.BasePath == "" {
+		return helpers.APIPathSuffix
+	}
+
+	return strings.TrimSuffix(c.BasePath, "/")
+}
+
+// callbackURL method returns connect callback of service reached at base
+// URL, empty without base URL.
+func (c httpConfig) callbackURL(baseURL string) string {
+	if baseURL == "" {
+		return ""
+	}
+
+	return strings.TrimSuffix(baseURL, "/") + c.basePath() + "/v1/tokens"
+}
+
+// load method returns listener TLS configuration, nil for plain HTTP.
+func (c tlsConfig) load() (*tls.Config, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + c.ClientCAFile)
+		}
+
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return conf, nil
+}
+
+// network function picks listen network of address. IPv4 and IPv6
+// literals bind single stack, host names and empty host bind dual stack.
+func network(bind string) string {
+	host, _, err := net.SplitHostPort(bind)
+
+	if err != nil {
+		return "tcp"
+	}
+
+	ip := net.ParseIP(host)
+
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	}
+
+	return "tcp6"
+}
+
+// scope function serves admin routes and metrics only on admin listeners,
+// everything else only on public ones.
+func scope(next http.Handler, adminPrefix string, admin bool) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			isAdmin := strings.HasPrefix(r.URL.Path, adminPrefix)
+
+			if isAdmin != admin && !(admin && r.URL.Path == "/metrics") {
+				http.NotFound(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct{}
+
+type readOnlyRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type readOnlyResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+// NewController method creates new controller instance.
+func NewController() *Controller {
+	return &Controller{}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/readonly", c.GetReadOnly)
+	r.Put("/readonly", c.SetReadOnly)
+
+	return r
+}
+
+// GetReadOnly handler renders read-only mode state.
+func (c *Controller) GetReadOnly(w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, newReadOnlyResponse())
+}
+
+// SetReadOnly handler switches read-only mode.
+func (c *Controller) SetReadOnly(w http.ResponseWriter, r *http.Request) {
+	payload := &readOnlyRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	helpers.SetReadOnly(*payload.Enabled)
+
+	render.Render(w, r, newReadOnlyResponse())
+}
+
+func (rs *readOnlyResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (rq *readOnlyRequest) Bind(_ *http.Request) error {
+	if rq.Enabled == nil {
+		return errors.New("missing required enabled field")
+	}
+
+	return nil
+}
+
+func newReadOnlyResponse() *readOnlyResponse {
+	return &readOnlyResponse{
+		Enabled: helpers.IsReadOnly(),
+	}
+}
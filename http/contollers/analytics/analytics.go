@@ -0,0 +1,111 @@
+package analytics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/analytics"
+	"github.com/Zetkolink/auth/planguard"
+	"github.com/go-chi/chi"
+)
+
+const defaultPeriod = 30 * 24 * time.Hour
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Analytics *analytics.Model
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/usage", c.Usage)
+
+	return r
+}
+
+// Usage handler renders daily connect usage report.
+func (c *Controller) Usage(w http.ResponseWriter, r *http.Request) {
+	filter, errs := decodeFilter(r)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	err := c.models.Analytics.Check(r.Context(), filter)
+
+	if err != nil {
+		if err == planguard.ErrUnindexed || err == planguard.ErrFullScan {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"filter": err.Error(),
+			})
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	helpers.StreamJSON(w, r,
+		func(emit func(v interface{}) error) error {
+			return c.models.Analytics.Each(r.Context(), filter,
+				func(u *analytics.Usage) error {
+					return emit(u)
+				},
+			)
+		},
+	)
+}
+
+func decodeFilter(r *http.Request) (analytics.Filter, helpers.ValidationErrors) {
+	var errs = make(helpers.ValidationErrors)
+
+	filter := analytics.Filter{
+		To:      time.Now(),
+		Service: r.FormValue("service"),
+		Tenant:  r.FormValue("tenant"),
+	}
+
+	if to := r.FormValue("to"); to != "" {
+		date, err := helpers.ParseDate(to)
+
+		if err != nil {
+			errs["to"] = "invalid value specified"
+		}
+
+		filter.To = date
+	}
+
+	filter.From = filter.To.Add(-defaultPeriod)
+
+	if from := r.FormValue("from"); from != "" {
+		date, err := helpers.ParseDate(from)
+
+		if err != nil {
+			errs["from"] = "invalid value specified"
+		}
+
+		filter.From = date
+	}
+
+	if len(errs) > 0 {
+		return filter, errs
+	}
+
+	return filter, nil
+}
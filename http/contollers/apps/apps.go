@@ -1,12 +1,16 @@
 package apps
 
 import (
+	"database/sql"
 	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/Zetkolink/auth/http/helpers"
 	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/tokens"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 )
@@ -18,15 +22,42 @@ type Controller struct {
 
 // ModelSet type represents model set.
 type ModelSet struct {
-	Apps *apps.Model
+	Apps   *apps.Model
+	Tokens *tokens.Model
 }
 
 type appRequest struct {
 	*apps.App
 }
 
+// appResponse type represents app as rendered by API. Client secrets are
+// left out, they are returned by privileged RevealSecrets only.
 type appResponse struct {
-	*apps.App
+	ID                     string            `json:"id"`
+	Service                string            `json:"service"`
+	Fingerprint            string            `json:"password_fingerprint"`
+	CallbackURL            string            `json:"callback_URL"`
+	Scopes                 []string          `json:"scopes"`
+	Environment            string            `json:"environment"`
+	Issuer                 string            `json:"issuer"`
+	AuthParams             map[string]string `json:"auth_params"`
+	Expiry                 *time.Time        `json:"expiry"`
+	CreatedAt              *time.Time        `json:"created_at"`
+	Status                 string            `json:"status"`
+	AuthMethod             string            `json:"auth_method"`
+	SigningKeyID           string            `json:"signing_key_id"`
+	OfflineAccess          bool              `json:"offline_access"`
+	Weight                 int               `json:"weight"`
+	Tenant                 string            `json:"tenant"`
+	PreviousPasswordExpiry *time.Time        `json:"previous_password_expiry,omitempty"`
+}
+
+type appPatchRequest struct {
+	*apps.AppPatch
+}
+
+type scopesRequest struct {
+	Scopes []string `json:"scopes" validate:"required,max=64,unique,dive,required,max=512,excludesall=0x20"`
 }
 
 type authCodeURLResponse struct {
@@ -44,12 +75,15 @@ func NewController(models ModelSet) *Controller {
 func (c *Controller) NewRouter() chi.Router {
 	r := chi.NewRouter()
 
-	r.Patch("/{appID}/status/{status}", c.Create)
+	r.With(helpers.Paginate).Get("/", c.List)
+	r.Patch("/{appID}/status/{status}", c.SetStatus)
+	r.Post("/{appID}/verify", c.Verify)
+	r.Put("/{appID}/scopes", c.SetScopes)
 
 	r.Route("/{service}",
 		func(r chi.Router) {
 			r.Get("/", c.Get)
-			r.Get("/{userID}", c.AuthCodeURL)
+			r.With(helpers.Mutating).Get("/{userID}", c.AuthCodeURL)
 			r.Post("/", c.Create)
 		},
 	)
@@ -57,6 +91,281 @@ func (c *Controller) NewRouter() chi.Router {
 	return r
 }
 
+// NewAdminRouter method returns HTTP-router managing apps by id, meant
+// for declarative tools: responses carry strong ETag, PUT creates or
+// replaces app and honors If-Match and If-None-Match, PATCH changes some
+// fields honoring If-Match. DELETE removes app, tokens minted under
+// it with cascade=true only. Migrations move service to new client. Client
+// secret is rotated by POST to /{appID}/secret and read in plain by GET.
+// Like every admin router it is mounted for admin role only.
+func (c *Controller) NewAdminRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/{appID}", c.GetByID)
+	r.Put("/{appID}", c.Put)
+	r.Patch("/{appID}", c.Update)
+	r.Delete("/{appID}", c.Delete)
+	r.Post("/{appID}/secret", c.RotateSecret)
+	r.Get("/{appID}/secret", c.RevealSecrets)
+
+	r.Post("/migrations", c.StartMigration)
+	r.Get("/migrations/{service}", c.GetMigration)
+	r.Delete("/migrations/{service}", c.FinishMigration)
+	r.With(helpers.Paginate).Get("/migrations/{service}/report",
+		c.MigrationReport)
+
+	return r
+}
+
+// List handler renders page of apps, filtered by service and status.
+func (c *Controller) List(w http.ResponseWriter, r *http.Request) {
+	status := r.FormValue("status")
+
+	if status != "" && !apps.ValidStatus(status) {
+		helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+			"status": "invalid value specified",
+		})
+		return
+	}
+
+	p := r.Context().Value(helpers.PaginatorContextKey).(*helpers.Paginator)
+
+	if p.PerPage == 0 {
+		p.PerPage = defaultPerPage
+	}
+
+	list, err := c.models.Apps.List(r.Context(), r.FormValue("service"),
+		status, p)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, app := range list {
+		res = append(res, newAppResponse(app))
+	}
+
+	p.SetHeaders(w, r)
+	render.RenderList(w, r, res)
+}
+
+// GetByID handler renders app by id, whatever its status.
+func (c *Controller) GetByID(w http.ResponseWriter, r *http.Request) {
+	app, err := c.models.Apps.GetByID(r.Context(), chi.URLParam(r, "appID"))
+
+	if err != nil {
+		if err == apps.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	renderTagged(w, r, http.StatusOK, app)
+}
+
+// Put handler creates app under id or replaces stored one. Service of
+// stored app cannot be changed.
+func (c *Controller) Put(w http.ResponseWriter, r *http.Request) {
+	payload := &appRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	app := payload.App
+	err = helpers.ConformStruct(app)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	app.ID = chi.URLParam(r, "appID")
+
+	errs := helpers.ValidateStruct(app, nil)
+
+	if app.Service == "" {
+		errs = withError(errs, "service", "required")
+	}
+
+	if !apps.ValidStatus(app.Status) {
+		errs = withError(errs, "status", "invalid value specified")
+	}
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	created, err := c.models.Apps.Put(ctx, app, func(current *apps.App) error {
+		etag := ""
+
+		if current != nil {
+			var err error
+
+			etag, err = helpers.ETag(newAppResponse(current))
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return helpers.CheckPreconditions(r, etag)
+	})
+
+	if err != nil {
+		switch err {
+		case helpers.ErrPrecondition:
+			helpers.PreconditionFailed(w, r, err)
+		case apps.ErrServiceChange, apps.ErrExists, apps.ErrTransition:
+			helpers.Conflict(w, r, err)
+		case apps.ErrAuthParams:
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"auth_params": err.Error(),
+			})
+		case apps.ErrSigningKey:
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"signing_key": err.Error(),
+			})
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	app, err = c.models.Apps.GetByID(ctx, app.ID)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+
+	if created {
+		status = http.StatusCreated
+	}
+
+	renderTagged(w, r, status, app)
+}
+
+// Update handler changes password, callback URL, scopes or expiry of
+// stored app, fields missing from request keeping stored values.
+func (c *Controller) Update(w http.ResponseWriter, r *http.Request) {
+	payload := &appPatchRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	patch := payload.AppPatch
+	errs := helpers.ValidateStruct(patch, nil)
+
+	if v := patch.CallbackURL; v != nil && *v != "" {
+		u, err := url.Parse(*v)
+
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = withError(errs, "callback_URL", "must be absolute http(s) URL")
+		}
+	}
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	app, err := c.models.Apps.Update(r.Context(), chi.URLParam(r, "appID"),
+		*patch, func(current *apps.App) error {
+			etag, err := helpers.ETag(newAppResponse(current))
+
+			if err != nil {
+				return err
+			}
+
+			return helpers.CheckPreconditions(r, etag)
+		})
+
+	if err != nil {
+		switch err {
+		case apps.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case helpers.ErrPrecondition:
+			helpers.PreconditionFailed(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	renderTagged(w, r, http.StatusOK, app)
+}
+
+// Delete handler removes app. App with tokens minted under it is kept
+// unless cascade=true confirms they are deleted too, without being
+// revoked.
+func (c *Controller) Delete(w http.ResponseWriter, r *http.Request) {
+	cascade := false
+
+	if v := r.FormValue("cascade"); v != "" {
+		var err error
+
+		cascade, err = strconv.ParseBool(v)
+
+		if err != nil {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"cascade": "invalid value specified",
+			})
+			return
+		}
+	}
+
+	ctx := r.Context()
+
+	var deleted []*tokens.Token
+	var purge func(tx *sql.Tx, app *apps.App) error
+
+	if cascade {
+		purge = func(tx *sql.Tx, app *apps.App) error {
+			var err error
+
+			deleted, err = c.models.Tokens.DeleteByApp(ctx, tx, app)
+
+			return err
+		}
+	}
+
+	err := c.models.Apps.Delete(ctx, chi.URLParam(r, "appID"), purge)
+
+	if err != nil {
+		switch err {
+		case apps.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case apps.ErrHasTokens, apps.ErrMigrating:
+			helpers.Conflict(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	c.models.Tokens.Deleted(ctx, deleted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Create handler creates new app.
 func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
 	payload := &appRequest{}
@@ -84,8 +393,16 @@ func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
 
 	newApp.Service = service
 
+	if newApp.Status == "" {
+		newApp.Status = apps.StatusEnable
+	}
+
 	errs := helpers.ValidateStruct(newApp, nil)
 
+	if !apps.ValidStatus(newApp.Status) {
+		errs = withError(errs, "status", "invalid value specified")
+	}
+
 	if errs != nil {
 		helpers.ValidationFailed(w, r, errs)
 		return
@@ -99,6 +416,20 @@ func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if err == apps.ErrAuthParams {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"auth_params": err.Error(),
+			})
+			return
+		}
+
+		if err == apps.ErrSigningKey {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"signing_key": err.Error(),
+			})
+			return
+		}
+
 		helpers.InternalServerError(w, r, err)
 		return
 	}
@@ -136,7 +467,19 @@ func (c *Controller) SetStatus(w http.ResponseWriter, r *http.Request) {
 	app, err := c.models.Apps.SetStatus(r.Context(), appID, status)
 
 	if err != nil {
-		if err == apps.ErrExists {
+		if err == apps.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		if err == apps.ErrStatus {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"status": "invalid value specified",
+			})
+			return
+		}
+
+		if err == apps.ErrExists || err == apps.ErrTransition {
 			helpers.Conflict(w, r, err)
 			return
 		}
@@ -149,6 +492,39 @@ func (c *Controller) SetStatus(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, newAppResponse(app))
 }
 
+// SetScopes handler replaces app scopes.
+func (c *Controller) SetScopes(w http.ResponseWriter, r *http.Request) {
+	payload := &scopesRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	app, err := c.models.Apps.SetScopes(r.Context(),
+		chi.URLParam(r, "appID"), payload.Scopes)
+
+	if err != nil {
+		if err == apps.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.Render(w, r, newAppResponse(app))
+}
+
 // Get handler renders returns app.
 func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
 	service := chi.URLParam(r, "service")
@@ -162,6 +538,11 @@ func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
 	app, err := c.models.Apps.GetByService(ctx, service)
 
 	if err != nil {
+		if err == apps.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
 		helpers.InternalServerError(w, r, err)
 		return
 	}
@@ -190,10 +571,36 @@ func (c *Controller) AuthCodeURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	hints := make(map[string]string)
+
+	for _, key := range apps.HintParams {
+		if value := r.URL.Query().Get(key); value != "" {
+			hints[key] = value
+		}
+	}
+
+	labels, err := helpers.ParseLabels(r.URL.Query()["label"])
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
 	ctx := r.Context()
-	url, err := c.models.Apps.AuthCodeURL(ctx, service, userID)
+	url, err := c.models.Apps.AuthCodeURL(ctx, service, userID,
+		r.URL.Query().Get("account"), hints, labels)
 
 	if err != nil {
+		if err == apps.ErrHint || err == apps.ErrAccount {
+			helpers.BadRequest(w, r, err)
+			return
+		}
+
+		if err == apps.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
 		helpers.InternalServerError(w, r, err)
 		return
 	}
@@ -222,9 +629,62 @@ func (prq *appRequest) Bind(_ *http.Request) error {
 	return nil
 }
 
+func (apr *appPatchRequest) Bind(_ *http.Request) error {
+	if apr.AppPatch == nil {
+		return errors.New("missing required AppPatch fields")
+	}
+
+	return nil
+}
+
+func (sr *scopesRequest) Bind(_ *http.Request) error {
+	return nil
+}
+
+// renderTagged function renders app with its entity tag.
+func renderTagged(w http.ResponseWriter, r *http.Request, status int, app *apps.App) {
+	res := newAppResponse(app)
+	etag, err := helpers.ETag(res)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(status)
+	render.Render(w, r, res)
+}
+
+func withError(errs helpers.ValidationErrors, field string, message string) helpers.ValidationErrors {
+	if errs == nil {
+		errs = helpers.ValidationErrors{}
+	}
+
+	errs[field] = message
+
+	return errs
+}
+
 func newAppResponse(app *apps.App) *appResponse {
 	return &appResponse{
-		App: app,
+		ID:                     app.ID,
+		Service:                app.Service,
+		Fingerprint:            app.Fingerprint,
+		CallbackURL:            app.CallbackURL,
+		Scopes:                 app.Scopes,
+		Environment:            app.Environment,
+		Issuer:                 app.Issuer,
+		AuthParams:             app.AuthParams,
+		Expiry:                 app.Expiry,
+		CreatedAt:              app.CreatedAt,
+		Status:                 app.Status,
+		AuthMethod:             app.AuthMethod,
+		SigningKeyID:           app.SigningKeyID,
+		OfflineAccess:          app.OfflineAccess,
+		Weight:                 app.Weight,
+		Tenant:                 app.Tenant,
+		PreviousPasswordExpiry: app.PreviousPasswordExpiry,
 	}
 }
 
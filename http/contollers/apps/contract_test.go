@@ -0,0 +1,223 @@
+package apps
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zetkolink/auth/cache"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/storage/storagetest"
+	"github.com/go-chi/chi"
+)
+
+var appColumnNames = []string{"id", "service", "password",
+	"password_fingerprint", "callback_URL", "scopes", "environment",
+	"issuer", "auth_params", "expiry", "created_at", "auth_method",
+	"signing_key", "signing_key_id", "offline_access", "status", "weight",
+	"tenant", "previous_password", "previous_password_expiry"}
+
+// storedApp answers app select by id with enabled app of google.
+func storedApp(args []driver.Value) storagetest.Result {
+	return storagetest.Result{
+		Columns: appColumnNames,
+		Rows: [][]driver.Value{{args[0], apps.Google, "secret", "fingerprint",
+			"", `{"openid"}`, "", "", []byte("{}"), nil,
+			time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			apps.AuthMethodSecret, "", "", false, apps.StatusEnable, int64(1),
+			"", "", nil}},
+	}
+}
+
+func newServer(t *testing.T, db *storagetest.DB) *httptest.Server {
+	t.Helper()
+
+	appsModel, err := apps.NewModel(apps.ModelConfig{
+		Db:    db.DB,
+		Cache: cache.NewMemory(0),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := NewController(ModelSet{Apps: appsModel})
+
+	r := chi.NewRouter()
+	r.Mount("/apps", controller.NewRouter())
+	r.Mount("/admin/apps", controller.NewAdminRouter())
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// TestContract runs documented requests against in-process server and
+// checks status codes and response shapes clients rely on: app fields
+// without secrets, ETag of admin reads, {"error"} of failures and
+// {"errors"} naming field of validation failures.
+func TestContract(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		script func(db *storagetest.DB)
+
+		status  int
+		fields  []string
+		absent  []string
+		invalid string
+		header  string
+	}{
+		{
+			name: "get app by id", method: http.MethodGet,
+			path: "/admin/apps/app-1",
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.apps`, storedApp)
+			},
+			status: http.StatusOK,
+			fields: []string{"id", "service", "password_fingerprint",
+				"callback_URL", "scopes", "status", "auth_method"},
+			absent: []string{"password", "signing_key", "previous_password"},
+			header: "ETag",
+		},
+		{
+			name: "get missing app by id", method: http.MethodGet,
+			path: "/admin/apps/app-1",
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.apps`, storagetest.Rows(appColumnNames))
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "list invalid status", method: http.MethodGet,
+			path:   "/apps/?status=unknown",
+			status: http.StatusUnprocessableEntity, invalid: "status",
+		},
+		{
+			name: "list invalid page", method: http.MethodGet,
+			path:   "/apps/?per_page=-1",
+			status: http.StatusUnprocessableEntity, invalid: "per_page",
+		},
+		{
+			name: "create malformed", method: http.MethodPost,
+			path: "/apps/google/", body: `{"scopes":`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "create invalid status", method: http.MethodPost,
+			path: "/apps/google/", body: `{"password":"secret","status":"unknown"}`,
+			status: http.StatusUnprocessableEntity, invalid: "status",
+		},
+		{
+			name: "put without service", method: http.MethodPut,
+			path: "/admin/apps/app-1", body: `{"password":"secret","status":"enable"}`,
+			status: http.StatusUnprocessableEntity, invalid: "service",
+		},
+		{
+			name: "update invalid callback", method: http.MethodPatch,
+			path: "/admin/apps/app-1", body: `{"callback_URL":"ftp://example.com"}`,
+			status: http.StatusUnprocessableEntity, invalid: "callback_URL",
+		},
+		{
+			name: "delete invalid cascade", method: http.MethodDelete,
+			path:   "/admin/apps/app-1?cascade=maybe",
+			status: http.StatusUnprocessableEntity, invalid: "cascade",
+		},
+		{
+			name: "auth code url invalid user", method: http.MethodGet,
+			path:   "/apps/google/someone",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storagetest.New()
+
+			if tt.script != nil {
+				tt.script(db)
+			}
+
+			srv := newServer(t, db)
+
+			var body io.Reader
+
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, body)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			resp, err := srv.Client().Do(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+
+			if tt.header != "" && resp.Header.Get(tt.header) == "" {
+				t.Errorf("%s header not set", tt.header)
+			}
+
+			var payload map[string]interface{}
+
+			err = json.NewDecoder(resp.Body).Decode(&payload)
+
+			if err != nil {
+				t.Fatalf("response is not JSON object: %s", err)
+			}
+
+			if tt.invalid != "" {
+				errs, _ := payload["errors"].(map[string]interface{})
+
+				if _, ok := errs[tt.invalid]; !ok {
+					t.Errorf("validation errors %v do not name %s", payload,
+						tt.invalid)
+				}
+
+				return
+			}
+
+			if tt.status >= http.StatusBadRequest {
+				if msg, _ := payload["error"].(string); msg == "" {
+					t.Errorf("response %v carries no error", payload)
+				}
+
+				return
+			}
+
+			for _, field := range tt.fields {
+				if _, ok := payload[field]; !ok {
+					t.Errorf("response %v lacks %s", payload, field)
+				}
+			}
+
+			for _, field := range tt.absent {
+				if _, ok := payload[field]; ok {
+					t.Errorf("response %v exposes %s", payload, field)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package apps
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const defaultPerPage = 100
+
+type migrationRequest struct {
+	Service string `json:"service" validate:"required"`
+	ToAppID string `json:"to_app_id" validate:"required"`
+}
+
+type migrationResponse struct {
+	*apps.Migration
+}
+
+// reissueResponse type represents user whose refresh token was minted
+// under old client and must be reissued by connecting again.
+type reissueResponse struct {
+	UserID      int       `json:"user_id"`
+	AppID       string    `json:"app_id"`
+	Refreshable bool      `json:"refreshable"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StartMigration handler moves service to new app.
+func (c *Controller) StartMigration(w http.ResponseWriter, r *http.Request) {
+	payload := &migrationRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	migration, err := c.models.Apps.StartMigration(r.Context(),
+		payload.Service, payload.ToAppID)
+
+	if err != nil {
+		switch err {
+		case apps.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case apps.ErrMigrating, apps.ErrSameApp:
+			helpers.Conflict(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.Render(w, r, &migrationResponse{Migration: migration})
+}
+
+// GetMigration handler renders migration of service under way.
+func (c *Controller) GetMigration(w http.ResponseWriter, r *http.Request) {
+	migration, err := c.models.Apps.Migration(r.Context(),
+		chi.URLParam(r, "service"))
+
+	if err != nil {
+		if err == apps.ErrNoMigration {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.Render(w, r, &migrationResponse{Migration: migration})
+}
+
+// FinishMigration handler stops accepting codes issued to old client.
+func (c *Controller) FinishMigration(w http.ResponseWriter, r *http.Request) {
+	err := c.models.Apps.FinishMigration(r.Context(),
+		chi.URLParam(r, "service"))
+
+	if err != nil {
+		if err == apps.ErrNoMigration {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MigrationReport handler renders page of users whose tokens were minted
+// under client other than migration target, so their refresh tokens must
+// be reissued before old client is removed. X-Total carries their number.
+func (c *Controller) MigrationReport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	migration, err := c.models.Apps.Migration(ctx, chi.URLParam(r, "service"))
+
+	if err != nil {
+		if err == apps.ErrNoMigration {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	p := ctx.Value(helpers.PaginatorContextKey).(*helpers.Paginator)
+
+	if p.PerPage == 0 {
+		p.PerPage = defaultPerPage
+	}
+
+	list, err := c.models.Tokens.ListByService(ctx, tokens.ServiceFilter{
+		Service:    migration.Service,
+		ExcludeApp: migration.ToAppID,
+	}, p)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, token := range list {
+		res = append(res, &reissueResponse{
+			UserID:      token.UserID,
+			AppID:       token.AppID,
+			Refreshable: token.Refreshable(),
+			CreatedAt:   token.CreatedAt,
+		})
+	}
+
+	p.SetHeaders(w, r)
+	render.RenderList(w, r, res)
+}
+
+func (mr *migrationRequest) Bind(_ *http.Request) error {
+	return nil
+}
+
+func (mr *migrationResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (rr *reissueResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package apps
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// secretRequest type represents new client secret of app. Grace is the
+// time in seconds replaced secret stays valid for, configured one when
+// omitted.
+type secretRequest struct {
+	Password string `json:"password" validate:"required"`
+	Grace    int    `json:"grace" validate:"min=0,max=2592000"`
+}
+
+type secretsResponse struct {
+	*apps.Secrets
+}
+
+// RotateSecret handler replaces client secret of app keeping the old one
+// valid for grace window.
+func (c *Controller) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	payload := &secretRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	app, err := c.models.Apps.RotateSecret(r.Context(),
+		chi.URLParam(r, "appID"), payload.Password,
+		time.Duration(payload.Grace)*time.Second)
+
+	if err != nil {
+		switch err {
+		case apps.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case apps.ErrNoSecret:
+			helpers.Conflict(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	renderTagged(w, r, http.StatusOK, app)
+}
+
+// RevealSecrets handler renders client secrets of app in plain.
+func (c *Controller) RevealSecrets(w http.ResponseWriter, r *http.Request) {
+	secrets, err := c.models.Apps.RevealSecrets(r.Context(),
+		chi.URLParam(r, "appID"))
+
+	if err != nil {
+		if err == apps.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	render.Render(w, r, &secretsResponse{Secrets: secrets})
+}
+
+func (sr *secretRequest) Bind(_ *http.Request) error {
+	return nil
+}
+
+func (sr *secretsResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+package apps
+
+import (
+	"net/http"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+type verificationResponse struct {
+	*apps.Verification
+}
+
+// Verify handler checks app credentials and callback URL at provider.
+func (c *Controller) Verify(w http.ResponseWriter, r *http.Request) {
+	v, err := c.models.Apps.Verify(r.Context(), chi.URLParam(r, "appID"))
+
+	if err != nil {
+		switch err {
+		case apps.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case apps.ErrVerification:
+			helpers.BadGateway(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	render.Render(w, r, &verificationResponse{Verification: v})
+}
+
+func (vr *verificationResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
@@ -0,0 +1,124 @@
+package exports
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Zetkolink/auth/exports"
+	"github.com/Zetkolink/auth/http/helpers"
+	exportsmodel "github.com/Zetkolink/auth/models/exports"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Exports *exportsmodel.Model
+	Runner  *exports.Runner
+}
+
+type jobRequest struct {
+	*exportsmodel.Job
+}
+
+type jobResponse struct {
+	*exportsmodel.Job
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Post("/", c.Create)
+	r.Get("/{jobID}", c.Get)
+
+	return r
+}
+
+// Create handler queues new export job.
+func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
+	payload := &jobRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload.Job, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	job, err := c.models.Exports.Create(r.Context(), payload.Job)
+
+	if err != nil {
+		if err == exportsmodel.ErrUserRequired {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"params.user_id": err.Error(),
+			})
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	render.Render(w, r, &jobResponse{Job: job})
+}
+
+// Get handler renders job status with download URL once done.
+func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
+	job, err := c.models.Exports.Get(r.Context(), chi.URLParam(r, "jobID"))
+
+	if err != nil {
+		if err == exportsmodel.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	resp := &jobResponse{Job: job}
+
+	if job.Status == exportsmodel.StatusDone {
+		resp.DownloadURL, err = c.models.Runner.URL(job)
+
+		if err != nil {
+			helpers.InternalServerError(w, r, err)
+			return
+		}
+	}
+
+	render.Render(w, r, resp)
+}
+
+func (jr *jobResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (jr *jobRequest) Bind(_ *http.Request) error {
+	if jr.Job == nil {
+		return errors.New("missing required Job field")
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package identities
+
+import (
+	"net/http"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/identities"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Identities *identities.Model
+}
+
+type identityResponse struct {
+	*identities.Identity
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/{userID}", c.ListByUser)
+
+	return r
+}
+
+// ListByUser handler renders external accounts behind tokens of user,
+// as fetched from provider profile endpoints at connect.
+func (c *Controller) ListByUser(w http.ResponseWriter, r *http.Request) {
+	list, err := c.models.Identities.ListByUser(r.Context(),
+		chi.URLParam(r, "userID"))
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, identity := range list {
+		res = append(res, &identityResponse{Identity: identity})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+func (ir *identityResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
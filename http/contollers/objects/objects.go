@@ -0,0 +1,78 @@
+package objects
+
+import (
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/objectstore"
+	"github.com/go-chi/chi"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Store objectstore.Store
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router serving signed object downloads.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/*", c.Download)
+
+	return r
+}
+
+// Download handler streams object of signed download URL.
+func (c *Controller) Download(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	q := r.URL.Query()
+
+	err := c.models.Store.Verify(key, q.Get("expires"), q.Get("signature"))
+
+	if err != nil {
+		switch err {
+		case objectstore.ErrSignature, objectstore.ErrExpired:
+			helpers.Forbidden(w, r)
+		case objectstore.ErrUnsigned:
+			helpers.NotFound(w, r, objectstore.ErrNotFound)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	body, err := c.models.Store.Open(r.Context(), key)
+
+	if err != nil {
+		if err == objectstore.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="`+path.Base(key)+`"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, body)
+}
@@ -0,0 +1,181 @@
+package operations
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	opsmodel "github.com/Zetkolink/auth/models/operations"
+	"github.com/Zetkolink/auth/operations"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Operations *opsmodel.Model
+	Runner     *operations.Runner
+}
+
+type operationRequest struct {
+	Kind   string          `json:"kind" validate:"required"`
+	Params json.RawMessage `json:"params"`
+}
+
+type operationResponse struct {
+	*opsmodel.Operation
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", c.List)
+	r.Post("/", c.Create)
+	r.Get("/{operationID}", c.Get)
+	r.Post("/{operationID}/cancel", c.Cancel)
+
+	return r
+}
+
+// List handler renders latest operations, of kind when requested.
+func (c *Controller) List(w http.ResponseWriter, r *http.Request) {
+	limit := defaultListLimit
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+
+		if err != nil || n <= 0 {
+			helpers.BadRequest(w, r, errors.New("limit must be positive integer"))
+			return
+		}
+
+		if n < maxListLimit {
+			limit = n
+		} else {
+			limit = maxListLimit
+		}
+	}
+
+	list, err := c.models.Operations.List(r.Context(),
+		r.URL.Query().Get("kind"), limit)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, op := range list {
+		res = append(res, &operationResponse{Operation: op})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// Create handler submits operation. Response points to operation
+// resource to poll.
+func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
+	payload := &operationRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	op, err := c.models.Runner.Submit(r.Context(), payload.Kind,
+		payload.Params)
+
+	if err != nil {
+		if err == operations.ErrUnknownKind {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"kind": err.Error(),
+			})
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+op.ID)
+	w.WriteHeader(http.StatusAccepted)
+	render.Render(w, r, &operationResponse{Operation: op})
+}
+
+// Get handler renders operation status and progress.
+func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
+	op, err := c.models.Operations.Get(r.Context(),
+		chi.URLParam(r, "operationID"))
+
+	if err != nil {
+		if err == opsmodel.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.Render(w, r, &operationResponse{Operation: op})
+}
+
+// Cancel handler requests cancel of operation. Running operation stops
+// shortly after, poll it for final status.
+func (c *Controller) Cancel(w http.ResponseWriter, r *http.Request) {
+	op, err := c.models.Operations.Cancel(r.Context(),
+		chi.URLParam(r, "operationID"))
+
+	if err != nil {
+		switch err {
+		case opsmodel.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case opsmodel.ErrFinished:
+			helpers.Conflict(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	render.Render(w, r, &operationResponse{Operation: op})
+}
+
+func (or *operationResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (or *operationRequest) Bind(_ *http.Request) error {
+	return nil
+}
@@ -0,0 +1,299 @@
+package providers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/providers"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const (
+	sourceBuiltin  = "builtin"
+	sourceRegistry = "registry"
+	sourceOIDC     = "oidc"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Apps      *apps.Model
+	Providers *providers.Model
+}
+
+// Service type represents supported service entry.
+type Service struct {
+	Service      string                  `json:"service"`
+	Source       string                  `json:"source"`
+	Scopes       []string                `json:"scopes"`
+	Capabilities *providers.Capabilities `json:"capabilities,omitempty"`
+	Enabled      bool                    `json:"enabled"`
+}
+
+type providerRequest struct {
+	*providers.Provider
+}
+
+type providerResponse struct {
+	*providers.Provider
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", c.List)
+	r.Get("/{service}", c.Get)
+	r.Put("/{service}", c.Save)
+	r.Delete("/{service}", c.Delete)
+
+	return r
+}
+
+// NewCatalogRouter method returns HTTP-router listing supported services.
+func (c *Controller) NewCatalogRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", c.Catalog)
+
+	return r
+}
+
+// Catalog handler renders services supported by deployment.
+func (c *Controller) Catalog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	enabled, err := c.models.Apps.EnabledServices(ctx)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	registry, err := c.models.Providers.List(ctx)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	services := make(map[string]*Service)
+
+	for _, service := range apps.Builtin {
+		caps := apps.BuiltinCapabilities(service)
+
+		services[service] = &Service{
+			Service:      service,
+			Source:       sourceBuiltin,
+			Scopes:       apps.DefaultScopes(service),
+			Capabilities: &caps,
+		}
+	}
+
+	for _, provider := range registry {
+		services[provider.Service] = &Service{
+			Service:      provider.Service,
+			Source:       sourceRegistry,
+			Scopes:       provider.Scopes,
+			Capabilities: &provider.Capabilities,
+		}
+	}
+
+	for service := range enabled {
+		if _, ok := services[service]; !ok {
+			services[service] = &Service{
+				Service: service,
+				Source:  sourceOIDC,
+			}
+		}
+
+		services[service].Enabled = true
+	}
+
+	list := make([]*Service, 0, len(services))
+
+	for _, service := range services {
+		if service.Scopes == nil {
+			service.Scopes = []string{}
+		}
+
+		list = append(list, service)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Service < list[j].Service
+	})
+
+	render.Respond(w, r, list)
+}
+
+// List handler renders registered providers.
+func (c *Controller) List(w http.ResponseWriter, r *http.Request) {
+	list, err := c.models.Providers.List(r.Context())
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.RenderList(w, r, newProviderListResponse(list))
+}
+
+// Get handler renders provider.
+func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
+	provider, err := c.models.Providers.Get(r.Context(),
+		chi.URLParam(r, "service"))
+
+	if err != nil {
+		if err == providers.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	renderTagged(w, r, provider)
+}
+
+// Save handler creates or replaces provider. If-Match and If-None-Match
+// are checked against stored provider.
+func (c *Controller) Save(w http.ResponseWriter, r *http.Request) {
+	payload := &providerRequest{
+		Provider: &providers.Provider{
+			Capabilities: providers.DefaultCapabilities,
+		},
+	}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	provider := payload.Provider
+	provider.Service = chi.URLParam(r, "service")
+
+	errs := helpers.ValidateStruct(provider, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	current, err := c.models.Providers.Get(ctx, provider.Service)
+
+	if err != nil && err != providers.ErrNotFound {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	etag := ""
+
+	if current != nil {
+		etag, err = helpers.ETag(newProviderResponse(current))
+
+		if err != nil {
+			helpers.InternalServerError(w, r, err)
+			return
+		}
+	}
+
+	err = helpers.CheckPreconditions(r, etag)
+
+	if err != nil {
+		helpers.PreconditionFailed(w, r, err)
+		return
+	}
+
+	err = c.models.Providers.Save(ctx, provider)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	provider, err = c.models.Providers.Get(ctx, provider.Service)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	renderTagged(w, r, provider)
+}
+
+// Delete handler removes provider.
+func (c *Controller) Delete(w http.ResponseWriter, r *http.Request) {
+	err := c.models.Providers.Delete(r.Context(), chi.URLParam(r, "service"))
+
+	if err != nil {
+		if err == providers.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renderTagged function renders provider with its entity tag.
+func renderTagged(w http.ResponseWriter, r *http.Request, provider *providers.Provider) {
+	res := newProviderResponse(provider)
+	etag, err := helpers.ETag(res)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	render.Render(w, r, res)
+}
+
+func (prs *providerResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (prq *providerRequest) Bind(_ *http.Request) error {
+	if prq.Provider == nil {
+		return errors.New("missing required Provider field")
+	}
+
+	return nil
+}
+
+func newProviderResponse(provider *providers.Provider) *providerResponse {
+	return &providerResponse{
+		Provider: provider,
+	}
+}
+
+func newProviderListResponse(list []*providers.Provider) []render.Renderer {
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, provider := range list {
+		res = append(res, newProviderResponse(provider))
+	}
+
+	return res
+}
@@ -0,0 +1,180 @@
+package providers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Zetkolink/auth/cache"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/providers"
+	"github.com/Zetkolink/auth/models/storage/storagetest"
+	"github.com/go-chi/chi"
+)
+
+var providerColumns = []string{"service", "auth_url", "token_url", "scopes",
+	"quirks", "supports_refresh", "supports_revoke", "pkce_required",
+	"supports_token_exchange", "revocation_url", "introspection_url",
+	"validation_url", "created_at"}
+
+func newCatalog(t *testing.T, db *storagetest.DB) http.Handler {
+	t.Helper()
+
+	c := cache.NewMemory(0)
+
+	providersModel, err := providers.NewModel(providers.ModelConfig{
+		Db:    db.DB,
+		Cache: c,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appsModel, err := apps.NewModel(apps.ModelConfig{
+		Db:        db.DB,
+		Providers: providersModel,
+		Cache:     c,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := NewController(ModelSet{
+		Apps:      appsModel,
+		Providers: providersModel,
+	})
+
+	r := chi.NewRouter()
+	r.Mount("/providers", controller.NewCatalogRouter())
+
+	return r
+}
+
+func TestCatalog(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	db := storagetest.New()
+	db.Handle(`SELECT DISTINCT "service"`, storagetest.Rows(
+		[]string{"service"},
+		[]driver.Value{apps.Google},
+		[]driver.Value{"acme"},
+		[]driver.Value{"keycloak"},
+	))
+	db.Handle(`FROM auth.providers`, storagetest.Rows(providerColumns,
+		[]driver.Value{"acme", "https://acme.example/auth",
+			"https://acme.example/token", `{"read"}`, "{}", true, false,
+			true, false, "", "", "", created},
+	))
+
+	w := httptest.NewRecorder()
+	newCatalog(t, db).ServeHTTP(w,
+		httptest.NewRequest(http.MethodGet, "/providers", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var list []Service
+
+	err := json.Unmarshal(w.Body.Bytes(), &list)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != len(apps.Builtin)+2 {
+		t.Fatalf("%d services listed, want %d", len(list), len(apps.Builtin)+2)
+	}
+
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Service >= list[i].Service {
+			t.Errorf("services not sorted: %q before %q",
+				list[i-1].Service, list[i].Service)
+		}
+	}
+
+	byService := make(map[string]Service, len(list))
+
+	for _, s := range list {
+		if s.Scopes == nil {
+			t.Errorf("scopes of %s rendered as null", s.Service)
+		}
+
+		byService[s.Service] = s
+	}
+
+	tests := []struct {
+		service string
+		source  string
+		enabled bool
+		pkce    bool
+		scopes  int
+	}{
+		{service: apps.Google, source: sourceBuiltin, enabled: true,
+			scopes: len(apps.DefaultScopes(apps.Google))},
+		{service: apps.Spotify, source: sourceBuiltin,
+			scopes: len(apps.DefaultScopes(apps.Spotify))},
+		{service: "acme", source: sourceRegistry, enabled: true, pkce: true, scopes: 1},
+		{service: "keycloak", source: sourceOIDC, enabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			s, ok := byService[tt.service]
+
+			if !ok {
+				t.Fatal("service not listed")
+			}
+
+			if s.Source != tt.source {
+				t.Errorf("source = %q, want %q", s.Source, tt.source)
+			}
+
+			if s.Enabled != tt.enabled {
+				t.Errorf("enabled = %v, want %v", s.Enabled, tt.enabled)
+			}
+
+			if len(s.Scopes) != tt.scopes {
+				t.Errorf("%d scopes, want %d", len(s.Scopes), tt.scopes)
+			}
+
+			if tt.source == sourceOIDC {
+				if s.Capabilities != nil {
+					t.Error("OIDC service lists capabilities")
+				}
+
+				return
+			}
+
+			if s.Capabilities == nil {
+				t.Fatal("capabilities missing")
+			}
+
+			if tt.source == sourceRegistry && s.Capabilities.PKCERequired != tt.pkce {
+				t.Errorf("pkce required = %v, want %v",
+					s.Capabilities.PKCERequired, tt.pkce)
+			}
+		})
+	}
+}
+
+func TestCatalogStorageFailure(t *testing.T) {
+	db := storagetest.New()
+	db.Handle(`SELECT DISTINCT "service"`, func([]driver.Value) storagetest.Result {
+		return storagetest.Result{Err: errors.New("connection refused")}
+	})
+
+	w := httptest.NewRecorder()
+	newCatalog(t, db).ServeHTTP(w,
+		httptest.NewRequest(http.MethodGet, "/providers", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,180 @@
+package push
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	pushmodel "github.com/Zetkolink/auth/models/push"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const deliveriesLimit = 100
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Push *pushmodel.Model
+}
+
+type targetRequest struct {
+	*pushmodel.Target
+}
+
+type targetResponse struct {
+	*pushmodel.Target
+}
+
+type deliveryResponse struct {
+	*pushmodel.Delivery
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/targets", c.Targets)
+	r.Post("/targets", c.CreateTarget)
+	r.Delete("/targets/{targetID}", c.DeleteTarget)
+	r.Get("/targets/{targetID}/deliveries", c.Deliveries)
+	r.Post("/deliveries/{deliveryID}/redeliver", c.Redeliver)
+
+	return r
+}
+
+// Targets handler renders push targets of tenant.
+func (c *Controller) Targets(w http.ResponseWriter, r *http.Request) {
+	list, err := c.models.Push.Targets(r.Context())
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, target := range list {
+		res = append(res, &targetResponse{Target: target})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// CreateTarget handler registers push target. Response carries target
+// secret, it is not shown again.
+func (c *Controller) CreateTarget(w http.ResponseWriter, r *http.Request) {
+	payload := &targetRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload.Target, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	target, err := c.models.Push.CreateTarget(r.Context(), payload.Target)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.Render(w, r, &targetResponse{Target: target})
+}
+
+// DeleteTarget handler removes push target.
+func (c *Controller) DeleteTarget(w http.ResponseWriter, r *http.Request) {
+	err := c.models.Push.DeleteTarget(r.Context(), chi.URLParam(r, "targetID"))
+
+	if err != nil {
+		if err == pushmodel.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries handler renders latest deliveries of push target.
+func (c *Controller) Deliveries(w http.ResponseWriter, r *http.Request) {
+	list, err := c.models.Push.Deliveries(r.Context(),
+		chi.URLParam(r, "targetID"), deliveriesLimit)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, delivery := range list {
+		res = append(res, &deliveryResponse{Delivery: delivery})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// Redeliver handler queues delivery again.
+func (c *Controller) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	delivery, err := c.models.Push.Redeliver(r.Context(), id)
+
+	if err != nil {
+		if err == pushmodel.ErrDeliveryNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	render.Render(w, r, &deliveryResponse{Delivery: delivery})
+}
+
+func (tr *targetResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (dr *deliveryResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (tr *targetRequest) Bind(_ *http.Request) error {
+	if tr.Target == nil {
+		return errors.New("missing required Target field")
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package retention
+
+import (
+	"net/http"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/retention"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Enforcer *retention.Enforcer
+}
+
+type reportResponse struct {
+	*retention.Report
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", c.Report)
+
+	return r
+}
+
+// Report handler renders number of tokens currently due under every
+// retention policy, deleted on next run unless in dry-run mode.
+func (c *Controller) Report(w http.ResponseWriter, r *http.Request) {
+	report, err := c.models.Enforcer.Report(r.Context())
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.Render(w, r, &reportResponse{report})
+}
+
+func (rr *reportResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
@@ -0,0 +1,142 @@
+package scripts
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/scripts"
+	"github.com/Zetkolink/auth/scripting"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Scripts *scripts.Model
+}
+
+type scriptRequest struct {
+	*scripts.Script
+}
+
+type scriptResponse struct {
+	*scripts.Script
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", c.List)
+	r.Post("/", c.Create)
+	r.Delete("/{scriptID}", c.Delete)
+
+	return r
+}
+
+// List handler renders scripts of tenant.
+func (c *Controller) List(w http.ResponseWriter, r *http.Request) {
+	list, err := c.models.Scripts.List(r.Context())
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.RenderList(w, r, newScriptListResponse(list))
+}
+
+// Create handler attaches script to event.
+func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
+	payload := &scriptRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload.Script, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	err = scripting.Check(payload.Source)
+
+	if err != nil {
+		helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+			"source": err.Error(),
+		})
+		return
+	}
+
+	script, err := c.models.Scripts.Create(r.Context(), payload.Script)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.Render(w, r, newScriptResponse(script))
+}
+
+// Delete handler removes script.
+func (c *Controller) Delete(w http.ResponseWriter, r *http.Request) {
+	err := c.models.Scripts.Delete(r.Context(), chi.URLParam(r, "scriptID"))
+
+	if err != nil {
+		if err == scripts.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sr *scriptResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (sr *scriptRequest) Bind(_ *http.Request) error {
+	if sr.Script == nil {
+		return errors.New("missing required Script field")
+	}
+
+	return nil
+}
+
+func newScriptResponse(script *scripts.Script) *scriptResponse {
+	return &scriptResponse{
+		Script: script,
+	}
+}
+
+func newScriptListResponse(list []*scripts.Script) []render.Renderer {
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, script := range list {
+		res = append(res, newScriptResponse(script))
+	}
+
+	return res
+}
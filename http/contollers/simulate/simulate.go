@@ -0,0 +1,100 @@
+package simulate
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const (
+	defaultLead = 600
+	horizon     = 24 * time.Hour
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Tokens *tokens.Model
+}
+
+type scheduleResponse struct {
+	*tokens.Schedule
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/refresh-schedule", c.RefreshSchedule)
+
+	return r
+}
+
+// RefreshSchedule handler previews refresh calls per hour over next 24
+// hours for service, were its tokens refreshed lead seconds before they
+// expire.
+func (c *Controller) RefreshSchedule(w http.ResponseWriter, r *http.Request) {
+	var errs = make(helpers.ValidationErrors)
+
+	service := r.FormValue("service")
+
+	if service == "" {
+		errs["service"] = "value is required"
+	}
+
+	lead := defaultLead
+
+	if v := r.FormValue("lead"); v != "" {
+		var err error
+
+		lead, err = strconv.Atoi(v)
+
+		if err != nil || lead < 0 {
+			errs["lead"] = "invalid value specified"
+		}
+	}
+
+	if len(errs) > 0 {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	schedule, err := c.models.Tokens.RefreshSchedule(r.Context(), service,
+		time.Duration(lead)*time.Second, horizon)
+
+	if err != nil {
+		switch err {
+		case apps.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case tokens.ErrRefreshUnsupported:
+			helpers.Conflict(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	render.Render(w, r, &scheduleResponse{Schedule: schedule})
+}
+
+func (sr *scheduleResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
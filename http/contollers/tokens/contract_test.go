@@ -0,0 +1,412 @@
+package tokens
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zetkolink/auth/models/storage/storagetest"
+	"github.com/Zetkolink/auth/models/tokenevents"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/go-chi/chi"
+)
+
+var tokenColumnNames = []string{"user_id", "token_type", "access_token",
+	"expiry", "refresh_token", "created_at", "service", "instance_url",
+	"extra", "access_token_fingerprint", "identity", "updated_at", "scopes",
+	"metadata", "app_id", "status", "refresh_failures", "refresh_error",
+	"refresh_retry_at", "use_count", "last_used_at", "account", "labels"}
+
+var eventColumnNames = []string{"id", "user_id", "service", "account", "kind",
+	"actor", "outcome", "error", "created_at"}
+
+var errStorage = errors.New("connection refused")
+
+// storedToken answers token select with token of account in path.
+func storedToken(args []driver.Value) storagetest.Result {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return storagetest.Result{
+		Columns: tokenColumnNames,
+		Rows: [][]driver.Value{{int64(1001), "Bearer", "access", created.Add(
+			24 * time.Hour * 365 * 100), "refresh", created, args[1], "",
+			[]byte("{}"), "fingerprint", nil, created, `{"openid"}`,
+			[]byte("{}"), "", tokens.StatusActive, int64(0), "", nil,
+			int64(0), nil, args[2], []byte("{}")}},
+	}
+}
+
+// storedEvents answers history queries with single event of account.
+func storedEvents(db *storagetest.DB) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	db.Handle(`SELECT count(*)`, storagetest.Rows([]string{"count"},
+		[]driver.Value{int64(1)}))
+	db.Handle(`FROM auth.token_events`, func(args []driver.Value) storagetest.Result {
+		return storagetest.Result{
+			Columns: eventColumnNames,
+			Rows: [][]driver.Value{{int64(1), int64(1001), args[1], args[4],
+				tokenevents.KindCreated, tokenevents.ActorUser,
+				tokenevents.OutcomeSucceeded, "", created}},
+		}
+	})
+}
+
+func failing(err error) storagetest.Handler {
+	return func([]driver.Value) storagetest.Result {
+		return storagetest.Result{Err: err}
+	}
+}
+
+func newServer(t *testing.T, db *storagetest.DB) *httptest.Server {
+	t.Helper()
+
+	tokensModel, err := tokens.NewModel(tokens.ModelConfig{Db: db.DB})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventsModel, err := tokenevents.NewModel(tokenevents.ModelConfig{Db: db.DB})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := NewController(ModelSet{
+		Tokens: tokensModel,
+		Events: eventsModel,
+	})
+
+	r := chi.NewRouter()
+	r.Mount("/tokens", controller.NewRouter())
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// TestContract runs documented requests against in-process server and
+// checks status codes and response shapes clients rely on: token fields,
+// {"error"} of failures, {"errors"} naming field of validation failures
+// and pagination headers.
+func TestContract(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		script func(db *storagetest.DB)
+
+		status  int
+		fields  []string
+		invalid string
+		header  string
+	}{
+		{
+			name: "get token", method: http.MethodGet,
+			path: "/tokens/1001/google",
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, storedToken)
+			},
+			status: http.StatusOK,
+			fields: []string{"user_id", "service", "access_token",
+				"refresh_token", "expiry", "token_type", "status",
+				"access_token_fingerprint", "scopes", "created_at",
+				"use_count"},
+		},
+		{
+			name: "get token of account", method: http.MethodGet,
+			path: "/tokens/1001/google/accounts/work",
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, storedToken)
+			},
+			status: http.StatusOK,
+			fields: []string{"user_id", "service", "account", "status"},
+		},
+		{
+			name: "get missing token", method: http.MethodGet,
+			path: "/tokens/1001/google",
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, storagetest.Rows(tokenColumnNames))
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "get token storage failure", method: http.MethodGet,
+			path: "/tokens/1001/google",
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, failing(errStorage))
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "token exists", method: http.MethodHead,
+			path: "/tokens/1001/google",
+			script: func(db *storagetest.DB) {
+				db.Handle(`SELECT EXISTS`, storagetest.Rows([]string{"exists"},
+					[]driver.Value{true}))
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "token does not exist", method: http.MethodHead,
+			path: "/tokens/1001/google/accounts/work",
+			script: func(db *storagetest.DB) {
+				db.Handle(`SELECT EXISTS`, storagetest.Rows([]string{"exists"},
+					[]driver.Value{false}))
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "lease invalid validity", method: http.MethodGet,
+			path:   "/tokens/1001/google/lease?min_validity=-1",
+			status: http.StatusUnprocessableEntity, invalid: "min_validity",
+		},
+		{
+			name: "usage invalid idle days", method: http.MethodGet,
+			path:   "/tokens/usage?idle_days=0",
+			status: http.StatusUnprocessableEntity, invalid: "idle_days",
+		},
+		{
+			name: "expiring invalid window", method: http.MethodGet,
+			path:   "/tokens/expiring?within=soon",
+			status: http.StatusUnprocessableEntity, invalid: "within",
+		},
+		{
+			name: "list by service invalid status", method: http.MethodGet,
+			path:   "/tokens/?service=google&status=unknown",
+			status: http.StatusUnprocessableEntity, invalid: "status",
+		},
+		{
+			name: "list by user invalid flag", method: http.MethodGet,
+			path:   "/tokens/1001?access_tokens=maybe",
+			status: http.StatusUnprocessableEntity, invalid: "access_tokens",
+		},
+		{
+			name: "callback without code", method: http.MethodGet,
+			path: "/tokens/?state=state", status: http.StatusBadRequest,
+		},
+		{
+			name: "callback without state", method: http.MethodGet,
+			path: "/tokens/?code=code", status: http.StatusBadRequest,
+		},
+		{
+			name: "batch malformed", method: http.MethodPost,
+			path: "/tokens/batch", body: `{"tokens":`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "batch empty", method: http.MethodPost,
+			path: "/tokens/batch", body: `{"tokens":[]}`,
+			status: http.StatusUnprocessableEntity, invalid: "tokens",
+		},
+		{
+			name: "bulk refresh invalid limit", method: http.MethodPost,
+			path: "/tokens/refresh", body: `{"limit":5000}`,
+			status: http.StatusUnprocessableEntity, invalid: "limit",
+		},
+		{
+			name: "history", method: http.MethodGet,
+			path: "/tokens/1001/google/history", script: storedEvents,
+			status: http.StatusOK,
+			fields: []string{"id", "user_id", "service", "kind", "actor",
+				"outcome", "created_at"},
+			header: "X-Total",
+		},
+		{
+			name: "history of account", method: http.MethodGet,
+			path: "/tokens/1001/google/accounts/work/history", script: storedEvents,
+			status: http.StatusOK,
+			fields: []string{"account", "kind"},
+			header: "X-Total",
+		},
+		{
+			name: "history invalid page", method: http.MethodGet,
+			path:   "/tokens/1001/google/history?page=-1",
+			status: http.StatusUnprocessableEntity, invalid: "page",
+		},
+		{
+			name: "delete token", method: http.MethodDelete,
+			path: "/tokens/1001/google",
+			script: func(db *storagetest.DB) {
+				db.Handle(`DELETE`, storagetest.Affected(1))
+				db.Handle(`FROM auth.tokens`, storedToken)
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "delete missing token", method: http.MethodDelete,
+			path: "/tokens/1001/google/accounts/work",
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, storagetest.Rows(tokenColumnNames))
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "delete invalid revoke", method: http.MethodDelete,
+			path:   "/tokens/1001/google?revoke=maybe",
+			status: http.StatusUnprocessableEntity, invalid: "revoke",
+		},
+		{
+			name: "verify matching token", method: http.MethodPost,
+			path: "/tokens/1001/google/verify", body: `{"access_token":"access"}`,
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, storedToken)
+			},
+			status: http.StatusOK,
+			fields: []string{"valid", "expiry"},
+		},
+		{
+			name: "verify other token", method: http.MethodPost,
+			path: "/tokens/1001/google/verify", body: `{"access_token":"other"}`,
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, storedToken)
+			},
+			status: http.StatusOK,
+			fields: []string{"valid", "reason"},
+		},
+		{
+			name: "verify missing token", method: http.MethodPost,
+			path: "/tokens/1001/google/accounts/work/verify",
+			body: `{"access_token":"access"}`,
+			script: func(db *storagetest.DB) {
+				db.Handle(`FROM auth.tokens`, storagetest.Rows(tokenColumnNames))
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "verify without token", method: http.MethodPost,
+			path: "/tokens/1001/google/verify", body: `{}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "reauthorize without scopes", method: http.MethodPost,
+			path: "/tokens/1001/google/reauthorize", body: `{}`,
+			status: http.StatusUnprocessableEntity, invalid: "scopes",
+		},
+		{
+			name: "exchange malformed", method: http.MethodPost,
+			path: "/tokens/1001/google/exchange", body: `[]`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storagetest.New()
+
+			if tt.script != nil {
+				tt.script(db)
+			}
+
+			srv := newServer(t, db)
+
+			var body io.Reader
+
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, body)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			resp, err := srv.Client().Do(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+
+			if tt.header != "" && resp.Header.Get(tt.header) == "" {
+				t.Errorf("%s header not set", tt.header)
+			}
+
+			if tt.method == http.MethodHead || tt.status == http.StatusNoContent {
+				return
+			}
+
+			var payload interface{}
+
+			err = json.NewDecoder(resp.Body).Decode(&payload)
+
+			if err != nil {
+				t.Fatalf("response is not JSON: %s", err)
+			}
+
+			switch {
+			case tt.invalid != "":
+				checkValidationErrors(t, payload, tt.invalid)
+			case tt.status >= http.StatusBadRequest:
+				checkError(t, payload)
+			default:
+				checkFields(t, payload, tt.fields)
+			}
+		})
+	}
+}
+
+func checkError(t *testing.T, payload interface{}) {
+	t.Helper()
+
+	obj, _ := payload.(map[string]interface{})
+
+	if msg, _ := obj["error"].(string); msg == "" {
+		t.Errorf("response %v carries no error", payload)
+	}
+}
+
+func checkValidationErrors(t *testing.T, payload interface{}, field string) {
+	t.Helper()
+
+	obj, _ := payload.(map[string]interface{})
+	errs, _ := obj["errors"].(map[string]interface{})
+
+	if _, ok := errs[field]; !ok {
+		t.Errorf("validation errors %v do not name %s", payload, field)
+	}
+}
+
+// checkFields checks object, or first item of list, carries fields.
+func checkFields(t *testing.T, payload interface{}, fields []string) {
+	t.Helper()
+
+	if list, ok := payload.([]interface{}); ok {
+		if len(list) == 0 {
+			t.Fatal("empty list rendered")
+		}
+
+		payload = list[0]
+	}
+
+	obj, ok := payload.(map[string]interface{})
+
+	if !ok {
+		t.Fatalf("response %v is not object", payload)
+	}
+
+	for _, field := range fields {
+		if _, ok := obj[field]; !ok {
+			t.Errorf("response %v lacks %s", obj, field)
+		}
+	}
+}
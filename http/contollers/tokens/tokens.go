@@ -2,14 +2,41 @@ package tokens
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/exchanges"
+	"github.com/Zetkolink/auth/models/servicetokens"
+	"github.com/Zetkolink/auth/models/tokenevents"
 	"github.com/Zetkolink/auth/models/tokens"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 )
 
+const (
+	defaultPerPage = 100
+
+	// defaultLeaseValidity and maxLeaseValidity bound lease validity,
+	// in seconds.
+	defaultLeaseValidity = 60
+	maxLeaseValidity     = 86400
+
+	defaultIdleDays = 90
+	maxIdleDays     = 3650
+
+	defaultExpiringWithin = 24 * time.Hour
+	maxExpiringWithin     = 30 * 24 * time.Hour
+
+	defaultBulkRefreshWithin = time.Hour
+	defaultBulkRefreshLimit  = 100
+	bulkRefreshConcurrency   = 8
+)
+
 // Controller type represents HTTP-controller.
 type Controller struct {
 	models *ModelSet
@@ -17,11 +44,99 @@ type Controller struct {
 
 // ModelSet type represents model set.
 type ModelSet struct {
-	Tokens *tokens.Model
+	Tokens        *tokens.Model
+	ServiceTokens *servicetokens.Model
+	Events        *tokenevents.Model
 }
 
+// tokenResponse type represents token with its current lifecycle status.
 type tokenResponse struct {
 	*tokens.Token
+	Status string `json:"status"`
+}
+
+// accountResponse type represents connected service of user with its
+// current lifecycle status.
+type accountResponse struct {
+	*tokens.Token
+	Status      string `json:"status"`
+	Refreshable bool   `json:"refreshable"`
+}
+
+type batchRequest struct {
+	Tokens []tokens.Key `json:"tokens" validate:"required,min=1,max=100,dive"`
+}
+
+// batchItemResponse type represents single result of batch retrieval,
+// token or error reason.
+type batchItemResponse struct {
+	tokens.Key
+	Token *tokenResponse `json:"token,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+type bulkRefreshRequest struct {
+	Service string `json:"service" validate:"max=512"`
+	Within  string `json:"within"`
+	Limit   int    `json:"limit" validate:"min=0,max=1000"`
+}
+
+type bulkResultResponse struct {
+	*tokens.BulkResult
+}
+
+type serviceUsageResponse struct {
+	*tokens.ServiceUsage
+}
+
+type failingTokenResponse struct {
+	*tokens.FailingToken
+}
+
+type forgetResponse struct {
+	*tokens.ForgetResult
+}
+
+type eventResponse struct {
+	*tokenevents.Event
+}
+
+type serviceTokenResponse struct {
+	*servicetokens.Token
+}
+
+type exchangeRequest struct {
+	*tokens.ExchangeRequest
+}
+
+type derivedTokenResponse struct {
+	*tokens.DerivedToken
+}
+
+type introspectionResponse struct {
+	*tokens.Introspection
+}
+
+type validationResponse struct {
+	*tokens.Validation
+}
+
+type reauthorizeRequest struct {
+	Scopes []string `json:"scopes" validate:"required,max=64,unique,dive,required,max=512,excludesall=0x20"`
+}
+
+type reauthorizeResponse struct {
+	Url string `json:"url"`
+}
+
+type verifyRequest struct {
+	AccessToken string `json:"access_token"`
+}
+
+type verifyResponse struct {
+	Valid  bool       `json:"valid"`
+	Reason string     `json:"reason,omitempty"`
+	Expiry *time.Time `json:"expiry,omitempty"`
 }
 
 // NewController method creates new controller instance.
@@ -35,9 +150,58 @@ func NewController(models ModelSet) *Controller {
 func (c *Controller) NewRouter() chi.Router {
 	r := chi.NewRouter()
 
-	r.Get("/", c.Create)
+	create := helpers.Mutating(http.HandlerFunc(c.Create))
+	list := helpers.Paginate(http.HandlerFunc(c.ListByService))
+
+	// Connect callback and listing by service share the path, told
+	// apart by code parameter only the callback carries.
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") == "" && r.FormValue("service") != "" {
+			list.ServeHTTP(w, r)
+			return
+		}
+
+		create.ServeHTTP(w, r)
+	})
+	r.Post("/batch", c.Batch)
+	r.Post("/refresh", c.BulkRefresh)
+	r.Get("/usage", c.Usage)
+	r.With(helpers.Paginate).Get("/expiring", c.Expiring)
+	r.Post("/service/{service}", c.ServiceToken)
+	r.Get("/{userID}", c.ListByUser)
 	r.Get("/{userID}/{service}", c.Get)
+	r.Head("/{userID}/{service}", c.Exists)
+	r.Get("/{userID}/{service}/lease", c.Lease)
+	r.With(helpers.Paginate).Get("/{userID}/{service}/history", c.History)
 	r.Put("/{userID}/{service}", c.Refresh)
+	r.Delete("/{userID}/{service}", c.Delete)
+	r.Get("/{userID}/{service}/accounts/{account}", c.Get)
+	r.Head("/{userID}/{service}/accounts/{account}", c.Exists)
+	r.Put("/{userID}/{service}/accounts/{account}", c.Refresh)
+	r.Delete("/{userID}/{service}/accounts/{account}", c.Delete)
+	r.Get("/{userID}/{service}/accounts/{account}/lease", c.Lease)
+	r.With(helpers.Paginate).Get("/{userID}/{service}/accounts/{account}/history",
+		c.History)
+	r.Post("/{userID}/{service}/verify", c.Verify)
+	r.Post("/{userID}/{service}/exchange", c.Exchange)
+	r.Post("/{userID}/{service}/introspect", c.Introspect)
+	r.Post("/{userID}/{service}/validate", c.Validate)
+	r.Post("/{userID}/{service}/reauthorize", c.Reauthorize)
+	r.Post("/{userID}/{service}/accounts/{account}/verify", c.Verify)
+	r.Post("/{userID}/{service}/accounts/{account}/exchange", c.Exchange)
+	r.Post("/{userID}/{service}/accounts/{account}/introspect", c.Introspect)
+	r.Post("/{userID}/{service}/accounts/{account}/validate", c.Validate)
+	r.Post("/{userID}/{service}/accounts/{account}/reauthorize", c.Reauthorize)
+
+	return r
+}
+
+// NewUsersRouter method returns HTTP-router for operations on every token
+// of user.
+func (c *Controller) NewUsersRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Delete("/{userID}/tokens", c.Forget)
 
 	return r
 }
@@ -61,6 +225,22 @@ func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
 	_, err := c.models.Tokens.Create(r.Context(), code, state)
 
 	if err != nil {
+		switch err {
+		case exchanges.ErrReplayed:
+			helpers.Conflict(w, r, err)
+			return
+		case exchanges.ErrNotFound:
+			helpers.NotFound(w, r, err)
+			return
+		case tokens.ErrNoRefreshToken:
+			helpers.BadGateway(w, r, err)
+			return
+		}
+
+		if providerFailed(w, r, err) {
+			return
+		}
+
 		helpers.InternalServerError(w, r, err)
 		return
 	}
@@ -69,7 +249,8 @@ func (c *Controller) Create(w http.ResponseWriter, r *http.Request) {
 	render.Respond(w, r, "")
 }
 
-// Get handler renders returns token.
+// Get handler renders returns token, of default account of service or of
+// external account named in path.
 func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userID")
 
@@ -85,11 +266,42 @@ func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scopes, downscope, errs := scopeParams(r)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
 	ctx := r.Context()
-	token, err := c.models.Tokens.Get(ctx, userID, service)
+
+	var token *tokens.Token
+	var err error
+
+	if len(scopes) > 0 {
+		token, err = c.models.Tokens.GetScoped(ctx, userID, service,
+			chi.URLParam(r, "account"), scopes, downscope)
+	} else {
+		token, err = c.models.Tokens.Get(ctx, userID, service,
+			chi.URLParam(r, "account"))
+	}
 
 	if err != nil {
-		helpers.InternalServerError(w, r, err)
+		switch err {
+		case tokens.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case tokens.ErrInsufficientScope:
+			render.Render(w, r, helpers.NewErrorResponse(
+				http.StatusForbidden, err))
+		case tokens.ErrExchangeUnsupported, tokens.ErrSubjectUnavailable,
+			tokens.ErrExpired:
+			helpers.Conflict(w, r, err)
+		default:
+			if !providerFailed(w, r, err) {
+				helpers.InternalServerError(w, r, err)
+			}
+		}
+
 		return
 	}
 
@@ -101,6 +313,352 @@ func (c *Controller) Get(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, newTokenResponse(token))
 }
 
+// Exists handler answers with no content whether usable token exists,
+// 404 otherwise.
+func (c *Controller) Exists(w http.ResponseWriter, r *http.Request) {
+	exists, err := c.models.Tokens.Exists(r.Context(), chi.URLParam(r, "userID"),
+		chi.URLParam(r, "service"), chi.URLParam(r, "account"))
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	if !exists {
+		helpers.NotFound(w, r, tokens.ErrNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Usage handler renders usage of tokens by service. Tokens not read for
+// idle_days days are idle.
+func (c *Controller) Usage(w http.ResponseWriter, r *http.Request) {
+	days := defaultIdleDays
+
+	if v := r.FormValue("idle_days"); v != "" {
+		var err error
+
+		days, err = strconv.Atoi(v)
+
+		if err != nil || days < 1 || days > maxIdleDays {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"idle_days": "invalid value specified",
+			})
+			return
+		}
+	}
+
+	list, err := c.models.Tokens.UsageStats(r.Context(),
+		time.Duration(days)*24*time.Hour)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, u := range list {
+		res = append(res, &serviceUsageResponse{ServiceUsage: u})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// Expiring handler renders tokens of service, or of every service, whose
+// access token expires within given duration, 24h by default, while their
+// refresh is failing, so users can be asked to connect again in time.
+func (c *Controller) Expiring(w http.ResponseWriter, r *http.Request) {
+	within := defaultExpiringWithin
+
+	if v := r.FormValue("within"); v != "" {
+		var err error
+
+		within, err = time.ParseDuration(v)
+
+		if err != nil || within <= 0 || within > maxExpiringWithin {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"within": "invalid value specified",
+			})
+			return
+		}
+	}
+
+	p := r.Context().Value(helpers.PaginatorContextKey).(*helpers.Paginator)
+
+	if p.PerPage == 0 {
+		p.PerPage = defaultPerPage
+	}
+
+	list, err := c.models.Tokens.ListFailingExpiring(r.Context(),
+		r.FormValue("service"), within, p)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, t := range list {
+		res = append(res, &failingTokenResponse{FailingToken: t})
+	}
+
+	p.SetHeaders(w, r)
+	render.RenderList(w, r, res)
+}
+
+// Lease handler renders token whose access token stays valid for at
+// least min_validity seconds, refreshing it first when necessary.
+func (c *Controller) Lease(w http.ResponseWriter, r *http.Request) {
+	validity := defaultLeaseValidity
+
+	if v := r.FormValue("min_validity"); v != "" {
+		seconds, err := strconv.Atoi(v)
+
+		if err != nil || seconds < 0 || seconds > maxLeaseValidity {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"min_validity": "invalid value specified",
+			})
+			return
+		}
+
+		validity = seconds
+	}
+
+	token, err := c.models.Tokens.Lease(r.Context(),
+		chi.URLParam(r, "userID"), chi.URLParam(r, "service"),
+		chi.URLParam(r, "account"), time.Duration(validity)*time.Second)
+
+	if err != nil {
+		switch err {
+		case tokens.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case tokens.ErrSubjectUnavailable, tokens.ErrNotRefreshable,
+			tokens.ErrLeaseTooLong, tokens.ErrRefreshUnsupported:
+			helpers.Conflict(w, r, err)
+		case helpers.ErrReadOnly:
+			helpers.ServiceUnavailable(w, r, err)
+		default:
+			if !providerFailed(w, r, err) {
+				helpers.InternalServerError(w, r, err)
+			}
+		}
+
+		return
+	}
+
+	render.Render(w, r, newTokenResponse(token))
+}
+
+// Batch handler renders tokens of up to 100 user and service pairs, in
+// request order. Pairs without token carry error instead.
+func (c *Controller) Batch(w http.ResponseWriter, r *http.Request) {
+	payload := &batchRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	list, err := c.models.Tokens.GetBatch(r.Context(), payload.Tokens)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for i, token := range list {
+		item := &batchItemResponse{Key: payload.Tokens[i]}
+
+		if token == nil {
+			item.Error = tokens.ErrNotFound.Error()
+		} else {
+			item.Token = newTokenResponse(token)
+		}
+
+		res = append(res, item)
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// BulkRefresh handler refreshes tokens of service, or of every service,
+// whose access token expires within given duration, 1h by default, and
+// renders result of every refresh.
+func (c *Controller) BulkRefresh(w http.ResponseWriter, r *http.Request) {
+	payload := &bulkRefreshRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	within := defaultBulkRefreshWithin
+
+	if payload.Within != "" {
+		within, err = time.ParseDuration(payload.Within)
+
+		if err != nil || within <= 0 || within > maxExpiringWithin {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"within": "invalid value specified",
+			})
+			return
+		}
+	}
+
+	if payload.Limit == 0 {
+		payload.Limit = defaultBulkRefreshLimit
+	}
+
+	list, err := c.models.Tokens.RefreshExpiring(r.Context(), payload.Service,
+		within, payload.Limit, bulkRefreshConcurrency)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, result := range list {
+		res = append(res, &bulkResultResponse{BulkResult: result})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// scopeParams function parses comma separated scopes and downscope flag
+// of token request.
+func scopeParams(r *http.Request) ([]string, bool, helpers.ValidationErrors) {
+	scopes := strings.FieldsFunc(r.FormValue("scopes"), func(r rune) bool {
+		return r == ','
+	})
+
+	downscope := false
+
+	if v := r.FormValue("downscope"); v != "" {
+		var err error
+
+		downscope, err = strconv.ParseBool(v)
+
+		if err != nil {
+			return nil, false, helpers.ValidationErrors{
+				"downscope": "invalid value specified",
+			}
+		}
+	}
+
+	if downscope && len(scopes) == 0 {
+		return nil, false, helpers.ValidationErrors{
+			"scopes": "required for downscope",
+		}
+	}
+
+	return scopes, downscope, nil
+}
+
+// ListByUser handler renders services user connected, filtered by labels
+// (label=key:value, repeated). Access tokens are included with
+// access_tokens=true, refresh tokens never.
+func (c *Controller) ListByUser(w http.ResponseWriter, r *http.Request) {
+	withAccessTokens := false
+
+	if v := r.FormValue("access_tokens"); v != "" {
+		var err error
+
+		withAccessTokens, err = strconv.ParseBool(v)
+
+		if err != nil {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"access_tokens": "invalid value specified",
+			})
+			return
+		}
+	}
+
+	labels, err := helpers.ParseLabels(r.URL.Query()["label"])
+
+	if err != nil {
+		helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+			"label": "invalid value specified",
+		})
+		return
+	}
+
+	list, err := c.models.Tokens.ListByUser(r.Context(),
+		chi.URLParam(r, "userID"), labels, withAccessTokens)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, token := range list {
+		res = append(res, newAccountResponse(token))
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// ListByService handler renders page of users connected to service,
+// without access and refresh tokens. Tokens may be filtered by expiry
+// window (expires_from, expires_to), creation time range (created_from,
+// created_to), lifecycle status (status) and labels (label=key:value,
+// repeated).
+func (c *Controller) ListByService(w http.ResponseWriter, r *http.Request) {
+	filter, errs := decodeServiceFilter(r)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	p := r.Context().Value(helpers.PaginatorContextKey).(*helpers.Paginator)
+
+	if p.PerPage == 0 {
+		p.PerPage = defaultPerPage
+	}
+
+	list, err := c.models.Tokens.ListByService(r.Context(), filter, p)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, token := range list {
+		res = append(res, newAccountResponse(token))
+	}
+
+	p.SetHeaders(w, r)
+	render.RenderList(w, r, res)
+}
+
 // Refresh handler refresh token.
 func (c *Controller) Refresh(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userID")
@@ -118,9 +676,24 @@ func (c *Controller) Refresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
-	token, err := c.models.Tokens.Refresh(ctx, userID, service)
+	token, err := c.models.Tokens.ManualRefresh(ctx, userID, service,
+		chi.URLParam(r, "account"))
 
 	if err != nil {
+		if err == tokens.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		if err == tokens.ErrRefreshUnsupported {
+			helpers.Conflict(w, r, err)
+			return
+		}
+
+		if providerFailed(w, r, err) {
+			return
+		}
+
 		helpers.InternalServerError(w, r, err)
 		return
 	}
@@ -133,12 +706,433 @@ func (c *Controller) Refresh(w http.ResponseWriter, r *http.Request) {
 	render.Render(w, r, newTokenResponse(token))
 }
 
+// History handler renders lifecycle events of token of external account,
+// latest first. Events outlive token, so history of deleted token is
+// rendered too.
+func (c *Controller) History(w http.ResponseWriter, r *http.Request) {
+	p := r.Context().Value(helpers.PaginatorContextKey).(*helpers.Paginator)
+
+	if p.PerPage == 0 {
+		p.PerPage = defaultPerPage
+	}
+
+	list, err := c.models.Events.ListByToken(r.Context(),
+		chi.URLParam(r, "userID"), chi.URLParam(r, "service"),
+		chi.URLParam(r, "account"), p)
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, event := range list {
+		res = append(res, &eventResponse{Event: event})
+	}
+
+	p.SetHeaders(w, r)
+	render.RenderList(w, r, res)
+}
+
+// ServiceToken handler renders client credentials token of service app.
+func (c *Controller) ServiceToken(w http.ResponseWriter, r *http.Request) {
+	token, err := c.models.ServiceTokens.Get(r.Context(),
+		chi.URLParam(r, "service"))
+
+	if err != nil {
+		if err == apps.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.Render(w, r, &serviceTokenResponse{Token: token})
+}
+
+// Delete handler removes token, revoking it at provider when revoke=true.
+func (c *Controller) Delete(w http.ResponseWriter, r *http.Request) {
+	revoke := false
+
+	if v := r.FormValue("revoke"); v != "" {
+		var err error
+
+		revoke, err = strconv.ParseBool(v)
+
+		if err != nil {
+			helpers.ValidationFailed(w, r, helpers.ValidationErrors{
+				"revoke": "invalid value specified",
+			})
+			return
+		}
+	}
+
+	err := c.models.Tokens.Delete(r.Context(), chi.URLParam(r, "userID"),
+		chi.URLParam(r, "service"), chi.URLParam(r, "account"), revoke)
+
+	if err != nil {
+		switch err {
+		case tokens.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case tokens.ErrRevokeUnsupported, tokens.ErrSubjectUnavailable:
+			helpers.Conflict(w, r, err)
+		case tokens.ErrRevocationFailed:
+			helpers.BadGateway(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Forget handler revokes and deletes every token of user along with their
+// exchanges, rendering tokens provider could not revoke.
+func (c *Controller) Forget(w http.ResponseWriter, r *http.Request) {
+	res, err := c.models.Tokens.Forget(r.Context(), chi.URLParam(r, "userID"))
+
+	if err != nil {
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.Render(w, r, &forgetResponse{res})
+}
+
+// Exchange handler renders token derived from stored one (RFC 8693).
+func (c *Controller) Exchange(w http.ResponseWriter, r *http.Request) {
+	payload := &exchangeRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	if account := chi.URLParam(r, "account"); account != "" {
+		payload.Account = account
+	}
+
+	token, err := c.models.Tokens.Exchange(r.Context(),
+		chi.URLParam(r, "userID"), chi.URLParam(r, "service"),
+		*payload.ExchangeRequest)
+
+	if err != nil {
+		switch err {
+		case tokens.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case tokens.ErrExchangeUnsupported, tokens.ErrSubjectUnavailable,
+			tokens.ErrExpired:
+			helpers.Conflict(w, r, err)
+		default:
+			if !providerFailed(w, r, err) {
+				helpers.InternalServerError(w, r, err)
+			}
+		}
+
+		return
+	}
+
+	render.Render(w, r, &derivedTokenResponse{DerivedToken: token})
+}
+
+// Introspect handler renders provider view of stored access token
+// (RFC 7662).
+func (c *Controller) Introspect(w http.ResponseWriter, r *http.Request) {
+	result, err := c.models.Tokens.Introspect(r.Context(),
+		chi.URLParam(r, "userID"), chi.URLParam(r, "service"),
+		chi.URLParam(r, "account"))
+
+	if err != nil {
+		switch err {
+		case tokens.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case tokens.ErrIntrospectUnsupported, tokens.ErrSubjectUnavailable:
+			helpers.Conflict(w, r, err)
+		case tokens.ErrIntrospectionFailed:
+			helpers.BadGateway(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	render.Render(w, r, &introspectionResponse{Introspection: result})
+}
+
+// Validate handler renders whether provider still accepts stored access
+// token.
+func (c *Controller) Validate(w http.ResponseWriter, r *http.Request) {
+	result, err := c.models.Tokens.Validate(r.Context(),
+		chi.URLParam(r, "userID"), chi.URLParam(r, "service"),
+		chi.URLParam(r, "account"))
+
+	if err != nil {
+		switch err {
+		case tokens.ErrNotFound:
+			helpers.NotFound(w, r, err)
+		case tokens.ErrValidateUnsupported, tokens.ErrSubjectUnavailable:
+			helpers.Conflict(w, r, err)
+		case tokens.ErrValidationFailed:
+			helpers.BadGateway(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	render.Render(w, r, &validationResponse{Validation: result})
+}
+
+// Reauthorize handler renders auth code url adding scopes to stored
+// grant.
+func (c *Controller) Reauthorize(w http.ResponseWriter, r *http.Request) {
+	payload := &reauthorizeRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	errs := helpers.ValidateStruct(payload, nil)
+
+	if errs != nil {
+		helpers.ValidationFailed(w, r, errs)
+		return
+	}
+
+	url, err := c.models.Tokens.Reauthorize(r.Context(),
+		chi.URLParam(r, "userID"), chi.URLParam(r, "service"),
+		chi.URLParam(r, "account"), payload.Scopes)
+
+	if err != nil {
+		if err == tokens.ErrNotFound {
+			helpers.NotFound(w, r, err)
+			return
+		}
+
+		helpers.InternalServerError(w, r, err)
+		return
+	}
+
+	render.Render(w, r, &reauthorizeResponse{Url: url})
+}
+
+// Verify handler checks access token presented by client.
+func (c *Controller) Verify(w http.ResponseWriter, r *http.Request) {
+	payload := &verifyRequest{}
+	err := render.Bind(r, payload)
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	token, err := c.models.Tokens.Verify(r.Context(),
+		chi.URLParam(r, "userID"), chi.URLParam(r, "service"),
+		chi.URLParam(r, "account"), payload.AccessToken)
+
+	switch err {
+	case nil:
+		render.Render(w, r, &verifyResponse{
+			Valid:  true,
+			Expiry: &token.Expiry,
+		})
+	case tokens.ErrMismatch, tokens.ErrExpired:
+		render.Render(w, r, &verifyResponse{Reason: err.Error()})
+	case tokens.ErrNotFound:
+		helpers.NotFound(w, r, err)
+	default:
+		helpers.InternalServerError(w, r, err)
+	}
+}
+
 func (prs *tokenResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
 	return nil
 }
 
+func (ar *accountResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (str *serviceTokenResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (er *exchangeRequest) Bind(_ *http.Request) error {
+	if er.ExchangeRequest == nil {
+		return errors.New("missing required ExchangeRequest fields")
+	}
+
+	return nil
+}
+
+func (dtr *derivedTokenResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (ir *introspectionResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (vr *validationResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (rr *reauthorizeRequest) Bind(_ *http.Request) error {
+	return nil
+}
+
+func (rr *reauthorizeResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (vr *verifyRequest) Bind(_ *http.Request) error {
+	if vr.AccessToken == "" {
+		return errors.New("access_token not specified")
+	}
+
+	return nil
+}
+
+func (vr *verifyResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (br *batchRequest) Bind(_ *http.Request) error {
+	return nil
+}
+
+func (brr *bulkRefreshRequest) Bind(_ *http.Request) error {
+	return nil
+}
+
+func (brr *bulkResultResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (bir *batchItemResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (sur *serviceUsageResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (ftr *failingTokenResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (er *eventResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (fr *forgetResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// providerFailed function renders classified provider failure by its
+// class and reports whether err was one: dead grant requires user to
+// authorize app again, rejected app is provider side misconfiguration,
+// the rest may be retried.
+func providerFailed(w http.ResponseWriter, r *http.Request, err error) bool {
+	var re *tokens.RefreshError
+
+	if !errors.As(err, &re) {
+		return false
+	}
+
+	switch {
+	case re.ReauthRequired():
+		helpers.Gone(w, r, errors.New("reauthorization required: "+re.Error()))
+	case re.Class == tokens.FailureClient:
+		helpers.BadGateway(w, r, re)
+	default:
+		if re.RetryAfter > 0 {
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(math.Ceil(re.RetryAfter.Seconds()))))
+		}
+
+		helpers.ServiceUnavailable(w, r, re)
+	}
+
+	return true
+}
+
 func newTokenResponse(token *tokens.Token) *tokenResponse {
 	return &tokenResponse{
-		Token: token,
+		Token:  token,
+		Status: token.CurrentStatus(),
+	}
+}
+
+func decodeServiceFilter(r *http.Request) (tokens.ServiceFilter, helpers.ValidationErrors) {
+	var errs = make(helpers.ValidationErrors)
+
+	filter := tokens.ServiceFilter{
+		Service: r.FormValue("service"),
+		Status:  r.FormValue("status"),
+	}
+
+	switch filter.Status {
+	case "", tokens.StatusActive, tokens.StatusExpired,
+		tokens.StatusRevoked, tokens.StatusRefreshFailed, tokens.StatusDead:
+	default:
+		errs["status"] = "invalid value specified"
+	}
+
+	bounds := map[string]**time.Time{
+		"expires_from":  &filter.ExpiresFrom,
+		"expires_to":    &filter.ExpiresTo,
+		"created_from":  &filter.CreatedFrom,
+		"created_to":    &filter.CreatedTo,
+		"unused_before": &filter.UnusedBefore,
+	}
+
+	labels, err := helpers.ParseLabels(r.URL.Query()["label"])
+
+	if err != nil {
+		errs["label"] = "invalid value specified"
+	}
+
+	filter.Labels = labels
+
+	for param, bound := range bounds {
+		v := r.FormValue(param)
+
+		if v == "" {
+			continue
+		}
+
+		date, err := helpers.ParseDate(v)
+
+		if err != nil {
+			errs[param] = "invalid value specified"
+			continue
+		}
+
+		*bound = &date
+	}
+
+	if len(errs) > 0 {
+		return filter, errs
+	}
+
+	return filter, nil
+}
+
+func newAccountResponse(token *tokens.Token) *accountResponse {
+	return &accountResponse{
+		Token:       token,
+		Status:      token.CurrentStatus(),
+		Refreshable: token.Refreshable(),
 	}
 }
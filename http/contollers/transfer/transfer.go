@@ -0,0 +1,102 @@
+package transfer
+
+import (
+	"net/http"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Tokens *tokens.Model
+}
+
+type importResponse struct {
+	*tokens.TransferResult
+}
+
+// archiveWriter type represents response writer reporting whether
+// archive started.
+type archiveWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/tokens", c.Export)
+	r.Post("/tokens", c.Import)
+
+	return r
+}
+
+// Export handler streams archive of every stored token sealed with
+// transfer key.
+func (c *Controller) Export(w http.ResponseWriter, r *http.Request) {
+	if !c.models.Tokens.TransferEnabled() {
+		helpers.Conflict(w, r, tokens.ErrTransferDisabled)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition",
+		`attachment; filename="tokens.archive"`)
+
+	cw := &archiveWriter{ResponseWriter: w}
+	_, err := c.models.Tokens.Export(r.Context(), cw)
+
+	if err != nil {
+		if !cw.written {
+			helpers.InternalServerError(w, r, err)
+			return
+		}
+
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// Import handler stores tokens of archive posted as request body.
+func (c *Controller) Import(w http.ResponseWriter, r *http.Request) {
+	res, err := c.models.Tokens.Import(r.Context(), r.Body)
+
+	if err != nil {
+		switch err {
+		case tokens.ErrTransferDisabled:
+			helpers.Conflict(w, r, err)
+		case tokens.ErrArchive:
+			helpers.BadRequest(w, r, err)
+		default:
+			helpers.InternalServerError(w, r, err)
+		}
+
+		return
+	}
+
+	render.Render(w, r, &importResponse{TransferResult: res})
+}
+
+func (ir *importResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (cw *archiveWriter) Write(p []byte) (int, error) {
+	cw.written = true
+	return cw.ResponseWriter.Write(p)
+}
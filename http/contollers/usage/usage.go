@@ -0,0 +1,78 @@
+package usage
+
+import (
+	"net/http"
+
+	"github.com/Zetkolink/auth/deprecation"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// Controller type represents HTTP-controller.
+type Controller struct {
+	models *ModelSet
+}
+
+// ModelSet type represents model set.
+type ModelSet struct {
+	Tracker *deprecation.Tracker
+}
+
+type usageResponse struct {
+	*deprecation.Usage
+}
+
+type deprecationResponse struct {
+	*deprecation.Deprecation
+}
+
+// NewController method creates new controller instance.
+func NewController(models ModelSet) *Controller {
+	return &Controller{
+		models: &models,
+	}
+}
+
+// NewRouter method returns HTTP-router for controller.
+func (c *Controller) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", c.List)
+	r.Get("/deprecations", c.Deprecations)
+
+	return r
+}
+
+// List handler renders invocations of every route called since this
+// instance started, by API version and client.
+func (c *Controller) List(w http.ResponseWriter, r *http.Request) {
+	list := c.models.Tracker.Usage()
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, u := range list {
+		res = append(res, &usageResponse{Usage: u})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+// Deprecations handler renders deprecated routes with clients still
+// calling them on this instance.
+func (c *Controller) Deprecations(w http.ResponseWriter, r *http.Request) {
+	list := c.models.Tracker.Deprecations()
+	res := make([]render.Renderer, 0, len(list))
+
+	for _, d := range list {
+		res = append(res, &deprecationResponse{Deprecation: d})
+	}
+
+	render.RenderList(w, r, res)
+}
+
+func (ur *usageResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (dr *deprecationResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
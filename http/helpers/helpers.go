@@ -3,13 +3,21 @@ package helpers
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
 
+	"github.com/Zetkolink/auth/proxyproto"
 	"github.com/go-chi/render"
 	"gopkg.in/go-playground/mold.v2/modifiers"
 	"gopkg.in/go-playground/validator.v9"
@@ -22,9 +30,27 @@ const (
 	// RFC339Short short version of time.RFC339.
 	RFC339Short = "2006-01-02"
 
+	// TenantHeader is the request header carrying tenant identifier.
+	TenantHeader = "X-Tenant"
+
+	// ForwardedForHeader is the request header listing proxied client
+	// addresses.
+	ForwardedForHeader = "X-Forwarded-For"
+
+	// RoleAdmin is the role of requests allowed to admin routes.
+	RoleAdmin = "admin"
+
+	// AdminKeyHeader is the request header carrying admin API key.
+	AdminKeyHeader = "X-Admin-Key"
+
 	defaultSchema = "http"
 	defaultPage   = 1
 	maxPerPage    = 1000
+	flushEvery    = 100
+
+	maxLabels           = 16
+	maxLabelKeyLength   = 63
+	maxLabelValueLength = 256
 
 	chars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
@@ -38,6 +64,12 @@ var (
 
 	// UserRoleContextKey is context key for role.
 	UserRoleContextKey = &contextKey{"userRole"}
+
+	// TenantContextKey is context key for tenant.
+	TenantContextKey = &contextKey{"tenant"}
+
+	// ClientIPContextKey is context key for client address.
+	ClientIPContextKey = &contextKey{"clientIP"}
 )
 
 var (
@@ -50,13 +82,36 @@ var (
 		http.MethodPut:    {},
 		http.MethodDelete: {},
 	}
+
+	mutatingHTTPMethods = map[string]struct{}{
+		http.MethodPost:   {},
+		http.MethodPut:    {},
+		http.MethodPatch:  {},
+		http.MethodDelete: {},
+	}
+
+	readOnly int32
+
+	// ErrReadOnly service is in read-only mode.
+	ErrReadOnly = errors.New("service is in read-only mode")
+
+	// ErrLabel label is not key:value pair of valid key and value, or
+	// too many labels specified.
+	ErrLabel = errors.New("invalid label")
+
+	// ErrPrecondition If-Match or If-None-Match precondition failed.
+	ErrPrecondition = errors.New("precondition failed")
 )
 
-// Paginator type represents paginator.
+// Paginator type represents paginator. With SkipCount set the total is not
+// counted: models fetch Fetch rows and report them with Trim, which sets
+// HasMore instead.
 type Paginator struct {
-	Total   int
-	PerPage int
-	Page    int
+	Total     int
+	PerPage   int
+	Page      int
+	SkipCount bool
+	HasMore   bool
 }
 
 // ErrorResponse type represents error response.
@@ -74,8 +129,9 @@ type ValidationErrorsResponse struct {
 }
 
 type paginateForm struct {
-	Page    int
-	PerPage int
+	Page      int
+	PerPage   int
+	SkipCount bool
 }
 
 type contextKey struct {
@@ -119,7 +175,7 @@ func AccessController(roles ...string) func(http.Handler) http.Handler {
 					allowed = true
 				}
 			} else if _, ok := protectedHTTPMethods[r.Method]; ok {
-				if role == "admin" {
+				if role == RoleAdmin {
 					allowed = true
 				}
 			} else {
@@ -147,6 +203,210 @@ func GetUserRole(r *http.Request) string {
 	return ""
 }
 
+// Role is a middleware for assigning role to requests of listener.
+func Role(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), UserRoleContextKey, role)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			},
+		)
+	}
+}
+
+// KeyRole is a middleware for assigning role to requests carrying key
+// in AdminKeyHeader whose sha256 is hash. Other requests keep no role.
+func KeyRole(role string, hash []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				key := r.Header.Get(AdminKeyHeader)
+				sum := sha256.Sum256([]byte(key))
+
+				if key != "" && subtle.ConstantTimeCompare(sum[:], hash) == 1 {
+					ctx := context.WithValue(r.Context(), UserRoleContextKey, role)
+					r = r.WithContext(ctx)
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// Tenant is a middleware for resolving request tenant.
+func Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			tenant := r.Header.Get(TenantHeader)
+
+			if tenant != "" {
+				ctx := context.WithValue(r.Context(), TenantContextKey, tenant)
+				r = r.WithContext(ctx)
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+// GetTenant method returns request tenant.
+func GetTenant(ctx context.Context) string {
+	if tenant, ok := ctx.Value(TenantContextKey).(string); ok {
+		return tenant
+	}
+
+	return ""
+}
+
+// ClientIP is a middleware for resolving client address. Behind trusted
+// proxies X-Forwarded-For is walked from the right, the first address
+// not belonging to trusted proxy is the client.
+func ClientIP(trusted proxyproto.Networks) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ip := remoteIP(r.RemoteAddr)
+
+				if ip != nil && trusted.Contains(ip) {
+					ip = forwardedIP(r.Header.Values(ForwardedForHeader),
+						ip, trusted)
+				}
+
+				if ip != nil {
+					ctx := context.WithValue(r.Context(),
+						ClientIPContextKey, ip.String())
+					r = r.WithContext(ctx)
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// BasePath is a middleware serving API under base path other than
+// APIPathSuffix, e.g. behind path-rewriting proxy. Paths of API version
+// under base path are rewritten to APIPathSuffix, so routes, metrics and
+// admin scope keep their paths. Base path "/" serves API at root.
+func BasePath(base string, apiVersion string) func(http.Handler) http.Handler {
+	base = strings.TrimSuffix(base, "/")
+
+	return func(next http.Handler) http.Handler {
+		if base == APIPathSuffix {
+			return next
+		}
+
+		prefix := base + "/" + apiVersion
+
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				path := r.URL.Path
+
+				if path == prefix || strings.HasPrefix(path, prefix+"/") {
+					r.URL.Path = APIPathSuffix + strings.TrimPrefix(path, base)
+
+					if r.URL.RawPath != "" {
+						r.URL.RawPath = APIPathSuffix +
+							strings.TrimPrefix(r.URL.RawPath, base)
+					}
+				}
+
+				next.ServeHTTP(w, r)
+			},
+		)
+	}
+}
+
+// GetClientIP method returns request client address.
+func GetClientIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(ClientIPContextKey).(string); ok {
+		return ip
+	}
+
+	return ""
+}
+
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		host = addr
+	}
+
+	return net.ParseIP(host)
+}
+
+func forwardedIP(headers []string, ip net.IP, trusted proxyproto.Networks) net.IP {
+	var hops []string
+
+	for _, header := range headers {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+
+		if hop == nil {
+			break
+		}
+
+		ip = hop
+
+		if !trusted.Contains(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// SetReadOnly method switches read-only mode.
+func SetReadOnly(enabled bool) {
+	var v int32
+
+	if enabled {
+		v = 1
+	}
+
+	atomic.StoreInt32(&readOnly, v)
+}
+
+// IsReadOnly method reports whether read-only mode is on.
+func IsReadOnly() bool {
+	return atomic.LoadInt32(&readOnly) == 1
+}
+
+// ReadOnly is a middleware rejecting mutating methods in read-only mode.
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := mutatingHTTPMethods[r.Method]; ok && IsReadOnly() {
+				ServiceUnavailable(w, r, ErrReadOnly)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
+// Mutating is a middleware rejecting any request in read-only mode. It is
+// meant for safe-method routes which nevertheless write state.
+func Mutating(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if IsReadOnly() {
+				ServiceUnavailable(w, r, ErrReadOnly)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		},
+	)
+}
+
 // Paginate is a middleware for pagination.
 func Paginate(next http.Handler) http.Handler {
 	return http.HandlerFunc(
@@ -164,8 +424,9 @@ func Paginate(next http.Handler) http.Handler {
 				PaginatorContextKey,
 
 				&Paginator{
-					PerPage: form.PerPage,
-					Page:    form.Page,
+					PerPage:   form.PerPage,
+					Page:      form.Page,
+					SkipCount: form.SkipCount,
 				},
 			)
 
@@ -287,6 +548,126 @@ func InternalServerError(w http.ResponseWriter, r *http.Request, err error) {
 		errors.New(err.Error())))
 }
 
+// StreamJSON method renders JSON array incrementally, item by item.
+// Items are produced by each via emit and flushed to client in chunks, so
+// the whole list is never buffered in memory. An error before the first item
+// renders error with status code 500, an error afterwards aborts the response.
+func StreamJSON(w http.ResponseWriter, r *http.Request,
+	each func(emit func(v interface{}) error) error) {
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	count := 0
+
+	emit := func(v interface{}) error {
+		delim := ","
+
+		if count == 0 {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			delim = "["
+		}
+
+		if _, err := w.Write([]byte(delim)); err != nil {
+			return err
+		}
+
+		count++
+
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+
+		if flusher != nil && count%flushEvery == 0 {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	err := each(emit)
+
+	if err != nil {
+		if count == 0 {
+			InternalServerError(w, r, err)
+			return
+		}
+
+		panic(http.ErrAbortHandler)
+	}
+
+	if count == 0 {
+		render.JSON(w, r, []struct{}{})
+		return
+	}
+
+	_, _ = w.Write([]byte("]"))
+}
+
+// Gone method renders error with status code 410.
+func Gone(w http.ResponseWriter, r *http.Request, err error) {
+	render.Render(w, r, NewErrorResponse(http.StatusGone, err))
+}
+
+// BadGateway method renders error with status code 502.
+func BadGateway(w http.ResponseWriter, r *http.Request, err error) {
+	render.Render(w, r, NewErrorResponse(http.StatusBadGateway, err))
+}
+
+// PreconditionFailed method renders error with status code 412.
+func PreconditionFailed(w http.ResponseWriter, r *http.Request, err error) {
+	render.Render(w, r, NewErrorResponse(http.StatusPreconditionFailed, err))
+}
+
+// ETag function returns strong entity tag of JSON representation of v.
+func ETag(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// CheckPreconditions function checks If-Match and If-None-Match headers
+// of request against entity tag of current resource, empty when resource
+// does not exist. Returns ErrPrecondition when either fails.
+func CheckPreconditions(r *http.Request, etag string) error {
+	if v := r.Header.Get("If-Match"); v != "" {
+		if etag == "" || !matchETag(v, etag) {
+			return ErrPrecondition
+		}
+	}
+
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		if etag != "" && matchETag(v, etag) {
+			return ErrPrecondition
+		}
+	}
+
+	return nil
+}
+
+func matchETag(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServiceUnavailable method renders error with status code 503.
+func ServiceUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	render.Render(w, r, NewErrorResponse(http.StatusServiceUnavailable, err))
+}
+
 // ParseDate function is a helper for parsing input date.
 func ParseDate(s string) (time.Time, error) {
 	date, err := time.Parse(RFC339Short, s)
@@ -302,6 +683,40 @@ func ParseDate(s string) (time.Time, error) {
 	return date, nil
 }
 
+// ParseLabels function parses labels given as key:value pairs. Keys are
+// lowercase letters, digits, dots, dashes and underscores; values carry
+// no control characters.
+func ParseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	if len(values) > maxLabels {
+		return nil, ErrLabel
+	}
+
+	labels := make(map[string]string, len(values))
+
+	for _, v := range values {
+		i := strings.IndexByte(v, ':')
+
+		if i <= 0 || i > maxLabelKeyLength || len(v)-i-1 > maxLabelValueLength {
+			return nil, ErrLabel
+		}
+
+		key, value := v[:i], v[i+1:]
+
+		if strings.Trim(key, "abcdefghijklmnopqrstuvwxyz0123456789._-") != "" ||
+			strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return nil, ErrLabel
+		}
+
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
 func decodePaginateForm(r *http.Request, form *paginateForm) ValidationErrors {
 	var errs = make(ValidationErrors)
 
@@ -337,6 +752,18 @@ func decodePaginateForm(r *http.Request, form *paginateForm) ValidationErrors {
 		form.PerPage = maxPerPage
 	}
 
+	count := r.FormValue("count")
+
+	if count != "" {
+		withCount, err := strconv.ParseBool(count)
+
+		if err != nil {
+			errs["count"] = "invalid value specified"
+		}
+
+		form.SkipCount = !withCount
+	}
+
 	if len(errs) > 0 {
 		return errs
 	}
@@ -354,8 +781,35 @@ func (p *Paginator) Limit() int {
 	return p.PerPage
 }
 
+// Fetch method returns number of rows to fetch. Without count one extra
+// row is fetched to learn whether there are more.
+func (p *Paginator) Fetch() int {
+	if p.SkipCount && p.PerPage > 0 {
+		return p.PerPage + 1
+	}
+
+	return p.PerPage
+}
+
+// Trim method records whether fetched rows exceed page and returns number
+// of rows to keep.
+func (p *Paginator) Trim(fetched int) int {
+	p.HasMore = p.PerPage > 0 && fetched > p.PerPage
+
+	if p.HasMore {
+		return p.PerPage
+	}
+
+	return fetched
+}
+
 // SetHeaders method sets paginator headers.
-func (p *Paginator) SetHeaders(w http.ResponseWriter, _ *http.Request) {
+func (p *Paginator) SetHeaders(w http.ResponseWriter, r *http.Request) {
+	if p.SkipCount {
+		p.setUncountedHeaders(w, r)
+		return
+	}
+
 	totalPages := p.Total / p.PerPage
 	if p.Total%p.PerPage > 0 {
 		totalPages++
@@ -378,6 +832,21 @@ func (p *Paginator) SetHeaders(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+func (p *Paginator) setUncountedHeaders(w http.ResponseWriter, _ *http.Request) {
+	headers := w.Header()
+	headers.Add("X-Has-More", strconv.FormatBool(p.HasMore))
+	headers.Add("X-Per-Page", strconv.Itoa(p.PerPage))
+	headers.Add("X-Page", strconv.Itoa(p.Page))
+
+	if p.Page > 1 {
+		headers.Add("X-Prev-Page", strconv.Itoa(p.Page-1))
+	}
+
+	if p.HasMore {
+		headers.Add("X-Next-Page", strconv.Itoa(p.Page+1))
+	}
+}
+
 func (k *contextKey) String() string {
 	return "go/subs/http context value " + k.name
 }
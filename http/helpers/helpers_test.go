@@ -0,0 +1,49 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKeyRoleAccess(t *testing.T) {
+	sum := sha256.Sum256([]byte("admin-key"))
+
+	tests := []struct {
+		name string
+		hash []byte
+		key  string
+		want int
+	}{
+		{name: "right key", hash: sum[:], key: "admin-key", want: http.StatusOK},
+		{name: "wrong key", hash: sum[:], key: "guess", want: http.StatusForbidden},
+		{name: "no key", hash: sum[:], want: http.StatusForbidden},
+		{name: "no key configured", key: "admin-key", want: http.StatusForbidden},
+		{name: "empty key of no key configured", want: http.StatusForbidden},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := KeyRole(RoleAdmin, tt.hash)(
+				AccessController(RoleAdmin)(ok))
+
+			r := httptest.NewRequest(http.MethodGet, "/admin/apps/app-1/secret", nil)
+
+			if tt.key != "" {
+				r.Header.Set(AdminKeyHeader, tt.key)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
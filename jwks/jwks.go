@@ -0,0 +1,277 @@
+// Package jwks fetches and caches JSON Web Key Sets.
+//
+// Providers publish keys signing their id_tokens at jwks_uri and rotate
+// them without notice. Cache keeps each key set for TTL and refetches it
+// early when a token names an unknown key id, at most once per
+// MinRefresh, so forged kids cannot make it hammer the provider. Keys are
+// shared by id_token verification and anything else checking JWTs.
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTTL        = 3600
+	defaultMinRefresh = 60
+	defaultTimeout    = 10 * time.Second
+)
+
+var (
+	// ErrKeyNotFound signing key is not published by provider.
+	ErrKeyNotFound = errors.New("jwks signing key not found")
+)
+
+// Config type represents key cache configuration. Durations are in
+// seconds.
+type Config struct {
+	TTL        int
+	MinRefresh int `yaml:"minRefresh"`
+}
+
+// JSONWebKey type represents public key of JWK set.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Set type represents decoded signing keys of key set by id.
+type Set struct {
+	Keys      map[string]crypto.PublicKey
+	FetchedAt time.Time
+}
+
+// Cache type represents caching key set client.
+type Cache struct {
+	client     *http.Client
+	ttl        time.Duration
+	minRefresh time.Duration
+	mu         sync.Mutex
+	sets       map[string]*Set
+}
+
+// NewCache method creates new cache instance. Nil client gets one with
+// default timeout.
+func NewCache(config Config, client *http.Client) *Cache {
+	if config.TTL <= 0 {
+		config.TTL = defaultTTL
+	}
+
+	if config.MinRefresh <= 0 {
+		config.MinRefresh = defaultMinRefresh
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+
+	return &Cache{
+		client:     client,
+		ttl:        time.Duration(config.TTL) * time.Second,
+		minRefresh: time.Duration(config.MinRefresh) * time.Second,
+		sets:       make(map[string]*Set),
+	}
+}
+
+// Keys method returns key set published at uri, cached for TTL.
+func (c *Cache) Keys(ctx context.Context, uri string) (*Set, error) {
+	c.mu.Lock()
+	set, ok := c.sets[uri]
+	c.mu.Unlock()
+
+	if ok && time.Since(set.FetchedAt) < c.ttl {
+		return set, nil
+	}
+
+	return c.refresh(ctx, uri)
+}
+
+// Key method returns signing key by id. Unknown id refetches key set,
+// unless it was fetched within MinRefresh.
+func (c *Cache) Key(ctx context.Context, uri string, kid string) (crypto.PublicKey, error) {
+	set, err := c.Keys(ctx, uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := set.Lookup(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(set.FetchedAt) < c.minRefresh {
+		return nil, ErrKeyNotFound
+	}
+
+	set, err = c.refresh(ctx, uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := set.Lookup(kid)
+
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+// Lookup method returns key by id. Empty id matches the only key of set.
+func (s *Set) Lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := s.Keys[kid]; ok {
+		return key, true
+	}
+
+	if kid == "" && len(s.Keys) == 1 {
+		for _, key := range s.Keys {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+func (c *Cache) refresh(ctx context.Context, uri string) (*Set, error) {
+	set, err := c.fetch(ctx, uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.sets[uri] = set
+	c.mu.Unlock()
+
+	return set, nil
+}
+
+func (c *Cache) fetch(ctx context.Context, uri string) (*Set, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks %s: status %d", uri, resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	set := &Set{
+		Keys:      make(map[string]crypto.PublicKey),
+		FetchedAt: time.Now(),
+	}
+
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+
+		if err != nil {
+			continue
+		}
+
+		set.Keys[jwk.Kid] = key
+	}
+
+	return set, nil
+}
+
+// PublicKey method decodes RSA or EC public key.
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeInt(k.E)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeInt(k.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeInt(k.Y)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
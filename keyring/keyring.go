@@ -0,0 +1,160 @@
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	prefix  = "enc:v1:"
+	keySize = 32
+)
+
+var (
+	// ErrKey value is sealed with key missing from keyring.
+	ErrKey = errors.New("encryption key not found")
+
+	// ErrKeySize key is not 32 bytes of base64.
+	ErrKeySize = errors.New("encryption key must be 32 bytes")
+
+	// ErrPrimary primary key is not in keyring.
+	ErrPrimary = errors.New("primary encryption key not found")
+
+	// ErrCiphertext sealed value is malformed.
+	ErrCiphertext = errors.New("malformed ciphertext")
+)
+
+// Config type represents keyring configuration. Keys are base64 encoded
+// AES-256 keys by id, Primary is the id new values are sealed with.
+type Config struct {
+	Primary string
+	Keys    map[string]string
+}
+
+// Keyring type represents set of AES-GCM keys. Retired keys stay in the
+// keyring to open values sealed before rotation.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// New method creates new keyring instance. Keyring without keys stores
+// values as is.
+func New(config Config) (*Keyring, error) {
+	k := &Keyring{
+		primary: config.Primary,
+		aeads:   make(map[string]cipher.AEAD),
+	}
+
+	for id, encoded := range config.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+
+		if err != nil || len(key) != keySize {
+			return nil, ErrKeySize
+		}
+
+		block, err := aes.NewCipher(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		k.aeads[id], err = cipher.NewGCM(block)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(k.aeads) == 0 {
+		return k, nil
+	}
+
+	if _, ok := k.aeads[k.primary]; !ok {
+		return nil, ErrPrimary
+	}
+
+	return k, nil
+}
+
+// Enabled method reports whether keyring seals values.
+func (k *Keyring) Enabled() bool {
+	return k != nil && len(k.aeads) > 0
+}
+
+// Seal method encrypts value with primary key.
+func (k *Keyring) Seal(value string) (string, error) {
+	if !k.Enabled() || value == "" {
+		return value, nil
+	}
+
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(k.primary))
+
+	return prefix + k.primary + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open method decrypts sealed value. Values stored before encryption was
+// enabled are returned as is.
+func (k *Keyring) Open(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 2)
+
+	if len(parts) != 2 {
+		return "", ErrCiphertext
+	}
+
+	if k == nil {
+		return "", ErrKey
+	}
+
+	aead, ok := k.aeads[parts[0]]
+
+	if !ok {
+		return "", ErrKey
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrCiphertext
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()],
+		sealed[aead.NonceSize():], []byte(parts[0]))
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+// Current method reports whether value is stored the way Seal would store
+// it now: sealed with primary key, or as is when keyring is disabled.
+// Empty values are always current.
+func (k *Keyring) Current(value string) bool {
+	if value == "" {
+		return true
+	}
+
+	if !k.Enabled() {
+		return !strings.HasPrefix(value, prefix)
+	}
+
+	return strings.HasPrefix(value, prefix+k.primary+":")
+}
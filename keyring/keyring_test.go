@@ -0,0 +1,159 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), keySize)))
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		err    error
+	}{
+		{name: "empty", config: Config{}},
+		{name: "valid", config: Config{Primary: "k1", Keys: map[string]string{"k1": key('a')}}},
+		{name: "short key", config: Config{Primary: "k1", Keys: map[string]string{"k1": "c2hvcnQ="}}, err: ErrKeySize},
+		{name: "not base64", config: Config{Primary: "k1", Keys: map[string]string{"k1": "%%%"}}, err: ErrKeySize},
+		{name: "missing primary", config: Config{Primary: "k2", Keys: map[string]string{"k1": key('a')}}, err: ErrPrimary},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+
+			if err != tt.err {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	enabled, err := New(Config{Primary: "k1", Keys: map[string]string{"k1": key('a')}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled, err := New(Config{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		ring   *Keyring
+		value  string
+		sealed bool
+	}{
+		{name: "enabled", ring: enabled, value: "client-secret", sealed: true},
+		{name: "enabled unicode", ring: enabled, value: "секрет", sealed: true},
+		{name: "enabled empty", ring: enabled, value: ""},
+		{name: "disabled", ring: disabled, value: "client-secret"},
+		{name: "nil", ring: nil, value: "client-secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := tt.ring.Seal(tt.value)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.HasPrefix(sealed, prefix); got != tt.sealed {
+				t.Errorf("sealed = %v, want %v", got, tt.sealed)
+			}
+
+			if tt.sealed && strings.Contains(sealed, tt.value) {
+				t.Error("sealed value holds plain one")
+			}
+
+			if !tt.ring.Current(sealed) {
+				t.Error("sealed value is not current")
+			}
+
+			opened, err := tt.ring.Open(sealed)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if opened != tt.value {
+				t.Errorf("opened %q, want %q", opened, tt.value)
+			}
+		})
+	}
+}
+
+func TestOpenRotated(t *testing.T) {
+	old, err := New(Config{Primary: "k1", Keys: map[string]string{"k1": key('a')}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := New(Config{Primary: "k2", Keys: map[string]string{
+		"k1": key('a'),
+		"k2": key('b'),
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := New(Config{Primary: "k1", Keys: map[string]string{"k1": key('c')}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := old.Seal("client-secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ring    *Keyring
+		value   string
+		want    string
+		current bool
+		err     bool
+	}{
+		{name: "retired key", ring: rotated, value: sealed, want: "client-secret"},
+		{name: "plain", ring: rotated, value: "client-secret", want: "client-secret"},
+		{name: "same id other key", ring: other, value: sealed, current: true, err: true},
+		{name: "nil keyring", ring: nil, value: sealed, err: true},
+		{name: "malformed", ring: rotated, value: prefix + "k1", err: true},
+		{name: "truncated", ring: rotated, value: prefix + "k1:AAAA", err: true},
+		{name: "unknown key", ring: rotated, value: prefix + "k9:AAAA", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened, err := tt.ring.Open(tt.value)
+
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+
+			if opened != tt.want {
+				t.Errorf("opened %q, want %q", opened, tt.want)
+			}
+
+			if tt.ring != nil && tt.value == sealed &&
+				tt.ring.Current(tt.value) != tt.current {
+				t.Errorf("current = %v, want %v", !tt.current, tt.current)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"io/ioutil"
 	"log"
 	"os"
@@ -13,9 +15,20 @@ import (
 var (
 	a   *auth
 	cfg *config
+
+	demoMode = flag.Bool("demo", false,
+		"apply migrations to empty database and serve mock provider as service \"demo\"")
+
+	exportTokens = flag.String("export-tokens", "",
+		"write archive of every token sealed with transfer key to file and exit")
+
+	importTokens = flag.String("import-tokens", "",
+		"import tokens from archive file sealed with transfer key and exit")
 )
 
 func init() {
+	flag.Parse()
+
 	confPath := os.Getenv("AUTH_CONFPATH")
 
 	if confPath == "" {
@@ -42,6 +55,17 @@ func init() {
 }
 
 func main() {
+	if *exportTokens != "" || *importTokens != "" {
+		err := transferTokens()
+		_ = destroySubs()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	err := a.Run()
 
 	if err != nil {
@@ -68,6 +92,56 @@ func listenSignals() {
 	}
 }
 
+// transferTokens function exports tokens to or imports them from archive
+// file named by flags.
+func transferTokens() error {
+	ctx := context.Background()
+
+	if *exportTokens != "" {
+		f, err := os.OpenFile(*exportTokens,
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+
+		if err != nil {
+			return err
+		}
+
+		n, err := a.models.Tokens.Export(ctx, f)
+
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		err = f.Close()
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("exported %d tokens to %s", n, *exportTokens)
+		return nil
+	}
+
+	f, err := os.Open(*importTokens)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	res, err := a.models.Tokens.Import(ctx, f)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("imported %d tokens from %s, %d skipped as older",
+		res.Imported, *importTokens, res.Skipped)
+
+	return nil
+}
+
 func initAuth() error {
 	if a != nil {
 		return nil
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// Namespace is the prefix of every exported metric.
+	Namespace = "auth"
+
+	// RequestsTotal is the name of HTTP requests counter.
+	RequestsTotal = Namespace + "_http_requests_total"
+
+	// RequestDuration is the name of HTTP request duration histogram.
+	RequestDuration = Namespace + "_http_request_duration_seconds"
+
+	// LabelRoute is the route pattern label.
+	LabelRoute = "route"
+
+	// LabelMethod is the HTTP method label.
+	LabelMethod = "method"
+
+	// LabelCode is the HTTP status code label.
+	LabelCode = "code"
+)
+
+var (
+	// Buckets is the set of request duration buckets.
+	Buckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// Registry is the registry of service metrics.
+	Registry = prometheus.NewRegistry()
+
+	// SLOs lists objectives tracked against exported metrics.
+	SLOs = []SLO{
+		{
+			Name:   "token_reads",
+			Method: http.MethodGet,
+			Route:  "/api/v1/tokens/{userID}/{service}",
+		},
+		{
+			Name:   "token_exchanges",
+			Method: http.MethodGet,
+			Route:  "/api/v1/tokens/",
+		},
+	}
+
+	requests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: RequestsTotal,
+			Help: "Total number of HTTP requests.",
+		},
+		[]string{LabelRoute, LabelMethod, LabelCode},
+	)
+
+	durations = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    RequestDuration,
+			Help:    "HTTP request duration in seconds.",
+			Buckets: Buckets,
+		},
+		[]string{LabelRoute, LabelMethod},
+	)
+)
+
+// SLO type represents service level objective of a route.
+type SLO struct {
+	Name   string
+	Method string
+	Route  string
+}
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		requests,
+		durations,
+	)
+}
+
+// Handler method returns HTTP-handler exposing metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware is a middleware for collecting request metrics.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			route := ""
+
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+
+			status := ww.Status()
+
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			requests.WithLabelValues(
+				route, r.Method, strconv.Itoa(status),
+			).Inc()
+
+			durations.WithLabelValues(
+				route, r.Method,
+			).Observe(time.Since(start).Seconds())
+		},
+	)
+}
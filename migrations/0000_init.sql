@@ -0,0 +1,31 @@
+CREATE SCHEMA IF NOT EXISTS auth;
+
+CREATE TABLE IF NOT EXISTS auth.apps
+(
+    "id"           text PRIMARY KEY,
+    "service"      text        NOT NULL,
+    "password"     text        NOT NULL,
+    "callback_URL" text        NOT NULL,
+    "expiry"       timestamptz,
+    "created_at"   timestamptz,
+    "status"       text        NOT NULL DEFAULT 'disable'
+);
+
+CREATE TABLE IF NOT EXISTS auth.tokens
+(
+    "user_id"       integer     NOT NULL,
+    "service"       text        NOT NULL,
+    "token_type"    text        NOT NULL,
+    "access_token"  text        NOT NULL,
+    "refresh_token" text        NOT NULL,
+    "expiry"        timestamptz NOT NULL,
+    "created_at"    timestamptz NOT NULL,
+    UNIQUE ("user_id", "service")
+);
+
+CREATE TABLE IF NOT EXISTS auth.exchanges
+(
+    "id"      text PRIMARY KEY,
+    "service" text    NOT NULL,
+    "user_id" integer NOT NULL
+);
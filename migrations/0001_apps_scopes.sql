@@ -0,0 +1,2 @@
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "scopes" text[] NOT NULL DEFAULT '{}';
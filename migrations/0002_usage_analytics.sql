@@ -0,0 +1,13 @@
+ALTER TABLE auth.exchanges
+    ADD COLUMN IF NOT EXISTS "tenant" text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS auth.usage_daily
+(
+    "day"     date    NOT NULL,
+    "tenant"  text    NOT NULL DEFAULT '',
+    "service" text    NOT NULL,
+    "event"   text    NOT NULL,
+    "reason"  text    NOT NULL DEFAULT '',
+    "count"   integer NOT NULL DEFAULT 0,
+    PRIMARY KEY ("day", "tenant", "service", "event", "reason")
+);
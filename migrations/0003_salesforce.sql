@@ -0,0 +1,5 @@
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "environment" text NOT NULL DEFAULT '';
+
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "instance_url" text NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS auth.webhook_dead_letters
+(
+    "id"         bigserial PRIMARY KEY,
+    "event_id"   text        NOT NULL,
+    "event_type" text        NOT NULL,
+    "url"        text        NOT NULL,
+    "payload"    jsonb       NOT NULL,
+    "attempts"   integer     NOT NULL,
+    "error"      text        NOT NULL,
+    "created_at" timestamptz NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE auth.webhook_dead_letters
+    ADD COLUMN IF NOT EXISTS "dedup_key" text NOT NULL DEFAULT '';
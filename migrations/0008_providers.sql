@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS auth.providers
+(
+    "service"    text PRIMARY KEY,
+    "auth_url"   text        NOT NULL,
+    "token_url"  text        NOT NULL,
+    "scopes"     text[]      NOT NULL DEFAULT '{}',
+    "quirks"     text[]      NOT NULL DEFAULT '{}',
+    "created_at" timestamptz NOT NULL
+);
@@ -0,0 +1,5 @@
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "updated_at" timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS "region" text NOT NULL DEFAULT '';
+
+UPDATE auth.tokens SET "updated_at" = "created_at";
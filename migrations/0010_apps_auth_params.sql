@@ -0,0 +1,2 @@
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "auth_params" jsonb NOT NULL DEFAULT '{}';
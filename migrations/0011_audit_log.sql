@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS auth.audit_log
+(
+    "id"         bigserial PRIMARY KEY,
+    "action"     text        NOT NULL,
+    "severity"   text        NOT NULL,
+    "tenant"     text        NOT NULL DEFAULT '',
+    "user_id"    text        NOT NULL DEFAULT '',
+    "service"    text        NOT NULL DEFAULT '',
+    "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx
+    ON auth.audit_log ("created_at");
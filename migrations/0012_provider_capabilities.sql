@@ -0,0 +1,5 @@
+ALTER TABLE auth.providers
+    ADD COLUMN IF NOT EXISTS "supports_refresh" boolean NOT NULL DEFAULT true,
+    ADD COLUMN IF NOT EXISTS "supports_revoke"  boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS "pkce_required"    boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS "revocation_url"   text    NOT NULL DEFAULT '';
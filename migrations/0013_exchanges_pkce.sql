@@ -0,0 +1,2 @@
+ALTER TABLE auth.exchanges
+    ADD COLUMN IF NOT EXISTS "code_verifier" text NOT NULL DEFAULT '';
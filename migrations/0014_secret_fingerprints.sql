@@ -0,0 +1,21 @@
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "access_token_fingerprint" text NOT NULL DEFAULT '';
+
+UPDATE auth.tokens
+SET "access_token_fingerprint" = 'sha256:' ||
+    left(encode(sha256(convert_to("access_token", 'UTF8')), 'hex'), 8)
+WHERE "access_token" <> '';
+
+CREATE INDEX IF NOT EXISTS tokens_access_token_fingerprint_idx
+    ON auth.tokens ("access_token_fingerprint");
+
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "password_fingerprint" text NOT NULL DEFAULT '';
+
+UPDATE auth.apps
+SET "password_fingerprint" = 'sha256:' ||
+    left(encode(sha256(convert_to("password", 'UTF8')), 'hex'), 8)
+WHERE "password" <> '';
+
+ALTER TABLE auth.audit_log
+    ADD COLUMN IF NOT EXISTS "fingerprint" text NOT NULL DEFAULT '';
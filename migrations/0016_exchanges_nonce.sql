@@ -0,0 +1,2 @@
+ALTER TABLE auth.exchanges
+    ADD COLUMN IF NOT EXISTS "nonce" text NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS auth.service_tokens
+(
+    "service"      text PRIMARY KEY,
+    "token_type"   text        NOT NULL,
+    "access_token" text        NOT NULL,
+    "expiry"       timestamptz,
+    "created_at"   timestamptz NOT NULL
+);
@@ -0,0 +1,2 @@
+ALTER TABLE auth.providers
+    ADD COLUMN IF NOT EXISTS "supports_token_exchange" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS auth.export_jobs
+(
+    "id"          text PRIMARY KEY,
+    "kind"        text        NOT NULL,
+    "tenant"      text        NOT NULL DEFAULT '',
+    "params"      jsonb       NOT NULL DEFAULT '{}',
+    "status"      text        NOT NULL,
+    "rows"        integer     NOT NULL DEFAULT 0,
+    "error"       text        NOT NULL DEFAULT '',
+    "created_at"  timestamptz NOT NULL,
+    "started_at"  timestamptz,
+    "finished_at" timestamptz,
+    "expires_at"  timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS export_jobs_status_idx
+    ON auth.export_jobs ("status", "created_at");
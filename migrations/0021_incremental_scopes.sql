@@ -0,0 +1,5 @@
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "scopes" text[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE auth.exchanges
+    ADD COLUMN IF NOT EXISTS "scopes" text[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE auth.audit_log
+    ADD COLUMN IF NOT EXISTS "ip" text NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS auth.consumed_states
+(
+    "hash"        text PRIMARY KEY,
+    "service"     text        NOT NULL,
+    "user_id"     integer     NOT NULL,
+    "tenant"      text        NOT NULL DEFAULT '',
+    "consumed_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS consumed_states_consumed_at_idx
+    ON auth.consumed_states ("consumed_at");
@@ -0,0 +1,4 @@
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "auth_method"    text NOT NULL DEFAULT 'client_secret',
+    ADD COLUMN IF NOT EXISTS "signing_key"    text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS "signing_key_id" text NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS auth.hook_jobs
+(
+    "id"         bigserial PRIMARY KEY,
+    "hook"       text        NOT NULL,
+    "event"      text        NOT NULL,
+    "user_id"    integer     NOT NULL,
+    "service"    text        NOT NULL,
+    "tenant"     text        NOT NULL DEFAULT '',
+    "attempts"   integer     NOT NULL DEFAULT 0,
+    "status"     text        NOT NULL,
+    "last_error" text        NOT NULL DEFAULT '',
+    "run_at"     timestamptz NOT NULL,
+    "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS hook_jobs_due_idx
+    ON auth.hook_jobs ("status", "run_at");
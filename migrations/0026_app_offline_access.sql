@@ -0,0 +1,2 @@
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "offline_access" boolean NOT NULL DEFAULT false;
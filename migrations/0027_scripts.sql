@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS auth.scripts
+(
+    "id"         text PRIMARY KEY,
+    "tenant"     text        NOT NULL DEFAULT '',
+    "event"      text        NOT NULL,
+    "source"     text        NOT NULL,
+    "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS scripts_tenant_event_idx
+    ON auth.scripts ("tenant", "event");
+
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "metadata" jsonb NOT NULL DEFAULT '{}';
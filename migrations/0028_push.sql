@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS auth.push_targets
+(
+    "id"         text PRIMARY KEY,
+    "tenant"     text        NOT NULL DEFAULT '',
+    "service"    text        NOT NULL,
+    "url"        text        NOT NULL,
+    "secret"     text        NOT NULL,
+    "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS push_targets_tenant_service_idx
+    ON auth.push_targets ("tenant", "service");
+
+CREATE TABLE IF NOT EXISTS auth.push_deliveries
+(
+    "id"         bigserial PRIMARY KEY,
+    "target_id"  text        NOT NULL
+        REFERENCES auth.push_targets ("id") ON DELETE CASCADE,
+    "event"      text        NOT NULL,
+    "user_id"    integer     NOT NULL,
+    "service"    text        NOT NULL,
+    "tenant"     text        NOT NULL DEFAULT '',
+    "attempts"   integer     NOT NULL DEFAULT 0,
+    "status"     text        NOT NULL,
+    "last_error" text        NOT NULL DEFAULT '',
+    "run_at"     timestamptz NOT NULL,
+    "acked_at"   timestamptz,
+    "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS push_deliveries_due_idx
+    ON auth.push_deliveries ("status", "run_at");
+
+CREATE INDEX IF NOT EXISTS push_deliveries_target_idx
+    ON auth.push_deliveries ("target_id", "created_at");
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS auth.operations
+(
+    "id"               text PRIMARY KEY,
+    "kind"             text        NOT NULL,
+    "tenant"           text        NOT NULL DEFAULT '',
+    "params"           jsonb       NOT NULL DEFAULT '{}',
+    "status"           text        NOT NULL,
+    "total"            integer     NOT NULL DEFAULT 0,
+    "processed"        integer     NOT NULL DEFAULT 0,
+    "failed"           integer     NOT NULL DEFAULT 0,
+    "item_errors"      jsonb       NOT NULL DEFAULT '[]',
+    "error"            text        NOT NULL DEFAULT '',
+    "cancel_requested" boolean     NOT NULL DEFAULT false,
+    "created_at"       timestamptz NOT NULL,
+    "started_at"       timestamptz,
+    "updated_at"       timestamptz,
+    "finished_at"      timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS operations_status_idx
+    ON auth.operations ("status", "created_at");
+
+CREATE INDEX IF NOT EXISTS operations_tenant_idx
+    ON auth.operations ("tenant", "created_at");
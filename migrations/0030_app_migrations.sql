@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS auth.app_migrations
+(
+    "service"     text PRIMARY KEY,
+    "from_app_id" text        NOT NULL,
+    "to_app_id"   text        NOT NULL,
+    "started_at"  timestamptz NOT NULL
+);
+
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "app_id" text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS tokens_service_app_idx
+    ON auth.tokens ("service", "app_id");
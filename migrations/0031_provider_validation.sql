@@ -0,0 +1,2 @@
+ALTER TABLE auth.providers
+    ADD COLUMN IF NOT EXISTS "validation_url" text NOT NULL DEFAULT '';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS auth.identities
+(
+    "user_id"    integer     NOT NULL,
+    "service"    text        NOT NULL,
+    "subject"    text        NOT NULL,
+    "email"      text        NOT NULL DEFAULT '',
+    "name"       text        NOT NULL DEFAULT '',
+    "fetched_at" timestamptz NOT NULL,
+    PRIMARY KEY ("user_id", "service"),
+    FOREIGN KEY ("user_id", "service")
+        REFERENCES auth.tokens ("user_id", "service") ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "refresh_failures" integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS "refresh_error" text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS "refresh_retry_at" timestamptz;
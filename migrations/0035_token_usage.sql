@@ -0,0 +1,3 @@
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "use_count" bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS "last_used_at" timestamptz;
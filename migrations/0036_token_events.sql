@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS auth.token_events
+(
+    "id"         bigserial PRIMARY KEY,
+    "user_id"    integer     NOT NULL,
+    "service"    text        NOT NULL,
+    "kind"       text        NOT NULL,
+    "actor"      text        NOT NULL,
+    "outcome"    text        NOT NULL,
+    "error"      text        NOT NULL DEFAULT '',
+    "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS token_events_token_idx
+    ON auth.token_events ("user_id", "service", "created_at");
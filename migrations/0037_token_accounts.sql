@@ -0,0 +1,20 @@
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "account" text NOT NULL DEFAULT '';
+
+ALTER TABLE auth.exchanges
+    ADD COLUMN IF NOT EXISTS "account" text NOT NULL DEFAULT '';
+
+ALTER TABLE auth.identities
+    ADD COLUMN IF NOT EXISTS "account" text NOT NULL DEFAULT '',
+    DROP CONSTRAINT IF EXISTS identities_user_id_service_fkey,
+    DROP CONSTRAINT IF EXISTS identities_pkey;
+
+ALTER TABLE auth.tokens
+    DROP CONSTRAINT IF EXISTS tokens_user_id_service_key,
+    ADD CONSTRAINT tokens_user_id_service_account_key
+        UNIQUE ("user_id", "service", "account");
+
+ALTER TABLE auth.identities
+    ADD PRIMARY KEY ("user_id", "service", "account"),
+    ADD FOREIGN KEY ("user_id", "service", "account")
+        REFERENCES auth.tokens ("user_id", "service", "account") ON DELETE CASCADE;
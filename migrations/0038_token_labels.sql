@@ -0,0 +1,8 @@
+ALTER TABLE auth.exchanges
+    ADD COLUMN IF NOT EXISTS "labels" jsonb NOT NULL DEFAULT '{}';
+
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "labels" jsonb NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS tokens_labels_idx
+    ON auth.tokens USING gin ("labels" jsonb_path_ops);
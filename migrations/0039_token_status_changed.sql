@@ -0,0 +1,2 @@
+ALTER TABLE auth.tokens
+    ADD COLUMN IF NOT EXISTS "status_changed_at" timestamptz NOT NULL DEFAULT now();
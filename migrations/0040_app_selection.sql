@@ -0,0 +1,6 @@
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "weight" integer NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS "tenant" text NOT NULL DEFAULT '';
+
+ALTER TABLE auth.exchanges
+    ADD COLUMN IF NOT EXISTS "app_id" text NOT NULL DEFAULT '';
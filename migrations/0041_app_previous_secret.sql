@@ -0,0 +1,3 @@
+ALTER TABLE auth.apps
+    ADD COLUMN IF NOT EXISTS "previous_password" text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS "previous_password_expiry" timestamptz;
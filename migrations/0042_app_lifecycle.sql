@@ -0,0 +1,4 @@
+UPDATE auth.apps a
+SET status = 'deprecated'
+WHERE status = 'disable'
+  AND EXISTS (SELECT 1 FROM auth.tokens t WHERE t.app_id = a.id);
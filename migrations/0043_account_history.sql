@@ -0,0 +1,10 @@
+ALTER TABLE auth.token_events
+    ADD COLUMN IF NOT EXISTS "account" text NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS auth.token_events_token_idx;
+
+CREATE INDEX IF NOT EXISTS token_events_token_idx
+    ON auth.token_events ("user_id", "service", "account", "created_at");
+
+ALTER TABLE auth.push_deliveries
+    ADD COLUMN IF NOT EXISTS "account" text NOT NULL DEFAULT '';
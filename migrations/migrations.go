@@ -0,0 +1,172 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration type represents single schema migration.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// List method returns migrations ordered by version.
+func List() ([]Migration, error) {
+	names, err := fs.Glob(files, "*.sql")
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Migration, 0, len(names))
+
+	for _, name := range names {
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := files.ReadFile(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(data),
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// Latest method returns version of the newest migration.
+func Latest() (int, error) {
+	list, err := List()
+
+	if err != nil || len(list) == 0 {
+		return 0, err
+	}
+
+	return list[len(list)-1].Version, nil
+}
+
+// Apply method applies migrations not applied to db yet, recording each
+// in auth.schema_migrations, and returns those applied.
+func Apply(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	_, err := db.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS auth`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS auth.schema_migrations
+									( "version" integer PRIMARY KEY,
+									 "name" text NOT NULL,
+									 "applied_at" timestamptz NOT NULL DEFAULT now())`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedVersions(ctx, db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := List()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+
+	for _, m := range list {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err = apply(ctx, db, m)
+
+		if err != nil {
+			return done, err
+		}
+
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+func appliedVersions(ctx context.Context, db *sql.DB) (map[int]struct{}, error) {
+	rows, err := db.QueryContext(ctx, `SELECT "version"
+									FROM auth.schema_migrations`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := make(map[int]struct{})
+
+	for rows.Next() {
+		var version int
+
+		err = rows.Scan(&version)
+
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = struct{}{}
+	}
+
+	return applied, rows.Err()
+}
+
+func apply(ctx context.Context, db *sql.DB, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, m.SQL)
+
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO auth.schema_migrations
+									( "version", "name")
+								VALUES ($1, $2)`,
+			m.Version, m.Name,
+		)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,404 @@
+// Package mirror shadows read traffic to secondary implementation of the
+// API, so a rewrite, e.g. of the storage layer, is rolled out only once it
+// answers like the current one.
+//
+// Requests matching configured routes are served by the primary handler
+// as usual. A sampled share of them is then replayed against secondary
+// in background and both answers are compared: status codes must match,
+// JSON bodies must be equal apart from ignored fields, other bodies
+// byte for byte. Outcomes are counted by route in
+// auth_mirror_requests_total, so divergence shows on dashboards:
+//
+//	mirror:
+//	  url: "http://auth-next:8071"
+//	  percent: 10
+//	  routes: ["GET /api/v1/tokens/*/*"]
+//	  ignoredFields: ["checked_at"]
+//
+// Routes are "METHOD pattern" with path.Match patterns like partner
+// routes, and only GET and HEAD requests are mirrored. Mirrored requests
+// carry the original headers, so secondary must be trusted, and its own
+// side effects, e.g. audit entries of token reads, happen again.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ResultMatch secondary answered like primary.
+	ResultMatch = "match"
+
+	// ResultDiverged secondary answered differently.
+	ResultDiverged = "diverged"
+
+	// ResultError secondary could not be asked.
+	ResultError = "error"
+
+	// ResultDropped request was not mirrored, queue being full or body
+	// too large.
+	ResultDropped = "dropped"
+
+	defaultWorkers   = 4
+	defaultQueueSize = 1024
+	defaultTimeout   = 10
+	maxBodySize      = 1 << 20
+)
+
+var (
+	mirrored = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metrics.Namespace + "_mirror_requests_total",
+			Help: "Total number of mirrored requests, by comparison result.",
+		},
+		[]string{metrics.LabelRoute, metrics.LabelMethod, "result"},
+	)
+)
+
+// Config type represents mirroring configuration. Percent is the share of
+// matching requests mirrored, Timeout bounds single secondary request in
+// seconds.
+type Config struct {
+	URL           string
+	Percent       int
+	Routes        []string
+	IgnoredFields []string `yaml:"ignoredFields"`
+	Workers       int
+	QueueSize     int `yaml:"queueSize"`
+	Timeout       int
+}
+
+// Mirror type represents traffic mirroring middleware.
+type Mirror struct {
+	config    Config
+	secondary http.Handler
+	routes    []route
+	ignored   [][]string
+	queue     chan *job
+	quit      chan struct{}
+	once      sync.Once
+	wg        sync.WaitGroup
+}
+
+type route struct {
+	method  string
+	pattern string
+}
+
+// job type represents primary answer waiting for comparison.
+type job struct {
+	req    *http.Request
+	route  string
+	status int
+	body   []byte
+}
+
+// recorder type represents response writer keeping copy of body.
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func init() {
+	metrics.Registry.MustRegister(mirrored)
+}
+
+// New method creates new mirror replaying requests to secondary
+// deployment at config URL.
+func New(config Config) (*Mirror, error) {
+	client := &http.Client{}
+	base := strings.TrimSuffix(config.URL, "/")
+
+	return NewHandler(config, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			req, err := http.NewRequestWithContext(r.Context(), r.Method,
+				base+r.URL.RequestURI(), nil)
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			req.Header = r.Header.Clone()
+			resp, err := client.Do(req)
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			defer resp.Body.Close()
+
+			for k, v := range resp.Header {
+				w.Header()[k] = v
+			}
+
+			w.WriteHeader(resp.StatusCode)
+			_, _ = io.Copy(w, resp.Body)
+		},
+	))
+}
+
+// NewHandler method creates new mirror replaying requests to secondary
+// handler, e.g. router of candidate implementation in the same process.
+func NewHandler(config Config, secondary http.Handler) (*Mirror, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+
+	m := &Mirror{
+		config:    config,
+		secondary: secondary,
+		queue:     make(chan *job, config.QueueSize),
+		quit:      make(chan struct{}),
+	}
+
+	for _, r := range config.Routes {
+		parts := strings.Fields(r)
+
+		if len(parts) != 2 {
+			return nil, errors.New("mirror route must be \"METHOD pattern\": " + r)
+		}
+
+		if _, err := path.Match(parts[1], "/"); err != nil {
+			return nil, err
+		}
+
+		method := strings.ToUpper(parts[0])
+
+		if method != http.MethodGet && method != http.MethodHead {
+			return nil, errors.New("only read requests are mirrored: " + r)
+		}
+
+		m.routes = append(m.routes, route{method: method, pattern: parts[1]})
+	}
+
+	for _, field := range config.IgnoredFields {
+		m.ignored = append(m.ignored, strings.Split(field, "."))
+	}
+
+	return m, nil
+}
+
+// Start method runs comparison workers.
+func (m *Mirror) Start() {
+	for i := 0; i < m.config.Workers; i++ {
+		m.wg.Add(1)
+
+		go func() {
+			defer m.wg.Done()
+			m.work()
+		}()
+	}
+}
+
+// Stop method stops comparison workers. Queued requests are dropped.
+func (m *Mirror) Stop() {
+	m.once.Do(func() { close(m.quit) })
+	m.wg.Wait()
+}
+
+// Middleware method serves request by primary handler and queues sampled
+// requests of mirrored routes for comparison with secondary.
+func (m *Mirror) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if !m.sampled(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Request is copied before routing rewrites its context.
+			req := r.Clone(context.Background())
+			rw := &recorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(rw, r)
+
+			pattern := ""
+
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				pattern = rctx.RoutePattern()
+			}
+
+			if rw.overflow {
+				mirrored.WithLabelValues(pattern, r.Method, ResultDropped).Inc()
+				return
+			}
+
+			j := &job{
+				req:    req,
+				route:  pattern,
+				status: rw.status,
+				body:   rw.body.Bytes(),
+			}
+
+			select {
+			case m.queue <- j:
+			default:
+				mirrored.WithLabelValues(pattern, r.Method, ResultDropped).Inc()
+			}
+		},
+	)
+}
+
+func (m *Mirror) sampled(r *http.Request) bool {
+	if m.config.Percent <= 0 || !m.matches(r.Method, r.URL.Path) {
+		return false
+	}
+
+	return m.config.Percent >= 100 || rand.Intn(100) < m.config.Percent
+}
+
+func (m *Mirror) matches(method string, urlPath string) bool {
+	for _, r := range m.routes {
+		if r.method != method {
+			continue
+		}
+
+		if ok, _ := path.Match(r.pattern, urlPath); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Mirror) work() {
+	for {
+		select {
+		case <-m.quit:
+			return
+		case j := <-m.queue:
+			result := m.compare(j)
+			mirrored.WithLabelValues(j.route, j.req.Method, result).Inc()
+		}
+	}
+}
+
+// compare method replays request against secondary and returns
+// comparison result.
+func (m *Mirror) compare(j *job) string {
+	ctx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(m.config.Timeout)*time.Second)
+	defer cancel()
+
+	rw := &recorder{ResponseWriter: discard{header: make(http.Header)},
+		status: http.StatusOK}
+
+	m.secondary.ServeHTTP(rw, j.req.WithContext(ctx))
+
+	if ctx.Err() != nil || rw.overflow {
+		return ResultError
+	}
+
+	if rw.status != j.status || !m.equal(j.body, rw.body.Bytes()) {
+		log.Printf("mirror: %s %s diverged: status %d, secondary %d",
+			j.req.Method, j.req.URL.Path, j.status, rw.status)
+		return ResultDiverged
+	}
+
+	return ResultMatch
+}
+
+// equal method compares bodies as JSON without ignored fields, or byte
+// for byte when either is no JSON.
+func (m *Mirror) equal(primary []byte, secondary []byte) bool {
+	var p, s interface{}
+
+	if json.Unmarshal(primary, &p) != nil ||
+		json.Unmarshal(secondary, &s) != nil {
+		return bytes.Equal(primary, secondary)
+	}
+
+	for _, field := range m.ignored {
+		remove(p, field)
+		remove(s, field)
+	}
+
+	return reflect.DeepEqual(p, s)
+}
+
+// remove function deletes field at path from every object v holds.
+func remove(v interface{}, fieldPath []string) {
+	switch t := v.(type) {
+	case []interface{}:
+		for _, item := range t {
+			remove(item, fieldPath)
+		}
+	case map[string]interface{}:
+		if len(fieldPath) == 1 {
+			delete(t, fieldPath[0])
+			return
+		}
+
+		if child, ok := t[fieldPath[0]]; ok {
+			remove(child, fieldPath[1:])
+		}
+	}
+}
+
+func (rw *recorder) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recorder) Write(p []byte) (int, error) {
+	if !rw.overflow {
+		if rw.body.Len()+len(p) > maxBodySize {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(p)
+		}
+	}
+
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// discard type represents response writer of secondary answer, which
+// never reaches client.
+type discard struct {
+	header http.Header
+}
+
+func (d discard) Header() http.Header {
+	return d.header
+}
+
+func (d discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d discard) WriteHeader(_ int) {}
@@ -0,0 +1,165 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Zetkolink/auth/planguard"
+)
+
+const (
+	// EventAttempt connect flow started.
+	EventAttempt = "attempt"
+
+	// EventCompletion connect flow completed.
+	EventCompletion = "completion"
+
+	// EventFailure connect flow failed.
+	EventFailure = "failure"
+
+	// ReasonAppUnavailable no enabled app for service.
+	ReasonAppUnavailable = "app_unavailable"
+
+	// ReasonExchangeFailed provider rejected code exchange.
+	ReasonExchangeFailed = "exchange_failed"
+
+	// ReasonIDToken provider returned invalid id_token.
+	ReasonIDToken = "id_token_invalid"
+
+	// ReasonNoRefreshToken offline access app got no refresh token.
+	ReasonNoRefreshToken = "no_refresh_token"
+
+	// ReasonStorage token could not be stored.
+	ReasonStorage = "storage_error"
+)
+
+const usageQuery = `SELECT
+									"day", "tenant", "service",
+									"event", "reason", "count"
+									     FROM auth.usage_daily
+								WHERE day >= $1 AND day <= $2
+								AND ($3 = '' OR service = $3)
+								AND ($4 = '' OR tenant = $4)
+								ORDER BY day, tenant, service, event, reason`
+
+// usageIndexes lists indexes of usage table.
+var usageIndexes = []planguard.Index{
+	{"day", "tenant", "service", "event", "reason"},
+}
+
+type Model struct {
+	db    *sql.DB
+	guard *planguard.Guard
+}
+
+type ModelConfig struct {
+	Db    *sql.DB
+	Guard *planguard.Guard
+}
+
+// Event type represents single connect flow event.
+type Event struct {
+	Service string
+	Tenant  string
+	Type    string
+	Reason  string
+}
+
+// Usage type represents aggregated daily usage row.
+type Usage struct {
+	Day     time.Time `json:"day"`
+	Tenant  string    `json:"tenant"`
+	Service string    `json:"service"`
+	Event   string    `json:"event"`
+	Reason  string    `json:"reason,omitempty"`
+	Count   int       `json:"count"`
+}
+
+// Filter type represents usage report filter.
+type Filter struct {
+	From    time.Time
+	To      time.Time
+	Service string
+	Tenant  string
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db:    config.Db,
+		guard: config.Guard,
+	}
+
+	return m, nil
+}
+
+func (m *Model) Record(ctx context.Context, event Event) error {
+	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.usage_daily
+									( "day", "tenant", "service",
+									 "event", "reason", "count")
+								VALUES (current_date, $1, $2, $3, $4, 1)
+								ON CONFLICT (day, tenant, service, event, reason)
+								DO UPDATE SET count = auth.usage_daily.count + 1`,
+		event.Tenant, event.Service, event.Type, event.Reason,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Check method rejects filters usage report cannot serve from indexes.
+func (m *Model) Check(ctx context.Context, filter Filter) error {
+	return m.guard.Check(ctx, usageIndexes, filter.columns(),
+		usageQuery, filter.args()...)
+}
+
+func (m *Model) Each(ctx context.Context, filter Filter, fn func(*Usage) error) error {
+	rows, err := m.db.QueryContext(ctx, usageQuery, filter.args()...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var u Usage
+
+		err = rows.Scan(&u.Day, &u.Tenant, &u.Service,
+			&u.Event, &u.Reason, &u.Count)
+
+		if err != nil {
+			return err
+		}
+
+		err = fn(&u)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (f *Filter) args() []interface{} {
+	return []interface{}{f.From, f.To, f.Service, f.Tenant}
+}
+
+// columns method returns columns filter constrains.
+func (f *Filter) columns() []string {
+	columns := []string{"day"}
+
+	if f.Service != "" {
+		columns = append(columns, "service")
+	}
+
+	if f.Tenant != "" {
+		columns = append(columns, "tenant")
+	}
+
+	return columns
+}
@@ -3,27 +3,66 @@ package apps
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"strings"
 	"time"
 
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/cache"
+	"github.com/Zetkolink/auth/dblog"
 	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/keyring"
+	"github.com/Zetkolink/auth/models/analytics"
 	"github.com/Zetkolink/auth/models/exchanges"
+	"github.com/Zetkolink/auth/models/providers"
+	"github.com/Zetkolink/auth/models/storage"
+	"github.com/Zetkolink/auth/oidc"
 	"github.com/lib/pq"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/amazon"
+	"golang.org/x/oauth2/bitbucket"
+	"golang.org/x/oauth2/endpoints"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/mailru"
+	"golang.org/x/oauth2/spotify"
 	"golang.org/x/oauth2/vk"
 	"golang.org/x/oauth2/yandex"
 )
 
 const (
-	StatusEnable  = "enable"
+	// StatusEnable app serves connect flows and refreshes tokens.
+	StatusEnable = "enable"
+
+	// StatusDisable app serves nothing.
 	StatusDisable = "disable"
 
-	Google = "google"
-	Yandex = "yandex"
-	Mail   = "mail"
-	VK     = "vk"
+	Google     = "google"
+	Yandex     = "yandex"
+	Mail       = "mail"
+	VK         = "vk"
+	Spotify    = "spotify"
+	Bitbucket  = "bitbucket"
+	Amazon     = "amazon"
+	Salesforce = "salesforce"
+	Zoom       = "zoom"
+	Notion     = "notion"
+	Strava     = "strava"
+	CustomOIDC = "custom_oidc"
+
+	// AuthMethodSecret app authenticates with client secret.
+	AuthMethodSecret = "client_secret"
+
+	// AuthMethodPrivateKeyJWT app authenticates with client assertion
+	// signed by its private key (RFC 7523).
+	AuthMethodPrivateKeyJWT = "private_key_jwt"
+
+	EnvironmentProduction = "production"
+	EnvironmentSandbox    = "sandbox"
+
+	maxHintLength    = 256
+	maxAccountLength = 256
 )
 
 var (
@@ -39,94 +78,395 @@ var (
 	// ErrService app status unavailable.
 	ErrService = errors.New("app service unavailable")
 
-	// TODO rework
+	// ErrIssuer app issuer not specified.
+	ErrIssuer = errors.New("app issuer not specified")
+
+	// ErrAuthParams app auth params override reserved parameters.
+	ErrAuthParams = errors.New("app auth params override reserved parameters")
+
+	// ErrSigningKey private_key_jwt app has no valid signing key.
+	ErrSigningKey = errors.New("app signing key invalid")
+
+	// ErrHasTokens tokens of app service are stored.
+	ErrHasTokens = errors.New("tokens of app service exist")
+
+	// ErrServiceChange app put with service other than stored one.
+	ErrServiceChange = errors.New("app service cannot be changed")
+
+	// ErrHint auth code url hint value is invalid.
+	ErrHint = errors.New("invalid auth code url hint")
+
+	// ErrAccount auth code url account is invalid.
+	ErrAccount = errors.New("invalid account")
+
+	// HintParams lists auth code url parameters callers may pass through
+	// to provider, e.g. to preselect account.
+	HintParams = []string{"login_hint", "prompt", "locale", "ui_locales"}
+
+	// ErrEnvironment app environment unavailable.
+	ErrEnvironment = errors.New("app environment unavailable")
+
+	salesforceHosts = map[string]string{
+		EnvironmentProduction: "https://login.salesforce.com",
+		EnvironmentSandbox:    "https://test.salesforce.com",
+	}
+
+	// scopes lists defaults requested when app defines no scopes.
 	scopes = map[string][]string{
 		Yandex: {"mail:imap_ro"},
 		Google: {"https://www.googleapis.com/github.com/Zetkolink/auth/gmail.addons.current.message.readonly"},
 	}
+
+	authParams = map[string][]oauth2.AuthCodeOption{
+		Notion: {oauth2.SetAuthURLParam("owner", "user")},
+	}
+
+	// reservedAuthParams lists parameters apps must not override.
+	reservedAuthParams = map[string]struct{}{
+		"client_id": {}, "redirect_uri": {}, "response_type": {},
+		"scope": {}, "state": {}, "code_challenge": {},
+		"code_challenge_method": {}, "nonce": {},
+	}
+
+	// capabilities lists protocol features of builtin services. Services
+	// missing here get providers.DefaultCapabilities.
+	capabilities = map[string]providers.Capabilities{
+		Google: {
+			SupportsRefresh: true,
+			SupportsRevoke:  true,
+			RevocationURL:   "https://oauth2.googleapis.com/revoke",
+			ValidationURL:   "https://openidconnect.googleapis.com/v1/userinfo",
+		},
+		Yandex: {
+			SupportsRefresh: true,
+			SupportsRevoke:  true,
+			RevocationURL:   "https://oauth.yandex.ru/revoke_token",
+			ValidationURL:   "https://login.yandex.ru/info",
+		},
+		VK:     {},
+		Notion: {},
+		Zoom: {
+			SupportsRefresh: true,
+			SupportsRevoke:  true,
+			RevocationURL:   "https://zoom.us/oauth/revoke",
+			ValidationURL:   "https://api.zoom.us/v2/users/me",
+		},
+		Strava: {
+			SupportsRefresh: true,
+			SupportsRevoke:  true,
+			RevocationURL:   "https://www.strava.com/oauth/deauthorize",
+			ValidationURL:   "https://www.strava.com/api/v3/athlete",
+		},
+		Salesforce: {
+			SupportsRefresh: true,
+			SupportsRevoke:  true,
+		},
+		Spotify: {
+			SupportsRefresh: true,
+			ValidationURL:   "https://api.spotify.com/v1/me",
+		},
+		Bitbucket: {
+			SupportsRefresh: true,
+			ValidationURL:   "https://api.bitbucket.org/2.0/user",
+		},
+		Amazon: {
+			SupportsRefresh: true,
+			ValidationURL:   "https://api.amazon.com/user/profile",
+		},
+	}
+
+	// issuers lists OIDC issuers of builtin services returning id_token.
+	issuers = map[string]string{
+		Google: "https://accounts.google.com",
+	}
+
+	// Builtin lists services supported without registry entry.
+	Builtin = []string{
+		Google, Yandex, Mail, VK, Spotify, Bitbucket, Amazon,
+		Salesforce, Zoom, Notion, Strava,
+	}
 )
 
+const appColumns = `"id", "service", "password", "password_fingerprint",
+	"callback_URL", "scopes", "environment", "issuer", "auth_params",
+	"expiry", "created_at", "auth_method", "signing_key", "signing_key_id",
+	"offline_access", "status", "weight", "tenant", "previous_password",
+	"previous_password_expiry"`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 type Model struct {
 	db        *sql.DB
 	exchanges *exchanges.Model
+	analytics *analytics.Model
+	providers *providers.Model
+	discovery *oidc.Discovery
+	audit     *audit.Writer
+	keyring   *keyring.Keyring
+	cache     cache.Cache
+	cacheTTL  time.Duration
+	selector  *selector
+
+	callbackURL string
+	secretGrace time.Duration
 }
 
 type ModelConfig struct {
 	Db        *sql.DB
 	Exchanges *exchanges.Model
+	Analytics *analytics.Model
+	Providers *providers.Model
+	Discovery *oidc.Discovery
+	Audit     *audit.Writer
+	Cache     cache.Cache
+	CacheTTL  time.Duration
+
+	// Keyring seals client secrets at rest, opened only to build client
+	// config.
+	Keyring *keyring.Keyring
+
+	// CallbackURL is connect callback of service, redirected to by apps
+	// without own callback URL.
+	CallbackURL string
+
+	// Strategies maps service to selection strategy of its enabled apps,
+	// StrategyFirst by default.
+	Strategies map[string]string
+
+	// SecretGrace is the time secret replaced by rotation stays valid
+	// for by default.
+	SecretGrace time.Duration
 }
 
 type App struct {
-	ID          string     `json:"id"`
-	Service     string     `json:"service"`
-	Password    string     `json:"password"`
-	CallbackURL string     `json:"callback_URL"`
+	ID          string            `json:"id"`
+	Service     string            `json:"service"`
+	Password    string            `json:"password"`
+	Fingerprint string            `json:"password_fingerprint"`
+	CallbackURL string            `json:"callback_URL"`
+	Scopes      []string          `json:"scopes" validate:"max=64,unique,dive,required,max=512,excludesall=0x20"`
+	Environment string            `json:"environment"`
+	Issuer      string            `json:"issuer"`
+	AuthParams  map[string]string `json:"auth_params"`
+	Expiry      *time.Time        `json:"expiry"`
+	CreatedAt   *time.Time        `json:"created_at"`
+	Status      string            `json:"status"`
+
+	// AuthMethod selects how app authenticates at token endpoint,
+	// SigningKey is PEM private key signing its client assertions.
+	AuthMethod   string `json:"auth_method" validate:"omitempty,oneof=client_secret private_key_jwt"`
+	SigningKey   string `json:"signing_key"`
+	SigningKeyID string `json:"signing_key_id"`
+
+	// OfflineAccess makes connect flow request refresh token and fail
+	// when provider returns none.
+	OfflineAccess bool `json:"offline_access"`
+
+	// Weight is the share of connect flows app serves under weighted
+	// strategy, 1 by default. Tenant reserves app for tenant under
+	// tenant strategy, empty serving tenants without own app.
+	Weight int    `json:"weight" validate:"min=0,max=1000"`
+	Tenant string `json:"tenant"`
+
+	// PreviousPassword is the secret replaced by rotation, accepted
+	// until PreviousPasswordExpiry.
+	PreviousPassword       string     `json:"previous_password,omitempty"`
+	PreviousPasswordExpiry *time.Time `json:"previous_password_expiry,omitempty"`
+}
+
+// AppPatch type represents partial update of app, nil fields keeping
+// stored values. Empty scopes fall back to service defaults.
+type AppPatch struct {
+	Password    *string    `json:"password"`
+	CallbackURL *string    `json:"callback_URL"`
+	Scopes      []string   `json:"scopes" validate:"omitempty,max=64,unique,dive,required,max=512,excludesall=0x20"`
 	Expiry      *time.Time `json:"expiry"`
-	CreatedAt   *time.Time `json:"created_at"`
-	Status      string     `json:"status"`
 }
 
 func NewModel(config ModelConfig) (*Model, error) {
+	s, err := newSelector(config.Strategies)
+
+	if err != nil {
+		return nil, err
+	}
+
 	m := &Model{
 		db:        config.Db,
 		exchanges: config.Exchanges,
+		analytics: config.Analytics,
+		providers: config.Providers,
+		discovery: config.Discovery,
+		audit:     config.Audit,
+		keyring:   config.Keyring,
+		cache:     config.Cache,
+		cacheTTL:  config.CacheTTL,
+		selector:  s,
+
+		callbackURL: config.CallbackURL,
+		secretGrace: config.SecretGrace,
+	}
+
+	if m.secretGrace <= 0 {
+		m.secretGrace = defaultSecretGrace
 	}
 
 	return m, nil
 }
 
 func (m *Model) GetByID(ctx context.Context, id string) (*App, error) {
-	var app App
-
-	err := m.db.QueryRowContext(ctx, `SELECT  
-									"id", "service","password", 
-       								"callback_URL", "expiry",
-       								"created_at"
+	return scanApp(m.db.QueryRowContext(ctx, `SELECT `+appColumns+`
 									     FROM auth.apps
 								WHERE id = $1`,
 		id,
-	).Scan(&app.ID, &app.Service, &app.Password, &app.CallbackURL,
-		&app.Expiry, &app.CreatedAt)
+	))
+}
+
+// List method returns page of apps ordered by service and creation time,
+// optionally of single service or status.
+func (m *Model) List(ctx context.Context, service string, status string, p *helpers.Paginator) ([]*App, error) {
+	where := `WHERE ($1 = '' OR service = $1) AND ($2 = '' OR status = $2)`
+
+	if !p.SkipCount {
+		err := m.db.QueryRowContext(ctx, `SELECT count(*)
+									     FROM auth.apps
+								`+where,
+			service, status,
+		).Scan(&p.Total)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								`+where+`
+								ORDER BY service, created_at, id
+								OFFSET $3 LIMIT $4`,
+		service, status, p.Skip(), p.Fetch(),
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &app, nil
+	defer rows.Close()
+
+	list := make([]*App, 0, p.PerPage)
+
+	for rows.Next() {
+		app, err := scanApp(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, app)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list[:p.Trim(len(list))], nil
 }
 
+// GetByService method returns enabled app of service serving connect flow
+// of ctx, picked by selection strategy of service among enabled ones.
+// Client secrets are left out, ClientConf loads them.
 func (m *Model) GetByService(ctx context.Context, service string) (*App, error) {
-	var app App
+	list, err := m.enabled(ctx, service)
+
+	if err != nil {
+		return nil, err
+	}
 
-	err := m.db.QueryRowContext(ctx, `SELECT  
-									"id", "service","password", 
-       								"callback_URL", "expiry",
-       								"created_at"
+	return m.pick(ctx, service, list)
+}
+
+// enabled method returns enabled apps of service, oldest first, without
+// client secrets, so none reach shared cache.
+func (m *Model) enabled(ctx context.Context, service string) ([]*App, error) {
+	var list []*App
+
+	key := serviceCacheKey(service)
+	data, err := m.cache.Get(ctx, key)
+
+	if err == nil && json.Unmarshal(data, &list) == nil && len(list) > 0 {
+		return list, nil
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT `+appColumns+`
 									     FROM auth.apps
-								WHERE service = $1 AND status = $2`,
+								WHERE service = $1 AND status = $2
+								ORDER BY created_at, id`,
 		service, StatusEnable,
-	).Scan(&app.ID, &app.Service, &app.Password, &app.CallbackURL,
-		&app.Expiry, &app.CreatedAt)
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &app, nil
+	defer rows.Close()
+
+	list = make([]*App, 0, 1)
+
+	for rows.Next() {
+		app, err := scanApp(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, app.withoutSecrets())
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+
+	data, err = json.Marshal(list)
+
+	if err == nil {
+		_ = m.cache.Set(ctx, key, data, m.cacheTTL)
+	}
+
+	return list, nil
 }
 
 func (m *Model) GetConf(ctx context.Context, service string) (*oauth2.Config, error) {
-	var app App
+	app, err := m.GetByService(ctx, service)
 
-	err := m.db.QueryRowContext(ctx, `SELECT  
-									"id", "service","password", 
-       								"callback_URL", "expiry",
-       								"created_at"
-									     FROM auth.apps
-								WHERE service = $1 AND status = $2`,
-		service, StatusEnable,
-	).Scan(&app.ID, &app.Service, &app.Password, &app.CallbackURL,
-		&app.Expiry, &app.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err = m.GetByID(ctx, app.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.config(ctx, app)
+}
+
+// config method builds oauth2 config of app.
+func (m *Model) config(ctx context.Context, app *App) (*oauth2.Config, error) {
+	secret, err := m.keyring.Open(app.Password)
 
 	if err != nil {
 		return nil, err
@@ -134,92 +474,1019 @@ func (m *Model) GetConf(ctx context.Context, service string) (*oauth2.Config, er
 
 	conf := &oauth2.Config{
 		ClientID:     app.ID,
-		ClientSecret: app.Password,
-		Scopes:       scopes[app.Service],
+		ClientSecret: secret,
+		Scopes:       app.Scopes,
 		RedirectURL:  app.CallbackURL,
 	}
 
-	switch app.Service {
-	case Yandex:
-		conf.Endpoint = yandex.Endpoint
-	case Google:
-		conf.Endpoint = google.Endpoint
-	case Mail:
-		conf.Endpoint = mailru.Endpoint
-	case VK:
-		conf.Endpoint = vk.Endpoint
+	if conf.RedirectURL == "" {
+		conf.RedirectURL = m.callbackURL
+	}
+
+	provider, err := m.providers.Get(ctx, app.Service)
+
+	switch {
+	case err == nil:
+		provider.Apply(conf)
+	case err != providers.ErrNotFound:
+		return nil, err
 	default:
-		return nil, ErrService
+		if len(conf.Scopes) == 0 {
+			conf.Scopes = scopes[app.Service]
+		}
+
+		err = m.builtin(ctx, app, conf)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if app.AuthMethod == AuthMethodPrivateKeyJWT {
+		// Client is identified in params, assertion is added by
+		// ClientContext transport.
+		conf.ClientSecret = ""
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInParams
 	}
 
 	return conf, nil
 }
 
-func (m *Model) AuthCodeURL(ctx context.Context, service string, userID int) (string, error) {
-	conf, err := m.GetConf(ctx, service)
+// ClientConf method returns oauth2 config of service with ctx whose
+// HTTP client authenticates the app. Apps using private_key_jwt get
+// client signing assertion for each token endpoint request, others
+// get ctx unchanged.
+func (m *Model) ClientConf(ctx context.Context, service string) (context.Context, *oauth2.Config, error) {
+	app, err := m.GetByService(ctx, service)
 
 	if err != nil {
-		return "", err
+		return ctx, nil, err
 	}
 
-	var exchange exchanges.Exchange
+	app, err = m.GetByID(ctx, app.ID)
 
-	exchange.Service = service
-	exchange.UserID = userID
-	exchange.ID, err = helpers.RandomStr(32)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	return m.clientConf(ctx, app)
+}
+
+// ClientConfByID method returns client config of app by id to refresh
+// tokens minted under the app, enabled or deprecated. Returns ErrStatus
+// for app in other status.
+func (m *Model) ClientConfByID(ctx context.Context, id string) (context.Context, *oauth2.Config, error) {
+	app, err := m.GetByID(ctx, id)
 
 	if err != nil {
-		return "", err
+		return ctx, nil, err
 	}
 
-	_, err = m.exchanges.Create(ctx, &exchange)
+	if !app.Refreshable() {
+		return ctx, nil, ErrStatus
+	}
+
+	return m.clientConf(ctx, app)
+}
+
+func (m *Model) clientConf(ctx context.Context, app *App) (context.Context, *oauth2.Config, error) {
+	conf, err := m.config(ctx, app)
 
 	if err != nil {
-		return "", err
+		return ctx, nil, err
+	}
+
+	if app.AuthMethod != AuthMethodPrivateKeyJWT {
+		return ctx, conf, nil
+	}
+
+	signingKey, err := m.keyring.Open(app.SigningKey)
+
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	assertion, err := oidc.NewAssertion(app.ID, conf.Endpoint.TokenURL,
+		app.SigningKeyID, signingKey)
+
+	if err != nil {
+		return ctx, nil, ErrSigningKey
 	}
 
-	return conf.AuthCodeURL(exchange.ID), nil
+	base, _ := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, assertion.Client(base))
+
+	return ctx, conf, nil
 }
 
-func (m *Model) SetStatus(ctx context.Context, id string, status string) (*App, error) {
-	var app App
+// DefaultScopes method returns scopes requested when app defines none.
+func DefaultScopes(service string) []string {
+	return scopes[service]
+}
 
-	if status != StatusDisable && status != StatusEnable {
-		return nil, ErrStatus
+// BuiltinCapabilities method returns protocol features of builtin service.
+func BuiltinCapabilities(service string) providers.Capabilities {
+	if caps, ok := capabilities[service]; ok {
+		return caps
 	}
 
-	err := m.db.QueryRowContext(ctx, `UPDATE auth.apps 
-								SET status = $2
-								WHERE id = $1`,
-		id, status,
-	).Scan()
+	return providers.DefaultCapabilities
+}
+
+// EnabledServices method returns services having enabled app.
+func (m *Model) EnabledServices(ctx context.Context) (map[string]struct{}, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT DISTINCT "service"
+									     FROM auth.apps
+								WHERE status = $1`,
+		StatusEnable,
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &app, nil
+	defer rows.Close()
+
+	services := make(map[string]struct{})
+
+	for rows.Next() {
+		var service string
+
+		err = rows.Scan(&service)
+
+		if err != nil {
+			return nil, err
+		}
+
+		services[service] = struct{}{}
+	}
+
+	return services, rows.Err()
 }
 
-func (m *Model) Create(ctx context.Context, app *App) (string, error) {
-	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.apps
-									( "id", "service","password", 
-									 "callback_URL", "expiry",
-									 "created_at", "status")
-								VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		app.ID, app.Service, app.Password, app.CallbackURL,
-		app.Expiry, time.Now(), app.Status,
-	)
+// Capabilities method returns protocol features of service. Registry
+// entries take precedence over builtin ones, OIDC issuers are described by
+// their discovery document.
+func (m *Model) Capabilities(ctx context.Context, service string) (providers.Capabilities, error) {
+	provider, err := m.providers.Get(ctx, service)
+
+	switch {
+	case err == nil:
+		return provider.Capabilities, nil
+	case err != providers.ErrNotFound:
+		return providers.Capabilities{}, err
+	}
+
+	app, err := m.GetByService(ctx, service)
 
 	if err != nil {
-		if pgErr, ok := err.(*pq.Error); ok {
-			if pgErr.Code == "23505" {
-				return "", ErrExists
+		return providers.Capabilities{}, err
+	}
+
+	caps := BuiltinCapabilities(app.Service)
+
+	switch {
+	case app.Service == Salesforce:
+		host, ok := salesforceHosts[app.Environment]
+
+		if !ok {
+			host = salesforceHosts[EnvironmentProduction]
+		}
+
+		caps.RevocationURL = host + "/services/oauth2/revoke"
+		caps.IntrospectionURL = host + "/services/oauth2/introspect"
+		caps.ValidationURL = host + "/services/oauth2/userinfo"
+	case app.Issuer != "" && !isBuiltin(app.Service):
+		meta, err := m.discovery.Get(ctx, app.Issuer)
+
+		if err != nil {
+			return providers.Capabilities{}, err
+		}
+
+		caps.RevocationURL = meta.RevocationEndpoint
+		caps.SupportsRevoke = meta.RevocationEndpoint != ""
+		caps.IntrospectionURL = meta.IntrospectionEndpoint
+		caps.ValidationURL = meta.UserinfoEndpoint
+
+		for _, grant := range meta.GrantTypesSupported {
+			if grant == oidc.GrantTypeTokenExchange {
+				caps.TokenExchange = true
 			}
 		}
+	}
+
+	return caps, nil
+}
+
+// Issuer method returns OIDC issuer id_token of service is verified
+// against, or empty string when service issues no id_token.
+func (m *Model) Issuer(ctx context.Context, service string) (string, error) {
+	app, err := m.GetByService(ctx, service)
 
+	if err != nil {
 		return "", err
 	}
 
-	return app.ID, nil
+	if app.Issuer != "" {
+		return app.Issuer, nil
+	}
+
+	return issuers[app.Service], nil
+}
+
+// VerifyIDToken method validates id_token issued by service provider.
+func (m *Model) VerifyIDToken(ctx context.Context, issuer string, clientID string, raw string, nonce string) (*oidc.Claims, error) {
+	return m.discovery.VerifyIDToken(ctx, issuer, clientID, raw, nonce)
+}
+
+// Provider method returns registry entry of service.
+func (m *Model) Provider(ctx context.Context, service string) (*providers.Provider, error) {
+	return m.providers.Get(ctx, service)
+}
+
+func isBuiltin(service string) bool {
+	for _, s := range Builtin {
+		if s == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+// builtin method sets endpoint of providers known at compile time.
+func (m *Model) builtin(ctx context.Context, app *App, conf *oauth2.Config) error {
+	switch app.Service {
+	case Yandex:
+		conf.Endpoint = yandex.Endpoint
+	case Google:
+		conf.Endpoint = google.Endpoint
+	case Mail:
+		conf.Endpoint = mailru.Endpoint
+	case VK:
+		conf.Endpoint = vk.Endpoint
+	case Spotify:
+		conf.Endpoint = spotify.Endpoint
+	case Bitbucket:
+		conf.Endpoint = bitbucket.Endpoint
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	case Amazon:
+		conf.Endpoint = amazon.Endpoint
+	case Zoom:
+		conf.Endpoint = endpoints.Zoom
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	case Strava:
+		// Strava expects comma separated scopes.
+		if len(conf.Scopes) > 0 {
+			conf.Scopes = []string{strings.Join(conf.Scopes, ",")}
+		}
+
+		conf.Endpoint = endpoints.Strava
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	case Notion:
+		conf.Endpoint = oauth2.Endpoint{
+			AuthURL:   "https://api.notion.com/v1/oauth/authorize",
+			TokenURL:  "https://api.notion.com/v1/oauth/token",
+			AuthStyle: oauth2.AuthStyleInHeader,
+		}
+	case Salesforce:
+		env := app.Environment
+
+		if env == "" {
+			env = EnvironmentProduction
+		}
+
+		host, ok := salesforceHosts[env]
+
+		if !ok {
+			return ErrEnvironment
+		}
+
+		conf.Endpoint = oauth2.Endpoint{
+			AuthURL:  host + "/services/oauth2/authorize",
+			TokenURL: host + "/services/oauth2/token",
+		}
+	case CustomOIDC:
+		return m.discover(ctx, conf, app.Issuer)
+	default:
+		if app.Issuer == "" {
+			return ErrService
+		}
+
+		return m.discover(ctx, conf, app.Issuer)
+	}
+
+	return nil
+}
+
+func (m *Model) discover(ctx context.Context, conf *oauth2.Config, issuer string) error {
+	if issuer == "" {
+		return ErrIssuer
+	}
+
+	meta, err := m.discovery.Get(ctx, issuer)
+
+	if err != nil {
+		return err
+	}
+
+	conf.Endpoint = oauth2.Endpoint{
+		AuthURL:  meta.AuthorizationEndpoint,
+		TokenURL: meta.TokenEndpoint,
+	}
+
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{"openid"}
+	}
+
+	return nil
+}
+
+// AuthCodeURL method starts connect flow and returns auth code url.
+// Connected token is stored under external account, empty for default
+// account of service, so one user may connect several accounts of
+// service. Hints are HintParams values passed to provider, app auth
+// params take precedence over them. Labels are stored on connected token.
+func (m *Model) AuthCodeURL(ctx context.Context, service string, userID int, account string, hints map[string]string, labels map[string]string) (string, error) {
+	if len(account) > maxAccountLength ||
+		strings.ContainsAny(account, "\r\n/") {
+		return "", ErrAccount
+	}
+
+	for key, value := range hints {
+		if !isHint(key) || len(value) > maxHintLength ||
+			strings.ContainsAny(value, "\r\n") {
+			return "", ErrHint
+		}
+	}
+
+	app, err := m.GetByService(ctx, service)
+
+	if err != nil {
+		return "", err
+	}
+
+	return m.authCodeURL(ctx, app, userID, account, nil, hints, labels)
+}
+
+// IncrementalAuthCodeURL method returns auth code url adding requested
+// scopes to granted ones. Google merges grants itself with
+// include_granted_scopes, other providers are asked for the union.
+func (m *Model) IncrementalAuthCodeURL(ctx context.Context, service string, userID int, account string, granted []string, requested []string) (string, error) {
+	app, err := m.GetByService(ctx, service)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(granted) == 0 {
+		conf, err := m.config(ctx, app)
+
+		if err != nil {
+			return "", err
+		}
+
+		granted = conf.Scopes
+	}
+
+	merged := append([]string{}, granted...)
+	seen := make(map[string]struct{}, len(granted))
+
+	for _, scope := range granted {
+		seen[scope] = struct{}{}
+	}
+
+	for _, scope := range requested {
+		if _, ok := seen[scope]; !ok {
+			seen[scope] = struct{}{}
+			merged = append(merged, scope)
+		}
+	}
+
+	scoped := *app
+	scoped.Scopes = merged
+
+	return m.authCodeURL(ctx, &scoped, userID, account, merged, nil, nil)
+}
+
+// authCodeURL method starts connect flow. Scopes are recorded on exchange
+// for incremental authorization only.
+func (m *Model) authCodeURL(ctx context.Context, app *App, userID int, account string, scopes []string, hints map[string]string, labels map[string]string) (string, error) {
+	service := app.Service
+	conf, err := m.config(ctx, app)
+
+	if err != nil {
+		return "", err
+	}
+
+	var exchange exchanges.Exchange
+
+	exchange.Service = service
+	exchange.UserID = userID
+	exchange.Tenant = helpers.GetTenant(ctx)
+	exchange.CodeVerifier = oauth2.GenerateVerifier()
+	exchange.Scopes = scopes
+	exchange.Account = account
+	exchange.Labels = labels
+	exchange.AppID = app.ID
+	exchange.ID, err = helpers.RandomStr(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	issuer, err := m.Issuer(ctx, service)
+
+	if err != nil {
+		return "", err
+	}
+
+	if issuer != "" {
+		exchange.Nonce, err = helpers.RandomStr(32)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	_, err = m.exchanges.Create(ctx, &exchange)
+
+	if err != nil {
+		return "", err
+	}
+
+	_ = m.analytics.Record(ctx, analytics.Event{
+		Service: service,
+		Tenant:  exchange.Tenant,
+		Type:    analytics.EventAttempt,
+	})
+
+	// PKCE is sent to every provider: servers not supporting it ignore
+	// the challenge, so providers with pkce_required need nothing extra.
+	opts := append([]oauth2.AuthCodeOption{
+		oauth2.S256ChallengeOption(exchange.CodeVerifier),
+	}, authParams[service]...)
+
+	if exchange.Nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", exchange.Nonce))
+	}
+
+	if len(scopes) > 0 && service == Google {
+		opts = append(opts,
+			oauth2.SetAuthURLParam("include_granted_scopes", "true"))
+	}
+
+	for key, value := range hints {
+		if _, ok := app.AuthParams[key]; !ok {
+			opts = append(opts, oauth2.SetAuthURLParam(key, value))
+		}
+	}
+
+	if app.OfflineAccess {
+		opts = append(opts, offline(service, issuer, conf)...)
+	}
+
+	for key, value := range app.AuthParams {
+		opts = append(opts, oauth2.SetAuthURLParam(key, value))
+	}
+
+	return conf.AuthCodeURL(exchange.ID, opts...), nil
+}
+
+// offline function asks provider for refresh token the way it expects:
+// Google by access type and forced consent, Salesforce and OIDC
+// providers by scope.
+func offline(service string, issuer string, conf *oauth2.Config) []oauth2.AuthCodeOption {
+	switch {
+	case service == Google:
+		return []oauth2.AuthCodeOption{
+			oauth2.AccessTypeOffline, oauth2.ApprovalForce,
+		}
+	case service == Salesforce:
+		conf.Scopes = withScope(conf.Scopes, "refresh_token")
+	case issuer != "":
+		conf.Scopes = withScope(conf.Scopes, "offline_access")
+	}
+
+	return nil
+}
+
+func withScope(scopes []string, scope string) []string {
+	for _, s := range scopes {
+		if s == scope {
+			return scopes
+		}
+	}
+
+	return append(append([]string{}, scopes...), scope)
+}
+
+func isHint(key string) bool {
+	for _, hint := range HintParams {
+		if hint == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Model) SetScopes(ctx context.Context, id string, scopes []string) (*App, error) {
+	var service string
+
+	err := m.db.QueryRowContext(ctx, `UPDATE auth.apps
+								SET scopes = $2
+								WHERE id = $1
+								RETURNING "service"`,
+		id, pq.Array(storedScopes(scopes)),
+	).Scan(&service)
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNotFound)
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionAppScopes,
+		Severity: audit.SeverityHigh,
+		Service:  service,
+	})
+
+	return m.GetByID(ctx, id)
+}
+
+func (m *Model) Create(ctx context.Context, app *App) (string, error) {
+	err := prepare(app)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = m.sealSecrets(app)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = insert(ctx, m.db, app)
+
+	if err != nil {
+		return "", err
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(app.Service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionAppCreated,
+		Severity:    audit.SeverityHigh,
+		Service:     app.Service,
+		Fingerprint: app.Fingerprint,
+	})
+
+	return app.ID, nil
+}
+
+// Put method creates app under its id or replaces every field of stored
+// one but service, in single transaction, so repeating request changes
+// nothing. Precondition is called with stored app, nil when there is
+// none, and its error aborts the write. Returns whether app was created.
+func (m *Model) Put(ctx context.Context, app *App, precondition func(current *App) error) (bool, error) {
+	err := prepare(app)
+
+	if err != nil {
+		return false, err
+	}
+
+	err = m.sealSecrets(app)
+
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	current, err := scanApp(tx.QueryRowContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								WHERE id = $1
+								FOR UPDATE`,
+		app.ID,
+	))
+
+	if err == ErrNotFound {
+		current, err = nil, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	err = precondition(current)
+
+	if err != nil {
+		return false, err
+	}
+
+	action := audit.ActionAppCreated
+
+	if current == nil {
+		err = insert(ctx, tx, app)
+	} else {
+		action = audit.ActionAppUpdated
+		err = update(ctx, tx, current, app)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return false, err
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(app.Service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      action,
+		Severity:    audit.SeverityHigh,
+		Service:     app.Service,
+		Fingerprint: app.Fingerprint,
+	})
+
+	return current == nil, nil
+}
+
+// Update method applies patch to stored app in single transaction.
+// Precondition is called with stored app and its error aborts the write.
+// Returns updated app.
+func (m *Model) Update(ctx context.Context, id string, patch AppPatch, precondition func(current *App) error) (*App, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	current, err := scanApp(tx.QueryRowContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								WHERE id = $1
+								FOR UPDATE`,
+		id,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = precondition(current)
+
+	if err != nil {
+		return nil, err
+	}
+
+	app := *current
+
+	if patch.Password != nil {
+		err = m.sealPassword(&app, *patch.Password)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if patch.CallbackURL != nil {
+		app.CallbackURL = *patch.CallbackURL
+	}
+
+	if patch.Scopes != nil {
+		app.Scopes = patch.Scopes
+	}
+
+	if patch.Expiry != nil {
+		app.Expiry = patch.Expiry
+	}
+
+	err = update(ctx, tx, current, &app)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(app.Service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionAppUpdated,
+		Severity:    audit.SeverityHigh,
+		Service:     app.Service,
+		Fingerprint: app.Fingerprint,
+	})
+
+	return &app, nil
+}
+
+// Delete method removes app along with connect flows pending under it,
+// in single transaction. App taking part in migration is kept, so is app
+// with tokens minted under it left after purge, which is called with the
+// transaction to delete them, nil purge deleting none. Tokens and flows
+// recorded without app belong to the only app of service.
+func (m *Model) Delete(ctx context.Context, id string, purge func(tx *sql.Tx, app *App) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	app, err := scanApp(tx.QueryRowContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								WHERE id = $1
+								FOR UPDATE`,
+		id,
+	))
+
+	if err != nil {
+		return err
+	}
+
+	var migrating bool
+
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1
+										     FROM auth.app_migrations
+									WHERE from_app_id = $1 OR to_app_id = $1)`,
+		app.ID,
+	).Scan(&migrating)
+
+	if err != nil {
+		return err
+	}
+
+	if migrating {
+		return ErrMigrating
+	}
+
+	if purge != nil {
+		err = purge(tx, app)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	var hasTokens bool
+
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1
+										     FROM auth.tokens
+									WHERE `+OwnedBy+`)`,
+		app.ID, app.Service,
+	).Scan(&hasTokens)
+
+	if err != nil {
+		return err
+	}
+
+	if hasTokens {
+		return ErrHasTokens
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE
+								FROM auth.exchanges
+								WHERE `+OwnedBy,
+		app.ID, app.Service,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE
+								FROM auth.apps
+								WHERE id = $1`,
+		app.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return err
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(app.Service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionAppDeleted,
+		Severity:    audit.SeverityHigh,
+		Service:     app.Service,
+		Fingerprint: app.Fingerprint,
+	})
+
+	return nil
+}
+
+// prepare function validates app and fills defaults before it is
+// stored.
+func prepare(app *App) error {
+	err := ValidateAuthParams(app.AuthParams)
+
+	if err != nil {
+		return err
+	}
+
+	if app.AuthMethod == "" {
+		app.AuthMethod = AuthMethodSecret
+	}
+
+	if app.Weight == 0 {
+		app.Weight = defaultWeight
+	}
+
+	if app.AuthMethod == AuthMethodPrivateKeyJWT {
+		_, _, err = oidc.ParseSigningKey(app.SigningKey)
+
+		if err != nil {
+			return ErrSigningKey
+		}
+	}
+
+	return nil
+}
+
+func insert(ctx context.Context, db execer, app *App) error {
+	authParams, err := json.Marshal(app.AuthParams)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO auth.apps
+									( "id", "service","password", 
+									 "password_fingerprint", "callback_URL",
+									 "scopes", "environment", "issuer",
+									 "auth_params", "expiry", "created_at",
+									 "status", "auth_method", "signing_key",
+									 "signing_key_id", "offline_access",
+									 "weight", "tenant")
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+									$13, $14, $15, $16, $17, $18)`,
+		app.ID, app.Service, dblog.Secret(app.Password),
+		app.Fingerprint, app.CallbackURL, pq.Array(storedScopes(app.Scopes)),
+		app.Environment, app.Issuer, authParams,
+		app.Expiry, time.Now(), app.Status, app.AuthMethod,
+		dblog.Secret(app.SigningKey), app.SigningKeyID, app.OfflineAccess,
+		app.Weight, app.Tenant,
+	)
+
+	if err != nil {
+		if pgErr, ok := err.(*pq.Error); ok {
+			if pgErr.Code == "23505" {
+				return ErrExists
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// sealSecrets method seals plain client secrets of app with keyring.
+func (m *Model) sealSecrets(app *App) error {
+	err := m.sealPassword(app, app.Password)
+
+	if err != nil {
+		return err
+	}
+
+	app.SigningKey, err = m.keyring.Seal(app.SigningKey)
+
+	return err
+}
+
+// withoutSecrets method returns copy of app without client secrets.
+func (app *App) withoutSecrets() *App {
+	c := *app
+	c.Password = ""
+	c.PreviousPassword = ""
+	c.SigningKey = ""
+
+	return &c
+}
+
+// sealPassword method sets password of app sealed with keyring, along
+// with fingerprint of plain one.
+func (m *Model) sealPassword(app *App, password string) error {
+	sealed, err := m.keyring.Seal(password)
+
+	if err != nil {
+		return err
+	}
+
+	app.Password = sealed
+	app.Fingerprint = dblog.Fingerprint(password)
+
+	return nil
+}
+
+func update(ctx context.Context, db execer, current *App, app *App) error {
+	if current.Service != app.Service {
+		return ErrServiceChange
+	}
+
+	if !CanTransition(current.Status, app.Status) {
+		return ErrTransition
+	}
+
+	authParams, err := json.Marshal(app.AuthParams)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `UPDATE auth.apps
+								SET password = $2, password_fingerprint = $3,
+									"callback_URL" = $4, scopes = $5,
+									environment = $6, issuer = $7,
+									auth_params = $8, expiry = $9,
+									status = $10, auth_method = $11,
+									signing_key = $12, signing_key_id = $13,
+									offline_access = $14, weight = $15,
+									tenant = $16
+								WHERE id = $1`,
+		app.ID, dblog.Secret(app.Password), app.Fingerprint,
+		app.CallbackURL, pq.Array(storedScopes(app.Scopes)), app.Environment,
+		app.Issuer, authParams, app.Expiry, app.Status, app.AuthMethod,
+		dblog.Secret(app.SigningKey), app.SigningKeyID, app.OfflineAccess,
+		app.Weight, app.Tenant,
+	)
+
+	return err
+}
+
+// storedScopes function returns scopes as stored: apps without own scopes
+// store empty ones, as column is not null, and use service defaults.
+func storedScopes(scopes []string) []string {
+	if scopes == nil {
+		return []string{}
+	}
+
+	return scopes
+}
+
+// ValidateAuthParams method checks that auth params override no
+// parameter the flow itself depends on.
+func ValidateAuthParams(params map[string]string) error {
+	for key := range params {
+		if _, ok := reservedAuthParams[key]; ok {
+			return ErrAuthParams
+		}
+	}
+
+	return nil
+}
+
+func scanApp(row scanner) (*App, error) {
+	var app App
+	var authParams []byte
+
+	err := row.Scan(&app.ID, &app.Service, &app.Password, &app.Fingerprint,
+		&app.CallbackURL,
+		pq.Array(&app.Scopes), &app.Environment, &app.Issuer, &authParams,
+		&app.Expiry, &app.CreatedAt, &app.AuthMethod, &app.SigningKey,
+		&app.SigningKeyID, &app.OfflineAccess, &app.Status, &app.Weight,
+		&app.Tenant, &app.PreviousPassword, &app.PreviousPasswordExpiry)
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNotFound)
+	}
+
+	err = json.Unmarshal(authParams, &app.AuthParams)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+// OwnedBy is SQL condition matching tokens and exchanges of app $1 of
+// service $2. Ones recorded without app belong to the only app of service.
+const OwnedBy = `(app_id = $1 OR (app_id = '' AND service = $2
+	AND NOT EXISTS (SELECT 1 FROM auth.apps WHERE service = $2 AND id <> $1)))`
+
+func serviceCacheKey(service string) string {
+	return "apps:service:" + service
 }
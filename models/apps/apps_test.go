@@ -0,0 +1,194 @@
+package apps
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Zetkolink/auth/cache"
+	"github.com/Zetkolink/auth/models/analytics"
+	"github.com/Zetkolink/auth/models/exchanges"
+	"github.com/Zetkolink/auth/models/providers"
+	"github.com/Zetkolink/auth/models/storage/storagetest"
+)
+
+var errNotNull = errors.New("null value violates not-null constraint")
+
+// newTestModel function returns model over scripted database, serving
+// apps of rows and no registry providers.
+func newTestModel(t *testing.T, db *storagetest.DB, rows ...[]driver.Value) *Model {
+	t.Helper()
+
+	db.Handle(`FROM auth.apps`, storagetest.Rows(appColumnNames, rows...))
+	db.Handle(`FROM auth.providers`, storagetest.Rows(nil))
+	db.Handle(`INSERT INTO auth.usage_daily`, storagetest.Affected(1))
+
+	exchangesModel, err := exchanges.NewModel(exchanges.ModelConfig{Db: db.DB})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analyticsModel, err := analytics.NewModel(analytics.ModelConfig{Db: db.DB})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cache.NewMemory(0)
+
+	providersModel, err := providers.NewModel(providers.ModelConfig{
+		Db:    db.DB,
+		Cache: c,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewModel(ModelConfig{
+		Db:          db.DB,
+		Exchanges:   exchangesModel,
+		Analytics:   analyticsModel,
+		Providers:   providersModel,
+		Cache:       c,
+		CallbackURL: "https://auth.example.com/api/v1/tokens",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+var appColumnNames = []string{"id", "service", "password",
+	"password_fingerprint", "callback_URL", "scopes", "environment",
+	"issuer", "auth_params", "expiry", "created_at", "auth_method",
+	"signing_key", "signing_key_id", "offline_access", "status", "weight",
+	"tenant", "previous_password", "previous_password_expiry"}
+
+// appRow function returns stored enabled app of service.
+func appRow(id string, service string) []driver.Value {
+	return []driver.Value{id, service, "secret", "", "", "{}", "", "",
+		[]byte("{}"), nil, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		AuthMethodSecret, "", "", false, StatusEnable, int64(1), "", "",
+		nil}
+}
+
+// notNullScopes function answers exchange insert the way not null scopes
+// column does.
+func notNullScopes(args []driver.Value) storagetest.Result {
+	if args[6] == nil {
+		return storagetest.Result{Err: errNotNull}
+	}
+
+	return storagetest.Result{RowsAffected: 1}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		incremental bool
+		scopes      string
+	}{
+		{name: "plain", scopes: "{}"},
+		{name: "incremental", incremental: true, scopes: `{"openid","email","drive"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storagetest.New()
+			db.Handle(`INSERT INTO auth.exchanges`, notNullScopes)
+			m := newTestModel(t, db, appRow("app-1", Google))
+			ctx := context.Background()
+
+			var raw string
+			var err error
+
+			if tt.incremental {
+				raw, err = m.IncrementalAuthCodeURL(ctx, Google, 1001, "",
+					[]string{"openid", "email"}, []string{"drive"})
+			} else {
+				raw, err = m.AuthCodeURL(ctx, Google, 1001, "", nil, nil)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, err := url.Parse(raw)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if u.Query().Get("client_id") != "app-1" {
+				t.Errorf("client_id = %q, want app-1", u.Query().Get("client_id"))
+			}
+
+			inserts := db.Statements(`INSERT INTO auth.exchanges`)
+
+			if len(inserts) != 1 {
+				t.Fatalf("%d exchanges stored, want 1", len(inserts))
+			}
+
+			if got := inserts[0].Args[6]; got != tt.scopes {
+				t.Errorf("exchange scopes = %v, want %s", got, tt.scopes)
+			}
+
+			if u.Query().Get("state") != inserts[0].Args[0] {
+				t.Error("state is not stored exchange id")
+			}
+		})
+	}
+}
+
+func TestCreateScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		want   string
+	}{
+		{name: "omitted", want: "{}"},
+		{name: "given", scopes: []string{"user-read-email"}, want: `{"user-read-email"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storagetest.New()
+			db.Handle(`INSERT INTO auth.apps`, func(args []driver.Value) storagetest.Result {
+				if args[5] == nil {
+					return storagetest.Result{Err: errNotNull}
+				}
+
+				return storagetest.Result{RowsAffected: 1}
+			})
+			m := newTestModel(t, db)
+
+			_, err := m.Create(context.Background(), &App{
+				ID:      "app-1",
+				Service: Spotify,
+				Scopes:  tt.scopes,
+				Status:  StatusEnable,
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			inserts := db.Statements(`INSERT INTO auth.apps`)
+
+			if len(inserts) != 1 {
+				t.Fatalf("%d apps stored, want 1", len(inserts))
+			}
+
+			if got := inserts[0].Args[5]; got != tt.want {
+				t.Errorf("scopes = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package apps
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Zetkolink/auth/audit"
+)
+
+const (
+	// StatusPending app is registered but serves nothing yet.
+	StatusPending = "pending"
+
+	// StatusDeprecated app starts no new connect flows, tokens minted
+	// under it keep refreshing.
+	StatusDeprecated = "deprecated"
+
+	// StatusExpired app credentials expired at provider, it serves
+	// nothing until renewed and enabled again.
+	StatusExpired = "expired"
+)
+
+// ErrTransition app status cannot change to requested one.
+var ErrTransition = errors.New("app status transition not allowed")
+
+// transitions lists statuses app may move to from each status.
+var transitions = map[string]map[string]struct{}{
+	StatusPending: {
+		StatusEnable:  {},
+		StatusDisable: {},
+	},
+	StatusEnable: {
+		StatusDeprecated: {},
+		StatusDisable:    {},
+		StatusExpired:    {},
+	},
+	StatusDeprecated: {
+		StatusEnable:  {},
+		StatusDisable: {},
+		StatusExpired: {},
+	},
+	StatusDisable: {
+		StatusPending:    {},
+		StatusEnable:     {},
+		StatusDeprecated: {},
+	},
+	StatusExpired: {
+		StatusEnable:  {},
+		StatusDisable: {},
+	},
+}
+
+// ValidStatus function reports whether status is app lifecycle status.
+func ValidStatus(status string) bool {
+	_, ok := transitions[status]
+
+	return ok
+}
+
+// CanTransition function reports whether app may move from status to
+// another. Staying in status is always allowed.
+func CanTransition(from string, to string) bool {
+	if from == to {
+		return ValidStatus(to)
+	}
+
+	_, ok := transitions[from][to]
+
+	return ok
+}
+
+// Refreshable method reports whether tokens minted under app may be
+// refreshed, revoked and introspected with it.
+func (app *App) Refreshable() bool {
+	return app.Status == StatusEnable || app.Status == StatusDeprecated
+}
+
+// SetStatus method moves app to status when its lifecycle allows it, in
+// single transaction.
+func (m *Model) SetStatus(ctx context.Context, id string, status string) (*App, error) {
+	if !ValidStatus(status) {
+		return nil, ErrStatus
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	app, err := scanApp(tx.QueryRowContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								WHERE id = $1
+								FOR UPDATE`,
+		id,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !CanTransition(app.Status, status) {
+		return nil, ErrTransition
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.apps
+								SET status = $2
+								WHERE id = $1`,
+		id, status,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	app.Status = status
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(app.Service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionAppStatus,
+		Severity: audit.SeverityHigh,
+		Service:  app.Service,
+	})
+
+	return app, nil
+}
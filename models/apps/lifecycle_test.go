@@ -0,0 +1,90 @@
+package apps
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{from: StatusPending, to: StatusEnable, want: true},
+		{from: StatusPending, to: StatusDisable, want: true},
+		{from: StatusPending, to: StatusDeprecated, want: false},
+		{from: StatusPending, to: StatusExpired, want: false},
+		{from: StatusEnable, to: StatusDeprecated, want: true},
+		{from: StatusEnable, to: StatusDisable, want: true},
+		{from: StatusEnable, to: StatusExpired, want: true},
+		{from: StatusEnable, to: StatusPending, want: false},
+		{from: StatusDeprecated, to: StatusEnable, want: true},
+		{from: StatusDeprecated, to: StatusExpired, want: true},
+		{from: StatusDeprecated, to: StatusPending, want: false},
+		{from: StatusDisable, to: StatusPending, want: true},
+		{from: StatusDisable, to: StatusEnable, want: true},
+		{from: StatusDisable, to: StatusDeprecated, want: true},
+		{from: StatusDisable, to: StatusExpired, want: false},
+		{from: StatusExpired, to: StatusEnable, want: true},
+		{from: StatusExpired, to: StatusDisable, want: true},
+		{from: StatusExpired, to: StatusDeprecated, want: false},
+		{from: StatusEnable, to: StatusEnable, want: true},
+		{from: StatusExpired, to: StatusExpired, want: true},
+		{from: "unknown", to: "unknown", want: false},
+		{from: "unknown", to: StatusEnable, want: false},
+		{from: StatusEnable, to: "unknown", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%q, %q) = %v, want %v",
+					tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{status: StatusPending, want: true},
+		{status: StatusEnable, want: true},
+		{status: StatusDeprecated, want: true},
+		{status: StatusDisable, want: true},
+		{status: StatusExpired, want: true},
+		{status: "", want: false},
+		{status: "enabled", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			if got := ValidStatus(tt.status); got != tt.want {
+				t.Errorf("ValidStatus(%q) = %v, want %v", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshable(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{status: StatusPending, want: false},
+		{status: StatusEnable, want: true},
+		{status: StatusDeprecated, want: true},
+		{status: StatusDisable, want: false},
+		{status: StatusExpired, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			app := &App{Status: tt.status}
+
+			if got := app.Refreshable(); got != tt.want {
+				t.Errorf("Refreshable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/models/storage"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrNoMigration service is not being migrated.
+	ErrNoMigration = errors.New("service is not being migrated")
+
+	// ErrMigrating service is being migrated already.
+	ErrMigrating = errors.New("service is being migrated already")
+
+	// ErrSameApp migration target is the enabled app.
+	ErrSameApp = errors.New("migration target is the enabled app")
+)
+
+// Migration type represents move of service to new client. While it is
+// under way the new app serves connect flows, codes issued to the old
+// client are still exchanged and tokens keep refreshing with the client
+// they were minted under.
+type Migration struct {
+	Service   string    `json:"service"`
+	FromAppID string    `json:"from_app_id"`
+	ToAppID   string    `json:"to_app_id" validate:"required"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// StartMigration method moves service to app toAppID: the app is enabled
+// in place of the enabled one, which is deprecated to serve tokens minted
+// under it. Tokens minted before tokens recorded their app are attributed to the
+// enabled app.
+func (m *Model) StartMigration(ctx context.Context, service string, toAppID string) (*Migration, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	from, err := scanApp(tx.QueryRowContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								WHERE service = $1 AND status = $2
+								FOR UPDATE`,
+		service, StatusEnable,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if from.ID == toAppID {
+		return nil, ErrSameApp
+	}
+
+	to, err := scanApp(tx.QueryRowContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								WHERE id = $1
+								FOR UPDATE`,
+		toAppID,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if to.Service != service {
+		return nil, ErrNotFound
+	}
+
+	migration := &Migration{
+		Service:   service,
+		FromAppID: from.ID,
+		ToAppID:   to.ID,
+		StartedAt: time.Now(),
+	}
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO auth.app_migrations
+									( "service", "from_app_id", "to_app_id",
+									 "started_at")
+								VALUES ($1, $2, $3, $4)
+								ON CONFLICT (service) DO NOTHING`,
+		migration.Service, migration.FromAppID, migration.ToAppID,
+		migration.StartedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, ErrMigrating
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.apps
+								SET status = CASE WHEN id = $2 THEN $3 ELSE $4 END
+								WHERE id IN ($1, $2)`,
+		from.ID, to.ID, StatusEnable, StatusDeprecated,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.tokens
+								SET app_id = $2
+								WHERE service = $1 AND app_id = ''`,
+		service, from.ID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionAppMigrationStarted,
+		Severity: audit.SeverityHigh,
+		Service:  service,
+	})
+
+	return migration, nil
+}
+
+// Migration method returns migration of service under way.
+func (m *Model) Migration(ctx context.Context, service string) (*Migration, error) {
+	var migration Migration
+
+	err := m.db.QueryRowContext(ctx, `SELECT "service", "from_app_id",
+										"to_app_id", "started_at"
+									     FROM auth.app_migrations
+								WHERE service = $1`,
+		service,
+	).Scan(&migration.Service, &migration.FromAppID, &migration.ToAppID,
+		&migration.StartedAt)
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNoMigration)
+	}
+
+	return &migration, nil
+}
+
+// FinishMigration method ends migration of service, codes issued to old
+// client are no longer exchanged. Tokens minted under old client keep
+// refreshing with it while the app is deprecated.
+func (m *Model) FinishMigration(ctx context.Context, service string) error {
+	res, err := m.db.ExecContext(ctx, `DELETE FROM auth.app_migrations
+								WHERE service = $1`,
+		service,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoMigration
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionAppMigrationFinished,
+		Severity: audit.SeverityHigh,
+		Service:  service,
+	})
+
+	return nil
+}
+
+// FallbackClientConf method returns client config of app service is
+// being migrated from, to exchange codes issued to it. Returns
+// ErrNoMigration when service is not being migrated.
+func (m *Model) FallbackClientConf(ctx context.Context, service string) (context.Context, *oauth2.Config, error) {
+	migration, err := m.Migration(ctx, service)
+
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	return m.ClientConfByID(ctx, migration.FromAppID)
+}
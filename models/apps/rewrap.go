@@ -0,0 +1,139 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/Zetkolink/auth/operations"
+)
+
+// OperationRewrap is the operation kind sealing stored client secrets
+// with primary encryption key.
+const OperationRewrap = "apps.rewrap"
+
+// sealed type represents client secrets of app as they are stored.
+type sealed struct {
+	id               string
+	service          string
+	password         string
+	previousPassword string
+	signingKey       string
+}
+
+// Rewrap method seals stored client secrets not sealed with primary key,
+// ones stored before encryption was enabled included, so retired keys can
+// be removed from keyring. Secret changed while it is rewrapped is
+// skipped, its writer sealed it with primary key already.
+func (m *Model) Rewrap(ctx context.Context, _ json.RawMessage, p *operations.Progress) error {
+	list, err := m.sealedSecrets(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	p.SetTotal(len(list))
+
+	for _, s := range list {
+		err = m.rewrap(ctx, s)
+
+		if err != nil {
+			p.Fail(s.id, err)
+		} else {
+			p.Advance(1)
+		}
+	}
+
+	return nil
+}
+
+func (m *Model) sealedSecrets(ctx context.Context) ([]sealed, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "id", "service", "password",
+										"previous_password", "signing_key"
+									     FROM auth.apps
+								ORDER BY id`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var list []sealed
+
+	for rows.Next() {
+		var s sealed
+
+		err = rows.Scan(&s.id, &s.service, &s.password, &s.previousPassword,
+			&s.signingKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, s)
+	}
+
+	return list, rows.Err()
+}
+
+// rewrap method re-seals client secrets of single app when they are not
+// current.
+func (m *Model) rewrap(ctx context.Context, s sealed) error {
+	password, err := m.reseal(s.password)
+
+	if err != nil {
+		return err
+	}
+
+	previousPassword, err := m.reseal(s.previousPassword)
+
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := m.reseal(s.signingKey)
+
+	if err != nil {
+		return err
+	}
+
+	if password == s.password && previousPassword == s.previousPassword &&
+		signingKey == s.signingKey {
+		return nil
+	}
+
+	_, err = m.db.ExecContext(ctx, `UPDATE auth.apps
+								SET password = $5, previous_password = $6,
+									signing_key = $7
+								WHERE id = $1 AND password = $2
+									AND previous_password = $3
+									AND signing_key = $4`,
+		s.id, dblog.Secret(s.password), dblog.Secret(s.previousPassword),
+		dblog.Secret(s.signingKey), dblog.Secret(password),
+		dblog.Secret(previousPassword), dblog.Secret(signingKey),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(s.service))
+
+	return nil
+}
+
+func (m *Model) reseal(value string) (string, error) {
+	if m.keyring.Current(value) {
+		return value, nil
+	}
+
+	plain, err := m.keyring.Open(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return m.keyring.Seal(plain)
+}
@@ -0,0 +1,165 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/dblog"
+	"golang.org/x/oauth2"
+)
+
+const defaultSecretGrace = 24 * time.Hour
+
+// Secrets type represents client secrets of app in plain. Previous
+// password is set while its grace window lasts.
+type Secrets struct {
+	Password               string     `json:"password"`
+	PreviousPassword       string     `json:"previous_password,omitempty"`
+	PreviousPasswordExpiry *time.Time `json:"previous_password_expiry,omitempty"`
+	SigningKey             string     `json:"signing_key,omitempty"`
+}
+
+var (
+	// ErrNoSecret app authenticates without client secret.
+	ErrNoSecret = errors.New("app authenticates without client secret")
+
+	// ErrNoPreviousSecret app has no previous secret valid.
+	ErrNoPreviousSecret = errors.New("app has no previous secret")
+)
+
+// RotateSecret method replaces client secret of app, the replaced one
+// kept valid for grace, configured grace when not positive, so refreshes
+// keep working whichever secret provider accepts meanwhile.
+func (m *Model) RotateSecret(ctx context.Context, id string, password string, grace time.Duration) (*App, error) {
+	if grace <= 0 {
+		grace = m.secretGrace
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	app, err := scanApp(tx.QueryRowContext(ctx, `SELECT `+appColumns+`
+									     FROM auth.apps
+								WHERE id = $1
+								FOR UPDATE`,
+		id,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if app.AuthMethod == AuthMethodPrivateKeyJWT {
+		return nil, ErrNoSecret
+	}
+
+	expiry := time.Now().Add(grace)
+
+	app.PreviousPassword = app.Password
+	app.PreviousPasswordExpiry = &expiry
+
+	err = m.sealPassword(app, password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.apps
+								SET password = $2, password_fingerprint = $3,
+									previous_password = $4,
+									previous_password_expiry = $5
+								WHERE id = $1`,
+		app.ID, dblog.Secret(app.Password), app.Fingerprint,
+		dblog.Secret(app.PreviousPassword), app.PreviousPasswordExpiry,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = m.cache.Delete(ctx, serviceCacheKey(app.Service))
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionAppSecretRotated,
+		Severity:    audit.SeverityHigh,
+		Service:     app.Service,
+		Fingerprint: app.Fingerprint,
+	})
+
+	return app, nil
+}
+
+// RevealSecrets method returns client secrets of app opened with keyring.
+// Every reveal is audited.
+func (m *Model) RevealSecrets(ctx context.Context, id string) (*Secrets, error) {
+	app, err := m.GetByID(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := &Secrets{}
+	secrets.Password, err = m.keyring.Open(app.Password)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secrets.SigningKey, err = m.keyring.Open(app.SigningKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if app.PreviousPasswordExpiry != nil &&
+		app.PreviousPasswordExpiry.After(time.Now()) {
+		secrets.PreviousPassword, err = m.keyring.Open(app.PreviousPassword)
+
+		if err != nil {
+			return nil, err
+		}
+
+		secrets.PreviousPasswordExpiry = app.PreviousPasswordExpiry
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionAppSecretRevealed,
+		Severity:    audit.SeverityHigh,
+		Service:     app.Service,
+		Fingerprint: app.Fingerprint,
+	})
+
+	return secrets, nil
+}
+
+// PreviousClientConf method returns client config of app by id with its
+// replaced secret, to retry requests provider rejected the current one
+// for. Returns ErrNoPreviousSecret once grace window is over.
+func (m *Model) PreviousClientConf(ctx context.Context, id string) (context.Context, *oauth2.Config, error) {
+	app, err := m.GetByID(ctx, id)
+
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	if app.PreviousPassword == "" || app.PreviousPasswordExpiry == nil ||
+		!app.PreviousPasswordExpiry.After(time.Now()) {
+		return ctx, nil, ErrNoPreviousSecret
+	}
+
+	app.Password = app.PreviousPassword
+
+	return m.clientConf(ctx, app)
+}
@@ -0,0 +1,131 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/Zetkolink/auth/http/helpers"
+)
+
+const (
+	// StrategyFirst connect flows of service are served by its oldest
+	// enabled app.
+	StrategyFirst = "first"
+
+	// StrategyRoundRobin connect flows of service are spread over its
+	// enabled apps in turn.
+	StrategyRoundRobin = "round_robin"
+
+	// StrategyWeighted connect flows of service are spread over its
+	// enabled apps at random, in proportion to their weights.
+	StrategyWeighted = "weighted"
+
+	// StrategyTenant connect flows of tenant are served by enabled app of
+	// the tenant, tenants without own app by apps without tenant.
+	StrategyTenant = "tenant"
+
+	defaultWeight = 1
+)
+
+// ErrStrategy app selection strategy is unknown.
+var ErrStrategy = errors.New("unknown app selection strategy")
+
+// selector type represents per-service selection state of enabled apps.
+type selector struct {
+	strategies map[string]string
+
+	mu      sync.Mutex
+	counter map[string]uint64
+}
+
+func newSelector(strategies map[string]string) (*selector, error) {
+	for _, strategy := range strategies {
+		switch strategy {
+		case StrategyFirst, StrategyRoundRobin, StrategyWeighted, StrategyTenant:
+		default:
+			return nil, ErrStrategy
+		}
+	}
+
+	s := &selector{
+		strategies: strategies,
+		counter:    make(map[string]uint64),
+	}
+
+	return s, nil
+}
+
+// pick method returns app of list serving connect flow of ctx, list
+// being enabled apps of service, oldest first.
+func (m *Model) pick(ctx context.Context, service string, list []*App) (*App, error) {
+	if len(list) == 1 {
+		return list[0], nil
+	}
+
+	switch m.selector.strategies[service] {
+	case StrategyRoundRobin:
+		return list[m.selector.next(service)%uint64(len(list))], nil
+	case StrategyWeighted:
+		return pickWeighted(list), nil
+	case StrategyTenant:
+		return pickTenant(helpers.GetTenant(ctx), list)
+	default:
+		return list[0], nil
+	}
+}
+
+func (s *selector) next(service string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.counter[service]
+	s.counter[service]++
+
+	return n
+}
+
+func pickWeighted(list []*App) *App {
+	total := 0
+
+	for _, app := range list {
+		total += app.Weight
+	}
+
+	if total <= 0 {
+		return list[0]
+	}
+
+	n := rand.Intn(total)
+
+	for _, app := range list {
+		if n < app.Weight {
+			return app
+		}
+
+		n -= app.Weight
+	}
+
+	return list[0]
+}
+
+func pickTenant(tenant string, list []*App) (*App, error) {
+	var shared *App
+
+	for _, app := range list {
+		if tenant != "" && app.Tenant == tenant {
+			return app, nil
+		}
+
+		if app.Tenant == "" && shared == nil {
+			shared = app
+		}
+	}
+
+	if shared == nil {
+		return nil, ErrNotFound
+	}
+
+	return shared, nil
+}
@@ -0,0 +1,146 @@
+package apps
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPickWeighted(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    []int
+	}{
+		{name: "single", weights: []int{1}, want: []int{1000}},
+		{name: "even", weights: []int{1, 1}, want: []int{500, 500}},
+		{name: "skewed", weights: []int{3, 1}, want: []int{750, 250}},
+		{name: "zero weight", weights: []int{0, 2}, want: []int{0, 1000}},
+		{name: "no weight", weights: []int{0, 0}, want: []int{1000, 0}},
+	}
+
+	const rounds = 1000
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := make([]*App, len(tt.weights))
+			index := make(map[*App]int, len(list))
+
+			for i, w := range tt.weights {
+				list[i] = &App{Weight: w}
+				index[list[i]] = i
+			}
+
+			got := make([]int, len(list))
+
+			for i := 0; i < rounds; i++ {
+				got[index[pickWeighted(list)]]++
+			}
+
+			for i, want := range tt.want {
+				// Random picks stay within tenth of rounds of their
+				// share.
+				if d := got[i] - want; d > rounds/10 || d < -rounds/10 {
+					t.Errorf("app %d picked %d times, want about %d",
+						i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestPickTenant(t *testing.T) {
+	shared := &App{ID: "shared"}
+	other := &App{ID: "other"}
+	acme := &App{ID: "acme", Tenant: "acme"}
+	globex := &App{ID: "globex", Tenant: "globex"}
+
+	tests := []struct {
+		name   string
+		tenant string
+		list   []*App
+		want   *App
+		err    error
+	}{
+		{name: "own app", tenant: "acme", list: []*App{shared, acme}, want: acme},
+		{name: "shared fallback", tenant: "initech", list: []*App{acme, shared}, want: shared},
+		{name: "oldest shared", tenant: "initech", list: []*App{shared, other}, want: shared},
+		{name: "no tenant", tenant: "", list: []*App{acme, shared}, want: shared},
+		{name: "no shared", tenant: "initech", list: []*App{acme, globex}, err: ErrNotFound},
+		{name: "no tenant no shared", tenant: "", list: []*App{acme}, err: ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickTenant(tt.tenant, tt.list)
+
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("picked %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	s, err := newSelector(map[string]string{
+		Google: StrategyRoundRobin,
+		Zoom:   StrategyFirst,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Model{selector: s}
+	list := []*App{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	tests := []struct {
+		service string
+		want    []string
+	}{
+		{service: Google, want: []string{"a", "b", "c", "a", "b"}},
+		{service: Zoom, want: []string{"a", "a", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			for i, want := range tt.want {
+				app, err := m.pick(context.Background(), tt.service, list)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if app.ID != want {
+					t.Errorf("pick %d = %q, want %q", i, app.ID, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSelector(t *testing.T) {
+	tests := []struct {
+		strategy string
+		err      error
+	}{
+		{strategy: StrategyFirst},
+		{strategy: StrategyRoundRobin},
+		{strategy: StrategyWeighted},
+		{strategy: StrategyTenant},
+		{strategy: "random", err: ErrStrategy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			_, err := newSelector(map[string]string{Google: tt.strategy})
+
+			if err != tt.err {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package apps
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// CheckValid provider accepted checked setting.
+	CheckValid = "valid"
+
+	// CheckInvalid provider rejected checked setting.
+	CheckInvalid = "invalid"
+
+	// CheckUnknown provider response tells nothing of checked setting.
+	CheckUnknown = "unknown"
+
+	verificationCodePrefix = "verification-"
+)
+
+// ErrVerification provider could not be asked to verify app.
+var ErrVerification = errors.New("app verification failed")
+
+// Verification type represents outcome of app credentials check at
+// provider. Credentials reports whether provider authenticated client,
+// CallbackURL whether it accepted redirect URI, both one of Check
+// constants. ProviderError is the error provider answered with.
+type Verification struct {
+	AppID         string    `json:"app_id"`
+	Credentials   string    `json:"credentials"`
+	CallbackURL   string    `json:"callback_URL"`
+	ProviderError string    `json:"provider_error,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// Verify method checks app credentials and callback URL at provider,
+// whatever app status, by exchanging made-up code: providers authenticate
+// client before they look at the code, so invalid_grant proves client
+// valid while invalid_client proves it wrong. No token is ever issued.
+func (m *Model) Verify(ctx context.Context, id string) (*Verification, error) {
+	app, err := m.GetByID(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, conf, err := m.clientConf(ctx, app)
+
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := helpers.RandomStr(32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verification{
+		AppID:       app.ID,
+		Credentials: CheckUnknown,
+		CallbackURL: CheckUnknown,
+		CheckedAt:   time.Now(),
+	}
+
+	_, err = conf.Exchange(ctx, verificationCodePrefix+code)
+	err = v.record(err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// record method sets checks of verification from outcome of made-up code
+// exchange. Returns ErrVerification when outcome tells nothing, e.g.
+// provider is unreachable or failing.
+func (v *Verification) record(err error) error {
+	if err == nil {
+		// Provider took made-up code, it could only do so for known
+		// client.
+		v.Credentials = CheckValid
+		return nil
+	}
+
+	var re *oauth2.RetrieveError
+
+	if !errors.As(err, &re) || re.Response == nil ||
+		re.Response.StatusCode >= 500 {
+		return ErrVerification
+	}
+
+	v.ProviderError = re.ErrorCode
+
+	if re.ErrorDescription != "" {
+		v.ProviderError += ": " + re.ErrorDescription
+	}
+
+	switch {
+	case re.ErrorCode == "invalid_client" || re.ErrorCode == "unauthorized_client":
+		v.Credentials = CheckInvalid
+	case re.ErrorCode == "redirect_uri_mismatch" ||
+		strings.Contains(strings.ToLower(re.ErrorDescription), "redirect"):
+		v.CallbackURL = CheckInvalid
+	case re.ErrorCode == "invalid_grant":
+		v.Credentials = CheckValid
+	}
+
+	return nil
+}
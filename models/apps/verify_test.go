@@ -0,0 +1,119 @@
+package apps
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestVerificationRecord(t *testing.T) {
+	retrieve := func(status int, code string, description string) error {
+		return &oauth2.RetrieveError{
+			Response:         &http.Response{StatusCode: status},
+			ErrorCode:        code,
+			ErrorDescription: description,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		err           error
+		credentials   string
+		callbackURL   string
+		providerError string
+		want          error
+	}{
+		{
+			name:        "code accepted",
+			credentials: CheckValid,
+			callbackURL: CheckUnknown,
+		},
+		{
+			name:          "invalid grant",
+			err:           retrieve(400, "invalid_grant", ""),
+			credentials:   CheckValid,
+			callbackURL:   CheckUnknown,
+			providerError: "invalid_grant",
+		},
+		{
+			name:          "invalid client",
+			err:           retrieve(401, "invalid_client", "bad secret"),
+			credentials:   CheckInvalid,
+			callbackURL:   CheckUnknown,
+			providerError: "invalid_client: bad secret",
+		},
+		{
+			name:          "unauthorized client",
+			err:           retrieve(400, "unauthorized_client", ""),
+			credentials:   CheckInvalid,
+			callbackURL:   CheckUnknown,
+			providerError: "unauthorized_client",
+		},
+		{
+			name:          "redirect mismatch",
+			err:           retrieve(400, "redirect_uri_mismatch", ""),
+			credentials:   CheckUnknown,
+			callbackURL:   CheckInvalid,
+			providerError: "redirect_uri_mismatch",
+		},
+		{
+			name:          "redirect in description",
+			err:           retrieve(400, "invalid_request", "Redirect URI not registered"),
+			credentials:   CheckUnknown,
+			callbackURL:   CheckInvalid,
+			providerError: "invalid_request: Redirect URI not registered",
+		},
+		{
+			name:          "other error",
+			err:           retrieve(400, "invalid_request", ""),
+			credentials:   CheckUnknown,
+			callbackURL:   CheckUnknown,
+			providerError: "invalid_request",
+		},
+		{
+			name: "provider failing",
+			err:  retrieve(503, "", ""),
+			want: ErrVerification,
+		},
+		{
+			name: "no response",
+			err:  &oauth2.RetrieveError{ErrorCode: "invalid_client"},
+			want: ErrVerification,
+		},
+		{
+			name: "network failure",
+			err:  errors.New("connection refused"),
+			want: ErrVerification,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Verification{Credentials: CheckUnknown, CallbackURL: CheckUnknown}
+			err := v.record(tt.err)
+
+			if err != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if v.Credentials != tt.credentials {
+				t.Errorf("credentials = %q, want %q", v.Credentials, tt.credentials)
+			}
+
+			if v.CallbackURL != tt.callbackURL {
+				t.Errorf("callback URL = %q, want %q", v.CallbackURL, tt.callbackURL)
+			}
+
+			if v.ProviderError != tt.providerError {
+				t.Errorf("provider error = %q, want %q",
+					v.ProviderError, tt.providerError)
+			}
+		})
+	}
+}
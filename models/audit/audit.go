@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/Zetkolink/auth/audit"
+)
+
+const entryColumns = 8
+
+type Model struct {
+	db *sql.DB
+}
+
+type ModelConfig struct {
+	Db *sql.DB
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{db: config.Db}
+
+	return m, nil
+}
+
+// Insert method stores entries with single statement.
+func (m *Model) Insert(ctx context.Context, entries []audit.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(entries))
+	args := make([]interface{}, 0, len(entries)*entryColumns)
+
+	for i, entry := range entries {
+		n := i * entryColumns
+
+		values = append(values, fmt.Sprintf(
+			"($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8,
+		))
+
+		args = append(args, entry.Action, entry.Severity, entry.Tenant,
+			entry.UserID, entry.Service, entry.Fingerprint, entry.IP,
+			entry.CreatedAt)
+	}
+
+	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.audit_log
+									( "action", "severity", "tenant",
+									 "user_id", "service", "fingerprint",
+									 "ip", "created_at")
+								VALUES `+strings.Join(values, ", "),
+		args...,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
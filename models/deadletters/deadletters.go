@@ -0,0 +1,42 @@
+package deadletters
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Zetkolink/auth/webhooks"
+)
+
+type Model struct {
+	db *sql.DB
+}
+
+type ModelConfig struct {
+	Db *sql.DB
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{db: config.Db}
+
+	return m, nil
+}
+
+func (m *Model) Put(ctx context.Context, delivery *webhooks.Delivery, reason error) error {
+	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.webhook_dead_letters
+									( "event_id", "dedup_key", "event_type",
+									 "url", "payload", "attempts", "error",
+									 "created_at")
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		delivery.Event.ID, delivery.Event.DedupKey, delivery.Event.Type,
+		delivery.Endpoint.URL,
+		[]byte(delivery.Event.Data), delivery.Attempt, reason.Error(),
+		time.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -2,38 +2,106 @@ package exchanges
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/Zetkolink/auth/models/storage"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultReplayWindow = 24 * time.Hour
+	pruneBatch          = 100
+)
+
+var (
+	// ErrNotFound exchange not found.
+	ErrNotFound = errors.New("exchange not found")
+
+	// ErrReplayed exchange state was already used.
+	ErrReplayed = errors.New("state already used")
 )
 
 type Model struct {
-	db *sql.DB
+	db           *sql.DB
+	replayWindow time.Duration
 }
 
 type ModelConfig struct {
 	Db *sql.DB
+
+	// ReplayWindow is how long consumed states are remembered.
+	ReplayWindow time.Duration
 }
 
 type Exchange struct {
 	ID      string `json:"id"`
 	Service string `json:"service"`
 	UserID  int    `json:"user_id"`
+	Tenant  string `json:"tenant"`
+
+	// CodeVerifier is the PKCE verifier (RFC 7636) sent on code exchange.
+	CodeVerifier string `json:"-"`
+
+	// Nonce is the OIDC nonce id_token of exchange must carry.
+	Nonce string `json:"-"`
+
+	// Scopes are requested by incremental authorization, granted ones
+	// are merged into stored token scopes.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Account is the external account token of exchange is stored
+	// under, empty for default account of service.
+	Account string `json:"account,omitempty"`
+
+	// Labels are stored on token of exchange, merged into labels of
+	// token connected before.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// AppID is the app flow was initiated with, its code being exchanged
+	// with the same client.
+	AppID string `json:"app_id,omitempty"`
 }
 
 func NewModel(config ModelConfig) (*Model, error) {
-	m := &Model{db: config.Db}
+	m := &Model{
+		db:           config.Db,
+		replayWindow: config.ReplayWindow,
+	}
+
+	if m.replayWindow <= 0 {
+		m.replayWindow = defaultReplayWindow
+	}
 
 	return m, nil
 }
 
 func (m *Model) Get(ctx context.Context, id string) (*Exchange, error) {
 	var exchange Exchange
+	var labels []byte
 
 	err := m.db.QueryRowContext(ctx, `SELECT  
-									"id", "service", "user_id"
+									"id", "service", "user_id", "tenant",
+									"code_verifier", "nonce", "scopes", "account",
+									"labels", "app_id"
 									     FROM auth.exchanges
 								WHERE id = $1`,
 		id,
-	).Scan(&exchange.ID, &exchange.Service, &exchange.UserID)
+	).Scan(&exchange.ID, &exchange.Service, &exchange.UserID,
+		&exchange.Tenant, &exchange.CodeVerifier, &exchange.Nonce,
+		pq.Array(&exchange.Scopes), &exchange.Account, &labels,
+		&exchange.AppID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(labels, &exchange.Labels)
 
 	if err != nil {
 		return nil, err
@@ -43,10 +111,34 @@ func (m *Model) Get(ctx context.Context, id string) (*Exchange, error) {
 }
 
 func (m *Model) Create(ctx context.Context, exchange *Exchange) (string, error) {
+	labels := []byte("{}")
+
+	if exchange.Labels != nil {
+		var err error
+
+		labels, err = json.Marshal(exchange.Labels)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Exchanges of plain connect flows request no scopes, column is
+	// not null.
+	scopes := exchange.Scopes
+
+	if scopes == nil {
+		scopes = []string{}
+	}
+
 	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.exchanges
-									( "id", "service", "user_id")
-								VALUES ($1, $2, $3)`,
-		exchange.ID, exchange.Service, exchange.UserID,
+									( "id", "service", "user_id", "tenant",
+									 "code_verifier", "nonce", "scopes", "account",
+									 "labels", "app_id")
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		exchange.ID, exchange.Service, exchange.UserID, exchange.Tenant,
+		dblog.Secret(exchange.CodeVerifier), exchange.Nonce,
+		pq.Array(scopes), exchange.Account, labels, exchange.AppID,
 	)
 
 	if err != nil {
@@ -68,3 +160,106 @@ func (m *Model) Delete(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// Consume method removes exchange of state and remembers state hash for
+// replay window, so the same callback cannot be presented twice. Replayed
+// state returns ErrReplayed with exchange service, user and tenant.
+func (m *Model) Consume(ctx context.Context, id string) (*Exchange, error) {
+	hash := stateHash(id)
+
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var exchange Exchange
+	var labels []byte
+
+	err = tx.QueryRowContext(ctx, `DELETE
+								FROM auth.exchanges
+								WHERE id = $1
+								RETURNING "id", "service", "user_id", "tenant",
+								"code_verifier", "nonce", "scopes", "account",
+								"labels", "app_id"`,
+		id,
+	).Scan(&exchange.ID, &exchange.Service, &exchange.UserID,
+		&exchange.Tenant, &exchange.CodeVerifier, &exchange.Nonce,
+		pq.Array(&exchange.Scopes), &exchange.Account, &labels,
+		&exchange.AppID)
+
+	if err == sql.ErrNoRows {
+		return m.replayed(ctx, hash)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(labels, &exchange.Labels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO auth.consumed_states
+									( "hash", "service", "user_id", "tenant",
+									 "consumed_at")
+								VALUES ($1, $2, $3, $4, $5)
+								ON CONFLICT (hash) DO NOTHING`,
+		hash, exchange.Service, exchange.UserID, exchange.Tenant, time.Now(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = m.prune(ctx)
+
+	return &exchange, nil
+}
+
+func (m *Model) replayed(ctx context.Context, hash string) (*Exchange, error) {
+	var exchange Exchange
+
+	err := m.db.QueryRowContext(ctx, `SELECT "service", "user_id", "tenant"
+									     FROM auth.consumed_states
+								WHERE hash = $1 AND consumed_at > $2`,
+		hash, time.Now().Add(-m.replayWindow),
+	).Scan(&exchange.Service, &exchange.UserID, &exchange.Tenant)
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNotFound)
+	}
+
+	return &exchange, ErrReplayed
+}
+
+// prune method forgets states consumed before replay window.
+func (m *Model) prune(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `DELETE
+								FROM auth.consumed_states
+								WHERE hash IN (
+									SELECT hash FROM auth.consumed_states
+									WHERE consumed_at < $1
+									LIMIT $2
+								)`,
+		time.Now().Add(-m.replayWindow), pruneBatch,
+	)
+
+	return err
+}
+
+func stateHash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,398 @@
+package exports
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/storage"
+)
+
+const (
+	// KindAudit exports audit log entries.
+	KindAudit = "audit"
+
+	// KindTokens exports token metadata, without secrets.
+	KindTokens = "tokens"
+
+	// KindGDPR exports everything stored about single user.
+	KindGDPR = "gdpr"
+
+	// StatusPending job waits for a worker.
+	StatusPending = "pending"
+
+	// StatusRunning job is being exported.
+	StatusRunning = "running"
+
+	// StatusDone artifact is ready for download.
+	StatusDone = "done"
+
+	// StatusFailed export failed, see job error.
+	StatusFailed = "failed"
+
+	// StatusExpired artifact was removed after retention passed.
+	StatusExpired = "expired"
+
+	jobColumns = `"id", "kind", "tenant", "params", "status", "rows",
+									"error", "created_at", "started_at",
+									"finished_at", "expires_at"`
+)
+
+var (
+	// ErrNotFound export job not found.
+	ErrNotFound = errors.New("export job not found")
+
+	// ErrUserRequired gdpr export requested without user.
+	ErrUserRequired = errors.New("user_id required for gdpr export")
+)
+
+type Model struct {
+	db    *sql.DB
+	audit *audit.Writer
+}
+
+type ModelConfig struct {
+	Db    *sql.DB
+	Audit *audit.Writer
+}
+
+// Job type represents background export job.
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind" validate:"required,oneof=audit tokens gdpr"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Params     Params     `json:"params"`
+	Status     string     `json:"status"`
+	Rows       int        `json:"rows"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Params type represents export job filter. From and To bound audit
+// entries, UserID selects gdpr export subject.
+type Params struct {
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+	UserID int        `json:"user_id,omitempty"`
+}
+
+// record type represents single line of gdpr export.
+type record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db:    config.Db,
+		audit: config.Audit,
+	}
+
+	return m, nil
+}
+
+// Create method queues new export job.
+func (m *Model) Create(ctx context.Context, job *Job) (*Job, error) {
+	if job.Kind == KindGDPR && job.Params.UserID == 0 {
+		return nil, ErrUserRequired
+	}
+
+	id, err := helpers.RandomStr(32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := json.Marshal(job.Params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	row := m.db.QueryRowContext(ctx, `INSERT INTO auth.export_jobs
+									( "id", "kind", "tenant", "params",
+									 "status", "created_at")
+								VALUES ($1, $2, $3, $4, $5, $6)
+								RETURNING `+jobColumns,
+		id, job.Kind, helpers.GetTenant(ctx), params, StatusPending,
+		time.Now(),
+	)
+
+	created, err := scanJob(row)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var userID string
+
+	if job.Params.UserID != 0 {
+		userID = strconv.Itoa(job.Params.UserID)
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionExportCreated,
+		Severity: audit.SeverityHigh,
+		UserID:   userID,
+		Service:  job.Kind,
+	})
+
+	return created, nil
+}
+
+// Get method returns export job of request tenant.
+func (m *Model) Get(ctx context.Context, id string) (*Job, error) {
+	row := m.db.QueryRowContext(ctx, `SELECT `+jobColumns+`
+									     FROM auth.export_jobs
+								WHERE id = $1 AND tenant = $2`,
+		id, helpers.GetTenant(ctx),
+	)
+
+	job, err := scanJob(row)
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNotFound)
+	}
+
+	return job, nil
+}
+
+// Claim method marks oldest pending job running and returns it. Running
+// jobs not finished within staleAfter are claimed again, their worker is
+// assumed dead. Returns nil when there is nothing to run.
+func (m *Model) Claim(ctx context.Context, staleAfter time.Duration) (*Job, error) {
+	now := time.Now()
+
+	row := m.db.QueryRowContext(ctx, `UPDATE auth.export_jobs
+								SET status = $1, started_at = $2
+								WHERE id = (
+									SELECT id FROM auth.export_jobs
+									WHERE status = $3
+									OR (status = $1 AND started_at < $4)
+									ORDER BY created_at
+									LIMIT 1
+									FOR UPDATE SKIP LOCKED
+								)
+								RETURNING `+jobColumns,
+		StatusRunning, now, StatusPending, now.Add(-staleAfter),
+	)
+
+	job, err := scanJob(row)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return job, err
+}
+
+// Finish method marks job done.
+func (m *Model) Finish(ctx context.Context, id string, rows int, expiresAt time.Time) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.export_jobs
+								SET status = $2, rows = $3, error = '',
+								finished_at = $4, expires_at = $5
+								WHERE id = $1`,
+		id, StatusDone, rows, time.Now(), expiresAt,
+	)
+
+	return err
+}
+
+// Fail method marks job failed.
+func (m *Model) Fail(ctx context.Context, id string, reason string) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.export_jobs
+								SET status = $2, error = $3, finished_at = $4
+								WHERE id = $1`,
+		id, StatusFailed, reason, time.Now(),
+	)
+
+	return err
+}
+
+// Expired method returns ids of done jobs whose retention has passed.
+func (m *Model) Expired(ctx context.Context, limit int) ([]string, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "id"
+									     FROM auth.export_jobs
+								WHERE status = $1 AND expires_at < $2
+								ORDER BY expires_at
+								LIMIT $3`,
+		StatusDone, time.Now(), limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ids []string
+
+	for rows.Next() {
+		var id string
+
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Expire method marks job artifact removed.
+func (m *Model) Expire(ctx context.Context, id string) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.export_jobs
+								SET status = $2
+								WHERE id = $1`,
+		id, StatusExpired,
+	)
+
+	return err
+}
+
+// Write method streams job data to w as newline delimited JSON and
+// returns number of rows written.
+func (m *Model) Write(ctx context.Context, job *Job, w io.Writer) (int, error) {
+	switch job.Kind {
+	case KindAudit:
+		return m.write(ctx, w, "", `SELECT to_jsonb(a)
+									     FROM auth.audit_log a
+								WHERE ($1::timestamptz IS NULL OR created_at >= $1)
+								AND ($2::timestamptz IS NULL OR created_at < $2)
+								AND tenant = $3
+								ORDER BY id`,
+			job.Params.From, job.Params.To, job.Tenant,
+		)
+	case KindTokens:
+		return m.write(ctx, w, "", `SELECT jsonb_build_object(
+									'user_id', user_id, 'service', service,
+									'token_type', token_type, 'expiry', expiry,
+									'created_at', created_at,
+									'updated_at', updated_at, 'region', region,
+									'access_token_fingerprint',
+									access_token_fingerprint)
+									     FROM auth.tokens
+								ORDER BY user_id, service`,
+		)
+	case KindGDPR:
+		return m.writeUser(ctx, w, job)
+	}
+
+	return 0, errors.New("unknown export kind " + job.Kind)
+}
+
+// writeUser method exports tokens, connect flows and audit trail of user.
+func (m *Model) writeUser(ctx context.Context, w io.Writer, job *Job) (int, error) {
+	userID := job.Params.UserID
+
+	tokens, err := m.write(ctx, w, "token", `SELECT jsonb_build_object(
+									'service', service, 'token_type', token_type,
+									'expiry', expiry, 'created_at', created_at,
+									'updated_at', updated_at,
+									'identity', identity)
+									     FROM auth.tokens
+								WHERE user_id = $1
+								ORDER BY service`,
+		userID,
+	)
+
+	if err != nil {
+		return tokens, err
+	}
+
+	exchanges, err := m.write(ctx, w, "exchange", `SELECT jsonb_build_object(
+									'service', service, 'tenant', tenant)
+									     FROM auth.exchanges
+								WHERE user_id = $1 AND tenant = $2`,
+		userID, job.Tenant,
+	)
+
+	if err != nil {
+		return tokens + exchanges, err
+	}
+
+	entries, err := m.write(ctx, w, "audit", `SELECT jsonb_build_object(
+									'action', action, 'service', service,
+									'ip', ip, 'created_at', created_at)
+									     FROM auth.audit_log
+								WHERE user_id = $1 AND tenant = $2
+								ORDER BY id`,
+		strconv.Itoa(userID), job.Tenant,
+	)
+
+	return tokens + exchanges + entries, err
+}
+
+// write method streams single jsonb column of query rows. Rows are
+// wrapped into typed records when recordType is set.
+func (m *Model) write(ctx context.Context, w io.Writer, recordType string,
+	query string, args ...interface{}) (int, error) {
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	n := 0
+
+	for rows.Next() {
+		var data json.RawMessage
+
+		if err = rows.Scan(&data); err != nil {
+			return n, err
+		}
+
+		if recordType != "" {
+			err = enc.Encode(record{Type: recordType, Data: data})
+		} else {
+			err = enc.Encode(data)
+		}
+
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, rows.Err()
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var job Job
+	var params []byte
+
+	err := row.Scan(&job.ID, &job.Kind, &job.Tenant, &params, &job.Status,
+		&job.Rows, &job.Error, &job.CreatedAt, &job.StartedAt,
+		&job.FinishedAt, &job.ExpiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(params, &job.Params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
@@ -0,0 +1,135 @@
+package hooks
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// StatusPending job waits for its run time.
+	StatusPending = "pending"
+
+	// StatusFailed job exhausted its attempts.
+	StatusFailed = "failed"
+
+	jobColumns = `"id", "hook", "event", "user_id", "service", "tenant",
+									"attempts", "status", "last_error", "run_at",
+									"created_at"`
+)
+
+type Model struct {
+	db *sql.DB
+}
+
+type ModelConfig struct {
+	Db *sql.DB
+}
+
+// Job type represents queued hook invocation.
+type Job struct {
+	ID        int64
+	Hook      string
+	Event     string
+	UserID    int
+	Service   string
+	Tenant    string
+	Attempts  int
+	Status    string
+	LastError string
+	RunAt     time.Time
+	CreatedAt time.Time
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{db: config.Db}
+
+	return m, nil
+}
+
+// Create method queues event for each of hooks.
+func (m *Model) Create(ctx context.Context, hooks []string, event string, userID int, service string, tenant string) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.hook_jobs
+									( "hook", "event", "user_id", "service",
+									 "tenant", "status", "run_at", "created_at")
+								SELECT unnest($1::text[]), $2, $3, $4, $5, $6, $7, $7`,
+		pq.Array(hooks), event, userID, service, tenant, StatusPending, now,
+	)
+
+	return err
+}
+
+// Claim method leases oldest due job and returns it. Leased job becomes
+// due again when lease passes, so job of dead worker is retried. Returns
+// nil when nothing is due.
+func (m *Model) Claim(ctx context.Context, lease time.Duration) (*Job, error) {
+	now := time.Now()
+
+	var job Job
+
+	err := m.db.QueryRowContext(ctx, `UPDATE auth.hook_jobs
+								SET attempts = attempts + 1, run_at = $2
+								WHERE id = (
+									SELECT id FROM auth.hook_jobs
+									WHERE status = $1 AND run_at <= $3
+									ORDER BY run_at
+									LIMIT 1
+									FOR UPDATE SKIP LOCKED
+								)
+								RETURNING `+jobColumns,
+		StatusPending, now.Add(lease), now,
+	).Scan(&job.ID, &job.Hook, &job.Event, &job.UserID, &job.Service,
+		&job.Tenant, &job.Attempts, &job.Status, &job.LastError, &job.RunAt,
+		&job.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// Done method removes job run successfully.
+func (m *Model) Done(ctx context.Context, id int64) error {
+	_, err := m.db.ExecContext(ctx, `DELETE
+								FROM auth.hook_jobs
+								WHERE id = $1`,
+		id,
+	)
+
+	return err
+}
+
+// Retry method records failed run and schedules the next one.
+func (m *Model) Retry(ctx context.Context, id int64, reason string, runAt time.Time) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.hook_jobs
+								SET last_error = $2, run_at = $3
+								WHERE id = $1`,
+		id, reason, runAt,
+	)
+
+	return err
+}
+
+// Fail method gives job up.
+func (m *Model) Fail(ctx context.Context, id int64, reason string) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.hook_jobs
+								SET status = $2, last_error = $3
+								WHERE id = $1`,
+		id, StatusFailed, reason,
+	)
+
+	return err
+}
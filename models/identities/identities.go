@@ -0,0 +1,91 @@
+package identities
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Model struct {
+	db *sql.DB
+}
+
+type ModelConfig struct {
+	Db *sql.DB
+}
+
+// Identity type represents external account behind token, as provider
+// profile endpoint describes it. Email and name are stored as the PII
+// mode of tenant requires.
+type Identity struct {
+	UserID    int       `json:"user_id"`
+	Service   string    `json:"service"`
+	Account   string    `json:"account,omitempty"`
+	Subject   string    `json:"sub"`
+	Email     string    `json:"email,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db: config.Db,
+	}
+
+	return m, nil
+}
+
+// Save method stores identity of token, replacing earlier one.
+func (m *Model) Save(ctx context.Context, identity *Identity) error {
+	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.identities
+									( "user_id", "service", "account",
+									 "subject", "email", "name", "fetched_at" )
+								VALUES ($1, $2, $3, $4, $5, $6, $7)
+								ON CONFLICT (user_id, service, account) DO UPDATE
+								SET subject = excluded.subject,
+								email = excluded.email,
+								name = excluded.name,
+								fetched_at = excluded.fetched_at`,
+		identity.UserID, identity.Service, identity.Account, identity.Subject,
+		identity.Email, identity.Name, identity.FetchedAt,
+	)
+
+	return err
+}
+
+// ListByUser method returns identities of every service user connected,
+// ordered by service.
+func (m *Model) ListByUser(ctx context.Context, userID string) ([]*Identity, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "user_id", "service",
+									"account", "subject", "email", "name",
+									"fetched_at"
+									     FROM auth.identities
+								WHERE user_id = $1
+								ORDER BY service, account`,
+		userID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Identity, 0)
+
+	for rows.Next() {
+		var identity Identity
+
+		err = rows.Scan(&identity.UserID, &identity.Service,
+			&identity.Account, &identity.Subject, &identity.Email, &identity.Name,
+			&identity.FetchedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, &identity)
+	}
+
+	return list, rows.Err()
+}
@@ -0,0 +1,297 @@
+package operations
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/storage"
+)
+
+const (
+	// StatusPending operation waits for a worker.
+	StatusPending = "pending"
+
+	// StatusRunning operation is being run.
+	StatusRunning = "running"
+
+	// StatusDone operation finished, some items may have failed.
+	StatusDone = "done"
+
+	// StatusFailed operation aborted, see its error.
+	StatusFailed = "failed"
+
+	// StatusCancelled operation was cancelled before it finished.
+	StatusCancelled = "cancelled"
+
+	// MaxItemErrors bounds item errors kept per operation.
+	MaxItemErrors = 100
+
+	operationColumns = `"id", "kind", "tenant", "params", "status", "total",
+									"processed", "failed", "item_errors",
+									"error", "cancel_requested", "created_at",
+									"started_at", "updated_at", "finished_at"`
+)
+
+var (
+	// ErrNotFound operation not found.
+	ErrNotFound = errors.New("operation not found")
+
+	// ErrFinished operation finished and cannot be cancelled.
+	ErrFinished = errors.New("operation already finished")
+)
+
+type Model struct {
+	db    *sql.DB
+	audit *audit.Writer
+}
+
+type ModelConfig struct {
+	Db    *sql.DB
+	Audit *audit.Writer
+}
+
+// Operation type represents long-running admin action. Processed counts
+// items handled so far, Failed those of them that failed; ItemErrors
+// keeps first MaxItemErrors of the failures.
+type Operation struct {
+	ID              string          `json:"id"`
+	Kind            string          `json:"kind"`
+	Tenant          string          `json:"tenant,omitempty"`
+	Params          json.RawMessage `json:"params"`
+	Status          string          `json:"status"`
+	Total           int             `json:"total"`
+	Processed       int             `json:"processed"`
+	Failed          int             `json:"failed"`
+	Progress        float64         `json:"progress"`
+	ItemErrors      []ItemError     `json:"item_errors"`
+	Error           string          `json:"error,omitempty"`
+	CancelRequested bool            `json:"cancel_requested"`
+	CreatedAt       time.Time       `json:"created_at"`
+	StartedAt       *time.Time      `json:"started_at,omitempty"`
+	UpdatedAt       *time.Time      `json:"updated_at,omitempty"`
+	FinishedAt      *time.Time      `json:"finished_at,omitempty"`
+}
+
+// ItemError type represents failure of single item of operation.
+type ItemError struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db:    config.Db,
+		audit: config.Audit,
+	}
+
+	return m, nil
+}
+
+// Create method queues operation of kind for request tenant.
+func (m *Model) Create(ctx context.Context, kind string, params json.RawMessage) (*Operation, error) {
+	id, err := helpers.RandomStr(32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+
+	return scanOperation(m.db.QueryRowContext(ctx, `INSERT INTO auth.operations
+									( "id", "kind", "tenant", "params",
+									 "status", "created_at")
+								VALUES ($1, $2, $3, $4, $5, $6)
+								RETURNING `+operationColumns,
+		id, kind, helpers.GetTenant(ctx), []byte(params), StatusPending,
+		time.Now(),
+	))
+}
+
+// Get method returns operation of request tenant.
+func (m *Model) Get(ctx context.Context, id string) (*Operation, error) {
+	op, err := scanOperation(m.db.QueryRowContext(ctx, `SELECT `+operationColumns+`
+									     FROM auth.operations
+								WHERE id = $1 AND tenant = $2`,
+		id, helpers.GetTenant(ctx),
+	))
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNotFound)
+	}
+
+	return op, nil
+}
+
+// List method returns latest operations of request tenant, of kind when
+// set.
+func (m *Model) List(ctx context.Context, kind string, limit int) ([]*Operation, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT `+operationColumns+`
+									     FROM auth.operations
+								WHERE tenant = $1 AND ($2 = '' OR kind = $2)
+								ORDER BY created_at DESC
+								LIMIT $3`,
+		helpers.GetTenant(ctx), kind, limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Operation, 0)
+
+	for rows.Next() {
+		op, err := scanOperation(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, op)
+	}
+
+	return list, rows.Err()
+}
+
+// Claim method marks oldest pending operation, or running one whose
+// worker stopped reporting progress for staleAfter, as running and
+// returns it. Returns nil when there is none.
+func (m *Model) Claim(ctx context.Context, staleAfter time.Duration) (*Operation, error) {
+	now := time.Now()
+
+	op, err := scanOperation(m.db.QueryRowContext(ctx, `UPDATE auth.operations
+								SET status = $1, started_at = $2, updated_at = $2
+								WHERE id = (
+									SELECT id FROM auth.operations
+									WHERE status = $3
+									OR (status = $1 AND updated_at < $4)
+									ORDER BY created_at
+									LIMIT 1
+									FOR UPDATE SKIP LOCKED
+								)
+								RETURNING `+operationColumns,
+		StatusRunning, now, StatusPending, now.Add(-staleAfter),
+	))
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return op, err
+}
+
+// Report method stores progress of running operation and returns
+// whether cancel was requested.
+func (m *Model) Report(ctx context.Context, id string, total int, processed int, failed int, itemErrors []ItemError) (bool, error) {
+	errs, err := json.Marshal(itemErrors)
+
+	if err != nil {
+		return false, err
+	}
+
+	var cancel bool
+
+	err = m.db.QueryRowContext(ctx, `UPDATE auth.operations
+								SET total = $2, processed = $3, failed = $4,
+									item_errors = $5, updated_at = $6
+								WHERE id = $1
+								RETURNING cancel_requested`,
+		id, total, processed, failed, errs, time.Now(),
+	).Scan(&cancel)
+
+	return cancel, err
+}
+
+// Finish method records final status of operation.
+func (m *Model) Finish(ctx context.Context, id string, status string, reason string) error {
+	now := time.Now()
+
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.operations
+								SET status = $2, error = $3, updated_at = $4,
+									finished_at = $4
+								WHERE id = $1`,
+		id, status, reason, now,
+	)
+
+	return err
+}
+
+// Cancel method requests cancel of operation of request tenant. Pending
+// operation is cancelled at once, running one when its worker next
+// reports progress.
+func (m *Model) Cancel(ctx context.Context, id string) (*Operation, error) {
+	now := time.Now()
+
+	op, err := scanOperation(m.db.QueryRowContext(ctx, `UPDATE auth.operations
+								SET cancel_requested = true,
+									status = CASE WHEN status = $3 THEN $4 ELSE status END,
+									finished_at = CASE WHEN status = $3 THEN $5 ELSE finished_at END
+								WHERE id = $1 AND tenant = $2
+									AND status IN ($3, $6)
+								RETURNING `+operationColumns,
+		id, helpers.GetTenant(ctx), StatusPending, StatusCancelled, now,
+		StatusRunning,
+	))
+
+	if err == nil {
+		_ = m.audit.Write(ctx, audit.Entry{
+			Action:  audit.ActionOperationCancelled,
+			Service: op.Kind,
+		})
+	}
+
+	if err != sql.ErrNoRows {
+		return op, err
+	}
+
+	_, err = m.Get(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, ErrFinished
+}
+
+func scanOperation(row scanner) (*Operation, error) {
+	var op Operation
+	var params, itemErrors []byte
+
+	err := row.Scan(&op.ID, &op.Kind, &op.Tenant, &params, &op.Status,
+		&op.Total, &op.Processed, &op.Failed, &itemErrors, &op.Error,
+		&op.CancelRequested, &op.CreatedAt, &op.StartedAt, &op.UpdatedAt,
+		&op.FinishedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	op.Params = params
+
+	err = json.Unmarshal(itemErrors, &op.ItemErrors)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case op.Status == StatusDone:
+		op.Progress = 100
+	case op.Total > 0:
+		op.Progress = float64(op.Processed) * 100 / float64(op.Total)
+	}
+
+	return &op, nil
+}
@@ -0,0 +1,273 @@
+package providers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Zetkolink/auth/cache"
+	"github.com/Zetkolink/auth/models/storage"
+	"github.com/lib/pq"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// QuirkCommaScopes provider expects comma separated scopes.
+	QuirkCommaScopes = "comma_scopes"
+
+	// QuirkBasicAuth provider expects client credentials in header.
+	QuirkBasicAuth = "basic_auth"
+
+	// QuirkParamsAuth provider expects client credentials in body.
+	QuirkParamsAuth = "params_auth"
+
+	// QuirkForceRefresh provider tokens are refreshed on every request.
+	QuirkForceRefresh = "force_refresh"
+
+	cacheKeyPrefix = "providers:"
+)
+
+var (
+	// ErrNotFound provider not found.
+	ErrNotFound = errors.New("provider not found")
+
+	// DefaultCapabilities is assumed for providers declaring none.
+	DefaultCapabilities = Capabilities{SupportsRefresh: true}
+
+	// Quirks lists known quirk flags.
+	Quirks = []string{
+		QuirkCommaScopes, QuirkBasicAuth, QuirkParamsAuth, QuirkForceRefresh,
+	}
+)
+
+type Model struct {
+	db       *sql.DB
+	cache    cache.Cache
+	cacheTTL time.Duration
+}
+
+type ModelConfig struct {
+	Db       *sql.DB
+	Cache    cache.Cache
+	CacheTTL time.Duration
+}
+
+type Provider struct {
+	Service  string   `json:"service"`
+	AuthURL  string   `json:"auth_url" validate:"required,url"`
+	TokenURL string   `json:"token_url" validate:"required,url"`
+	Scopes   []string `json:"scopes"`
+	Quirks   []string `json:"quirks" validate:"dive,oneof=comma_scopes basic_auth params_auth force_refresh"`
+	Capabilities
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+// Capabilities type represents protocol features provider supports.
+type Capabilities struct {
+	SupportsRefresh bool   `json:"supports_refresh"`
+	SupportsRevoke  bool   `json:"supports_revoke"`
+	PKCERequired    bool   `json:"pkce_required"`
+	TokenExchange   bool   `json:"supports_token_exchange"`
+	RevocationURL   string `json:"revocation_url,omitempty" validate:"required_with=SupportsRevoke,omitempty,url"`
+
+	// IntrospectionURL is the RFC 7662 endpoint, empty if provider
+	// has none.
+	IntrospectionURL string `json:"introspection_url,omitempty" validate:"omitempty,url"`
+
+	// ValidationURL is the endpoint accepting access token as bearer,
+	// such as tokeninfo or userinfo, empty if provider has none.
+	ValidationURL string `json:"validation_url,omitempty" validate:"omitempty,url"`
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db:       config.Db,
+		cache:    config.Cache,
+		cacheTTL: config.CacheTTL,
+	}
+
+	return m, nil
+}
+
+func (m *Model) Get(ctx context.Context, service string) (*Provider, error) {
+	var provider Provider
+
+	data, err := m.cache.Get(ctx, cacheKeyPrefix+service)
+
+	if err == nil && json.Unmarshal(data, &provider) == nil {
+		return &provider, nil
+	}
+
+	err = m.db.QueryRowContext(ctx, `SELECT
+									"service", "auth_url", "token_url",
+									"scopes", "quirks", "supports_refresh",
+									"supports_revoke", "pkce_required",
+									"supports_token_exchange",
+									"revocation_url", "introspection_url",
+									"validation_url", "created_at"
+									     FROM auth.providers
+								WHERE service = $1`,
+		service,
+	).Scan(&provider.Service, &provider.AuthURL, &provider.TokenURL,
+		pq.Array(&provider.Scopes), pq.Array(&provider.Quirks),
+		&provider.SupportsRefresh, &provider.SupportsRevoke,
+		&provider.PKCERequired, &provider.TokenExchange,
+		&provider.RevocationURL, &provider.IntrospectionURL,
+		&provider.ValidationURL, &provider.CreatedAt)
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNotFound)
+	}
+
+	data, err = json.Marshal(&provider)
+
+	if err == nil {
+		_ = m.cache.Set(ctx, cacheKeyPrefix+service, data, m.cacheTTL)
+	}
+
+	return &provider, nil
+}
+
+func (m *Model) List(ctx context.Context) ([]*Provider, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT
+									"service", "auth_url", "token_url",
+									"scopes", "quirks", "supports_refresh",
+									"supports_revoke", "pkce_required",
+									"supports_token_exchange",
+									"revocation_url", "introspection_url",
+									"validation_url", "created_at"
+									     FROM auth.providers
+								ORDER BY service`,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Provider, 0)
+
+	for rows.Next() {
+		var provider Provider
+
+		err = rows.Scan(&provider.Service, &provider.AuthURL,
+			&provider.TokenURL, pq.Array(&provider.Scopes),
+			pq.Array(&provider.Quirks), &provider.SupportsRefresh,
+			&provider.SupportsRevoke, &provider.PKCERequired,
+			&provider.TokenExchange, &provider.RevocationURL,
+			&provider.IntrospectionURL, &provider.ValidationURL,
+			&provider.CreatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, &provider)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (m *Model) Save(ctx context.Context, provider *Provider) error {
+	_, err := m.db.ExecContext(ctx, `INSERT INTO auth.providers
+									( "service", "auth_url", "token_url",
+									 "scopes", "quirks", "supports_refresh",
+									 "supports_revoke", "pkce_required",
+									 "supports_token_exchange",
+									 "revocation_url", "introspection_url",
+									 "validation_url", "created_at")
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+								ON CONFLICT (service) DO UPDATE
+								SET auth_url = excluded.auth_url,
+								token_url = excluded.token_url,
+								scopes = excluded.scopes,
+								quirks = excluded.quirks,
+								supports_refresh = excluded.supports_refresh,
+								supports_revoke = excluded.supports_revoke,
+								pkce_required = excluded.pkce_required,
+								supports_token_exchange = excluded.supports_token_exchange,
+								revocation_url = excluded.revocation_url,
+								introspection_url = excluded.introspection_url,
+								validation_url = excluded.validation_url`,
+		provider.Service, provider.AuthURL, provider.TokenURL,
+		pq.Array(provider.Scopes), pq.Array(provider.Quirks),
+		provider.SupportsRefresh, provider.SupportsRevoke,
+		provider.PKCERequired, provider.TokenExchange,
+		provider.RevocationURL, provider.IntrospectionURL,
+		provider.ValidationURL, time.Now(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_ = m.cache.Delete(ctx, cacheKeyPrefix+provider.Service)
+
+	return nil
+}
+
+func (m *Model) Delete(ctx context.Context, service string) error {
+	res, err := m.db.ExecContext(ctx, `DELETE
+								FROM auth.providers
+								WHERE service = $1`, service,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_ = m.cache.Delete(ctx, cacheKeyPrefix+service)
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Has method reports whether provider has quirk flag.
+func (p *Provider) Has(quirk string) bool {
+	for _, q := range p.Quirks {
+		if q == quirk {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply method sets provider endpoint and quirks on oauth2 config.
+func (p *Provider) Apply(conf *oauth2.Config) {
+	conf.Endpoint = oauth2.Endpoint{
+		AuthURL:  p.AuthURL,
+		TokenURL: p.TokenURL,
+	}
+
+	if p.Has(QuirkBasicAuth) {
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	} else if p.Has(QuirkParamsAuth) {
+		conf.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = p.Scopes
+	}
+
+	if p.Has(QuirkCommaScopes) && len(conf.Scopes) > 0 {
+		conf.Scopes = []string{strings.Join(conf.Scopes, ",")}
+	}
+}
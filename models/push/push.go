@@ -0,0 +1,400 @@
+package push
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/keyring"
+	"github.com/Zetkolink/auth/models/storage"
+)
+
+const (
+	// StatusPending delivery waits for its run time.
+	StatusPending = "pending"
+
+	// StatusAcked target acknowledged delivery.
+	StatusAcked = "acked"
+
+	// StatusFailed delivery exhausted its attempts or was rejected.
+	StatusFailed = "failed"
+
+	secretLength = 32
+
+	targetColumns = `"id", "tenant", "service", "url", "created_at"`
+
+	deliveryColumns = `d."id", d."target_id", d."event", d."user_id",
+									d."service", d."tenant", d."attempts",
+									d."status", d."last_error", d."run_at",
+									d."acked_at", d."created_at", d."account"`
+)
+
+var (
+	// ErrNotFound target not found.
+	ErrNotFound = errors.New("push target not found")
+
+	// ErrDeliveryNotFound delivery not found.
+	ErrDeliveryNotFound = errors.New("push delivery not found")
+)
+
+type Model struct {
+	db      *sql.DB
+	keyring *keyring.Keyring
+	audit   *audit.Writer
+}
+
+type ModelConfig struct {
+	Db      *sql.DB
+	Keyring *keyring.Keyring
+	Audit   *audit.Writer
+}
+
+// Target type represents endpoint of consuming service tokens of service
+// are pushed to. Secret signs and encrypts payloads and is returned on
+// creation only.
+type Target struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Service   string    `json:"service" validate:"required"`
+	URL       string    `json:"url" validate:"required,url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery type represents push of token event to target. URL and
+// Secret are set on claimed deliveries only.
+type Delivery struct {
+	ID        int64      `json:"id"`
+	TargetID  string     `json:"target_id"`
+	Event     string     `json:"event"`
+	UserID    int        `json:"user_id"`
+	Service   string     `json:"service"`
+	Account   string     `json:"account,omitempty"`
+	Tenant    string     `json:"tenant,omitempty"`
+	Attempts  int        `json:"attempts"`
+	Status    string     `json:"status"`
+	LastError string     `json:"last_error,omitempty"`
+	RunAt     time.Time  `json:"run_at"`
+	AckedAt   *time.Time `json:"acked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db:      config.Db,
+		keyring: config.Keyring,
+		audit:   config.Audit,
+	}
+
+	return m, nil
+}
+
+// CreateTarget method registers target of request tenant with new
+// secret.
+func (m *Model) CreateTarget(ctx context.Context, target *Target) (*Target, error) {
+	id, err := helpers.RandomStr(16)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := helpers.RandomStr(secretLength)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := m.keyring.Seal(secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := scanTarget(m.db.QueryRowContext(ctx, `INSERT INTO auth.push_targets
+									( "id", "tenant", "service", "url",
+									 "secret", "created_at")
+								VALUES ($1, $2, $3, $4, $5, $6)
+								RETURNING `+targetColumns,
+		id, helpers.GetTenant(ctx), target.Service, target.URL, sealed,
+		time.Now(),
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	created.Secret = secret
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionPushTargetCreated,
+		Severity: audit.SeverityHigh,
+		Service:  created.Service,
+	})
+
+	return created, nil
+}
+
+// Targets method returns targets of request tenant.
+func (m *Model) Targets(ctx context.Context) ([]*Target, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT `+targetColumns+`
+									     FROM auth.push_targets
+								WHERE tenant = $1
+								ORDER BY created_at`,
+		helpers.GetTenant(ctx),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Target, 0)
+
+	for rows.Next() {
+		target, err := scanTarget(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, target)
+	}
+
+	return list, rows.Err()
+}
+
+// DeleteTarget method removes target of request tenant along with its
+// deliveries.
+func (m *Model) DeleteTarget(ctx context.Context, id string) error {
+	var service string
+
+	err := m.db.QueryRowContext(ctx, `DELETE
+								FROM auth.push_targets
+								WHERE id = $1 AND tenant = $2
+								RETURNING service`,
+		id, helpers.GetTenant(ctx),
+	).Scan(&service)
+
+	if err != nil {
+		return storage.NotFound(err, ErrNotFound)
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionPushTargetDeleted,
+		Severity: audit.SeverityHigh,
+		Service:  service,
+	})
+
+	return nil
+}
+
+// Enqueue method queues event of token of external account for every
+// target of tenant registered for service. Returns number of deliveries
+// queued.
+func (m *Model) Enqueue(ctx context.Context, event string, userID int, service string, account string, tenant string) (int64, error) {
+	now := time.Now()
+
+	res, err := m.db.ExecContext(ctx, `INSERT INTO auth.push_deliveries
+									( "target_id", "event", "user_id",
+									 "service", "tenant", "status", "run_at",
+									 "created_at", "account")
+								SELECT id, $1, $2, $3, $4, $5, $6, $6, $7
+								FROM auth.push_targets
+								WHERE tenant = $4 AND service = $3`,
+		event, userID, service, tenant, StatusPending, now, account,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Claim method leases oldest due delivery and returns it with target URL
+// and opened secret. Leased delivery becomes due again when lease
+// passes. Returns nil when nothing is due.
+func (m *Model) Claim(ctx context.Context, lease time.Duration) (*Delivery, error) {
+	now := time.Now()
+
+	var url, sealed string
+
+	delivery, err := scanDelivery(m.db.QueryRowContext(ctx, `UPDATE auth.push_deliveries d
+								SET attempts = d.attempts + 1, run_at = $2
+								FROM auth.push_targets t
+								WHERE t.id = d.target_id AND d.id = (
+									SELECT id FROM auth.push_deliveries
+									WHERE status = $1 AND run_at <= $3
+									ORDER BY run_at
+									LIMIT 1
+									FOR UPDATE SKIP LOCKED
+								)
+								RETURNING `+deliveryColumns+`, t."url", t."secret"`,
+		StatusPending, now.Add(lease), now,
+	), &url, &sealed)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.URL = url
+
+	delivery.Secret, err = m.keyring.Open(sealed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// Ack method records delivery acknowledged by target.
+func (m *Model) Ack(ctx context.Context, id int64) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.push_deliveries
+								SET status = $2, acked_at = $3, last_error = ''
+								WHERE id = $1`,
+		id, StatusAcked, time.Now(),
+	)
+
+	return err
+}
+
+// Retry method records failed attempt and schedules the next one.
+func (m *Model) Retry(ctx context.Context, id int64, reason string, runAt time.Time) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.push_deliveries
+								SET last_error = $2, run_at = $3
+								WHERE id = $1`,
+		id, reason, runAt,
+	)
+
+	return err
+}
+
+// Fail method gives delivery up.
+func (m *Model) Fail(ctx context.Context, id int64, reason string) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.push_deliveries
+								SET status = $2, last_error = $3
+								WHERE id = $1`,
+		id, StatusFailed, reason,
+	)
+
+	return err
+}
+
+// Cancel method gives up pending deliveries of user token for service
+// and external account, e.g. when token was deleted and there is nothing
+// left to push.
+func (m *Model) Cancel(ctx context.Context, userID int, service string, account string, tenant string, reason string) error {
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.push_deliveries
+								SET status = $5, last_error = $6
+								WHERE user_id = $1 AND service = $2
+									AND tenant = $3 AND status = $4
+									AND account = $7`,
+		userID, service, tenant, StatusPending, StatusFailed, reason,
+		account,
+	)
+
+	return err
+}
+
+// Deliveries method returns latest deliveries of target of request
+// tenant.
+func (m *Model) Deliveries(ctx context.Context, targetID string, limit int) ([]*Delivery, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT `+deliveryColumns+`
+									     FROM auth.push_deliveries d
+								WHERE d.target_id = $1 AND d.tenant = $2
+								ORDER BY d.created_at DESC
+								LIMIT $3`,
+		targetID, helpers.GetTenant(ctx), limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Delivery, 0)
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, delivery)
+	}
+
+	return list, rows.Err()
+}
+
+// Redeliver method queues delivery of request tenant again with fresh
+// attempt budget, whatever its status.
+func (m *Model) Redeliver(ctx context.Context, id int64) (*Delivery, error) {
+	delivery, err := scanDelivery(m.db.QueryRowContext(ctx, `UPDATE auth.push_deliveries d
+								SET status = $3, attempts = 0, run_at = $4,
+									acked_at = NULL
+								WHERE d.id = $1 AND d.tenant = $2
+								RETURNING `+deliveryColumns,
+		id, helpers.GetTenant(ctx), StatusPending, time.Now(),
+	))
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrDeliveryNotFound)
+	}
+
+	return delivery, nil
+}
+
+func scanTarget(row scanner) (*Target, error) {
+	var target Target
+
+	err := row.Scan(&target.ID, &target.Tenant, &target.Service,
+		&target.URL, &target.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &target, nil
+}
+
+// scanDelivery function scans delivery columns followed by extra ones.
+func scanDelivery(row scanner, extra ...interface{}) (*Delivery, error) {
+	var delivery Delivery
+	var ackedAt sql.NullTime
+
+	dest := []interface{}{&delivery.ID, &delivery.TargetID,
+		&delivery.Event, &delivery.UserID, &delivery.Service,
+		&delivery.Tenant, &delivery.Attempts, &delivery.Status,
+		&delivery.LastError, &delivery.RunAt, &ackedAt,
+		&delivery.CreatedAt, &delivery.Account}
+
+	err := row.Scan(append(dest, extra...)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ackedAt.Valid {
+		delivery.AckedAt = &ackedAt.Time
+	}
+
+	return &delivery, nil
+}
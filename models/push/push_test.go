@@ -0,0 +1,55 @@
+package push
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Zetkolink/auth/models/storage/storagetest"
+)
+
+func TestAccountScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+	}{
+		{name: "default account"},
+		{name: "external account", account: "work"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storagetest.New()
+			db.Handle(`INSERT INTO auth.push_deliveries`, storagetest.Affected(1))
+			db.Handle(`UPDATE auth.push_deliveries`, storagetest.Affected(1))
+			m, _ := NewModel(ModelConfig{Db: db.DB})
+			ctx := context.Background()
+
+			_, err := m.Enqueue(ctx, "token.created", 1001, "google",
+				tt.account, "")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = m.Cancel(ctx, 1001, "google", tt.account, "", "token deleted")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			enqueued := db.Statements(`INSERT INTO auth.push_deliveries`)[0]
+
+			if enqueued.Args[6] != tt.account {
+				t.Errorf("enqueued account = %v, want %q",
+					enqueued.Args[6], tt.account)
+			}
+
+			cancelled := db.Statements(`UPDATE auth.push_deliveries`)[0]
+
+			if cancelled.Args[6] != tt.account {
+				t.Errorf("cancelled account = %v, want %q",
+					cancelled.Args[6], tt.account)
+			}
+		})
+	}
+}
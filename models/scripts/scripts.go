@@ -0,0 +1,166 @@
+package scripts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/http/helpers"
+)
+
+const scriptColumns = `"id", "tenant", "event", "source", "created_at"`
+
+var (
+	// ErrNotFound script not found.
+	ErrNotFound = errors.New("script not found")
+)
+
+type Model struct {
+	db    *sql.DB
+	audit *audit.Writer
+}
+
+type ModelConfig struct {
+	Db    *sql.DB
+	Audit *audit.Writer
+}
+
+// Script type represents Starlark script run on event of tenant.
+type Script struct {
+	ID        string    `json:"id"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Event     string    `json:"event" validate:"required,oneof=on_token_created on_refresh_failed"`
+	Source    string    `json:"source" validate:"required,max=65536"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db:    config.Db,
+		audit: config.Audit,
+	}
+
+	return m, nil
+}
+
+// Create method attaches script to event of request tenant.
+func (m *Model) Create(ctx context.Context, script *Script) (*Script, error) {
+	id, err := helpers.RandomStr(16)
+
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := scanScript(m.db.QueryRowContext(ctx, `INSERT INTO auth.scripts
+									( "id", "tenant", "event", "source",
+									 "created_at")
+								VALUES ($1, $2, $3, $4, $5)
+								RETURNING `+scriptColumns,
+		id, helpers.GetTenant(ctx), script.Event, script.Source, time.Now(),
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionScriptCreated,
+		Severity: audit.SeverityHigh,
+		Service:  created.Event,
+	})
+
+	return created, nil
+}
+
+// List method returns scripts of request tenant.
+func (m *Model) List(ctx context.Context) ([]*Script, error) {
+	return m.list(ctx, `SELECT `+scriptColumns+`
+									     FROM auth.scripts
+								WHERE tenant = $1
+								ORDER BY created_at`,
+		helpers.GetTenant(ctx),
+	)
+}
+
+// ForEvent method returns scripts tenant attached to event.
+func (m *Model) ForEvent(ctx context.Context, tenant string, event string) ([]*Script, error) {
+	return m.list(ctx, `SELECT `+scriptColumns+`
+									     FROM auth.scripts
+								WHERE tenant = $1 AND event = $2
+								ORDER BY created_at`,
+		tenant, event,
+	)
+}
+
+// Delete method removes script of request tenant.
+func (m *Model) Delete(ctx context.Context, id string) error {
+	res, err := m.db.ExecContext(ctx, `DELETE
+								FROM auth.scripts
+								WHERE id = $1 AND tenant = $2`,
+		id, helpers.GetTenant(ctx),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionScriptDeleted,
+		Severity: audit.SeverityHigh,
+	})
+
+	return nil
+}
+
+func (m *Model) list(ctx context.Context, query string, args ...interface{}) ([]*Script, error) {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Script, 0)
+
+	for rows.Next() {
+		script, err := scanScript(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, script)
+	}
+
+	return list, rows.Err()
+}
+
+func scanScript(row scanner) (*Script, error) {
+	var script Script
+
+	err := row.Scan(&script.ID, &script.Tenant, &script.Event,
+		&script.Source, &script.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &script, nil
+}
@@ -0,0 +1,176 @@
+package servicetokens
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/Zetkolink/auth/keyring"
+	"github.com/Zetkolink/auth/models/apps"
+	"github.com/Zetkolink/auth/models/storage"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// refreshSkew is how long before expiry stored token is replaced.
+const refreshSkew = time.Minute
+
+var (
+	// ErrNotFound service token not found.
+	ErrNotFound = errors.New("service token not found")
+)
+
+type Model struct {
+	db      *sql.DB
+	apps    *apps.Model
+	keyring *keyring.Keyring
+	mu      sync.Mutex
+}
+
+type ModelConfig struct {
+	Db      *sql.DB
+	Apps    *apps.Model
+	Keyring *keyring.Keyring
+}
+
+// Token type represents client credentials token of service app, not
+// tied to any user.
+type Token struct {
+	Service     string    `json:"service"`
+	TokenType   string    `json:"token_type"`
+	AccessToken string    `json:"access_token"`
+	Expiry      time.Time `json:"expiry,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db:      config.Db,
+		apps:    config.Apps,
+		keyring: config.Keyring,
+	}
+
+	return m, nil
+}
+
+// Get method returns stored token of service, obtaining new one from
+// provider when there is none or it is about to expire.
+func (m *Model) Get(ctx context.Context, service string) (*Token, error) {
+	token, err := m.stored(ctx, service)
+
+	if err == nil && token.fresh() {
+		return token, nil
+	}
+
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Another request may have replaced token while we waited.
+	token, err = m.stored(ctx, service)
+
+	if err == nil && token.fresh() {
+		return token, nil
+	}
+
+	return m.obtain(ctx, service)
+}
+
+func (m *Model) obtain(ctx context.Context, service string) (*Token, error) {
+	ctx, conf, err := m.apps.ClientConf(ctx, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cc := &clientcredentials.Config{
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		TokenURL:     conf.Endpoint.TokenURL,
+		Scopes:       conf.Scopes,
+		AuthStyle:    conf.Endpoint.AuthStyle,
+	}
+
+	tk, err := cc.Token(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := m.keyring.Seal(tk.AccessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{
+		Service:     service,
+		TokenType:   tk.Type(),
+		AccessToken: tk.AccessToken,
+		Expiry:      tk.Expiry,
+		CreatedAt:   time.Now(),
+	}
+
+	_, err = m.db.ExecContext(ctx, `INSERT INTO auth.service_tokens
+									( "service", "token_type", "access_token",
+									 "expiry", "created_at")
+								VALUES ($1, $2, $3, $4, $5)
+								ON CONFLICT (service) DO UPDATE
+								SET token_type = excluded.token_type,
+								access_token = excluded.access_token,
+								expiry = excluded.expiry,
+								created_at = excluded.created_at`,
+		token.Service, token.TokenType, dblog.Secret(accessToken),
+		nullTime(token.Expiry), token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (m *Model) stored(ctx context.Context, service string) (*Token, error) {
+	var token Token
+	var expiry sql.NullTime
+
+	err := m.db.QueryRowContext(ctx, `SELECT
+									"service", "token_type", "access_token",
+									"expiry", "created_at"
+									     FROM auth.service_tokens
+								WHERE service = $1`,
+		service,
+	).Scan(&token.Service, &token.TokenType, &token.AccessToken,
+		&expiry, &token.CreatedAt)
+
+	if err != nil {
+		return nil, storage.NotFound(err, ErrNotFound)
+	}
+
+	token.Expiry = expiry.Time
+	token.AccessToken, err = m.keyring.Open(token.AccessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (t *Token) fresh() bool {
+	if t.AccessToken == "" {
+		return false
+	}
+
+	return t.Expiry.IsZero() || time.Now().Add(refreshSkew).Before(t.Expiry)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
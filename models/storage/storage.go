@@ -0,0 +1,17 @@
+// Package storage maps database errors to errors of models, so storage
+// details never reach controllers and missing rows render as 404.
+package storage
+
+import (
+	"database/sql"
+)
+
+// NotFound function returns notFound in place of sql.ErrNoRows, other
+// errors as they are.
+func NotFound(err error, notFound error) error {
+	if err == sql.ErrNoRows {
+		return notFound
+	}
+
+	return err
+}
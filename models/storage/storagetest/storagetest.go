@@ -0,0 +1,215 @@
+// Package storagetest provides scripted database for tests of models and
+// controllers without Postgres. Statements are answered by handlers
+// matched by query fragment, and every statement is recorded with its
+// arguments, as the driver received them.
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrUnexpected statement matches no handler.
+var ErrUnexpected = errors.New("storagetest: unexpected statement")
+
+// Result type represents answer to statement. Queries return Rows of
+// Columns, statements report RowsAffected, both fail with Err.
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+}
+
+// Statement type represents executed statement.
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Handler answers statement given its arguments.
+type Handler func(args []driver.Value) Result
+
+// DB type represents scripted database.
+type DB struct {
+	*sql.DB
+
+	mu         sync.Mutex
+	fragments  []string
+	handlers   []Handler
+	statements []Statement
+}
+
+// New method creates new scripted database instance.
+func New() *DB {
+	d := &DB{}
+	d.DB = sql.OpenDB(connector{d})
+
+	return d
+}
+
+// Handle method answers statements containing fragment with handler.
+// Handlers are tried in order of registration.
+func (d *DB) Handle(fragment string, handler Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.fragments = append(d.fragments, fragment)
+	d.handlers = append(d.handlers, handler)
+}
+
+// Rows function returns handler answering with rows of columns.
+func Rows(columns []string, rows ...[]driver.Value) Handler {
+	return func([]driver.Value) Result {
+		return Result{Columns: columns, Rows: rows}
+	}
+}
+
+// Affected function returns handler answering statements with number of
+// affected rows.
+func Affected(n int64) Handler {
+	return func([]driver.Value) Result {
+		return Result{RowsAffected: n}
+	}
+}
+
+// Statements method returns executed statements containing fragment.
+func (d *DB) Statements(fragment string) []Statement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var list []Statement
+
+	for _, s := range d.statements {
+		if strings.Contains(s.Query, fragment) {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+func (d *DB) answer(query string, named []driver.NamedValue) Result {
+	args := make([]driver.Value, len(named))
+
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+
+	d.mu.Lock()
+	d.statements = append(d.statements, Statement{Query: query, Args: args})
+
+	var handler Handler
+
+	for i, fragment := range d.fragments {
+		if strings.Contains(query, fragment) {
+			handler = d.handlers[i]
+			break
+		}
+	}
+
+	d.mu.Unlock()
+
+	if handler == nil {
+		return Result{Err: ErrUnexpected}
+	}
+
+	return handler(args)
+}
+
+type connector struct {
+	db *DB
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return drv{}
+}
+
+type drv struct{}
+
+func (drv) Open(string) (driver.Conn, error) {
+	return nil, errors.New("storagetest: use New")
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("storagetest: prepared statements unsupported")
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return tx{}, nil
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return tx{}, nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.db.answer(query, args)
+
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	return &rows{columns: res.Columns, rows: res.Rows}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.db.answer(query, args)
+
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+type tx struct{}
+
+func (tx) Commit() error {
+	return nil
+}
+
+func (tx) Rollback() error {
+	return nil
+}
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
@@ -0,0 +1,192 @@
+// Package tokenevents keeps history of token lifecycle: every create,
+// refresh, manual refresh and delete with its actor and outcome, kept
+// after token itself is deleted, so broken integrations can be traced
+// back to the event that broke them.
+package tokenevents
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/partners"
+)
+
+const (
+	// KindCreated token created by code exchange.
+	KindCreated = "created"
+
+	// KindRefreshed token refreshed, on read or ahead of expiry.
+	KindRefreshed = "refreshed"
+
+	// KindManualRefresh token refresh requested by client.
+	KindManualRefresh = "manual_refresh"
+
+	// KindDeleted token deleted.
+	KindDeleted = "deleted"
+
+	// OutcomeSucceeded event succeeded.
+	OutcomeSucceeded = "succeeded"
+
+	// OutcomeFailed event failed, see Error.
+	OutcomeFailed = "failed"
+
+	// ActorAPI event caused by internal API client.
+	ActorAPI = "api"
+
+	// ActorUser event caused by user in connect flow.
+	ActorUser = "user"
+
+	// ActorRefresher event caused by refresh-ahead worker.
+	ActorRefresher = "refresher"
+
+	// ActorRetention event caused by retention policy enforcement.
+	ActorRetention = "retention"
+
+	partnerActorPrefix = "partner:"
+)
+
+var actorContextKey = &contextKey{"actor"}
+
+type contextKey struct {
+	name string
+}
+
+type Model struct {
+	db *sql.DB
+}
+
+type ModelConfig struct {
+	Db *sql.DB
+}
+
+// Event type represents single token lifecycle event of external account,
+// empty for default account of service. Error is set for failed events.
+type Event struct {
+	ID        int64     `json:"id"`
+	UserID    int       `json:"user_id"`
+	Service   string    `json:"service"`
+	Account   string    `json:"account,omitempty"`
+	Kind      string    `json:"kind"`
+	Actor     string    `json:"actor"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewModel(config ModelConfig) (*Model, error) {
+	m := &Model{
+		db: config.Db,
+	}
+
+	return m, nil
+}
+
+// WithActor function returns context whose token events are attributed
+// to actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// GetActor function returns actor of context: one set by WithActor,
+// partner of request or ActorAPI.
+func GetActor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorContextKey).(string); ok {
+		return actor
+	}
+
+	if partner := partners.GetPartner(ctx); partner != "" {
+		return partnerActorPrefix + partner
+	}
+
+	return ActorAPI
+}
+
+// Record method stores event, outcome taken from err. Actor defaults to
+// actor of context. Nil model records nothing.
+func (m *Model) Record(ctx context.Context, event Event, err error) error {
+	if m == nil {
+		return nil
+	}
+
+	if event.Actor == "" {
+		event.Actor = GetActor(ctx)
+	}
+
+	event.Outcome = OutcomeSucceeded
+
+	if err != nil {
+		event.Outcome = OutcomeFailed
+		event.Error = err.Error()
+	}
+
+	_, err = m.db.ExecContext(ctx, `INSERT INTO auth.token_events
+									( "user_id", "service", "kind", "actor",
+									 "outcome", "error", "created_at",
+									 "account" )
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		event.UserID, event.Service, event.Kind, event.Actor,
+		event.Outcome, event.Error, time.Now(), event.Account,
+	)
+
+	return err
+}
+
+// ListByToken method returns events of user's token of service for
+// external account, latest first.
+func (m *Model) ListByToken(ctx context.Context, userID string, service string, account string, p *helpers.Paginator) ([]*Event, error) {
+	if !p.SkipCount {
+		err := m.db.QueryRowContext(ctx, `SELECT count(*)
+									     FROM auth.token_events
+								WHERE user_id = $1 AND service = $2
+								AND account = $3`,
+			userID, service, account,
+		).Scan(&p.Total)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT "id", "user_id", "service",
+										"account", "kind", "actor", "outcome",
+										"error", "created_at"
+									     FROM auth.token_events
+								WHERE user_id = $1 AND service = $2
+								AND account = $5
+								ORDER BY created_at DESC, id DESC
+								OFFSET $3 LIMIT $4`,
+		userID, service, p.Skip(), p.Fetch(), account,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Event, 0, p.PerPage)
+
+	for rows.Next() {
+		var event Event
+
+		err = rows.Scan(&event.ID, &event.UserID, &event.Service,
+			&event.Account, &event.Kind, &event.Actor, &event.Outcome, &event.Error,
+			&event.CreatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, &event)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list[:p.Trim(len(list))], nil
+}
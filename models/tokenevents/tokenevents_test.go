@@ -0,0 +1,102 @@
+package tokenevents
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/models/storage/storagetest"
+)
+
+func TestRecordAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		err     error
+		outcome string
+	}{
+		{name: "default account", outcome: OutcomeSucceeded},
+		{name: "external account", account: "work", outcome: OutcomeSucceeded},
+		{name: "failed", account: "work", err: errors.New("revoked"), outcome: OutcomeFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storagetest.New()
+			db.Handle(`INSERT INTO auth.token_events`, storagetest.Affected(1))
+			m, _ := NewModel(ModelConfig{Db: db.DB})
+
+			err := m.Record(context.Background(), Event{
+				UserID:  1001,
+				Service: "google",
+				Account: tt.account,
+				Kind:    KindDeleted,
+			}, tt.err)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			inserts := db.Statements(`INSERT INTO auth.token_events`)
+
+			if len(inserts) != 1 {
+				t.Fatalf("%d events stored, want 1", len(inserts))
+			}
+
+			args := inserts[0].Args
+
+			if args[7] != tt.account {
+				t.Errorf("account = %v, want %q", args[7], tt.account)
+			}
+
+			if args[4] != tt.outcome {
+				t.Errorf("outcome = %v, want %q", args[4], tt.outcome)
+			}
+		})
+	}
+}
+
+func TestListByTokenAccount(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	db := storagetest.New()
+	db.Handle(`SELECT count(*)`, storagetest.Rows([]string{"count"},
+		[]driver.Value{int64(1)}))
+	db.Handle(`FROM auth.token_events`, func(args []driver.Value) storagetest.Result {
+		return storagetest.Result{
+			Columns: []string{"id", "user_id", "service", "account", "kind",
+				"actor", "outcome", "error", "created_at"},
+			Rows: [][]driver.Value{{int64(1), int64(1001), "google", args[4],
+				KindCreated, ActorUser, OutcomeSucceeded, "", created}},
+		}
+	})
+	m, _ := NewModel(ModelConfig{Db: db.DB})
+
+	list, err := m.ListByToken(context.Background(), "1001", "google", "work",
+		&helpers.Paginator{Page: 1, PerPage: 10})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].Account != "work" {
+		t.Fatalf("listed %+v, want one event of account work", list)
+	}
+
+	for _, s := range db.Statements(`FROM auth.token_events`) {
+		found := false
+
+		for _, arg := range s.Args {
+			if arg == "work" {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("statement not filtered by account: %s", s.Query)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tokens
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BulkResult type represents outcome of refreshing single token in bulk,
+// new expiry or error reason.
+type BulkResult struct {
+	Key
+	Refreshed bool       `json:"refreshed"`
+	Expiry    *time.Time `json:"expiry,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// RefreshExpiring method refreshes up to limit tokens of service, of every
+// service for empty one, whose access token expires within window, at most
+// concurrency at a time. Results are ordered as ExpiringKeys orders
+// tokens. Refreshes are recorded in token history as requested by client.
+func (m *Model) RefreshExpiring(ctx context.Context, service string, window time.Duration, limit int, concurrency int) ([]*BulkResult, error) {
+	keys, err := m.ExpiringKeys(ctx, service, window, limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*BulkResult, len(keys))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, key := range keys {
+		list[i] = &BulkResult{Key: key}
+
+		select {
+		case <-ctx.Done():
+			list[i].Error = ctx.Err().Error()
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+
+		go func(res *BulkResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			token, err := m.ManualRefresh(ctx, strconv.Itoa(res.UserID),
+				res.Service, res.Account)
+
+			if err != nil {
+				res.Error = err.Error()
+				return
+			}
+
+			res.Refreshed = true
+
+			if !token.Expiry.IsZero() {
+				expiry := token.Expiry
+				res.Expiry = &expiry
+			}
+		}(list[i])
+	}
+
+	wg.Wait()
+
+	return list, nil
+}
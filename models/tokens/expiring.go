@@ -0,0 +1,84 @@
+package tokens
+
+import (
+	"context"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+)
+
+// FailingToken type represents token whose access token expires soon
+// while its refresh keeps failing, so user has to connect again before
+// access is lost.
+type FailingToken struct {
+	UserID          int        `json:"user_id"`
+	Service         string     `json:"service"`
+	Account         string     `json:"account,omitempty"`
+	Expiry          time.Time  `json:"expiry"`
+	Status          string     `json:"status"`
+	RefreshFailures int        `json:"refresh_failures"`
+	RefreshError    string     `json:"refresh_error,omitempty"`
+	RetryAt         *time.Time `json:"refresh_retry_at,omitempty"`
+}
+
+// ListFailingExpiring method returns tokens whose access token expires
+// within given window, already expired ones included, and whose last
+// refresh failed or which are dead, soonest expiring first. Empty service
+// lists tokens of every service.
+func (m *Model) ListFailingExpiring(ctx context.Context, service string, within time.Duration, p *helpers.Paginator) ([]*FailingToken, error) {
+	where := `WHERE expiry > '0001-01-01 00:00:00+00' AND expiry < $1
+								AND (refresh_failures > 0 OR status = $2)
+								AND ($3 = '' OR service = $3)`
+
+	args := []interface{}{time.Now().Add(within), StatusDead, service}
+
+	if !p.SkipCount {
+		err := m.db.QueryRowContext(ctx, `SELECT count(*)
+									     FROM auth.tokens
+								`+where, args...,
+		).Scan(&p.Total)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT "user_id", "service", "account",
+										"expiry", "status", "refresh_failures",
+										"refresh_error", "refresh_retry_at"
+									     FROM auth.tokens
+								`+where+`
+								ORDER BY expiry, user_id, service, account
+								OFFSET $4 LIMIT $5`,
+		append(args, p.Skip(), p.Fetch())...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*FailingToken, 0, p.PerPage)
+
+	for rows.Next() {
+		var t FailingToken
+
+		err = rows.Scan(&t.UserID, &t.Service, &t.Account, &t.Expiry, &t.Status,
+			&t.RefreshFailures, &t.RefreshError, &t.RetryAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, &t)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return list[:p.Trim(len(list))], nil
+}
@@ -0,0 +1,261 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/breaker"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// FailureTransient provider is unreachable or failing, refresh may
+	// succeed later.
+	FailureTransient = "transient"
+
+	// FailureRateLimited provider throttles requests, refresh may succeed
+	// after RetryAfter.
+	FailureRateLimited = "rate_limited"
+
+	// FailurePermanent grant is gone, revoked or its consent withdrawn,
+	// user must connect again.
+	FailurePermanent = "permanent"
+
+	// FailureClient provider rejected app itself, app credentials or
+	// registration must be fixed.
+	FailureClient = "client"
+
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = time.Minute
+
+	refreshAttempts   = 3
+	refreshRetryDelay = 250 * time.Millisecond
+
+	defaultMaxRefreshFailures = 8
+	defaultBaseBackoff        = 30 * time.Second
+	defaultMaxBackoff         = time.Hour
+)
+
+var (
+	// ErrProviderUnavailable refresh skipped while provider is failing.
+	ErrProviderUnavailable = errors.New("provider temporarily unavailable")
+
+	// ErrRefreshBackoff refresh skipped until failed one may be retried.
+	ErrRefreshBackoff = errors.New("refresh backing off after failure")
+
+	// ErrTokenDead refresh failed too many times and is no longer tried.
+	ErrTokenDead = errors.New("refresh failed repeatedly, token is dead")
+
+	// permanentErrors lists OAuth error codes meaning grant is unusable
+	// until user authorizes app again.
+	permanentErrors = map[string]struct{}{
+		"invalid_grant":        {},
+		"invalid_token":        {},
+		"access_denied":        {},
+		"consent_required":     {},
+		"interaction_required": {},
+		"login_required":       {},
+	}
+
+	// clientErrors lists OAuth error codes blaming app, not grant.
+	clientErrors = map[string]struct{}{
+		"invalid_client":      {},
+		"unauthorized_client": {},
+	}
+)
+
+// RefreshError type represents classified failure of provider token
+// endpoint, on refresh, code or token exchange. Class is one of Failure
+// constants, RetryAfter is set when provider or breaker names the time to
+// try again.
+type RefreshError struct {
+	Class      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RefreshError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RefreshError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary method reports whether refresh may succeed if retried.
+func (e *RefreshError) Temporary() bool {
+	return e.Class == FailureTransient || e.Class == FailureRateLimited
+}
+
+// ReauthRequired method reports whether user must authorize app again
+// before token can be used.
+func (e *RefreshError) ReauthRequired() bool {
+	return e.Class == FailurePermanent
+}
+
+// classify function sorts provider token endpoint error. Errors without
+// provider response, e.g. network failures, are transient.
+func classify(err error) *RefreshError {
+	var re *oauth2.RetrieveError
+
+	if !errors.As(err, &re) {
+		return &RefreshError{Class: FailureTransient, Err: err}
+	}
+
+	if _, ok := permanentErrors[re.ErrorCode]; ok {
+		return &RefreshError{Class: FailurePermanent, Err: err}
+	}
+
+	if _, ok := clientErrors[re.ErrorCode]; ok {
+		return &RefreshError{Class: FailureClient, Err: err}
+	}
+
+	if re.Response == nil {
+		return &RefreshError{Class: FailureTransient, Err: err}
+	}
+
+	switch code := re.Response.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		return &RefreshError{
+			Class:      FailureRateLimited,
+			RetryAfter: retryAfter(re.Response.Header.Get("Retry-After")),
+			Err:        err,
+		}
+	case code >= http.StatusInternalServerError:
+		return &RefreshError{Class: FailureTransient, Err: err}
+	}
+
+	return &RefreshError{Class: FailureClient, Err: err}
+}
+
+// retryable function reports whether refresh may be retried at once.
+// Only provider 5xx responses are, as the refresh token was surely not
+// consumed; network failures may hide rotation already done.
+func retryable(err *RefreshError) bool {
+	var re *oauth2.RetrieveError
+
+	return err.Class == FailureTransient && errors.As(err.Err, &re) &&
+		re.Response != nil
+}
+
+// retryAfter function parses Retry-After header given in seconds or as
+// HTTP date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// backoff method records failed refresh of token and delays its next
+// refresh, doubling delay with every failure in a row, longer when
+// provider asked for it. Token failing MaxRefreshFailures times is marked
+// dead. Refreshes abandoned by caller are not counted.
+func (m *Model) backoff(ctx context.Context, token *Token, rerr *RefreshError) *RefreshError {
+	if ctx.Err() != nil {
+		return rerr
+	}
+
+	delay := backoffDelay(token.RefreshFailures, m.baseBackoff, m.maxBackoff,
+		rerr.RetryAfter)
+	userID := strconv.Itoa(token.UserID)
+	var status string
+
+	err := m.db.QueryRowContext(ctx, `UPDATE auth.tokens SET
+									"refresh_failures" = refresh_failures + 1,
+									"refresh_error" = $3,
+									"refresh_retry_at" = $4,
+									"status" = CASE WHEN refresh_failures + 1 >= $5
+										THEN 'dead' ELSE status END,
+									"status_changed_at" = CASE WHEN refresh_failures + 1 >= $5
+										AND status <> 'dead' THEN now()
+										ELSE status_changed_at END
+								WHERE user_id = $1 AND service = $2
+								AND account = $7 AND updated_at = $6
+								RETURNING status`,
+		userID, token.Service, rerr.Error(), time.Now().Add(delay),
+		m.maxRefreshFailures, token.updatedAt, token.Account,
+	).Scan(&status)
+
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("tokens: refresh failure of %s for user %s not stored: %s",
+				token.Service, userID, err)
+		}
+
+		return rerr
+	}
+
+	m.warm(ctx, userID, token.Service, token.Account)
+
+	if status != StatusDead {
+		if rerr.RetryAfter < delay {
+			rerr.RetryAfter = delay
+		}
+
+		return rerr
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenDead,
+		Severity:    audit.SeverityHigh,
+		UserID:      userID,
+		Service:     token.Service,
+		Fingerprint: token.Fingerprint,
+	})
+
+	return &RefreshError{Class: FailurePermanent, Err: ErrTokenDead}
+}
+
+// backoffDelay function returns delay of refresh after given number of
+// failures in a row: base doubled with every failure up to max, or
+// retryAfter when provider asked for longer.
+func backoffDelay(failures int, base, max, retryAfter time.Duration) time.Duration {
+	delay := base
+
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+
+	return delay
+}
+
+// breaker method returns circuit breaker of service provider.
+func (m *Model) breaker(service string) *breaker.Breaker {
+	m.breakersMu.Lock()
+	defer m.breakersMu.Unlock()
+
+	b, ok := m.breakers[service]
+
+	if !ok {
+		b = breaker.New(m.breakerThreshold, m.breakerCooldown)
+		m.breakers[service] = b
+	}
+
+	return b
+}
@@ -0,0 +1,164 @@
+package tokens
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func retrieveError(status int, code string, header http.Header) error {
+	var resp *http.Response
+
+	if status != 0 {
+		resp = &http.Response{StatusCode: status, Header: header}
+	}
+
+	return &oauth2.RetrieveError{Response: resp, ErrorCode: code}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		class      string
+		retryAfter time.Duration
+		retryable  bool
+	}{
+		{name: "network", err: errors.New("connection reset"), class: FailureTransient},
+		{name: "invalid grant", err: retrieveError(400, "invalid_grant", nil), class: FailurePermanent},
+		{name: "invalid token", err: retrieveError(401, "invalid_token", nil), class: FailurePermanent},
+		{name: "consent required", err: retrieveError(400, "consent_required", nil), class: FailurePermanent},
+		{name: "permanent without response", err: retrieveError(0, "access_denied", nil), class: FailurePermanent},
+		{name: "invalid client", err: retrieveError(401, "invalid_client", nil), class: FailureClient},
+		{name: "unauthorized client", err: retrieveError(400, "unauthorized_client", nil), class: FailureClient},
+		{name: "no response", err: retrieveError(0, "", nil), class: FailureTransient},
+		{
+			name:       "rate limited",
+			err:        retrieveError(429, "", http.Header{"Retry-After": {"120"}}),
+			class:      FailureRateLimited,
+			retryAfter: 2 * time.Minute,
+		},
+		{name: "rate limited without header", err: retrieveError(429, "", http.Header{}), class: FailureRateLimited},
+		{name: "server error", err: retrieveError(502, "", nil), class: FailureTransient, retryable: true},
+		{name: "server error code", err: retrieveError(500, "server_error", nil), class: FailureTransient, retryable: true},
+		{name: "bad request", err: retrieveError(400, "invalid_request", nil), class: FailureClient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rerr := classify(tt.err)
+
+			if rerr.Class != tt.class {
+				t.Errorf("class = %q, want %q", rerr.Class, tt.class)
+			}
+
+			if rerr.RetryAfter != tt.retryAfter {
+				t.Errorf("retry after = %v, want %v", rerr.RetryAfter, tt.retryAfter)
+			}
+
+			if got := retryable(rerr); got != tt.retryable {
+				t.Errorf("retryable = %v, want %v", got, tt.retryable)
+			}
+
+			if !errors.Is(rerr, tt.err) {
+				t.Error("classified error does not wrap provider error")
+			}
+		})
+	}
+}
+
+func TestRefreshErrorClass(t *testing.T) {
+	tests := []struct {
+		class     string
+		temporary bool
+		reauth    bool
+	}{
+		{class: FailureTransient, temporary: true},
+		{class: FailureRateLimited, temporary: true},
+		{class: FailurePermanent, reauth: true},
+		{class: FailureClient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			rerr := &RefreshError{Class: tt.class, Err: errors.New("failed")}
+
+			if got := rerr.Temporary(); got != tt.temporary {
+				t.Errorf("Temporary() = %v, want %v", got, tt.temporary)
+			}
+
+			if got := rerr.ReauthRequired(); got != tt.reauth {
+				t.Errorf("ReauthRequired() = %v, want %v", got, tt.reauth)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{name: "empty", value: ""},
+		{name: "seconds", value: "30", min: 30 * time.Second, max: 30 * time.Second},
+		{name: "zero", value: "0"},
+		{name: "negative", value: "-5"},
+		{name: "garbage", value: "soon"},
+		{
+			name:  "date",
+			value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			min:   58 * time.Second,
+			max:   time.Minute,
+		},
+		{name: "past date", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryAfter(tt.value)
+
+			if got < tt.min || got > tt.max {
+				t.Errorf("retryAfter(%q) = %v, want within [%v, %v]",
+					tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	const (
+		base = 30 * time.Second
+		max  = time.Hour
+	)
+
+	tests := []struct {
+		name       string
+		failures   int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{name: "first", failures: 0, want: base},
+		{name: "second", failures: 1, want: time.Minute},
+		{name: "fourth", failures: 3, want: 4 * time.Minute},
+		{name: "capped", failures: 7, want: max},
+		{name: "far past cap", failures: 100, want: max},
+		{name: "provider asks longer", failures: 0, retryAfter: 5 * time.Minute, want: 5 * time.Minute},
+		{name: "provider asks shorter", failures: 3, retryAfter: time.Second, want: 4 * time.Minute},
+		{name: "provider asks past cap", failures: 0, retryAfter: 2 * time.Hour, want: 2 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backoffDelay(tt.failures, base, max, tt.retryAfter)
+
+			if got != tt.want {
+				t.Errorf("backoffDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package tokens
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/models/tokenevents"
+	"github.com/Zetkolink/auth/webhooks"
+)
+
+// ForgetResult type represents outcome of user disconnect. Unrevoked lists
+// deleted tokens whose grant provider could not revoke.
+type ForgetResult struct {
+	Deleted   int              `json:"deleted"`
+	Revoked   int              `json:"revoked"`
+	Exchanges int              `json:"exchanges"`
+	Unrevoked []UnrevokedToken `json:"unrevoked,omitempty"`
+}
+
+// UnrevokedToken type represents deleted token still granted at provider,
+// with reason of revocation failure.
+type UnrevokedToken struct {
+	Key
+	Error string `json:"error"`
+}
+
+// Forget method disconnects user from every service: each stored token is
+// revoked at provider, then tokens and exchanges of user are deleted in
+// single transaction. Tokens provider fails to revoke are deleted too and
+// reported in result, so disconnect never leaves data behind.
+func (m *Model) Forget(ctx context.Context, userID string) (*ForgetResult, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT `+tokenColumns+`
+									     FROM auth.tokens
+								WHERE user_id = $1
+								ORDER BY service, account
+								FOR UPDATE`,
+		userID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Token, 0)
+
+	for rows.Next() {
+		token, err := m.scan(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, token)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ForgetResult{}
+
+	for _, token := range list {
+		err = m.revoke(ctx, token)
+
+		if err != nil {
+			res.Unrevoked = append(res.Unrevoked, UnrevokedToken{
+				Key: Key{
+					UserID:  token.UserID,
+					Service: token.Service,
+					Account: token.Account,
+				},
+				Error: err.Error(),
+			})
+			continue
+		}
+
+		res.Revoked++
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE
+								FROM auth.tokens
+								WHERE user_id = $1`,
+		userID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res.Deleted = int(n)
+
+	result, err = tx.ExecContext(ctx, `DELETE
+								FROM auth.exchanges
+								WHERE user_id = $1`,
+		userID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err = result.RowsAffected()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res.Exchanges = int(n)
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range list {
+		if m.cache != nil {
+			_ = m.cache.Delete(ctx, tokenCacheKey(strconv.Itoa(token.UserID),
+				token.Service, token.Account))
+		}
+
+		m.publish(webhooks.EventTokenDeleted, token.UserID, token.Service,
+			token.Fingerprint)
+		m.push.Cancel(ctx, token.UserID, token.Service, token.Account)
+		m.event(ctx, token.UserID, token.Service, token.Account, tokenevents.KindDeleted, nil)
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionUserForgotten,
+		Severity: audit.SeverityHigh,
+		UserID:   userID,
+	})
+
+	return res, nil
+}
@@ -0,0 +1,65 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+)
+
+var (
+	// ErrNotRefreshable token expires before lease ends and cannot be
+	// refreshed.
+	ErrNotRefreshable = errors.New("token expires too soon and cannot be refreshed")
+
+	// ErrLeaseTooLong provider issues access tokens living shorter than
+	// requested lease.
+	ErrLeaseTooLong = errors.New("provider issues access tokens shorter than requested lease")
+)
+
+// Lease method returns token of external account whose access token
+// stays valid for at least validity, refreshing it at provider first when
+// it expires sooner. Tokens without expiry are always valid. Refresh is
+// not attempted in read-only mode.
+func (m *Model) Lease(ctx context.Context, userID string, service string, account string, validity time.Duration) (*Token, error) {
+	token, err := m.Get(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" {
+		return nil, ErrSubjectUnavailable
+	}
+
+	if leased(token, validity) {
+		return token, nil
+	}
+
+	if !token.Refreshable() {
+		return nil, ErrNotRefreshable
+	}
+
+	if helpers.IsReadOnly() {
+		return nil, helpers.ErrReadOnly
+	}
+
+	token, err = m.Refresh(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !leased(token, validity) {
+		return nil, ErrLeaseTooLong
+	}
+
+	return token, nil
+}
+
+// leased function reports whether access token of token is valid for at
+// least validity.
+func leased(token *Token, validity time.Duration) bool {
+	return token.Expiry.IsZero() || token.Expiry.After(time.Now().Add(validity))
+}
@@ -0,0 +1,121 @@
+package tokens
+
+import (
+	"context"
+
+	"github.com/Zetkolink/auth/models/tokenevents"
+)
+
+var manualRefreshKey = &contextKey{"manualRefresh"}
+
+type contextKey struct {
+	name string
+}
+
+// flight type represents refresh in progress that concurrent refreshes
+// of the same token wait for instead of calling provider again.
+type flight struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// ManualRefresh method refreshes token as Refresh does, recorded in token
+// history as refresh requested by client.
+func (m *Model) ManualRefresh(ctx context.Context, userID string, service string, account string) (*Token, error) {
+	return m.Refresh(context.WithValue(ctx, manualRefreshKey, true), userID,
+		service, account)
+}
+
+// Refresh method refreshes token at provider. Concurrent refreshes of
+// token are serialized, within instance by joining refresh in progress
+// and across instances by advisory lock, so provider is called once and
+// every caller gets the token it returned.
+func (m *Model) Refresh(ctx context.Context, userID string, service string, account string) (*Token, error) {
+	key := tokenCacheKey(userID, service, account)
+
+	m.flightsMu.Lock()
+	f, ok := m.flights[key]
+
+	if ok {
+		m.flightsMu.Unlock()
+
+		select {
+		case <-f.done:
+			return f.token, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	f = &flight{done: make(chan struct{})}
+	m.flights[key] = f
+	m.flightsMu.Unlock()
+
+	f.token, f.err = m.refreshLocked(ctx, userID, service, account)
+
+	m.flightsMu.Lock()
+	delete(m.flights, key)
+	m.flightsMu.Unlock()
+	close(f.done)
+
+	return f.token, f.err
+}
+
+// refreshLocked method refreshes token holding its advisory lock for the
+// duration of provider call. Token refreshed by other instance while the
+// lock was awaited is returned as is.
+func (m *Model) refreshLocked(ctx context.Context, userID string, service string, account string) (*Token, error) {
+	token, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`,
+		token.UserID, lockKey(token),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !current.updatedAt.Equal(token.updatedAt) {
+		return current, nil
+	}
+
+	return m.refresh(ctx, userID, service, current)
+}
+
+// lockKey function returns refresh lock key of token within its user,
+// service for default account as before accounts were told apart.
+func lockKey(token *Token) string {
+	if token.Account == "" {
+		return token.Service
+	}
+
+	return token.Service + "/" + token.Account
+}
+
+// refreshKind function returns token history kind of refresh of context.
+func refreshKind(ctx context.Context) string {
+	if manual, _ := ctx.Value(manualRefreshKey).(bool); manual {
+		return tokenevents.KindManualRefresh
+	}
+
+	return tokenevents.KindRefreshed
+}
@@ -0,0 +1,141 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zetkolink/auth/models/exchanges"
+	"github.com/Zetkolink/auth/models/identities"
+	"golang.org/x/oauth2"
+)
+
+const maxProfileSize = 1 << 20
+
+var (
+	// errProfile provider profile endpoint did not describe account.
+	errProfile = errors.New("provider returned no profile")
+
+	// profileFields lists profile keys account id, email and name are
+	// read from, in order of preference, covering builtin providers
+	// and OIDC userinfo.
+	profileFields = map[string][]string{
+		"subject": {"sub", "user_id", "id", "account_id", "uuid"},
+		"email":   {"email", "default_email"},
+		"name":    {"name", "display_name", "real_name"},
+	}
+
+	// nameParts lists key pairs name is assembled from when profile
+	// carries no full name.
+	nameParts = [][2]string{
+		{"first_name", "last_name"},
+		{"firstname", "lastname"},
+		{"given_name", "family_name"},
+	}
+)
+
+// fetchProfile method reads account behind newly connected token from
+// provider profile endpoint and stores it as token identity. Failures
+// are logged only, as token is usable without profile.
+func (m *Model) fetchProfile(ctx context.Context, exchange *exchanges.Exchange, tk *oauth2.Token) {
+	caps, err := m.apps.Capabilities(ctx, exchange.Service)
+
+	if err != nil || caps.ValidationURL == "" {
+		return
+	}
+
+	identity, err := m.profile(ctx, caps.ValidationURL, tk)
+
+	if err == nil {
+		identity.UserID = exchange.UserID
+		identity.Service = exchange.Service
+		identity.Account = exchange.Account
+		identity.Email = m.pii.Protect(exchange.Tenant, identity.Email)
+		identity.Name = m.pii.Protect(exchange.Tenant, identity.Name)
+
+		err = m.identities.Save(ctx, identity)
+	}
+
+	if err != nil {
+		log.Printf("tokens: profile of %s for user %d not stored: %s",
+			exchange.Service, exchange.UserID, err)
+	}
+}
+
+// profile method requests profile endpoint with access token.
+func (m *Model) profile(ctx context.Context, endpoint string, tk *oauth2.Token) (*identities.Identity, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tk.SetAuthHeader(req)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := endpointClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("profile endpoint returned " + resp.Status)
+	}
+
+	var profile map[string]interface{}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxProfileSize)).Decode(&profile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &identities.Identity{
+		Subject:   profileString(profile, profileFields["subject"]),
+		Email:     profileString(profile, profileFields["email"]),
+		Name:      profileString(profile, profileFields["name"]),
+		FetchedAt: time.Now(),
+	}
+
+	for _, parts := range nameParts {
+		if identity.Name != "" {
+			break
+		}
+
+		identity.Name = strings.TrimSpace(
+			profileString(profile, parts[:1]) + " " +
+				profileString(profile, parts[1:]))
+	}
+
+	if identity.Subject == "" {
+		return nil, errProfile
+	}
+
+	return identity, nil
+}
+
+// profileString function returns first of keys profile carries as
+// string or number.
+func profileString(profile map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		switch v := profile[key].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,189 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/models/tokenevents"
+	"github.com/Zetkolink/auth/webhooks"
+	"github.com/lib/pq"
+)
+
+const (
+	// RetentionUnused token was not read for retention period.
+	RetentionUnused = "unused"
+
+	// RetentionDead token has required user to connect again, being
+	// revoked, refresh_failed or dead, for retention period.
+	RetentionDead = "dead"
+
+	// retentionWhere matches tokens due under rule in $1-$4, see
+	// RetentionRule.
+	retentionWhere = `WHERE ($1 = '' OR service = $1)
+								AND NOT (service = ANY($2))
+								AND (` + retentionUnusedExpr + ` OR ` + retentionDeadExpr + `)`
+
+	retentionUnusedExpr = `($3::timestamptz IS NOT NULL
+									AND COALESCE(last_used_at, created_at) < $3)`
+
+	retentionDeadExpr = `($4::timestamptz IS NOT NULL
+									AND status IN ('revoked', 'refresh_failed', 'dead')
+									AND status_changed_at < $4)`
+
+	retentionReasonExpr = `CASE WHEN ` + retentionDeadExpr + `
+									THEN '` + RetentionDead + `' ELSE '` + RetentionUnused + `' END`
+)
+
+// RetentionRule type represents retention policy applied to tokens. Empty
+// service matches every service but excluded ones. Tokens not read since
+// UnusedBefore and tokens requiring user to connect again since
+// DeadBefore are due; nil bound disables its rule.
+type RetentionRule struct {
+	Service      string
+	Exclude      []string
+	UnusedBefore *time.Time
+	DeadBefore   *time.Time
+}
+
+// ExpiredToken type represents token due under retention rule, with
+// reason.
+type ExpiredToken struct {
+	Key
+	Reason string `json:"reason"`
+}
+
+// RetentionCount type represents number of tokens of service due under
+// retention rule for reason.
+type RetentionCount struct {
+	Service string `json:"service"`
+	Reason  string `json:"reason"`
+	Tokens  int    `json:"tokens"`
+}
+
+// ExpiredTokens method returns up to limit tokens due under rule, oldest
+// first.
+func (m *Model) ExpiredTokens(ctx context.Context, rule RetentionRule, limit int) ([]ExpiredToken, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "user_id", "service", "account",
+										`+retentionReasonExpr+`
+									     FROM auth.tokens
+								`+retentionWhere+`
+								ORDER BY COALESCE(last_used_at, created_at)
+								LIMIT $5`,
+		append(rule.args(), limit)...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]ExpiredToken, 0)
+
+	for rows.Next() {
+		var t ExpiredToken
+
+		err = rows.Scan(&t.UserID, &t.Service, &t.Account, &t.Reason)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, t)
+	}
+
+	return list, rows.Err()
+}
+
+// CountExpired method returns number of tokens due under rule per service
+// and reason, ordered by service.
+func (m *Model) CountExpired(ctx context.Context, rule RetentionRule) ([]*RetentionCount, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "service", `+retentionReasonExpr+` AS reason,
+										count(*)
+									     FROM auth.tokens
+								`+retentionWhere+`
+								GROUP BY service, reason
+								ORDER BY service, reason`,
+		rule.args()...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*RetentionCount, 0)
+
+	for rows.Next() {
+		var c RetentionCount
+
+		err = rows.Scan(&c.Service, &c.Reason, &c.Tokens)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, &c)
+	}
+
+	return list, rows.Err()
+}
+
+// DeleteExpired method deletes token unless it is no longer due under
+// rule, e.g. was read or connected again meanwhile. It reports whether
+// token was deleted. Grant is not revoked at provider.
+func (m *Model) DeleteExpired(ctx context.Context, token ExpiredToken, rule RetentionRule) (bool, error) {
+	var fingerprint string
+
+	err := m.db.QueryRowContext(ctx, `DELETE
+								FROM auth.tokens
+								`+retentionWhere+`
+								AND user_id = $5 AND service = $6 AND account = $7
+								RETURNING "access_token_fingerprint"`,
+		append(rule.args(), token.UserID, token.Service, token.Account)...,
+	).Scan(&fingerprint)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	userID := strconv.Itoa(token.UserID)
+
+	if m.cache != nil {
+		_ = m.cache.Delete(ctx, tokenCacheKey(userID, token.Service,
+			token.Account))
+	}
+
+	m.publish(webhooks.EventTokenDeleted, token.UserID, token.Service,
+		fingerprint)
+	m.push.Cancel(ctx, token.UserID, token.Service, token.Account)
+	m.event(ctx, token.UserID, token.Service, token.Account, tokenevents.KindDeleted, nil)
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenDeleted,
+		UserID:      userID,
+		Service:     token.Service,
+		Fingerprint: fingerprint,
+	})
+
+	return true, nil
+}
+
+func (r RetentionRule) args() []interface{} {
+	exclude := r.Exclude
+
+	if exclude == nil {
+		exclude = []string{}
+	}
+
+	return []interface{}{r.Service, pq.Array(exclude), r.UnusedBefore,
+		r.DeadBefore}
+}
@@ -0,0 +1,152 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zetkolink/auth/operations"
+)
+
+const (
+	// OperationRewrap is the operation kind re-sealing stored tokens with
+	// primary encryption key.
+	OperationRewrap = "tokens.rewrap"
+
+	rewrapBatchSize = 500
+)
+
+// stored type represents token secrets as they are stored.
+type stored struct {
+	userID       int
+	service      string
+	account      string
+	accessToken  string
+	refreshToken string
+	updatedAt    time.Time
+}
+
+// Rewrap method re-seals stored tokens not sealed with primary key, so
+// retired keys can be removed from keyring. Hashed access tokens are left
+// as they are. Token changed while it is rewrapped is skipped, its writer
+// sealed it with primary key already.
+func (m *Model) Rewrap(ctx context.Context, _ json.RawMessage, p *operations.Progress) error {
+	var total int
+
+	err := m.db.QueryRowContext(ctx, `SELECT count(*) FROM auth.tokens`).
+		Scan(&total)
+
+	if err != nil {
+		return err
+	}
+
+	p.SetTotal(total)
+
+	last := stored{}
+
+	for {
+		batch, err := m.storedBatch(ctx, last)
+
+		if err != nil {
+			return err
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, s := range batch {
+			err = m.rewrap(ctx, s)
+
+			if err != nil {
+				p.Fail(strconv.Itoa(s.userID)+"/"+s.service, err)
+			} else {
+				p.Advance(1)
+			}
+		}
+
+		last = batch[len(batch)-1]
+	}
+}
+
+// storedBatch method returns next batch of stored tokens after given
+// one, in key order.
+func (m *Model) storedBatch(ctx context.Context, last stored) ([]stored, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "user_id", "service",
+										"account", "access_token",
+										"refresh_token", "updated_at"
+									     FROM auth.tokens
+								WHERE (user_id, service, account) > ($1, $2, $3)
+								ORDER BY user_id, service, account
+								LIMIT $4`,
+		last.userID, last.service, last.account, rewrapBatchSize,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	batch := make([]stored, 0, rewrapBatchSize)
+
+	for rows.Next() {
+		var s stored
+
+		err = rows.Scan(&s.userID, &s.service, &s.account, &s.accessToken,
+			&s.refreshToken, &s.updatedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, s)
+	}
+
+	return batch, rows.Err()
+}
+
+// rewrap method re-seals single stored token when it is not current.
+func (m *Model) rewrap(ctx context.Context, s stored) error {
+	accessToken, err := m.reseal(s.accessToken)
+
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := m.reseal(s.refreshToken)
+
+	if err != nil {
+		return err
+	}
+
+	if accessToken == s.accessToken && refreshToken == s.refreshToken {
+		return nil
+	}
+
+	_, err = m.db.ExecContext(ctx, `UPDATE auth.tokens
+								SET access_token = $3, refresh_token = $4
+								WHERE user_id = $1 AND service = $2
+									AND account = $6 AND updated_at = $5`,
+		s.userID, s.service, accessToken, refreshToken, s.updatedAt,
+		s.account,
+	)
+
+	return err
+}
+
+func (m *Model) reseal(value string) (string, error) {
+	if m.keyring.Current(value) || strings.HasPrefix(value, hashPrefix) {
+		return value, nil
+	}
+
+	plain, err := m.keyring.Open(value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return m.keyring.Seal(plain)
+}
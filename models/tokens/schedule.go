@@ -0,0 +1,127 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const minRefreshPeriod = time.Minute
+
+// Schedule type represents refresh workload of service previewed from
+// stored expiries. Lifetime is the median lifetime of tokens provider
+// issues, refreshed tokens being assumed to live as long. Lead and
+// Lifetime are in seconds.
+type Schedule struct {
+	Service  string     `json:"service"`
+	Lead     int        `json:"lead"`
+	Lifetime int        `json:"lifetime"`
+	Tokens   int        `json:"tokens"`
+	Total    int        `json:"total"`
+	Peak     int        `json:"peak"`
+	Hours    []HourLoad `json:"hours"`
+}
+
+// HourLoad type represents refresh calls falling within hour.
+type HourLoad struct {
+	Start time.Time `json:"start"`
+	Calls int       `json:"calls"`
+}
+
+// RefreshSchedule method previews refresh calls per hour over horizon
+// when refreshable tokens of service are refreshed lead before they
+// expire. Tokens due already are counted in first hour.
+func (m *Model) RefreshSchedule(ctx context.Context, service string, lead time.Duration, horizon time.Duration) (*Schedule, error) {
+	caps, err := m.apps.Capabilities(ctx, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !caps.SupportsRefresh {
+		return nil, ErrRefreshUnsupported
+	}
+
+	start := time.Now().Truncate(time.Hour)
+	end := start.Add(horizon)
+
+	s := &Schedule{
+		Service: service,
+		Lead:    int(lead / time.Second),
+		Hours:   make([]HourLoad, int(horizon/time.Hour)),
+	}
+
+	for i := range s.Hours {
+		s.Hours[i].Start = start.Add(time.Duration(i) * time.Hour)
+	}
+
+	var lifetime sql.NullFloat64
+
+	err = m.db.QueryRowContext(ctx, `SELECT count(*),
+									percentile_cont(0.5) WITHIN GROUP (
+										ORDER BY extract(epoch FROM expiry - created_at)
+									) FILTER (WHERE expiry > created_at)
+									     FROM auth.tokens
+								WHERE service = $1 AND refresh_token <> ''`,
+		service,
+	).Scan(&s.Tokens, &lifetime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lifetime = int(lifetime.Float64)
+
+	period := time.Duration(s.Lifetime)*time.Second - lead
+
+	if period < minRefreshPeriod {
+		period = minRefreshPeriod
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT date_trunc('minute',
+										GREATEST(expiry - $2 * interval '1 second', $3)),
+									count(*)
+									     FROM auth.tokens
+								WHERE service = $1 AND refresh_token <> ''
+									AND expiry - $2 * interval '1 second' < $4
+								GROUP BY 1`,
+		service, lead.Seconds(), start, end,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var at time.Time
+		var n int
+
+		err = rows.Scan(&at, &n)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for ; at.Before(end); at = at.Add(period) {
+			s.Hours[int(at.Sub(start)/time.Hour)].Calls += n
+		}
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range s.Hours {
+		s.Total += h.Calls
+
+		if h.Calls > s.Peak {
+			s.Peak = h.Calls
+		}
+	}
+
+	return s, nil
+}
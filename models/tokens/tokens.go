@@ -2,163 +2,2205 @@ package tokens
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/breaker"
+	"github.com/Zetkolink/auth/cache"
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/Zetkolink/auth/hooks"
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/keyring"
+	"github.com/Zetkolink/auth/models/analytics"
 	"github.com/Zetkolink/auth/models/apps"
 	"github.com/Zetkolink/auth/models/exchanges"
+	"github.com/Zetkolink/auth/models/identities"
+	"github.com/Zetkolink/auth/models/providers"
+	"github.com/Zetkolink/auth/models/storage"
+	"github.com/Zetkolink/auth/models/tokenevents"
+	"github.com/Zetkolink/auth/oidc"
+	"github.com/Zetkolink/auth/pii"
+	"github.com/Zetkolink/auth/push"
+	"github.com/Zetkolink/auth/scripting"
+	"github.com/Zetkolink/auth/tokenuse"
+	"github.com/Zetkolink/auth/webhooks"
+	"github.com/lib/pq"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// AccessTokenStore access tokens are stored encrypted and returned
+	// to clients.
+	AccessTokenStore = "store"
+
+	// AccessTokenHash access tokens are stored only as hashes and can
+	// only be verified.
+	AccessTokenHash = "hash"
+
+	hashPrefix = "hash:sha256:"
+
+	// TokenTypeAccessToken is the RFC 8693 access token type identifier.
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+	tokenColumns = `"user_id", "token_type", "access_token", "expiry",
+	"refresh_token", "created_at", "service", "instance_url", "extra",
+	"access_token_fingerprint", "identity", "updated_at", "scopes",
+	"metadata", "app_id", "status", "refresh_failures", "refresh_error",
+	"refresh_retry_at", "use_count", "last_used_at", "account", "labels"`
+
+	// StatusActive token is usable, refreshing it if access token
+	// expired.
+	StatusActive = "active"
+
+	// StatusExpired access token of active token expired. It is never
+	// stored, but derived from expiry.
+	StatusExpired = "expired"
+
+	// StatusRevoked provider rejected unexpired access token or grant
+	// was revoked at provider, user must connect again.
+	StatusRevoked = "revoked"
+
+	// StatusRefreshFailed provider rejected refresh token, user must
+	// connect again.
+	StatusRefreshFailed = "refresh_failed"
+
+	// StatusDead refresh failed too many times in a row and is no longer
+	// tried, user must connect again.
+	StatusDead = "dead"
+
+	// statusExpr derives status of token row like Token.CurrentStatus
+	// does. Tokens without expiry are stored with zero time.
+	statusExpr = `CASE WHEN status = 'active' AND expiry < now()
+		AND expiry > '0001-01-01 00:00:00+00' THEN 'expired' ELSE status END`
+
+	cacheKeyPrefix = "tokens:"
+
+	endpointTimeout = 10 * time.Second
 )
 
 var (
+	endpointClient = &http.Client{Timeout: endpointTimeout}
+
 	// ErrNotFound token not found.
 	ErrNotFound = errors.New("token not found")
+
+	// ErrAccessTokenMode unknown access token storage mode.
+	ErrAccessTokenMode = errors.New("unknown access token mode")
+
+	// ErrMismatch presented access token differs from stored one.
+	ErrMismatch = errors.New("access token mismatch")
+
+	// ErrExpired stored access token expired.
+	ErrExpired = errors.New("access token expired")
+
+	// ErrExchangeUnsupported provider does not support token exchange.
+	ErrExchangeUnsupported = errors.New("provider does not support token exchange")
+
+	// ErrSubjectUnavailable stored access token cannot be presented.
+	ErrSubjectUnavailable = errors.New("access token is stored hashed")
+
+	// ErrRevokeUnsupported provider has no revocation endpoint.
+	ErrRevokeUnsupported = errors.New("provider does not support revocation")
+
+	// ErrRevocationFailed provider refused to revoke token.
+	ErrRevocationFailed = errors.New("token revocation failed")
+
+	// ErrIntrospectUnsupported provider has no introspection endpoint.
+	ErrIntrospectUnsupported = errors.New("provider does not support introspection")
+
+	// ErrIntrospectionFailed provider refused to introspect token.
+	ErrIntrospectionFailed = errors.New("token introspection failed")
+
+	// ErrValidateUnsupported provider has no endpoint to check token at.
+	ErrValidateUnsupported = errors.New("provider does not support token validation")
+
+	// ErrValidationFailed provider could not tell whether token is
+	// accepted.
+	ErrValidationFailed = errors.New("token validation failed")
+
+	// ErrRefreshRejected provider rejected stored refresh token, the
+	// grant has to be authorized again.
+	ErrRefreshRejected = errors.New("refresh token rejected by provider")
+
+	// ErrRefreshUnsupported provider issues no refreshable tokens.
+	ErrRefreshUnsupported = errors.New("provider does not support refresh")
+
+	// ErrNoRefreshToken provider issued no refresh token to offline
+	// access app.
+	ErrNoRefreshToken = errors.New("provider returned no refresh token")
+
+	// ErrInsufficientScope stored grant does not cover requested scopes.
+	ErrInsufficientScope = errors.New("stored grant does not cover requested scopes")
+
+	// forcedRefresh lists services whose tokens are refreshed on every
+	// refresh request regardless of stored expiry.
+	forcedRefresh = map[string]struct{}{
+		apps.Strava: {},
+	}
+
+	// revocationParams lists services taking revoked token under
+	// parameter other than RFC 7009 token.
+	revocationParams = map[string]string{
+		apps.Strava: "access_token",
+	}
+
+	// commonExtraFields lists token response fields kept with token
+	// of every provider.
+	commonExtraFields = []string{"id_token"}
+
+	// extraFields lists provider token response fields kept with token.
+	// Registry providers are matched by service name.
+	extraFields = map[string][]string{
+		apps.Notion: {
+			"bot_id", "workspace_id", "workspace_name",
+			"workspace_icon", "owner", "duplicated_template_id",
+		},
+		apps.VK:         {"email", "user_id"},
+		apps.Salesforce: {"instance_url", "id", "issued_at", "signature"},
+		apps.Strava:     {"athlete"},
+		"slack": {
+			"team", "enterprise", "authed_user", "bot_user_id",
+			"app_id", "is_enterprise_install",
+		},
+	}
 )
 
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
 type Model struct {
-	db        *sql.DB
-	exchanges *exchanges.Model
-	apps      *apps.Model
+	db         *sql.DB
+	exchanges  *exchanges.Model
+	apps       *apps.Model
+	analytics  *analytics.Model
+	identities *identities.Model
+	webhooks   *webhooks.Dispatcher
+	hooks      *hooks.Runner
+	push       *push.Pusher
+	scripts    *scripting.Engine
+	audit      *audit.Writer
+	usage      *tokenuse.Recorder
+	events     *tokenevents.Model
+	keyring    *keyring.Keyring
+	transfer   *keyring.Keyring
+	pii        *pii.Minimizer
+	cache      cache.Cache
+	cacheTTL   time.Duration
+	region     string
+
+	accessTokenMode string
+	fetchProfiles   bool
+	prewarm         int
+	prewarmWindow   time.Duration
+
+	flightsMu sync.Mutex
+	flights   map[string]*flight
+
+	breakersMu       sync.Mutex
+	breakers         map[string]*breaker.Breaker
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	maxRefreshFailures int
+	baseBackoff        time.Duration
+	maxBackoff         time.Duration
 }
 
 type ModelConfig struct {
-	Db        *sql.DB
-	Exchanges *exchanges.Model
-	Apps      *apps.Model
+	Db         *sql.DB
+	Exchanges  *exchanges.Model
+	Apps       *apps.Model
+	Analytics  *analytics.Model
+	Identities *identities.Model
+	Webhooks   *webhooks.Dispatcher
+	Hooks      *hooks.Runner
+	Push       *push.Pusher
+	Scripts    *scripting.Engine
+	Audit      *audit.Writer
+	Keyring    *keyring.Keyring
+	Pii        *pii.Minimizer
+	Region     string
+
+	// Usage counts token reads. Nil disables usage tracking.
+	Usage *tokenuse.Recorder
+
+	// Events keeps token lifecycle history. Nil disables history.
+	Events *tokenevents.Model
+
+	// Transfer seals token archives moved between instances. Export and
+	// Import are unavailable without keys.
+	Transfer *keyring.Keyring
+
+	// AccessTokenMode is AccessTokenStore or AccessTokenHash.
+	AccessTokenMode string
+
+	// FetchProfiles makes connect read account from provider profile
+	// endpoint into Identities.
+	FetchProfiles bool
+
+	// Cache holds opened tokens, so it must be in-process. Nil disables
+	// token caching.
+	Cache    cache.Cache
+	CacheTTL time.Duration
+
+	// Prewarm is the number of tokens Prewarm loads, PrewarmWindow
+	// the expiry horizon tokens loaded first fall within.
+	Prewarm       int
+	PrewarmWindow time.Duration
+
+	// BreakerThreshold transient refresh failures in a row stop refresh
+	// of service for BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxRefreshFailures failed refreshes of token in a row mark it
+	// dead. Refresh after failure waits BaseBackoff, doubled with every
+	// further failure up to MaxBackoff.
+	MaxRefreshFailures int
+	BaseBackoff        time.Duration
+	MaxBackoff         time.Duration
 }
 
 type Token struct {
 	*oauth2.Token
-	UserID    int       `json:"user_id"`
-	Service   string    `json:"service"`
-	CreatedAt time.Time `json:"created_at"`
+	UserID      int                    `json:"user_id"`
+	Service     string                 `json:"service"`
+	Account     string                 `json:"account,omitempty"`
+	InstanceURL string                 `json:"instance_url,omitempty"`
+	Fingerprint string                 `json:"access_token_fingerprint"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	Identity    *Identity              `json:"identity,omitempty"`
+	Scopes      []string               `json:"scopes,omitempty"`
+	Metadata    map[string]string      `json:"metadata,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+
+	// Labels are set by caller starting connect flow, to tell apart
+	// grants of products sharing the service.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// AppID is the client token was minted under, empty for tokens
+	// minted before it was recorded.
+	AppID string `json:"app_id,omitempty"`
+
+	// Status is stored lifecycle status, see CurrentStatus.
+	Status string `json:"status"`
+
+	// RefreshFailures counts refreshes failed in a row, RefreshError is
+	// the last failure and RetryAt the time refresh is tried again.
+	RefreshFailures int        `json:"refresh_failures,omitempty"`
+	RefreshError    string     `json:"refresh_error,omitempty"`
+	RetryAt         *time.Time `json:"retry_at,omitempty"`
+
+	// UseCount counts reads of token by clients, LastUsedAt is the time
+	// of last one. Both lag reads by usage flush interval.
+	UseCount   int64      `json:"use_count"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	hash string
+
+	// refreshable is set on listed tokens whose refresh token was
+	// stripped.
+	refreshable bool
+
+	// updatedAt is the row version refresh writes are conditioned on.
+	updatedAt time.Time
+}
+
+// Key type represents token identity, user, service and external
+// account connected. Empty account is default account of service.
+type Key struct {
+	UserID  int    `json:"user_id" validate:"required"`
+	Service string `json:"service" validate:"required,max=512"`
+	Account string `json:"account,omitempty" validate:"max=256"`
+}
+
+// ServiceFilter type represents filter of tokens listed by service. Nil
+// bounds are open; lower bounds are inclusive, upper ones exclusive.
+type ServiceFilter struct {
+	Service     string
+	ExpiresFrom *time.Time
+	ExpiresTo   *time.Time
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+
+	// ExcludeApp skips tokens minted under the app.
+	ExcludeApp string
+
+	// Status keeps tokens of the status only, as CurrentStatus reports
+	// it.
+	Status string
+
+	// UnusedBefore keeps tokens not read since, tokens never read
+	// since created.
+	UnusedBefore *time.Time
+
+	// Labels keeps tokens carrying every label.
+	Labels map[string]string
+}
+
+// Identity type represents external account id_token was issued for.
+// Email and name are stored as the PII mode of tenant requires.
+type Identity struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// ExchangeRequest type represents RFC 8693 token exchange parameters.
+type ExchangeRequest struct {
+	Scopes             []string `json:"scopes"`
+	Audience           string   `json:"audience"`
+	Resource           string   `json:"resource"`
+	RequestedTokenType string   `json:"requested_token_type"`
+
+	// Account is external account of stored token, empty for default
+	// account of service.
+	Account string `json:"account,omitempty" validate:"max=256"`
+}
+
+// Validation type represents provider answer to stored access token.
+// Status is HTTP status of provider validation endpoint.
+type Validation struct {
+	Valid     bool      `json:"valid"`
+	Reason    string    `json:"reason,omitempty"`
+	Status    int       `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// DerivedToken type represents token issued by token exchange. It is
+// returned to caller only and never stored.
+type DerivedToken struct {
+	AccessToken     string    `json:"access_token"`
+	TokenType       string    `json:"token_type"`
+	IssuedTokenType string    `json:"issued_token_type,omitempty"`
+	Scope           string    `json:"scope,omitempty"`
+	Expiry          time.Time `json:"expiry,omitempty"`
+}
+
+// Introspection type represents provider view of stored access token
+// (RFC 7662). Inactive tokens carry no other fields.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
 }
 
 func NewModel(config ModelConfig) (*Model, error) {
 	m := &Model{
-		db:        config.Db,
-		exchanges: config.Exchanges,
-		apps:      config.Apps,
+		db:         config.Db,
+		exchanges:  config.Exchanges,
+		apps:       config.Apps,
+		analytics:  config.Analytics,
+		identities: config.Identities,
+		webhooks:   config.Webhooks,
+		hooks:      config.Hooks,
+		push:       config.Push,
+		scripts:    config.Scripts,
+		audit:      config.Audit,
+		usage:      config.Usage,
+		events:     config.Events,
+		keyring:    config.Keyring,
+		transfer:   config.Transfer,
+		pii:        config.Pii,
+		cache:      config.Cache,
+		cacheTTL:   config.CacheTTL,
+		region:     config.Region,
+
+		accessTokenMode: config.AccessTokenMode,
+		fetchProfiles:   config.FetchProfiles,
+		prewarm:         config.Prewarm,
+		prewarmWindow:   config.PrewarmWindow,
+
+		flights: make(map[string]*flight),
+
+		breakers:         make(map[string]*breaker.Breaker),
+		breakerThreshold: config.BreakerThreshold,
+		breakerCooldown:  config.BreakerCooldown,
+
+		maxRefreshFailures: config.MaxRefreshFailures,
+		baseBackoff:        config.BaseBackoff,
+		maxBackoff:         config.MaxBackoff,
+	}
+
+	if m.breakerThreshold <= 0 {
+		m.breakerThreshold = defaultBreakerThreshold
+	}
+
+	if m.breakerCooldown <= 0 {
+		m.breakerCooldown = defaultBreakerCooldown
+	}
+
+	if m.maxRefreshFailures <= 0 {
+		m.maxRefreshFailures = defaultMaxRefreshFailures
+	}
+
+	if m.baseBackoff <= 0 {
+		m.baseBackoff = defaultBaseBackoff
+	}
+
+	if m.maxBackoff <= 0 {
+		m.maxBackoff = defaultMaxBackoff
+	}
+
+	if m.accessTokenMode == "" {
+		m.accessTokenMode = AccessTokenStore
+	}
+
+	if m.accessTokenMode != AccessTokenStore &&
+		m.accessTokenMode != AccessTokenHash {
+		return nil, ErrAccessTokenMode
 	}
 
 	return m, nil
 }
 
-func (m *Model) Get(ctx context.Context, userID string, service string) (*Token, error) {
-	token := Token{
-		Token: &oauth2.Token{},
+// Get method returns token of user's external account of service, empty
+// account being default one.
+func (m *Model) Get(ctx context.Context, userID string, service string, account string) (*Token, error) {
+	token, err := m.cached(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
 	}
 
-	err := m.db.QueryRowContext(ctx, `SELECT  
-									"user_id", "token_type","access_token", 
-       								"expiry", "refresh_token",
-       								"created_at", "service"
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenRead,
+		UserID:      userID,
+		Service:     service,
+		Fingerprint: token.Fingerprint,
+	})
+
+	m.usage.Record(token.UserID, token.Service, token.Account)
+
+	return token, nil
+}
+
+// Exists method reports whether user's external account of service has
+// usable token: active one whose access token is unexpired or can be
+// refreshed. Token is neither decrypted nor its read audited.
+func (m *Model) Exists(ctx context.Context, userID string, service string, account string) (bool, error) {
+	var exists bool
+
+	err := m.db.QueryRowContext(ctx, `SELECT EXISTS (
+									SELECT 1
 									     FROM auth.tokens
-								WHERE user_id = $1 AND service = $2`,
-		userID, service,
-	).Scan(&token.UserID, &token.TokenType, &token.AccessToken,
-		&token.Expiry, &token.RefreshToken,
-		&token.CreatedAt, &token.Service,
+									WHERE user_id = $1 AND service = $2
+									AND account = $3 AND status = $4
+									AND (refresh_token <> '' OR expiry >= now()
+										OR expiry = '0001-01-01 00:00:00+00')
+								)`,
+		userID, service, account, StatusActive,
+	).Scan(&exists)
+
+	return exists, err
+}
+
+// GetBatch method returns tokens of keys, in key order, nil for keys
+// without token. Tokens missing from token cache are loaded in single
+// query.
+func (m *Model) GetBatch(ctx context.Context, keys []Key) ([]*Token, error) {
+	list := make([]*Token, len(keys))
+	missing := make(map[string][]int)
+
+	var userIDs []int64
+	var services, accounts []string
+
+	for i, key := range keys {
+		cacheKey := tokenCacheKey(strconv.Itoa(key.UserID), key.Service,
+			key.Account)
+
+		if m.cache != nil {
+			data, err := m.cache.Get(ctx, cacheKey)
+
+			if err == nil {
+				var token Token
+
+				if json.Unmarshal(data, &token) == nil {
+					list[i] = &token
+					continue
+				}
+			}
+		}
+
+		if _, ok := missing[cacheKey]; !ok {
+			userIDs = append(userIDs, int64(key.UserID))
+			services = append(services, key.Service)
+			accounts = append(accounts, key.Account)
+		}
+
+		missing[cacheKey] = append(missing[cacheKey], i)
+	}
+
+	if len(userIDs) > 0 {
+		rows, err := m.db.QueryContext(ctx, `SELECT `+tokenColumns+`
+									     FROM auth.tokens
+								WHERE (user_id, service, account) IN (
+									SELECT * FROM unnest($1::integer[], $2::text[],
+										$3::text[])
+								)`,
+			pq.Array(userIDs), pq.Array(services), pq.Array(accounts),
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			token, err := m.scan(rows)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if m.cache != nil {
+				m.remember(ctx, token)
+			}
+
+			cacheKey := tokenCacheKey(strconv.Itoa(token.UserID),
+				token.Service, token.Account)
+
+			for _, i := range missing[cacheKey] {
+				list[i] = token
+			}
+		}
+
+		err = rows.Err()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, token := range list {
+		if token == nil {
+			continue
+		}
+
+		_ = m.audit.Write(ctx, audit.Entry{
+			Action:      audit.ActionTokenRead,
+			UserID:      strconv.Itoa(token.UserID),
+			Service:     token.Service,
+			Fingerprint: token.Fingerprint,
+		})
+
+		m.usage.Record(token.UserID, token.Service, token.Account)
+	}
+
+	return list, nil
+}
+
+// ListByUser method returns tokens of every service user connected,
+// ordered by service, only those carrying every label. Refresh tokens are
+// never returned, access tokens only with withAccessTokens set, each such
+// read being audited.
+func (m *Model) ListByUser(ctx context.Context, userID string, labels map[string]string, withAccessTokens bool) ([]*Token, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT `+tokenColumns+`
+									     FROM auth.tokens
+								WHERE user_id = $1 AND labels @> $2
+								ORDER BY service, account`,
+		userID, labelsJSON(labels),
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &token, nil
+	defer rows.Close()
+
+	list := make([]*Token, 0)
+
+	for rows.Next() {
+		token, err := m.scan(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		token.refreshable = token.RefreshToken != ""
+		token.RefreshToken = ""
+
+		if withAccessTokens {
+			_ = m.audit.Write(ctx, audit.Entry{
+				Action:      audit.ActionTokenRead,
+				UserID:      userID,
+				Service:     token.Service,
+				Fingerprint: token.Fingerprint,
+			})
+
+			m.usage.Record(token.UserID, token.Service, token.Account)
+		} else {
+			token.AccessToken = ""
+		}
+
+		list = append(list, token)
+	}
+
+	return list, rows.Err()
 }
 
-func (m *Model) Refresh(ctx context.Context, userID string, service string) (*Token, error) {
-	token := Token{
-		Token: &oauth2.Token{},
+// ListByService method returns page of tokens users connected to service,
+// newest first. Neither access nor refresh tokens are returned.
+func (m *Model) ListByService(ctx context.Context, filter ServiceFilter, p *helpers.Paginator) ([]*Token, error) {
+	where := `WHERE service = $1
+								AND ($2::timestamptz IS NULL OR expiry >= $2)
+								AND ($3::timestamptz IS NULL OR expiry < $3)
+								AND ($4::timestamptz IS NULL OR created_at >= $4)
+								AND ($5::timestamptz IS NULL OR created_at < $5)
+								AND ($6 = '' OR app_id <> $6)
+								AND ($7 = '' OR ` + statusExpr + ` = $7)
+								AND ($8::timestamptz IS NULL
+									OR COALESCE(last_used_at, created_at) < $8)
+								AND labels @> $9`
+
+	args := []interface{}{
+		filter.Service, filter.ExpiresFrom, filter.ExpiresTo,
+		filter.CreatedFrom, filter.CreatedTo, filter.ExcludeApp,
+		filter.Status, filter.UnusedBefore, labelsJSON(filter.Labels),
 	}
 
-	err := m.db.QueryRowContext(ctx, `SELECT  
-									"user_id", "token_type","access_token", 
-       								"expiry", "refresh_token",
-       								"created_at", "service"
+	if !p.SkipCount {
+		err := m.db.QueryRowContext(ctx, `SELECT count(*)
 									     FROM auth.tokens
-								WHERE user_id = $1 AND service = $2`,
-		userID, service,
-	).Scan(&token.UserID, &token.TokenType, &token.AccessToken,
-		&token.Expiry, &token.RefreshToken,
-		&token.CreatedAt, &token.Service,
+								`+where, args...,
+		).Scan(&p.Total)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT `+tokenColumns+`
+									     FROM auth.tokens
+								`+where+`
+								ORDER BY created_at DESC, user_id
+								OFFSET $10 LIMIT $11`,
+		append(args, p.Skip(), p.Fetch())...,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	conf, err := m.apps.GetConf(ctx, token.Service)
+	defer rows.Close()
 
-	if err != nil {
-		return nil, err
+	list := make([]*Token, 0, p.PerPage)
+
+	for rows.Next() {
+		token, err := m.scan(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		token.refreshable = token.RefreshToken != ""
+		token.RefreshToken = ""
+		token.AccessToken = ""
+
+		list = append(list, token)
 	}
 
-	ts := conf.TokenSource(ctx, token.Token)
-	newToken, err := ts.Token()
+	err = rows.Err()
 
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = m.db.ExecContext(ctx, `UPDATE auth.tokens SET
-									"access_token" = $2,
-                       				"refresh_token" = $3,
-       								"expiry" = $4,
-       								"created_at" = $5
-								WHERE user_id = $1`,
-		userID, newToken.AccessToken, newToken.RefreshToken,
-		newToken.Expiry, time.Now(),
+	return list[:p.Trim(len(list))], nil
+}
+
+// CurrentStatus method returns lifecycle status of token: stored status,
+// or StatusExpired while active token's access token is expired.
+func (t *Token) CurrentStatus() string {
+	if t.Status == StatusActive && !t.Expiry.IsZero() &&
+		t.Expiry.Before(time.Now()) {
+		return StatusExpired
+	}
+
+	return t.Status
+}
+
+// ExpiringKeys method returns keys of up to limit active refreshable
+// tokens of service, of every service for empty one, whose access token
+// expires within window, soonest first. Tokens expired already are
+// included, tokens backing off after failed refresh are not.
+func (m *Model) ExpiringKeys(ctx context.Context, service string, window time.Duration, limit int) ([]Key, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "user_id", "service", "account"
+									     FROM auth.tokens
+								WHERE status = 'active' AND refresh_token <> ''
+									AND expiry > '0001-01-01 00:00:00+00'
+									AND expiry < $1
+									AND (refresh_retry_at IS NULL OR refresh_retry_at <= now())
+									AND ($3 = '' OR service = $3)
+								ORDER BY expiry
+								LIMIT $2`,
+		time.Now().Add(window), limit, service,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &token, nil
+	defer rows.Close()
+
+	list := make([]Key, 0)
+
+	for rows.Next() {
+		var key Key
+
+		err = rows.Scan(&key.UserID, &key.Service, &key.Account)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, key)
+	}
+
+	return list, rows.Err()
 }
 
-func (m *Model) Create(ctx context.Context, code string, exchangeID string) (int, error) {
-	exchange, err := m.exchanges.Get(ctx, exchangeID)
+// Refreshable method reports whether token can be refreshed without
+// user. It holds for tokens returned by ListByUser too, which carry no
+// refresh token.
+func (t *Token) Refreshable() bool {
+	return t.refreshable || t.RefreshToken != ""
+}
+
+// GetScoped method returns token only when stored grant covers scopes.
+// With downscope set, access token narrowed to scopes is obtained from
+// provider by token exchange, so caller never holds more privilege than
+// it asked for. Downscoped token carries no refresh token.
+func (m *Model) GetScoped(ctx context.Context, userID string, service string, account string, scopes []string, downscope bool) (*Token, error) {
+	token, err := m.Get(ctx, userID, service, account)
 
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	if !covers(token.Scopes, scopes) {
+		return nil, ErrInsufficientScope
+	}
+
+	if !downscope {
+		return token, nil
 	}
 
-	conf, err := m.apps.GetConf(ctx, exchange.Service)
+	derived, err := m.Exchange(ctx, userID, service, ExchangeRequest{
+		Scopes:  scopes,
+		Account: account,
+	})
 
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	narrowed := *token
+	narrowed.Token = &oauth2.Token{
+		AccessToken: derived.AccessToken,
+		TokenType:   derived.TokenType,
+		Expiry:      derived.Expiry,
+	}
+	narrowed.Fingerprint = dblog.Fingerprint(derived.AccessToken)
+	narrowed.Scopes = scopes
+
+	if derived.Scope != "" {
+		narrowed.Scopes = strings.Fields(derived.Scope)
 	}
 
-	tk, err := conf.Exchange(ctx, code)
+	return &narrowed, nil
+}
+
+// Verify method checks that access token presented by client is the one
+// stored for user's external account of service and has not expired.
+func (m *Model) Verify(ctx context.Context, userID string, service string, account string, accessToken string) (*Token, error) {
+	token, err := m.get(ctx, userID, service, account)
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	_ = m.exchanges.Delete(ctx, exchangeID)
+	stored := token.AccessToken
 
-	_, err = m.db.ExecContext(ctx, `INSERT INTO auth.tokens
-									( "user_id", "token_type","access_token", 
-       								"expiry", "refresh_token",
-       								"created_at", "service" )
-								VALUES ($1, $2, $3, $4, $5, $6, $7) 
-								ON CONFLICT (user_id, service) DO UPDATE 
-								SET access_token = excluded.access_token,
-								refresh_token = excluded.refresh_token,
-								expiry = excluded.expiry,
-								created_at = excluded.created_at`,
-		exchange.UserID, tk.TokenType, tk.AccessToken,
-		tk.Expiry, tk.RefreshToken,
-		time.Now(), exchange.Service,
-	)
+	if stored == "" {
+		stored = token.hash
+	}
+
+	presented := accessToken
+
+	if strings.HasPrefix(stored, hashPrefix) {
+		presented = hashAccessToken(accessToken)
+	}
+
+	if accessToken == "" ||
+		subtle.ConstantTimeCompare([]byte(stored), []byte(presented)) != 1 {
+		return nil, ErrMismatch
+	}
+
+	if !token.Expiry.IsZero() && token.Expiry.Before(time.Now()) {
+		return nil, ErrExpired
+	}
+
+	return token, nil
+}
+
+// refresh method refreshes token at provider, stores the new pair and
+// returns the stored token. Caller holds refresh lock of token.
+func (m *Model) refresh(ctx context.Context, userID string, service string, token *Token) (*Token, error) {
+	caps, err := m.apps.Capabilities(ctx, token.Service)
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return exchange.UserID, nil
+	if !caps.SupportsRefresh {
+		return nil, ErrRefreshUnsupported
+	}
+
+	ctx, conf, err := m.clientConf(ctx, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	current := *token.Token
+
+	if m.accessTokenMode == AccessTokenHash {
+		// Hashed access token cannot be presented to provider,
+		// so token source always refreshes.
+		current.AccessToken = ""
+	}
+
+	if m.forceRefresh(ctx, token.Service) {
+		current.Expiry = time.Now()
+	}
+
+	if token.Status == StatusDead {
+		return nil, &RefreshError{Class: FailurePermanent, Err: ErrTokenDead}
+	}
+
+	if token.RetryAt != nil && token.RetryAt.After(time.Now()) {
+		return nil, &RefreshError{
+			Class:      FailureTransient,
+			RetryAfter: time.Until(*token.RetryAt),
+			Err:        ErrRefreshBackoff,
+		}
+	}
+
+	b := m.breaker(token.Service)
+	allowed, retryAt := b.Allow(time.Now())
+
+	if !allowed {
+		return nil, &RefreshError{
+			Class:      FailureTransient,
+			RetryAfter: time.Until(retryAt),
+			Err:        ErrProviderUnavailable,
+		}
+	}
+
+	newToken, rerr := m.retrieve(ctx, conf, &current)
+
+	if rerr != nil && rerr.Class == FailureClient {
+		// Provider may accept only the secret replaced by rotation
+		// until its console is updated.
+		pctx, pconf, err := m.apps.PreviousClientConf(ctx, conf.ClientID)
+
+		if err == nil {
+			var perr *RefreshError
+
+			newToken, perr = m.retrieve(pctx, pconf, &current)
+
+			if perr == nil {
+				rerr = nil
+			}
+		}
+	}
+
+	if rerr != nil {
+		if rerr.Temporary() {
+			b.Failure(time.Now())
+		} else {
+			b.Success()
+		}
+
+		refreshed, err := m.refreshFailed(ctx, token, rerr)
+
+		if err != nil {
+			m.event(ctx, token.UserID, token.Service, token.Account, refreshKind(ctx), err)
+		}
+
+		return refreshed, err
+	}
+
+	b.Success()
+
+	if current.AccessToken != "" && newToken.AccessToken == current.AccessToken &&
+		newToken.RefreshToken == current.RefreshToken {
+		// Token source returned the stored token still valid, provider
+		// was not asked and there is nothing to store.
+		return token, nil
+	}
+
+	instanceURL := extraString(newToken, "instance_url")
+
+	if instanceURL == "" {
+		instanceURL = token.InstanceURL
+	}
+
+	accessToken, refreshToken, err := m.seal(newToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	extra, err := json.Marshal(extraMap(newToken, token.Service))
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	// Providers rotating refresh tokens invalidate the old one, so the
+	// returned pair is authoritative and must not be overwritten by a
+	// concurrent refresh that started from the same row version. Scopes
+	// are replaced only when provider reports them, as most providers
+	// keep the grant unchanged and say nothing. Extra fields returned
+	// at connect only, e.g. workspace, are kept.
+	refreshed, err := m.scan(m.db.QueryRowContext(ctx, `UPDATE auth.tokens SET
+									"access_token" = $3,
+                       				"refresh_token" = $4,
+       								"expiry" = $5,
+       								"created_at" = $6,
+       								"instance_url" = $7,
+       								"updated_at" = $6,
+       								"region" = $8,
+       								"access_token_fingerprint" = $9,
+       								"status" = 'active',
+       								"status_changed_at" = CASE WHEN status <> 'active'
+       									THEN $6 ELSE status_changed_at END,
+       								"refresh_failures" = 0,
+       								"refresh_error" = '',
+       								"refresh_retry_at" = NULL,
+       								"scopes" = CASE WHEN cardinality($11::text[]) > 0
+       									THEN $11 ELSE scopes END,
+       								"extra" = extra || $12::jsonb
+								WHERE user_id = $1 AND service = $2
+								AND account = $13 AND updated_at = $10
+								RETURNING `+tokenColumns,
+		userID, service, dblog.Secret(accessToken),
+		dblog.Secret(refreshToken),
+		newToken.Expiry, now, instanceURL, m.region,
+		dblog.Fingerprint(newToken.AccessToken), token.updatedAt,
+		pq.Array(grantedScopes(newToken, nil)), extra, token.Account,
+	))
+
+	if err == sql.ErrNoRows {
+		// Concurrent refresh stored its pair first. Ours was issued
+		// from the same refresh token, so the stored one stays valid
+		// wherever ours is.
+		log.Printf("tokens: refresh of %s for user %s superseded", service, userID)
+		return m.get(ctx, userID, service, token.Account)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.cache != nil {
+		m.remember(ctx, refreshed)
+	}
+	m.publish(webhooks.EventTokenRefreshed, token.UserID, token.Service,
+		newToken.AccessToken)
+	m.hooks.Enqueue(ctx, hooks.EventTokenRefreshed, token.UserID,
+		token.Service)
+	m.push.Enqueue(ctx, push.EventTokenRefreshed, token.UserID,
+		token.Service, token.Account)
+
+	m.event(ctx, token.UserID, token.Service, token.Account, refreshKind(ctx), nil)
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenRefreshed,
+		UserID:      userID,
+		Service:     service,
+		Fingerprint: dblog.Fingerprint(newToken.AccessToken),
+	})
+
+	return refreshed, nil
+}
+
+// retrieve method refreshes token at provider, retrying provider 5xx
+// responses.
+func (m *Model) retrieve(ctx context.Context, conf *oauth2.Config, current *oauth2.Token) (*oauth2.Token, *RefreshError) {
+	for attempt := 1; ; attempt++ {
+		newToken, err := conf.TokenSource(ctx, current).Token()
+
+		if err == nil {
+			return newToken, nil
+		}
+
+		rerr := classify(err)
+
+		if attempt == refreshAttempts || !retryable(rerr) {
+			return nil, rerr
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, classify(ctx.Err())
+		case <-time.After(time.Duration(attempt) * refreshRetryDelay):
+		}
+	}
+}
+
+// refreshFailed method handles refresh error. Refresh token rejected
+// because concurrent refresh rotated it meanwhile is no error, the rotated
+// token is returned. Otherwise rejection means the grant is dead and is
+// reported, so the stale copy is not retried silently.
+func (m *Model) refreshFailed(ctx context.Context, token *Token, rerr *RefreshError) (*Token, error) {
+	userID := strconv.Itoa(token.UserID)
+
+	m.scripts.Fire(scripting.Event{
+		Type:    scripting.EventRefreshFailed,
+		UserID:  token.UserID,
+		Service: token.Service,
+		Account: token.Account,
+		Tenant:  helpers.GetTenant(ctx),
+		Error:   rerr.Error(),
+		Failure: rerr.Class,
+	})
+
+	if rerr.Class == FailureClient {
+		_ = m.audit.Write(ctx, audit.Entry{
+			Action:   audit.ActionAppRejected,
+			Severity: audit.SeverityHigh,
+			UserID:   userID,
+			Service:  token.Service,
+		})
+	}
+
+	if rerr.Class != FailurePermanent {
+		return nil, m.backoff(ctx, token, rerr)
+	}
+
+	stored, err := m.get(ctx, userID, token.Service, token.Account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.RefreshToken != token.RefreshToken {
+		return stored, nil
+	}
+
+	m.setStatus(ctx, token, StatusRefreshFailed)
+	m.publish(webhooks.EventTokenRefreshRejected, token.UserID,
+		token.Service, token.Fingerprint)
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenRefreshRejected,
+		Severity:    audit.SeverityHigh,
+		UserID:      userID,
+		Service:     token.Service,
+		Fingerprint: token.Fingerprint,
+	})
+
+	return nil, &RefreshError{Class: FailurePermanent, Err: ErrRefreshRejected}
+}
+
+// SetMetadata method sets metadata key of token of external account.
+func (m *Model) SetMetadata(ctx context.Context, userID int, service string, account string, key string, value string) error {
+	res, err := m.db.ExecContext(ctx, `UPDATE auth.tokens
+								SET metadata = metadata || jsonb_build_object($3::text, $4::text)
+								WHERE user_id = $1 AND service = $2 AND account = $5`,
+		userID, service, key, value, account,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	m.warm(ctx, strconv.Itoa(userID), service, account)
+
+	return nil
+}
+
+// Reauthorize method returns auth code url asking user to grant scopes
+// in addition to those of stored token of external account (incremental
+// authorization).
+func (m *Model) Reauthorize(ctx context.Context, userID string, service string, account string, scopes []string) (string, error) {
+	token, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		return "", err
+	}
+
+	return m.apps.IncrementalAuthCodeURL(ctx, service, token.UserID,
+		token.Account, token.Scopes, scopes)
+}
+
+// Delete method removes token of external account, empty account being
+// default one. With revoke set, the grant is revoked at provider first
+// and the token is kept if revocation fails.
+func (m *Model) Delete(ctx context.Context, userID string, service string, account string, revoke bool) error {
+	token, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		return err
+	}
+
+	if revoke {
+		err = m.revoke(ctx, token)
+
+		if err != nil {
+			m.event(ctx, token.UserID, token.Service, token.Account,
+				tokenevents.KindDeleted, err)
+			return err
+		}
+
+		// Should removal fail, the dead grant is still told apart.
+		m.setStatus(ctx, token, StatusRevoked)
+	}
+
+	res, err := m.db.ExecContext(ctx, `DELETE
+								FROM auth.tokens
+								WHERE user_id = $1 AND service = $2
+								AND account = $3`,
+		userID, service, account,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if m.cache != nil {
+		_ = m.cache.Delete(ctx, tokenCacheKey(userID, service, account))
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	m.publish(webhooks.EventTokenDeleted, token.UserID, token.Service,
+		token.Fingerprint)
+	m.push.Cancel(ctx, token.UserID, token.Service, token.Account)
+	m.event(ctx, token.UserID, token.Service, token.Account, tokenevents.KindDeleted, nil)
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenDeleted,
+		UserID:      userID,
+		Service:     service,
+		Fingerprint: token.Fingerprint,
+	})
+
+	return nil
+}
+
+// DeleteByApp method deletes every token minted under app in tx without
+// revoking it at provider, e.g. before the app is removed. Deleted tokens
+// are returned for Deleted once tx commits.
+func (m *Model) DeleteByApp(ctx context.Context, tx *sql.Tx, app *apps.App) ([]*Token, error) {
+	rows, err := tx.QueryContext(ctx, `DELETE
+								FROM auth.tokens
+								WHERE `+apps.OwnedBy+`
+								RETURNING "user_id", "account", "access_token_fingerprint"`,
+		app.ID, app.Service,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*Token, 0)
+
+	for rows.Next() {
+		token := &Token{Service: app.Service, AppID: app.ID}
+
+		err = rows.Scan(&token.UserID, &token.Account, &token.Fingerprint)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, token)
+	}
+
+	return list, rows.Err()
+}
+
+// Deleted method completes deletion of tokens deleted by DeleteByApp:
+// drops them from cache and announces it by webhook, push, event and
+// audit.
+func (m *Model) Deleted(ctx context.Context, list []*Token) {
+	for _, token := range list {
+		userID := strconv.Itoa(token.UserID)
+
+		if m.cache != nil {
+			_ = m.cache.Delete(ctx, tokenCacheKey(userID, token.Service,
+				token.Account))
+		}
+
+		m.publish(webhooks.EventTokenDeleted, token.UserID, token.Service,
+			token.Fingerprint)
+		m.push.Cancel(ctx, token.UserID, token.Service, token.Account)
+		m.event(ctx, token.UserID, token.Service, token.Account, tokenevents.KindDeleted, nil)
+
+		_ = m.audit.Write(ctx, audit.Entry{
+			Action:      audit.ActionTokenDeleted,
+			UserID:      userID,
+			Service:     token.Service,
+			Fingerprint: token.Fingerprint,
+		})
+	}
+}
+
+// revoke method revokes token at provider (RFC 7009). Refresh token is
+// preferred, as revoking it ends the whole grant.
+func (m *Model) revoke(ctx context.Context, token *Token) error {
+	caps, err := m.apps.Capabilities(ctx, token.Service)
+
+	if err != nil {
+		return err
+	}
+
+	if !caps.SupportsRevoke || caps.RevocationURL == "" {
+		return ErrRevokeUnsupported
+	}
+
+	ctx, conf, err := m.clientConf(ctx, token)
+
+	if err != nil {
+		return err
+	}
+
+	value, hint := token.RefreshToken, "refresh_token"
+
+	if value == "" {
+		value, hint = token.AccessToken, "access_token"
+	}
+
+	if value == "" {
+		return ErrSubjectUnavailable
+	}
+
+	form := url.Values{}
+	param, ok := revocationParams[token.Service]
+
+	if ok {
+		form.Set(param, token.AccessToken)
+	} else {
+		form.Set("token", value)
+		form.Set("token_type_hint", hint)
+	}
+
+	resp, err := postForm(ctx, conf, caps.RevocationURL, form)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ErrRevocationFailed
+	}
+
+	return nil
+}
+
+// Introspect method asks provider whether stored access token of external
+// account is active (RFC 7662), so callers need no trial API request.
+func (m *Model) Introspect(ctx context.Context, userID string, service string, account string) (*Introspection, error) {
+	token, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" {
+		return nil, ErrSubjectUnavailable
+	}
+
+	caps, err := m.apps.Capabilities(ctx, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if caps.IntrospectionURL == "" {
+		return nil, ErrIntrospectUnsupported
+	}
+
+	ctx, conf, err := m.clientConf(ctx, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"token":           {token.AccessToken},
+		"token_type_hint": {"access_token"},
+	}
+
+	resp, err := postForm(ctx, conf, caps.IntrospectionURL, form)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrIntrospectionFailed
+	}
+
+	var result Introspection
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !result.Active {
+		return &Introspection{}, nil
+	}
+
+	return &result, nil
+}
+
+// Validate method checks whether provider still accepts stored access
+// token of external account by presenting it to provider validation
+// endpoint, which catches grants revoked by user before token expires.
+func (m *Model) Validate(ctx context.Context, userID string, service string, account string) (*Validation, error) {
+	token, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" {
+		return nil, ErrSubjectUnavailable
+	}
+
+	caps, err := m.apps.Capabilities(ctx, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if caps.ValidationURL == "" {
+		return nil, ErrValidateUnsupported
+	}
+
+	req, err := http.NewRequest(http.MethodGet, caps.ValidationURL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.SetAuthHeader(req)
+
+	resp, err := endpointClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return nil, ErrValidationFailed
+	}
+
+	_ = resp.Body.Close()
+
+	result := &Validation{
+		Status:    resp.StatusCode,
+		CheckedAt: time.Now(),
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		result.Valid = true
+	case resp.StatusCode == http.StatusUnauthorized,
+		resp.StatusCode == http.StatusForbidden:
+		result.Reason = "rejected"
+
+		// Unexpired token is rejected only once grant is revoked.
+		if token.CurrentStatus() == StatusActive {
+			m.setStatus(ctx, token, StatusRevoked)
+		}
+	default:
+		return nil, ErrValidationFailed
+	}
+
+	return result, nil
+}
+
+// postForm method posts form to provider endpoint, authenticating
+// client the way its token endpoint expects.
+func postForm(ctx context.Context, conf *oauth2.Config, endpoint string, form url.Values) (*http.Response, error) {
+	if conf.Endpoint.AuthStyle == oauth2.AuthStyleInParams {
+		form.Set("client_id", conf.ClientID)
+
+		if conf.ClientSecret != "" {
+			form.Set("client_secret", conf.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint,
+		strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if conf.Endpoint.AuthStyle != oauth2.AuthStyleInParams {
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID),
+			url.QueryEscape(conf.ClientSecret))
+	}
+
+	client := endpointClient
+
+	// Apps using private_key_jwt carry client signing assertions.
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = &http.Client{Transport: c.Transport, Timeout: endpointTimeout}
+	}
+
+	return client.Do(req.WithContext(ctx))
+}
+
+// Exchange method exchanges stored access token for downscoped or
+// delegated one at provider (RFC 8693). Provider failures are returned as
+// *RefreshError.
+func (m *Model) Exchange(ctx context.Context, userID string, service string, req ExchangeRequest) (*DerivedToken, error) {
+	token, err := m.get(ctx, userID, service, req.Account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" {
+		return nil, ErrSubjectUnavailable
+	}
+
+	if !token.Valid() {
+		return nil, ErrExpired
+	}
+
+	caps, err := m.apps.Capabilities(ctx, service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !caps.TokenExchange {
+		return nil, ErrExchangeUnsupported
+	}
+
+	ctx, conf, err := m.clientConf(ctx, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{
+		"grant_type":         {oidc.GrantTypeTokenExchange},
+		"subject_token":      {token.AccessToken},
+		"subject_token_type": {TokenTypeAccessToken},
+	}
+
+	for key, value := range map[string]string{
+		"audience":             req.Audience,
+		"resource":             req.Resource,
+		"requested_token_type": req.RequestedTokenType,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	// Token exchange is client credentials request with grant type
+	// overridden, which clientcredentials explicitly allows.
+	cc := &clientcredentials.Config{
+		ClientID:       conf.ClientID,
+		ClientSecret:   conf.ClientSecret,
+		TokenURL:       conf.Endpoint.TokenURL,
+		Scopes:         req.Scopes,
+		EndpointParams: params,
+		AuthStyle:      conf.Endpoint.AuthStyle,
+	}
+
+	tk, err := cc.Token(ctx)
+
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenExchanged,
+		UserID:      userID,
+		Service:     service,
+		Fingerprint: token.Fingerprint,
+	})
+
+	return &DerivedToken{
+		AccessToken:     tk.AccessToken,
+		TokenType:       tk.Type(),
+		IssuedTokenType: extraString(tk, "issued_token_type"),
+		Scope:           extraString(tk, "scope"),
+		Expiry:          tk.Expiry,
+	}, nil
+}
+
+func (m *Model) Create(ctx context.Context, code string, exchangeID string) (int, error) {
+	exchange, err := m.exchanges.Consume(ctx, exchangeID)
+
+	if err == exchanges.ErrReplayed {
+		_ = m.audit.Write(ctx, audit.Entry{
+			Action:   audit.ActionStateReplayed,
+			Severity: audit.SeverityHigh,
+			Tenant:   exchange.Tenant,
+			UserID:   strconv.Itoa(exchange.UserID),
+			Service:  exchange.Service,
+		})
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, conf, err := m.exchangeConf(ctx, exchange)
+
+	if err != nil {
+		m.recordFailure(ctx, exchange, analytics.ReasonAppUnavailable)
+		return 0, err
+	}
+
+	var opts []oauth2.AuthCodeOption
+
+	if exchange.CodeVerifier != "" {
+		opts = append(opts, oauth2.VerifierOption(exchange.CodeVerifier))
+	}
+
+	tk, err := conf.Exchange(ctx, code, opts...)
+
+	if err != nil {
+		// While service is migrated to new client, flows started
+		// before the switch carry codes issued to the old one.
+		fctx, fconf, ferr := m.apps.FallbackClientConf(ctx, exchange.Service)
+
+		if ferr == nil {
+			var ftk *oauth2.Token
+
+			ftk, ferr = fconf.Exchange(fctx, code, opts...)
+
+			if ferr == nil {
+				ctx, conf, tk, err = fctx, fconf, ftk, nil
+			}
+		}
+	}
+
+	if err != nil {
+		m.recordFailure(ctx, exchange, analytics.ReasonExchangeFailed)
+		return 0, classify(err)
+	}
+
+	if tk.RefreshToken == "" {
+		app, err := m.apps.GetByID(ctx, conf.ClientID)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if app.OfflineAccess {
+			m.recordFailure(ctx, exchange, analytics.ReasonNoRefreshToken)
+			return 0, ErrNoRefreshToken
+		}
+	}
+
+	identity, err := m.identity(ctx, conf.ClientID, exchange, tk)
+
+	if err != nil {
+		m.recordFailure(ctx, exchange, analytics.ReasonIDToken)
+		return 0, err
+	}
+
+	identityData, err := json.Marshal(identity)
+
+	if err != nil {
+		return 0, err
+	}
+
+	extra, err := json.Marshal(extraMap(tk, exchange.Service))
+
+	if err != nil {
+		return 0, err
+	}
+
+	accessToken, refreshToken, err := m.seal(tk)
+
+	if err != nil {
+		return 0, err
+	}
+
+	requested := exchange.Scopes
+
+	if len(requested) == 0 {
+		requested = conf.Scopes
+	}
+
+	res, err := m.db.ExecContext(ctx, `INSERT INTO auth.tokens
+									( "user_id", "token_type","access_token", 
+       								"expiry", "refresh_token",
+       								"created_at", "service", "instance_url",
+       								"extra", "updated_at", "region",
+       								"access_token_fingerprint", "identity",
+       								"scopes", "app_id", "account", "labels" )
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $6, $10, $11, $12, $13, $15, $16, $17) 
+								ON CONFLICT (user_id, service, account) DO UPDATE 
+								SET access_token = excluded.access_token,
+								app_id = excluded.app_id,
+								refresh_token = excluded.refresh_token,
+								expiry = excluded.expiry,
+								created_at = excluded.created_at,
+								instance_url = excluded.instance_url,
+								extra = excluded.extra,
+								updated_at = excluded.updated_at,
+								region = excluded.region,
+								access_token_fingerprint = excluded.access_token_fingerprint,
+								identity = excluded.identity,
+								status = 'active',
+								status_changed_at = CASE WHEN auth.tokens.status <> 'active'
+									THEN excluded.updated_at ELSE auth.tokens.status_changed_at END,
+								refresh_failures = 0,
+								refresh_error = '',
+								refresh_retry_at = NULL,
+								labels = auth.tokens.labels || excluded.labels,
+								scopes = CASE WHEN $14 THEN ARRAY(
+									SELECT DISTINCT s FROM unnest(
+										auth.tokens.scopes || excluded.scopes
+									) s ORDER BY s
+								) ELSE excluded.scopes END
+								WHERE (auth.tokens.updated_at, auth.tokens.region) <=
+								(excluded.updated_at, excluded.region)`,
+		exchange.UserID, tk.TokenType, dblog.Secret(accessToken),
+		tk.Expiry, dblog.Secret(refreshToken),
+		time.Now(), exchange.Service, extraString(tk, "instance_url"),
+		extra, m.region, dblog.Fingerprint(tk.AccessToken), identityData,
+		pq.Array(grantedScopes(tk, requested)), len(exchange.Scopes) > 0,
+		conf.ClientID, exchange.Account, labelsJSON(exchange.Labels),
+	)
+
+	if err != nil {
+		m.recordFailure(ctx, exchange, analytics.ReasonStorage)
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Token written later, e.g. in other region, won last-writer-wins:
+	// nothing was stored, so nothing is announced.
+	if n == 0 {
+		return exchange.UserID, nil
+	}
+
+	_ = m.analytics.Record(ctx, analytics.Event{
+		Service: exchange.Service,
+		Tenant:  exchange.Tenant,
+		Type:    analytics.EventCompletion,
+	})
+
+	if m.fetchProfiles {
+		m.fetchProfile(ctx, exchange, tk)
+	}
+
+	m.warm(ctx, strconv.Itoa(exchange.UserID), exchange.Service,
+		exchange.Account)
+	m.publish(webhooks.EventTokenCreated, exchange.UserID, exchange.Service,
+		tk.AccessToken)
+	m.hooks.Enqueue(ctx, hooks.EventTokenCreated, exchange.UserID,
+		exchange.Service)
+	m.push.Enqueue(ctx, push.EventTokenCreated, exchange.UserID,
+		exchange.Service, exchange.Account)
+	m.scripts.Fire(scripting.Event{
+		Type:    scripting.EventTokenCreated,
+		UserID:  exchange.UserID,
+		Service: exchange.Service,
+		Account: exchange.Account,
+		Tenant:  exchange.Tenant,
+	})
+	m.event(tokenevents.WithActor(ctx, tokenevents.ActorUser),
+		exchange.UserID, exchange.Service, exchange.Account,
+		tokenevents.KindCreated, nil)
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:      audit.ActionTokenCreated,
+		Tenant:      exchange.Tenant,
+		UserID:      strconv.Itoa(exchange.UserID),
+		Service:     exchange.Service,
+		Fingerprint: dblog.Fingerprint(tk.AccessToken),
+	})
+
+	return exchange.UserID, nil
+}
+
+func (m *Model) get(ctx context.Context, userID string, service string, account string) (*Token, error) {
+	token, err := m.scan(m.db.QueryRowContext(ctx, `SELECT `+tokenColumns+`
+									     FROM auth.tokens
+								WHERE user_id = $1 AND service = $2
+								AND account = $3`,
+		userID, service, account,
+	))
+
+	return token, storage.NotFound(err, ErrNotFound)
+}
+
+func (m *Model) scan(row scanner) (*Token, error) {
+	token := Token{
+		Token: &oauth2.Token{},
+	}
+
+	var extra, identity, metadata, labels []byte
+
+	err := row.Scan(&token.UserID, &token.TokenType, &token.AccessToken,
+		&token.Expiry, &token.RefreshToken,
+		&token.CreatedAt, &token.Service, &token.InstanceURL,
+		&extra, &token.Fingerprint, &identity, &token.updatedAt,
+		pq.Array(&token.Scopes), &metadata, &token.AppID, &token.Status,
+		&token.RefreshFailures, &token.RefreshError, &token.RetryAt,
+		&token.UseCount, &token.LastUsedAt, &token.Account, &labels,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(extra, &token.Extra)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(metadata, &token.Metadata)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(labels, &token.Labels)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if identity != nil {
+		err = json.Unmarshal(identity, &token.Identity)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.open(&token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Prewarm method loads tokens expiring within prewarm window, then the
+// most recently updated ones, into token cache.
+func (m *Model) Prewarm(ctx context.Context) (int, error) {
+	if m.cache == nil || m.prewarm <= 0 {
+		return 0, nil
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT `+tokenColumns+`
+									     FROM auth.tokens
+								ORDER BY (expiry < $2) DESC, updated_at DESC
+								LIMIT $1`,
+		m.prewarm, time.Now().Add(m.prewarmWindow),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	n := 0
+
+	for rows.Next() {
+		token, err := m.scan(rows)
+
+		if err != nil {
+			return n, err
+		}
+
+		m.remember(ctx, token)
+		n++
+	}
+
+	return n, rows.Err()
+}
+
+// cached method returns token from token cache, loading it on miss.
+func (m *Model) cached(ctx context.Context, userID string, service string, account string) (*Token, error) {
+	if m.cache == nil {
+		return m.get(ctx, userID, service, account)
+	}
+
+	data, err := m.cache.Get(ctx, tokenCacheKey(userID, service, account))
+
+	if err == nil {
+		var token Token
+
+		if json.Unmarshal(data, &token) == nil {
+			return &token, nil
+		}
+	}
+
+	token, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.remember(ctx, token)
+
+	return token, nil
+}
+
+// setStatus method stores lifecycle status of token unless token changed
+// since it was read, e.g. was refreshed or connected again.
+func (m *Model) setStatus(ctx context.Context, token *Token, status string) {
+	userID := strconv.Itoa(token.UserID)
+
+	_, err := m.db.ExecContext(ctx, `UPDATE auth.tokens
+								SET status = $3,
+								status_changed_at = CASE WHEN status <> $3
+									THEN now() ELSE status_changed_at END
+								WHERE user_id = $1 AND service = $2
+								AND account = $5 AND updated_at = $4`,
+		userID, token.Service, status, token.updatedAt, token.Account,
+	)
+
+	if err != nil {
+		log.Printf("tokens: status of %s for user %s not stored: %s",
+			token.Service, userID, err)
+		return
+	}
+
+	m.warm(ctx, userID, token.Service, token.Account)
+}
+
+// warm method reloads token into token cache after it changed.
+func (m *Model) warm(ctx context.Context, userID string, service string, account string) {
+	if m.cache == nil {
+		return
+	}
+
+	token, err := m.get(ctx, userID, service, account)
+
+	if err != nil {
+		_ = m.cache.Delete(ctx, tokenCacheKey(userID, service, account))
+		return
+	}
+
+	m.remember(ctx, token)
+}
+
+func (m *Model) remember(ctx context.Context, token *Token) {
+	data, err := json.Marshal(token)
+
+	if err != nil {
+		return
+	}
+
+	_ = m.cache.Set(ctx, tokenCacheKey(strconv.Itoa(token.UserID),
+		token.Service, token.Account), data, m.cacheTTL)
+}
+
+// exchangeConf method returns client config of app exchange was
+// initiated with, code being issued to its client. Exchanges started
+// before they recorded their app use enabled app of service.
+func (m *Model) exchangeConf(ctx context.Context, exchange *exchanges.Exchange) (context.Context, *oauth2.Config, error) {
+	if exchange.AppID != "" {
+		return m.apps.ClientConfByID(ctx, exchange.AppID)
+	}
+
+	return m.apps.ClientConf(ctx, exchange.Service)
+}
+
+// clientConf method returns client config of app token was minted
+// under, refresh tokens being bound to client. Tokens of unknown or
+// removed app use enabled app of service, tokens of app neither enabled
+// nor deprecated fail as rejected by provider.
+func (m *Model) clientConf(ctx context.Context, token *Token) (context.Context, *oauth2.Config, error) {
+	if token.AppID != "" {
+		cctx, conf, err := m.apps.ClientConfByID(ctx, token.AppID)
+
+		if err == apps.ErrStatus {
+			return ctx, nil, &RefreshError{Class: FailureClient, Err: err}
+		}
+
+		if err != apps.ErrNotFound {
+			return cctx, conf, err
+		}
+	}
+
+	return m.apps.ClientConf(ctx, token.Service)
+}
+
+// seal method returns access and refresh tokens in the form they are
+// stored: access token hashed or encrypted by mode, refresh token
+// encrypted.
+func (m *Model) seal(tk *oauth2.Token) (string, string, error) {
+	accessToken := hashAccessToken(tk.AccessToken)
+
+	if m.accessTokenMode != AccessTokenHash {
+		var err error
+
+		accessToken, err = m.keyring.Seal(tk.AccessToken)
+
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	refreshToken, err := m.keyring.Seal(tk.RefreshToken)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// open method decrypts stored token. Hashed access token is moved out of
+// the response, as it is of no use to clients.
+func (m *Model) open(token *Token) error {
+	if strings.HasPrefix(token.AccessToken, hashPrefix) {
+		token.hash = token.AccessToken
+		token.AccessToken = ""
+	}
+
+	var err error
+
+	token.AccessToken, err = m.keyring.Open(token.AccessToken)
+
+	if err != nil {
+		return err
+	}
+
+	token.RefreshToken, err = m.keyring.Open(token.RefreshToken)
+
+	return err
+}
+
+// identity method verifies id_token returned with token, if provider
+// issues one, and returns account it identifies.
+func (m *Model) identity(ctx context.Context, clientID string, exchange *exchanges.Exchange, tk *oauth2.Token) (*Identity, error) {
+	raw := extraString(tk, "id_token")
+
+	if raw == "" {
+		return nil, nil
+	}
+
+	issuer, err := m.apps.Issuer(ctx, exchange.Service)
+
+	if err != nil || issuer == "" {
+		return nil, err
+	}
+
+	claims, err := m.apps.VerifyIDToken(ctx, issuer, clientID, raw,
+		exchange.Nonce)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Subject: claims.Subject,
+		Email:   m.pii.Protect(exchange.Tenant, claims.Email),
+		Name:    m.pii.Protect(exchange.Tenant, claims.Name),
+	}, nil
+}
+
+func (m *Model) recordFailure(ctx context.Context, exchange *exchanges.Exchange, reason string) {
+	_ = m.analytics.Record(ctx, analytics.Event{
+		Service: exchange.Service,
+		Tenant:  exchange.Tenant,
+		Type:    analytics.EventFailure,
+		Reason:  reason,
+	})
+
+	m.event(tokenevents.WithActor(ctx, tokenevents.ActorUser),
+		exchange.UserID, exchange.Service, exchange.Account,
+		tokenevents.KindCreated, errors.New(reason))
+}
+
+// event method records lifecycle event of token of external account,
+// failed when err is set. Event not stored is logged only.
+func (m *Model) event(ctx context.Context, userID int, service string, account string, kind string, err error) {
+	rerr := m.events.Record(ctx, tokenevents.Event{
+		UserID:  userID,
+		Service: service,
+		Account: account,
+		Kind:    kind,
+	}, err)
+
+	if rerr != nil {
+		log.Printf("tokens: %s event of %s for user %d not stored: %s",
+			kind, service, userID, rerr)
+	}
+}
+
+func (m *Model) forceRefresh(ctx context.Context, service string) bool {
+	if _, ok := forcedRefresh[service]; ok {
+		return true
+	}
+
+	provider, err := m.apps.Provider(ctx, service)
+
+	return err == nil && provider.Has(providers.QuirkForceRefresh)
+}
+
+// publish emits token event. Dedup key is bound to the access token the
+// transition produced, so redeliveries of one refresh share the key.
+func (m *Model) publish(eventType string, userID int, service string, accessToken string) {
+	key := webhooks.DedupKey(eventType,
+		strconv.Itoa(userID), service, accessToken)
+
+	err := m.webhooks.Publish(eventType, key, map[string]interface{}{
+		"user_id": userID,
+		"service": service,
+	})
+
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// grantedScopes function returns scopes provider reports granted, or
+// requested ones when token response carries no scope. Both may be comma
+// separated.
+func grantedScopes(tk *oauth2.Token, requested []string) []string {
+	scope := extraString(tk, "scope")
+
+	if scope == "" {
+		scope = strings.Join(requested, " ")
+	}
+
+	return strings.FieldsFunc(scope, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+}
+
+// covers function reports whether granted scopes include every requested
+// one. Grant of unknown scopes covers nothing.
+func covers(granted []string, requested []string) bool {
+	set := make(map[string]struct{}, len(granted))
+
+	for _, scope := range granted {
+		set[scope] = struct{}{}
+	}
+
+	for _, scope := range requested {
+		if _, ok := set[scope]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func tokenCacheKey(userID string, service string, account string) string {
+	if account == "" {
+		return cacheKeyPrefix + userID + ":" + service
+	}
+
+	return cacheKeyPrefix + userID + ":" + service + ":" + account
+}
+
+// labelsJSON function returns labels as stored, empty object for none.
+func labelsJSON(labels map[string]string) []byte {
+	if len(labels) == 0 {
+		return []byte("{}")
+	}
+
+	data, _ := json.Marshal(labels)
+
+	return data
+}
+
+func hashAccessToken(accessToken string) string {
+	if accessToken == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(accessToken))
+
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+func extraString(tk *oauth2.Token, key string) string {
+	if v, ok := tk.Extra(key).(string); ok {
+		return v
+	}
+
+	return ""
+}
+
+// extraMap function returns token response fields kept with token of
+// service.
+func extraMap(tk *oauth2.Token, service string) map[string]interface{} {
+	extra := make(map[string]interface{})
+
+	for _, key := range append(commonExtraFields, extraFields[service]...) {
+		if v := tk.Extra(key); v != nil {
+			extra[key] = v
+		}
+	}
+
+	return extra
 }
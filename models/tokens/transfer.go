@@ -0,0 +1,427 @@
+package tokens
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zetkolink/auth/audit"
+	"github.com/Zetkolink/auth/dblog"
+	"github.com/lib/pq"
+)
+
+const (
+	archiveVersion   = 1
+	transferBatch    = 500
+	maxArchiveLine   = 1 << 20
+	archiveSeparator = '\n'
+)
+
+var (
+	// ErrTransferDisabled no transfer key is configured.
+	ErrTransferDisabled = errors.New("token transfer key not configured")
+
+	// ErrArchive token archive is malformed, truncated or sealed with
+	// unknown transfer key.
+	ErrArchive = errors.New("token archive malformed or truncated")
+)
+
+// TransferResult type represents outcome of token archive import. Tokens
+// stored here more recently than archived are skipped.
+type TransferResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// archiveLine type represents single line of token archive: version
+// header first, then tokens, then count trailer, each sealed with
+// transfer key.
+type archiveLine struct {
+	Version int            `json:"version,omitempty"`
+	Token   *archivedToken `json:"token,omitempty"`
+	Count   *int           `json:"count,omitempty"`
+}
+
+// archivedToken type represents token row with secrets in plain text,
+// hashed access tokens as they are stored.
+type archivedToken struct {
+	UserID       int             `json:"user_id"`
+	Service      string          `json:"service"`
+	Account      string          `json:"account,omitempty"`
+	TokenType    string          `json:"token_type"`
+	AccessToken  string          `json:"access_token"`
+	RefreshToken string          `json:"refresh_token"`
+	Expiry       time.Time       `json:"expiry"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	InstanceURL  string          `json:"instance_url"`
+	Extra        json.RawMessage `json:"extra"`
+	Identity     json.RawMessage `json:"identity"`
+	Scopes       []string        `json:"scopes"`
+	Metadata     json.RawMessage `json:"metadata"`
+	Labels       json.RawMessage `json:"labels,omitempty"`
+	AppID        string          `json:"app_id"`
+	Status       string          `json:"status"`
+	Fingerprint  string          `json:"access_token_fingerprint"`
+	Region       string          `json:"region"`
+}
+
+// TransferEnabled method reports whether tokens can be exported and
+// imported.
+func (m *Model) TransferEnabled() bool {
+	return m.transfer.Enabled()
+}
+
+// Export method writes every stored token, refresh token included, to w
+// as archive sealed with transfer key, and returns number of tokens
+// written.
+func (m *Model) Export(ctx context.Context, w io.Writer) (int, error) {
+	if !m.TransferEnabled() {
+		return 0, ErrTransferDisabled
+	}
+
+	err := m.writeArchiveLine(w, archiveLine{Version: archiveVersion})
+
+	if err != nil {
+		return 0, err
+	}
+
+	var userID, count int
+	var service, account string
+
+	for {
+		batch, err := m.archiveBatch(ctx, userID, service, account)
+
+		if err != nil {
+			return count, err
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, token := range batch {
+			err = m.writeArchiveLine(w, archiveLine{Token: token})
+
+			if err != nil {
+				return count, err
+			}
+
+			count++
+		}
+
+		last := batch[len(batch)-1]
+		userID, service, account = last.UserID, last.Service, last.Account
+	}
+
+	err = m.writeArchiveLine(w, archiveLine{Count: &count})
+
+	if err != nil {
+		return count, err
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionTokensExported,
+		Severity: audit.SeverityHigh,
+	})
+
+	return count, nil
+}
+
+// Import method stores tokens of archive read from r, written by Export
+// of this or other instance, in single transaction. Archive not read to
+// its trailer imports nothing.
+func (m *Model) Import(ctx context.Context, r io.Reader) (*TransferResult, error) {
+	if !m.TransferEnabled() {
+		return nil, ErrTransferDisabled
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	br := bufio.NewReader(r)
+	res := &TransferResult{}
+	var keys []Key
+	read := 0
+
+	for {
+		line, err := m.readArchiveLine(br)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if read == 0 {
+			if line.Version != archiveVersion {
+				return nil, ErrArchive
+			}
+
+			read++
+			continue
+		}
+
+		read++
+
+		if line.Count != nil {
+			if *line.Count != res.Imported+res.Skipped {
+				return nil, ErrArchive
+			}
+
+			break
+		}
+
+		if line.Token == nil {
+			return nil, ErrArchive
+		}
+
+		imported, err := m.importToken(ctx, tx, line.Token)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if imported {
+			res.Imported++
+			keys = append(keys, Key{
+				UserID:  line.Token.UserID,
+				Service: line.Token.Service,
+				Account: line.Token.Account,
+			})
+		} else {
+			res.Skipped++
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.cache != nil {
+		for _, key := range keys {
+			_ = m.cache.Delete(ctx, tokenCacheKey(strconv.Itoa(key.UserID),
+				key.Service, key.Account))
+		}
+	}
+
+	_ = m.audit.Write(ctx, audit.Entry{
+		Action:   audit.ActionTokensImported,
+		Severity: audit.SeverityHigh,
+	})
+
+	return res, nil
+}
+
+// archiveBatch method returns next batch of tokens after given key, in
+// key order, with secrets opened.
+func (m *Model) archiveBatch(ctx context.Context, userID int, service string, account string) ([]*archivedToken, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT "user_id", "service",
+										"token_type", "access_token",
+										"refresh_token", "expiry",
+										"created_at", "updated_at",
+										"instance_url", "extra",
+										"identity", "scopes", "metadata",
+										"app_id", "status",
+										"access_token_fingerprint", "region",
+										"account", "labels"
+									     FROM auth.tokens
+								WHERE (user_id, service, account) > ($1, $2, $3)
+								ORDER BY user_id, service, account
+								LIMIT $4`,
+		userID, service, account, transferBatch,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	batch := make([]*archivedToken, 0, transferBatch)
+
+	for rows.Next() {
+		var t archivedToken
+		var extra, identity, metadata, labels []byte
+
+		err = rows.Scan(&t.UserID, &t.Service, &t.TokenType,
+			&t.AccessToken, &t.RefreshToken, &t.Expiry, &t.CreatedAt,
+			&t.UpdatedAt, &t.InstanceURL, &extra, &identity,
+			pq.Array(&t.Scopes), &metadata, &t.AppID, &t.Status,
+			&t.Fingerprint, &t.Region, &t.Account, &labels)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.Extra, t.Identity, t.Metadata = extra, identity, metadata
+		t.Labels = labels
+
+		if !strings.HasPrefix(t.AccessToken, hashPrefix) {
+			t.AccessToken, err = m.keyring.Open(t.AccessToken)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		t.RefreshToken, err = m.keyring.Open(t.RefreshToken)
+
+		if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, &t)
+	}
+
+	return batch, rows.Err()
+}
+
+// importToken method stores archived token sealed as this instance
+// stores tokens, unless stored one is as recent. It reports whether
+// token was stored.
+func (m *Model) importToken(ctx context.Context, tx *sql.Tx, t *archivedToken) (bool, error) {
+	accessToken := t.AccessToken
+
+	if !strings.HasPrefix(accessToken, hashPrefix) {
+		var err error
+
+		if m.accessTokenMode == AccessTokenHash {
+			accessToken = hashAccessToken(accessToken)
+		} else {
+			accessToken, err = m.keyring.Seal(accessToken)
+		}
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	refreshToken, err := m.keyring.Seal(t.RefreshToken)
+
+	if err != nil {
+		return false, err
+	}
+
+	var identity interface{}
+
+	if len(t.Identity) > 0 && string(t.Identity) != "null" {
+		identity = []byte(t.Identity)
+	}
+
+	// Archives written before labels carry none.
+	labels := []byte(t.Labels)
+
+	if len(labels) == 0 {
+		labels = []byte("{}")
+	}
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO auth.tokens
+									( "user_id", "service", "token_type",
+									"access_token", "refresh_token",
+									"expiry", "created_at", "updated_at",
+									"instance_url", "extra", "identity",
+									"scopes", "metadata", "app_id",
+									"status", "access_token_fingerprint",
+									"region", "account", "labels" )
+								VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9,
+									$10, $11, $12, $13, $14, $15, $16, $17, $18,
+									$19)
+								ON CONFLICT (user_id, service, account) DO UPDATE
+								SET token_type = excluded.token_type,
+								access_token = excluded.access_token,
+								refresh_token = excluded.refresh_token,
+								expiry = excluded.expiry,
+								created_at = excluded.created_at,
+								updated_at = excluded.updated_at,
+								instance_url = excluded.instance_url,
+								extra = excluded.extra,
+								identity = excluded.identity,
+								scopes = excluded.scopes,
+								metadata = excluded.metadata,
+								labels = excluded.labels,
+								app_id = excluded.app_id,
+								status = excluded.status,
+								status_changed_at = CASE WHEN auth.tokens.status <> excluded.status
+									THEN now() ELSE auth.tokens.status_changed_at END,
+								access_token_fingerprint = excluded.access_token_fingerprint,
+								region = excluded.region,
+								refresh_failures = 0,
+								refresh_error = '',
+								refresh_retry_at = NULL
+								WHERE auth.tokens.updated_at < excluded.updated_at`,
+		t.UserID, t.Service, t.TokenType, dblog.Secret(accessToken),
+		dblog.Secret(refreshToken), t.Expiry, t.CreatedAt, t.UpdatedAt,
+		t.InstanceURL, []byte(t.Extra), identity, pq.Array(t.Scopes),
+		[]byte(t.Metadata), t.AppID, t.Status, t.Fingerprint, t.Region,
+		t.Account, labels,
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+func (m *Model) writeArchiveLine(w io.Writer, line archiveLine) error {
+	data, err := json.Marshal(line)
+
+	if err != nil {
+		return err
+	}
+
+	sealed, err := m.transfer.Seal(string(data))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, sealed+string(archiveSeparator))
+
+	return err
+}
+
+// readArchiveLine method reads and opens next line of archive. Archive
+// ending before trailer is truncated.
+func (m *Model) readArchiveLine(br *bufio.Reader) (*archiveLine, error) {
+	data, err := br.ReadString(archiveSeparator)
+
+	if err == io.EOF || len(data) > maxArchiveLine {
+		return nil, ErrArchive
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	data = strings.TrimSuffix(data, string(archiveSeparator))
+	plain, err := m.transfer.Open(data)
+
+	// Keyring opens unsealed values as they are, and archive lines are
+	// always sealed.
+	if err != nil || plain == "" || plain == data {
+		return nil, ErrArchive
+	}
+
+	var line archiveLine
+
+	if json.Unmarshal([]byte(plain), &line) != nil {
+		return nil, ErrArchive
+	}
+
+	return &line, nil
+}
@@ -0,0 +1,57 @@
+package tokens
+
+import (
+	"context"
+	"time"
+)
+
+// ServiceUsage type represents how tokens of service are used. Idle counts
+// tokens not read within idle window, NeverUsed tokens never read at all.
+type ServiceUsage struct {
+	Service    string     `json:"service"`
+	Tokens     int        `json:"tokens"`
+	Uses       int64      `json:"uses"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Idle       int        `json:"idle"`
+	NeverUsed  int        `json:"never_used"`
+}
+
+// UsageStats method returns usage of tokens by service, ordered by
+// service. Tokens are idle when not read for idle, counted from creation
+// for tokens never read.
+func (m *Model) UsageStats(ctx context.Context, idle time.Duration) ([]*ServiceUsage, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT service, count(*),
+									COALESCE(sum(use_count), 0), max(last_used_at),
+									count(*) FILTER (
+										WHERE COALESCE(last_used_at, created_at) < $1
+									),
+									count(*) FILTER (WHERE last_used_at IS NULL)
+									     FROM auth.tokens
+								GROUP BY service
+								ORDER BY service`,
+		time.Now().Add(-idle),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	list := make([]*ServiceUsage, 0)
+
+	for rows.Next() {
+		var u ServiceUsage
+
+		err = rows.Scan(&u.Service, &u.Tokens, &u.Uses, &u.LastUsedAt,
+			&u.Idle, &u.NeverUsed)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, &u)
+	}
+
+	return list, rows.Err()
+}
@@ -0,0 +1,118 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Local type represents store keeping objects in local directory.
+// Downloads are served by the application from HMAC signed URLs.
+type Local struct {
+	dir     string
+	baseURL string
+	signer  signer
+}
+
+// NewLocal method creates new local store instance.
+func NewLocal(config Config) (*Local, error) {
+	if config.SigningKey == "" {
+		return nil, ErrSigningKey
+	}
+
+	err := os.MkdirAll(config.Dir, 0700)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{
+		dir:     config.Dir,
+		baseURL: strings.TrimSuffix(config.BaseURL, "/"),
+		signer:  signer{key: []byte(config.SigningKey)},
+	}, nil
+}
+
+// Put method writes object to temporary file and renames it in place.
+func (l *Local) Put(_ context.Context, key string, r io.Reader, _ int64) error {
+	name := l.path(key)
+	err := os.MkdirAll(filepath.Dir(name), 0700)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Open method opens object for reading.
+func (l *Local) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+// Delete method removes object.
+func (l *Local) Delete(_ context.Context, key string) error {
+	err := os.Remove(l.path(key))
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// URL method returns signed download URL.
+func (l *Local) URL(key string, ttl time.Duration) (string, error) {
+	expires := time.Now().Add(ttl).Unix()
+
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", l.signer.sign(key, expires))
+
+	u := url.URL{Path: l.baseURL + "/" + key, RawQuery: q.Encode()}
+
+	return u.String(), nil
+}
+
+// Verify method checks download URL signature and expiry.
+func (l *Local) Verify(key, expires, signature string) error {
+	return l.signer.verify(key, expires, signature)
+}
+
+// path keeps keys inside store directory.
+func (l *Local) path(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(path.Clean("/"+key)))
+}
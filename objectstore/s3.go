@@ -0,0 +1,265 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3DateFormat     = "20060102T150405Z"
+	s3DefaultRegion  = "us-east-1"
+	s3RequestTimeout = 60 * time.Second
+	s3MaxPresignTTL  = 7 * 24 * time.Hour
+)
+
+// S3 type represents store keeping objects in S3-compatible bucket.
+// Objects are addressed path style.
+type S3 struct {
+	endpoint *url.URL
+	config   Config
+	client   *http.Client
+}
+
+// NewS3 method creates new S3-compatible store instance.
+func NewS3(config Config) (*S3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Host == "" || config.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket required")
+	}
+
+	if config.Region == "" {
+		config.Region = s3DefaultRegion
+	}
+
+	return &S3{
+		endpoint: endpoint,
+		config:   config,
+		client:   &http.Client{Timeout: s3RequestTimeout},
+	}, nil
+}
+
+// Put method uploads object.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	req, err := s.request(ctx, http.MethodPut, key, r)
+
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+
+	_, err = s.do(req)
+
+	return err
+}
+
+// Open method downloads object.
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.do(req)
+}
+
+// Delete method removes object.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := s.do(req)
+
+	if err == ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return body.Close()
+}
+
+// URL method returns presigned GET URL.
+func (s *S3) URL(key string, ttl time.Duration) (string, error) {
+	if ttl > s3MaxPresignTTL {
+		ttl = s3MaxPresignTTL
+	}
+
+	now := time.Now().UTC()
+	u := s.objectURL(key)
+
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", s3Algorithm)
+	q.Set("X-Amz-Credential", s.config.AccessKey+"/"+s.scope(now))
+	q.Set("X-Amz-Date", now.Format(s3DateFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery(q),
+		"host:" + u.Host + "\n",
+		"host",
+		s3UnsignedBody,
+	}, "\n")
+
+	q.Set("X-Amz-Signature", s.signature(now, canonical))
+	u.RawQuery = canonicalQuery(q)
+
+	return u.String(), nil
+}
+
+// Verify method reports bucket serves downloads.
+func (s *S3) Verify(_, _, _ string) error {
+	return ErrUnsigned
+}
+
+func (s *S3) request(ctx context.Context, method, key string,
+	body io.Reader) (*http.Request, error) {
+
+	now := time.Now().UTC()
+	u := s.objectURL(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	date := now.Format(s3DateFormat)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedBody)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		"",
+		"host:" + u.Host + "\n" +
+			"x-amz-content-sha256:" + s3UnsignedBody + "\n" +
+			"x-amz-date:" + date + "\n",
+		signed,
+		s3UnsignedBody,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.config.AccessKey, s.scope(now), signed,
+		s.signature(now, canonical),
+	))
+
+	return req, nil
+}
+
+func (s *S3) do(req *http.Request) (io.ReadCloser, error) {
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		_ = resp.Body.Close()
+
+		return nil, fmt.Errorf("s3: %s %s: %d %s",
+			req.Method, req.URL.Path, resp.StatusCode, msg)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket +
+		"/" + s.config.Prefix + key
+	u.RawPath = ""
+	u.RawQuery = ""
+
+	return &u
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+}
+
+// signature method derives SigV4 signing key and signs canonical request.
+func (s *S3) signature(t time.Time, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+
+	toSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format(s3DateFormat),
+		s.scope(t),
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key with RFC 3986 escaping.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+
+	for k := range q {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
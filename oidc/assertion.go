@@ -0,0 +1,255 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ClientAssertionType is the RFC 7523 client assertion type.
+	ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	assertionTTL = 5 * time.Minute
+)
+
+// ErrSigningKey client assertion signing key is invalid.
+var ErrSigningKey = errors.New("invalid client assertion signing key")
+
+// Assertion type represents private_key_jwt client credentials (RFC 7523).
+type Assertion struct {
+	ClientID string
+	Audience string
+	KeyID    string
+	key      crypto.Signer
+	alg      string
+}
+
+type assertionClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Audience string `json:"aud"`
+	ID       string `json:"jti"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+}
+
+// NewAssertion method parses PEM encoded RSA or EC private key. RSA keys
+// sign with RS256, P-256, P-384 and P-521 keys with matching ES algorithm.
+func NewAssertion(clientID string, audience string, keyID string, signingKey string) (*Assertion, error) {
+	key, alg, err := ParseSigningKey(signingKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Assertion{
+		ClientID: clientID,
+		Audience: audience,
+		KeyID:    keyID,
+		key:      key,
+		alg:      alg,
+	}, nil
+}
+
+// ParseSigningKey method parses PEM encoded private key and returns
+// signing algorithm it is used with.
+func ParseSigningKey(signingKey string) (crypto.Signer, string, error) {
+	block, _ := pem.Decode([]byte(signingKey))
+
+	if block == nil {
+		return nil, "", ErrSigningKey
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, "", ErrSigningKey
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, "RS256", nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return key, "ES256", nil
+		case elliptic.P384():
+			return key, "ES384", nil
+		case elliptic.P521():
+			return key, "ES512", nil
+		}
+	}
+
+	return nil, "", ErrSigningKey
+}
+
+// Sign method returns fresh signed client assertion.
+func (a *Assertion) Sign() (string, error) {
+	jti := make([]byte, 16)
+
+	_, err := io.ReadFull(rand.Reader, jti)
+
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	head, err := json.Marshal(header{Alg: a.alg, Kid: a.KeyID})
+
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(assertionClaims{
+		Issuer:   a.ClientID,
+		Subject:  a.ClientID,
+		Audience: a.Audience,
+		ID:       hex.EncodeToString(jti),
+		IssuedAt: now.Unix(),
+		Expiry:   now.Add(assertionTTL).Unix(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(head) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := hashes[a.alg]
+	h := hash.New()
+	h.Write([]byte(signed))
+
+	signature, err := a.key.Sign(rand.Reader, h.Sum(nil), hash)
+
+	if err != nil {
+		return "", err
+	}
+
+	if key, ok := a.key.(*ecdsa.PrivateKey); ok {
+		signature, err = joseSignature(key, signature)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Client method returns HTTP client adding client assertion to every
+// form posted through it, so token, revocation and introspection
+// requests authenticate without client secret.
+func (a *Assertion) Client(base *http.Client) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+
+	transport := base.Transport
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	client := *base
+	client.Transport = &assertionTransport{assertion: a, base: transport}
+
+	return &client
+}
+
+type assertionTransport struct {
+	assertion *Assertion
+	base      http.RoundTripper
+}
+
+func (t *assertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"),
+		"application/x-www-form-urlencoded") {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := url.ParseQuery(string(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, err := t.assertion.Sign()
+
+	if err != nil {
+		return nil, err
+	}
+
+	form.Set("client_id", t.assertion.ClientID)
+	form.Set("client_assertion_type", ClientAssertionType)
+	form.Set("client_assertion", assertion)
+	form.Del("client_secret")
+
+	encoded := []byte(form.Encode())
+
+	r := req.Clone(req.Context())
+	r.Header.Del("Authorization")
+	r.Body = io.NopCloser(bytes.NewReader(encoded))
+	r.ContentLength = int64(len(encoded))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(encoded)), nil
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// joseSignature function converts ASN.1 ECDSA signature into fixed size
+// r || s form JWS expects.
+func joseSignature(key *ecdsa.PrivateKey, der []byte) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+
+	_, err := asn1.Unmarshal(der, &sig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+
+	return out, nil
+}
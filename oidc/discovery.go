@@ -0,0 +1,148 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/jwks"
+)
+
+const (
+	// GrantTypeTokenExchange is the RFC 8693 token exchange grant type.
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	discoveryPath = "/.well-known/openid-configuration"
+
+	defaultTTL = time.Hour
+)
+
+var (
+	// ErrIssuer discovered issuer differs from configured one.
+	ErrIssuer = errors.New("oidc issuer mismatch")
+)
+
+// Configuration type represents OpenID provider metadata.
+type Configuration struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	UserinfoEndpoint              string   `json:"userinfo_endpoint"`
+	JwksURI                       string   `json:"jwks_uri"`
+	RevocationEndpoint            string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint         string   `json:"introspection_endpoint"`
+	ScopesSupported               []string `json:"scopes_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	GrantTypesSupported           []string `json:"grant_types_supported"`
+}
+
+// DiscoveryConfig type represents discovery client configuration.
+// Keys defaults to cache of its own sharing Client.
+type DiscoveryConfig struct {
+	Client *http.Client
+	TTL    time.Duration
+	Keys   *jwks.Cache
+}
+
+// Discovery type represents caching discovery document client.
+type Discovery struct {
+	client *http.Client
+	ttl    time.Duration
+	mu     sync.Mutex
+	cache  map[string]*entry
+	keys   *jwks.Cache
+}
+
+type entry struct {
+	conf    *Configuration
+	expires time.Time
+}
+
+// NewDiscovery method creates new discovery client instance.
+func NewDiscovery(config DiscoveryConfig) *Discovery {
+	d := &Discovery{
+		client: config.Client,
+		ttl:    config.TTL,
+		cache:  make(map[string]*entry),
+		keys:   config.Keys,
+	}
+
+	if d.client == nil {
+		d.client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if d.keys == nil {
+		d.keys = jwks.NewCache(jwks.Config{}, d.client)
+	}
+
+	if d.ttl <= 0 {
+		d.ttl = defaultTTL
+	}
+
+	return d
+}
+
+// Get method returns provider metadata for issuer, cached for TTL.
+func (d *Discovery) Get(ctx context.Context, issuer string) (*Configuration, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	d.mu.Lock()
+	e, ok := d.cache[issuer]
+	d.mu.Unlock()
+
+	if ok && time.Now().Before(e.expires) {
+		return e.conf, nil
+	}
+
+	conf, err := d.fetch(ctx, issuer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.cache[issuer] = &entry{conf: conf, expires: time.Now().Add(d.ttl)}
+	d.mu.Unlock()
+
+	return conf, nil
+}
+
+func (d *Discovery) fetch(ctx context.Context, issuer string) (*Configuration, error) {
+	req, err := http.NewRequest(http.MethodGet, issuer+discoveryPath, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery %s: status %d",
+			issuer, resp.StatusCode)
+	}
+
+	var conf Configuration
+
+	err = json.NewDecoder(resp.Body).Decode(&conf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(conf.Issuer, "/") != issuer {
+		return nil, ErrIssuer
+	}
+
+	return &conf, nil
+}
@@ -0,0 +1,220 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const clockSkew = time.Minute
+
+var (
+	// ErrIDToken id_token is malformed.
+	ErrIDToken = errors.New("malformed id_token")
+
+	// ErrSignature id_token signature is invalid.
+	ErrSignature = errors.New("invalid id_token signature")
+
+	// ErrAlgorithm id_token is signed with unsupported algorithm.
+	ErrAlgorithm = errors.New("unsupported id_token algorithm")
+
+	// ErrAudience id_token is issued to another client.
+	ErrAudience = errors.New("id_token audience mismatch")
+
+	// ErrExpired id_token expired.
+	ErrExpired = errors.New("id_token expired")
+
+	// ErrNonce id_token nonce mismatch.
+	ErrNonce = errors.New("id_token nonce mismatch")
+
+	hashes = map[string]crypto.Hash{
+		"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+		"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	}
+)
+
+// Claims type represents id_token claims.
+type Claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	IssuedAt int64    `json:"iat"`
+	Nonce    string   `json:"nonce"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+}
+
+type audience []string
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// VerifyIDToken method checks id_token signature against issuer JWKS and
+// validates iss, aud, exp and, when expected nonce is given, nonce.
+func (d *Discovery) VerifyIDToken(ctx context.Context, issuer string, clientID string, raw string, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+
+	if len(parts) != 3 {
+		return nil, ErrIDToken
+	}
+
+	var h header
+
+	err := decodeSegment(parts[0], &h)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hash, ok := hashes[h.Alg]
+
+	if !ok {
+		return nil, ErrAlgorithm
+	}
+
+	meta, err := d.Get(ctx, issuer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := d.keys.Key(ctx, meta.JwksURI, h.Kid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, ErrIDToken
+	}
+
+	err = verify(key, h.Alg, hash, []byte(parts[0]+"."+parts[1]), signature)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+
+	err = decodeSegment(parts[1], &claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, ErrIssuer
+	}
+
+	if !claims.Audience.contains(clientID) {
+		return nil, ErrAudience
+	}
+
+	if time.Unix(claims.Expiry, 0).Add(clockSkew).Before(time.Now()) {
+		return nil, ErrExpired
+	}
+
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, ErrNonce
+	}
+
+	return &claims, nil
+}
+
+func verify(key crypto.PublicKey, alg string, hash crypto.Hash, signed []byte, signature []byte) error {
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return ErrAlgorithm
+		}
+
+		if rsa.VerifyPKCS1v15(key, hash, digest, signature) != nil {
+			return ErrSignature
+		}
+
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return ErrAlgorithm
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8
+
+		if len(signature) != 2*size {
+			return ErrSignature
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(key, digest, r, s) {
+			return ErrSignature
+		}
+
+		return nil
+	}
+
+	return ErrAlgorithm
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return ErrIDToken
+	}
+
+	if json.Unmarshal(b, v) != nil {
+		return ErrIDToken
+	}
+
+	return nil
+}
+
+// UnmarshalJSON method accepts aud as string or array.
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+
+	err := json.Unmarshal(b, &list)
+
+	if err != nil {
+		return err
+	}
+
+	*a = list
+
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,291 @@
+// Package operations runs long admin actions as background operations.
+//
+// Features register handler for their operation kind and submit
+// operations instead of inventing own job tables. Operations are queued
+// in the operations table and claimed by a Runner polling it, so any
+// instance may run operation submitted on another. Handlers report
+// progress through Progress, which is stored periodically; clients poll
+// /admin/operations/{id} for status, progress percentage and item
+// errors, and may cancel running operation there. Cancel is noticed on
+// next progress report and cancels handler context.
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	opsmodel "github.com/Zetkolink/auth/models/operations"
+)
+
+const (
+	defaultPollInterval   = 5
+	defaultReportInterval = 2
+	defaultStaleAfter     = 300
+)
+
+var (
+	// ErrUnknownKind operation of kind no handler is registered for.
+	ErrUnknownKind = errors.New("unknown operation kind")
+)
+
+// Handler runs operation with params, reporting progress to p. It
+// should stop when ctx is cancelled.
+type Handler func(ctx context.Context, params json.RawMessage, p *Progress) error
+
+// Config type represents operation runner configuration. Durations are
+// in seconds; operation not reporting progress for StaleAfter is claimed
+// again.
+type Config struct {
+	PollInterval   int `yaml:"pollInterval"`
+	ReportInterval int `yaml:"reportInterval"`
+	StaleAfter     int `yaml:"staleAfter"`
+}
+
+// Runner type represents background operation worker.
+type Runner struct {
+	config   Config
+	model    *opsmodel.Model
+	handlers map[string]Handler
+	quit     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// Progress type represents progress of running operation.
+type Progress struct {
+	mu         sync.Mutex
+	total      int
+	processed  int
+	failed     int
+	itemErrors []opsmodel.ItemError
+}
+
+// NewRunner method creates new runner instance.
+func NewRunner(config Config, model *opsmodel.Model) *Runner {
+	setDefault(&config.PollInterval, defaultPollInterval)
+	setDefault(&config.ReportInterval, defaultReportInterval)
+	setDefault(&config.StaleAfter, defaultStaleAfter)
+
+	return &Runner{
+		config:   config,
+		model:    model,
+		handlers: make(map[string]Handler),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Register method sets handler of operation kind. It must be called
+// before Start.
+func (r *Runner) Register(kind string, handler Handler) {
+	r.handlers[kind] = handler
+}
+
+// Submit method queues operation of registered kind.
+func (r *Runner) Submit(ctx context.Context, kind string, params interface{}) (*opsmodel.Operation, error) {
+	if _, ok := r.handlers[kind]; !ok {
+		return nil, ErrUnknownKind
+	}
+
+	raw, err := json.Marshal(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if string(raw) == "null" {
+		raw = nil
+	}
+
+	return r.model.Create(ctx, kind, raw)
+}
+
+// Start method runs operations as they are queued.
+func (r *Runner) Start() {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(r.config.PollInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+				r.drain(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop method stops runner. Running operation is interrupted and
+// resumed by next claim after StaleAfter.
+func (r *Runner) Stop() {
+	r.once.Do(func() { close(r.quit) })
+	r.wg.Wait()
+}
+
+// drain method runs queued operations until none is left or runner
+// stops.
+func (r *Runner) drain(ctx context.Context) {
+	staleAfter := time.Duration(r.config.StaleAfter) * time.Second
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+
+		op, err := r.model.Claim(ctx, staleAfter)
+
+		if err != nil {
+			log.Println("operations: " + err.Error())
+			return
+		}
+
+		if op == nil {
+			return
+		}
+
+		status, reason := r.run(ctx, op)
+
+		if status == "" {
+			return
+		}
+
+		err = r.model.Finish(ctx, op.ID, status, reason)
+
+		if err != nil {
+			log.Println("operations: " + err.Error())
+		}
+	}
+}
+
+// run method runs operation handler while reporting its progress, and
+// returns final status. Status is empty when runner stopped meanwhile,
+// leaving operation running to be claimed again.
+func (r *Runner) run(ctx context.Context, op *opsmodel.Operation) (string, string) {
+	handler, ok := r.handlers[op.Kind]
+
+	if !ok {
+		return opsmodel.StatusFailed, ErrUnknownKind.Error()
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p := &Progress{}
+	cancelled := false
+	done := make(chan struct{})
+	reported := make(chan struct{})
+
+	go func() {
+		defer close(reported)
+
+		ticker := time.NewTicker(time.Duration(r.config.ReportInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-r.quit:
+				cancel()
+				return
+			case <-ticker.C:
+				if r.report(ctx, op.ID, p) {
+					cancelled = true
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := handler(runCtx, op.Params, p)
+
+	close(done)
+	<-reported
+
+	r.report(ctx, op.ID, p)
+
+	select {
+	case <-r.quit:
+		return "", ""
+	default:
+	}
+
+	switch {
+	case cancelled:
+		return opsmodel.StatusCancelled, ""
+	case err != nil:
+		log.Printf("operations: %s %s: %s", op.Kind, op.ID, err)
+		return opsmodel.StatusFailed, err.Error()
+	}
+
+	return opsmodel.StatusDone, ""
+}
+
+// report method stores progress and returns whether cancel was
+// requested.
+func (r *Runner) report(ctx context.Context, id string, p *Progress) bool {
+	p.mu.Lock()
+	total, processed, failed := p.total, p.processed, p.failed
+	itemErrors := append([]opsmodel.ItemError{}, p.itemErrors...)
+	p.mu.Unlock()
+
+	cancel, err := r.model.Report(ctx, id, total, processed, failed,
+		itemErrors)
+
+	if err != nil {
+		log.Println("operations: " + err.Error())
+		return false
+	}
+
+	return cancel
+}
+
+// SetTotal method sets number of items operation handles.
+func (p *Progress) SetTotal(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total = total
+}
+
+// Advance method records n items handled.
+func (p *Progress) Advance(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.processed += n
+}
+
+// Fail method records item handled with error.
+func (p *Progress) Fail(item string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.processed++
+	p.failed++
+
+	if len(p.itemErrors) < opsmodel.MaxItemErrors {
+		p.itemErrors = append(p.itemErrors, opsmodel.ItemError{
+			Item:  item,
+			Error: err.Error(),
+		})
+	}
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}
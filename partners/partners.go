@@ -0,0 +1,378 @@
+// Package partners exposes limited slice of the API to external partners.
+//
+// Listeners marked partner in http config serve requests carrying known
+// API key only. Each key is assigned a profile that lists routes partner
+// may call, its request rate and response fields removed before response
+// leaves the service:
+//
+//	partners:
+//	  profiles:
+//	    - name: "readonly"
+//	      routes: ["GET /api/v1/tokens/*/*"]
+//	      rateLimit: 60
+//	      burst: 10
+//	      maskedFields: ["refresh_token", "identity.email"]
+//	  keys:
+//	    - partner: "acme"
+//	      profile: "readonly"
+//	      keyHash: "<hex sha256 of key>"
+//
+// Routes are "METHOD pattern" with path.Match patterns, * matching single
+// path segment. Masked fields are dotted paths into JSON objects, applied
+// to every element of arrays. Rate limits are per key and per instance.
+package partners
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/go-chi/render"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// KeyHeader is the request header carrying partner API key.
+	KeyHeader = "X-Api-Key"
+
+	defaultRateLimit = 60
+	defaultBurst     = 10
+)
+
+var (
+	// ErrUnknownProfile key is assigned profile missing from config.
+	ErrUnknownProfile = errors.New("unknown partner profile")
+
+	// ErrKeyHash key hash is not hex sha256.
+	ErrKeyHash = errors.New("partner key hash must be hex sha256")
+
+	// PartnerContextKey is context key for partner name.
+	PartnerContextKey = &contextKey{"partner"}
+
+	errKey = errors.New("missing or unknown API key")
+
+	errRoute = errors.New("route not available to partner")
+
+	errRate = errors.New("partner rate limit exceeded")
+
+	rejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metrics.Namespace + "_partner_rejected_total",
+			Help: "Total number of partner requests rejected, by reason.",
+		},
+		[]string{"partner", "reason"},
+	)
+)
+
+// Config type represents partner access configuration.
+type Config struct {
+	Profiles []Profile
+	Keys     []Key
+}
+
+// Profile type represents slice of API available to partner. RateLimit
+// is in requests per minute.
+type Profile struct {
+	Name         string
+	Routes       []string
+	RateLimit    int      `yaml:"rateLimit"`
+	Burst        int      `yaml:"burst"`
+	MaskedFields []string `yaml:"maskedFields"`
+}
+
+// Key type represents partner API key. Only hash of the key is
+// configured.
+type Key struct {
+	Partner string
+	Profile string
+	KeyHash string `yaml:"keyHash"`
+}
+
+// Gate type represents partner access middleware.
+type Gate struct {
+	keys []*key
+}
+
+type key struct {
+	partner string
+	hash    []byte
+	profile *profile
+	bucket  *bucket
+}
+
+type profile struct {
+	routes []route
+	masks  [][]string
+	rate   float64
+	burst  float64
+}
+
+type route struct {
+	method  string
+	pattern string
+}
+
+// bucket type represents token bucket refilled at profile rate.
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+type contextKey struct {
+	name string
+}
+
+func init() {
+	metrics.Registry.MustRegister(rejected)
+}
+
+// New method creates new gate instance.
+func New(config Config) (*Gate, error) {
+	profiles := make(map[string]*profile, len(config.Profiles))
+
+	for _, p := range config.Profiles {
+		rate := p.RateLimit
+
+		if rate <= 0 {
+			rate = defaultRateLimit
+		}
+
+		burst := p.Burst
+
+		if burst <= 0 {
+			burst = defaultBurst
+		}
+
+		compiled := &profile{
+			rate:  float64(rate) / 60,
+			burst: float64(burst),
+		}
+
+		for _, r := range p.Routes {
+			parts := strings.Fields(r)
+
+			if len(parts) != 2 {
+				return nil, errors.New("partner route must be \"METHOD pattern\": " + r)
+			}
+
+			if _, err := path.Match(parts[1], "/"); err != nil {
+				return nil, err
+			}
+
+			compiled.routes = append(compiled.routes, route{
+				method:  strings.ToUpper(parts[0]),
+				pattern: parts[1],
+			})
+		}
+
+		for _, field := range p.MaskedFields {
+			compiled.masks = append(compiled.masks, strings.Split(field, "."))
+		}
+
+		profiles[p.Name] = compiled
+	}
+
+	g := &Gate{}
+
+	for _, k := range config.Keys {
+		p, ok := profiles[k.Profile]
+
+		if !ok {
+			return nil, ErrUnknownProfile
+		}
+
+		hash, err := hex.DecodeString(k.KeyHash)
+
+		if err != nil || len(hash) != sha256.Size {
+			return nil, ErrKeyHash
+		}
+
+		g.keys = append(g.keys, &key{
+			partner: k.Partner,
+			hash:    hash,
+			profile: p,
+			bucket:  &bucket{tokens: p.burst, last: time.Now()},
+		})
+	}
+
+	return g, nil
+}
+
+// Middleware method admits requests of known keys to routes of their
+// profile within rate limit and masks responses.
+func (g *Gate) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			k := g.lookup(r.Header.Get(KeyHeader))
+
+			if k == nil {
+				rejected.WithLabelValues("", "key").Inc()
+				helpers.Unauthorized(w, r, errKey)
+				return
+			}
+
+			if !k.profile.allows(r.Method, r.URL.Path) {
+				rejected.WithLabelValues(k.partner, "route").Inc()
+				helpers.NotFound(w, r, errRoute)
+				return
+			}
+
+			if wait := k.bucket.take(k.profile, time.Now()); wait > 0 {
+				rejected.WithLabelValues(k.partner, "rate").Inc()
+				w.Header().Set("Retry-After",
+					strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				render.Render(w, r, helpers.NewErrorResponse(
+					http.StatusTooManyRequests, errRate))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), PartnerContextKey, k.partner)
+			r = r.WithContext(ctx)
+
+			if len(k.profile.masks) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			mw := &maskWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(mw, r)
+			mw.flush(k.profile.masks)
+		},
+	)
+}
+
+// GetPartner method returns partner of request, empty for internal
+// callers.
+func GetPartner(ctx context.Context) string {
+	if partner, ok := ctx.Value(PartnerContextKey).(string); ok {
+		return partner
+	}
+
+	return ""
+}
+
+// lookup method returns key matching presented one. Every key is
+// compared, so timing does not reveal which one matched.
+func (g *Gate) lookup(presented string) *key {
+	if presented == "" {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(presented))
+
+	var found *key
+
+	for _, k := range g.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			found = k
+		}
+	}
+
+	return found
+}
+
+func (p *profile) allows(method string, urlPath string) bool {
+	for _, r := range p.routes {
+		if r.method != method && r.method != "*" {
+			continue
+		}
+
+		if ok, _ := path.Match(r.pattern, urlPath); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// take method takes token from bucket. Returns zero when taken, or time
+// until next token otherwise.
+func (b *bucket) take(p *profile, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(p.burst, b.tokens+now.Sub(b.last).Seconds()*p.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / p.rate * float64(time.Second))
+}
+
+// maskWriter type represents response writer buffering body to remove
+// masked fields before it is sent.
+type maskWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (mw *maskWriter) WriteHeader(status int) {
+	mw.status = status
+}
+
+func (mw *maskWriter) Write(p []byte) (int, error) {
+	return mw.body.Write(p)
+}
+
+func (mw *maskWriter) flush(masks [][]string) {
+	body := mw.body.Bytes()
+
+	if strings.HasPrefix(mw.Header().Get("Content-Type"), "application/json") {
+		var v interface{}
+
+		if json.Unmarshal(body, &v) == nil {
+			for _, mask := range masks {
+				remove(v, mask)
+			}
+
+			if masked, err := json.Marshal(v); err == nil {
+				body = masked
+			}
+		}
+	}
+
+	mw.Header().Del("Content-Length")
+	mw.ResponseWriter.WriteHeader(mw.status)
+	_, _ = mw.ResponseWriter.Write(body)
+}
+
+// remove function deletes field at path from every object v holds.
+func remove(v interface{}, fieldPath []string) {
+	switch t := v.(type) {
+	case []interface{}:
+		for _, item := range t {
+			remove(item, fieldPath)
+		}
+	case map[string]interface{}:
+		if len(fieldPath) == 1 {
+			delete(t, fieldPath[0])
+			return
+		}
+
+		if child, ok := t[fieldPath[0]]; ok {
+			remove(child, fieldPath[1:])
+		}
+	}
+}
+
+func (k *contextKey) String() string {
+	return "partners context value " + k.name
+}
@@ -0,0 +1,106 @@
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	// ModePlain stores personal data as is.
+	ModePlain = "plain"
+
+	// ModeHash stores personal data only as salted hashes.
+	ModeHash = "hash"
+
+	hashPrefix = "hmac-sha256:"
+)
+
+var (
+	// ErrMode unknown minimization mode.
+	ErrMode = errors.New("unknown pii mode")
+
+	// ErrSalt salt is required for hash mode.
+	ErrSalt = errors.New("pii salt not specified")
+)
+
+// Config type represents minimizer configuration.
+type Config struct {
+	Mode    string
+	Salt    string
+	Tenants map[string]string
+}
+
+// Minimizer type represents PII minimizer.
+type Minimizer struct {
+	mode    string
+	salt    []byte
+	tenants map[string]string
+}
+
+// NewMinimizer method creates new minimizer instance.
+func NewMinimizer(config Config) (*Minimizer, error) {
+	m := &Minimizer{
+		mode:    config.Mode,
+		salt:    []byte(config.Salt),
+		tenants: make(map[string]string),
+	}
+
+	if m.mode == "" {
+		m.mode = ModePlain
+	}
+
+	m.tenants[""] = m.mode
+
+	for tenant, mode := range config.Tenants {
+		m.tenants[tenant] = mode
+	}
+
+	for _, mode := range m.tenants {
+		if mode != ModePlain && mode != ModeHash {
+			return nil, ErrMode
+		}
+
+		if mode == ModeHash && len(m.salt) == 0 {
+			return nil, ErrSalt
+		}
+	}
+
+	return m, nil
+}
+
+// Mode method returns minimization mode for tenant.
+func (m *Minimizer) Mode(tenant string) string {
+	if mode, ok := m.tenants[tenant]; ok {
+		return mode
+	}
+
+	return m.mode
+}
+
+// Protect method returns value in the form it must be stored for tenant.
+func (m *Minimizer) Protect(tenant string, value string) string {
+	if value == "" || m.Mode(tenant) != ModeHash {
+		return value
+	}
+
+	return m.hash(value)
+}
+
+// Match method checks whether stored value corresponds to plain value.
+func (m *Minimizer) Match(stored string, value string) bool {
+	if strings.HasPrefix(stored, hashPrefix) {
+		return hmac.Equal([]byte(stored), []byte(m.hash(value)))
+	}
+
+	return stored == value
+}
+
+func (m *Minimizer) hash(value string) string {
+	mac := hmac.New(sha256.New, m.salt)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+
+	return hashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,134 @@
+// Package planguard keeps ad-hoc filtered queries off full table scans.
+// Filter combinations are first matched against known indexes, then the
+// planner estimate of the query is checked, so a combination that is
+// indexed in principle but still scans too much is rejected as well.
+package planguard
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	defaultMaxScanRows = 100000
+
+	nodeSeqScan = "Seq Scan"
+)
+
+var (
+	// ErrUnindexed filter combination is not served by any index.
+	ErrUnindexed = errors.New("filter combination is not indexed")
+
+	// ErrFullScan query plan scans too many rows.
+	ErrFullScan = errors.New("query would scan too many rows")
+)
+
+// Config type represents guard configuration.
+type Config struct {
+	MaxScanRows int `yaml:"maxScanRows"`
+}
+
+// Index type represents index columns in order.
+type Index []string
+
+// Guard type represents query plan guard.
+type Guard struct {
+	db          *sql.DB
+	maxScanRows float64
+}
+
+type plan struct {
+	NodeType string  `json:"Node Type"`
+	Relation string  `json:"Relation Name"`
+	Rows     float64 `json:"Plan Rows"`
+	Plans    []plan  `json:"Plans"`
+}
+
+// New method creates new guard instance.
+func New(config Config, db *sql.DB) *Guard {
+	if config.MaxScanRows <= 0 {
+		config.MaxScanRows = defaultMaxScanRows
+	}
+
+	return &Guard{
+		db:          db,
+		maxScanRows: float64(config.MaxScanRows),
+	}
+}
+
+// Covered method reports whether filters include leading column of some
+// index.
+func Covered(indexes []Index, filters []string) bool {
+	set := make(map[string]struct{}, len(filters))
+
+	for _, f := range filters {
+		set[f] = struct{}{}
+	}
+
+	for _, index := range indexes {
+		if len(index) == 0 {
+			continue
+		}
+
+		if _, ok := set[index[0]]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check method validates filters against indexes and rejects query whose
+// plan sequentially scans more rows than allowed. Nil guard allows all.
+func (g *Guard) Check(ctx context.Context, indexes []Index, filters []string, query string, args ...interface{}) error {
+	if g == nil {
+		return nil
+	}
+
+	if !Covered(indexes, filters) {
+		return ErrUnindexed
+	}
+
+	var raw []byte
+
+	err := g.db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query,
+		args...).Scan(&raw)
+
+	if err != nil {
+		return err
+	}
+
+	var explained []struct {
+		Plan plan `json:"Plan"`
+	}
+
+	err = json.Unmarshal(raw, &explained)
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range explained {
+		if g.scans(&e.Plan) {
+			return ErrFullScan
+		}
+	}
+
+	return nil
+}
+
+func (g *Guard) scans(p *plan) bool {
+	if p.NodeType == nodeSeqScan && p.Rows > g.maxScanRows {
+		return true
+	}
+
+	for i := range p.Plans {
+		if g.scans(&p.Plans[i]) {
+			return true
+		}
+	}
+
+	return false
+}
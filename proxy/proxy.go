@@ -0,0 +1,284 @@
+// Package proxy forwards requests to provider APIs on behalf of users, so
+// internal services call provider APIs without ever holding user tokens.
+//
+// Requests to /proxy/{userID}/{service}/* are forwarded to upstream of
+// service with the rest of path and query appended and the stored access
+// token attached as bearer. Token living shorter than MinValidity is
+// refreshed first. Upstreams are configured per service:
+//
+//	proxy:
+//	  enabled: true
+//	  upstreams:
+//	    google: "https://www.googleapis.com"
+//	    zoom: "https://api.zoom.us/v2"
+//
+// Services listed in instanceURL, e.g. Salesforce, are forwarded to
+// instance URL of token instead, and rejected when token has none. Other
+// services are not proxied. Paths with dot segments are rejected, so
+// requests never leave upstream path. Credentials of caller,
+// i.e. Authorization, Cookie, partner key and tenant headers, never reach
+// provider. Outcomes are counted by service and upstream status in
+// auth_proxy_requests_total.
+package proxy
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/Zetkolink/auth/partners"
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultMinValidity = 30
+	defaultTimeout     = 30
+)
+
+var (
+	// ErrNotProxied service has no upstream to forward requests to.
+	ErrNotProxied = errors.New("service is not proxied")
+
+	// ErrPath forwarded path is malformed or has dot segments.
+	ErrPath = errors.New("proxied path must not have dot segments")
+
+	proxied = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metrics.Namespace + "_proxy_requests_total",
+			Help: "Total number of requests proxied to provider APIs, by upstream status.",
+		},
+		[]string{"service", "code"},
+	)
+
+	// strippedHeaders lists caller headers never forwarded to provider.
+	strippedHeaders = []string{
+		"Authorization", "Cookie", partners.KeyHeader,
+		helpers.AdminKeyHeader, helpers.TenantHeader, "X-Forwarded-For", "Forwarded",
+	}
+)
+
+// Config type represents proxy configuration. InstanceURL lists services
+// forwarded to instance URL of token. MinValidity is the time forwarded
+// access token stays valid for at least, Timeout bounds upstream response
+// headers, both in seconds.
+type Config struct {
+	Enabled     bool
+	Upstreams   map[string]string
+	InstanceURL []string `yaml:"instanceURL"`
+	MinValidity int      `yaml:"minValidity"`
+	Timeout     int
+}
+
+// Proxy type represents provider API reverse proxy.
+type Proxy struct {
+	tokens      *tokens.Model
+	upstreams   map[string]*url.URL
+	instanceURL map[string]struct{}
+	minValidity time.Duration
+	transport   http.RoundTripper
+}
+
+func init() {
+	metrics.Registry.MustRegister(proxied)
+}
+
+// New method creates new proxy instance.
+func New(config Config, tokensModel *tokens.Model) (*Proxy, error) {
+	setDefault(&config.MinValidity, defaultMinValidity)
+	setDefault(&config.Timeout, defaultTimeout)
+
+	p := &Proxy{
+		tokens:      tokensModel,
+		upstreams:   make(map[string]*url.URL, len(config.Upstreams)),
+		instanceURL: make(map[string]struct{}, len(config.InstanceURL)),
+		minValidity: time.Duration(config.MinValidity) * time.Second,
+		transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: time.Duration(config.Timeout) * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+
+	for service, upstream := range config.Upstreams {
+		u, err := parseUpstream(upstream)
+
+		if err != nil {
+			return nil, errors.New("proxy upstream of " + service +
+				" must be absolute http(s) URL: " + upstream)
+		}
+
+		p.upstreams[service] = u
+	}
+
+	for _, service := range config.InstanceURL {
+		if _, ok := p.upstreams[service]; ok {
+			return nil, errors.New("proxy service " + service +
+				" has both upstream and instance URL")
+		}
+
+		p.instanceURL[service] = struct{}{}
+	}
+
+	return p, nil
+}
+
+// NewRouter method returns HTTP-router for proxy.
+func (p *Proxy) NewRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.HandleFunc("/{userID}/{service}", p.Forward)
+	r.HandleFunc("/{userID}/{service}/*", p.Forward)
+
+	return r
+}
+
+// Forward handler forwards request to upstream of service with access
+// token of user attached. Upstream and path are checked before token is
+// leased, so rejected requests never refresh it.
+func (p *Proxy) Forward(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	service := chi.URLParam(r, "service")
+
+	upstream, ok := p.upstreams[service]
+	_, instance := p.instanceURL[service]
+
+	if !ok && !instance {
+		helpers.NotFound(w, r, ErrNotProxied)
+		return
+	}
+
+	rest, err := cleanPath(chi.URLParam(r, "*"))
+
+	if err != nil {
+		helpers.BadRequest(w, r, err)
+		return
+	}
+
+	token, err := p.tokens.Lease(r.Context(), userID, service, "",
+		p.minValidity)
+
+	if err != nil {
+		p.leaseFailed(w, r, err)
+		return
+	}
+
+	if instance {
+		upstream, err = parseUpstream(token.InstanceURL)
+
+		if err != nil {
+			helpers.NotFound(w, r, ErrNotProxied)
+			return
+		}
+	}
+
+	code := 0
+
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = upstream.Scheme
+			req.URL.Host = upstream.Host
+			req.URL.Path = strings.TrimSuffix(upstream.Path, "/") + "/" + rest
+			req.URL.RawPath = ""
+			req.Host = upstream.Host
+
+			for _, h := range strippedHeaders {
+				req.Header.Del(h)
+			}
+
+			// Nil value keeps ReverseProxy from adding client address.
+			req.Header["X-Forwarded-For"] = nil
+			req.Header.Set("Authorization",
+				token.Type()+" "+token.AccessToken)
+		},
+		Transport: p.transport,
+		ModifyResponse: func(resp *http.Response) error {
+			code = resp.StatusCode
+			resp.Header.Del("Set-Cookie")
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("proxy: %s %s: %s", service, r.Method, err)
+			code = http.StatusBadGateway
+			helpers.BadGateway(w, r, errors.New("provider API unreachable"))
+		},
+	}
+
+	rp.ServeHTTP(w, r)
+	proxied.WithLabelValues(service, strconv.Itoa(code)).Inc()
+}
+
+// leaseFailed method renders error of obtaining token to forward.
+func (p *Proxy) leaseFailed(w http.ResponseWriter, r *http.Request, err error) {
+	var re *tokens.RefreshError
+
+	switch {
+	case err == tokens.ErrNotFound:
+		helpers.NotFound(w, r, err)
+	case err == tokens.ErrSubjectUnavailable || err == tokens.ErrNotRefreshable ||
+		err == tokens.ErrLeaseTooLong || err == tokens.ErrRefreshUnsupported:
+		helpers.Conflict(w, r, err)
+	case err == helpers.ErrReadOnly:
+		helpers.ServiceUnavailable(w, r, err)
+	case errors.As(err, &re) && re.ReauthRequired():
+		helpers.Gone(w, r, errors.New("reauthorization required: "+re.Error()))
+	case errors.As(err, &re) && re.Class == tokens.FailureClient:
+		helpers.BadGateway(w, r, re)
+	case errors.As(err, &re):
+		if re.RetryAfter > 0 {
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(math.Ceil(re.RetryAfter.Seconds()))))
+		}
+
+		helpers.ServiceUnavailable(w, r, re)
+	default:
+		helpers.InternalServerError(w, r, err)
+	}
+}
+
+// cleanPath function returns unescaped path forwarded to upstream,
+// ErrPath when it has dot segments, escaped or not.
+func cleanPath(raw string) (string, error) {
+	rest, err := url.PathUnescape(raw)
+
+	if err != nil {
+		return "", ErrPath
+	}
+
+	for _, segment := range strings.Split(rest, "/") {
+		if segment == "." || segment == ".." {
+			return "", ErrPath
+		}
+	}
+
+	return rest, nil
+}
+
+func parseUpstream(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, ErrNotProxied
+	}
+
+	return u, nil
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import "testing"
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  error
+	}{
+		{raw: "", want: ""},
+		{raw: "drive/v3/files", want: "drive/v3/files"},
+		{raw: "files/", want: "files/"},
+		{raw: "a%20b", want: "a b"},
+		{raw: "..", err: ErrPath},
+		{raw: "v2/../admin", err: ErrPath},
+		{raw: "./files", err: ErrPath},
+		{raw: "v2/%2e%2e/admin", err: ErrPath},
+		{raw: "v2/%2E%2E", err: ErrPath},
+		{raw: "..%2fadmin", err: ErrPath},
+		{raw: "%zz", err: ErrPath},
+		{raw: "files/..data", want: "files/..data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := cleanPath(tt.raw)
+
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Errorf("path = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// Package proxyproto accepts connections carrying PROXY protocol header.
+//
+// Load balancers terminating TCP prepend a PROXY protocol header (v1 text
+// or v2 binary) to every connection, naming the original client address.
+// Listener strips the header and reports that address as the remote
+// address of the connection. Headers are honoured only from trusted
+// networks, connections from elsewhere are served as is.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107
+
+	v2CmdLocal = 0x0
+	v2CmdProxy = 0x1
+	v2FamInet  = 0x1
+	v2FamInet6 = 0x2
+
+	defaultTimeout = 5 * time.Second
+)
+
+var (
+	// ErrHeader PROXY protocol header is malformed.
+	ErrHeader = errors.New("malformed PROXY protocol header")
+
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// Networks type represents list of trusted networks.
+type Networks []*net.IPNet
+
+// ParseNetworks method parses CIDRs, single addresses are taken as
+// host networks.
+func ParseNetworks(cidrs []string) (Networks, error) {
+	nets := make(Networks, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+
+			if ip == nil {
+				return nil, errors.New("invalid address " + cidr)
+			}
+
+			bits := 8 * net.IPv6len
+
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// Contains method reports whether ip belongs to any network.
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Listener type represents listener reading PROXY protocol headers.
+type Listener struct {
+	net.Listener
+	trusted Networks
+	timeout time.Duration
+}
+
+// NewListener method wraps listener. Header is read on first use of
+// connection, so Accept never blocks on slow clients.
+func NewListener(ln net.Listener, trusted Networks) *Listener {
+	return &Listener{
+		Listener: ln,
+		trusted:  trusted,
+		timeout:  defaultTimeout,
+	}
+}
+
+// Accept method accepts connection, wrapped when peer is trusted.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok &&
+		!l.trusted.Contains(addr.IP) {
+		return conn, nil
+	}
+
+	return &Conn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: l.timeout,
+	}, nil
+}
+
+// Conn type represents connection with PROXY protocol header.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+// Read method reads connection data following header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr method returns client address named by header.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	sig, err := c.reader.Peek(len(v2Signature))
+
+	switch {
+	case err == nil && bytes.Equal(sig, v2Signature):
+		c.remote, c.err = readV2(c.reader)
+	case err == nil || err == io.EOF:
+		c.remote, c.err = readV1(c.reader)
+	default:
+		c.err = err
+	}
+
+	if c.err != nil {
+		_ = c.Conn.Close()
+	}
+}
+
+// readV1 function parses text header, e.g.
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	prefix, err := r.Peek(len(v1Prefix))
+
+	if err != nil || string(prefix) != v1Prefix {
+		return nil, ErrHeader
+	}
+
+	var line []byte
+
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrHeader
+	}
+
+	fields := strings.Fields(string(line))
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, ErrHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readV2 function parses binary header.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, len(v2Signature)+4)
+
+	_, err := io.ReadFull(r, head)
+
+	if err != nil {
+		return nil, err
+	}
+
+	verCmd, fam := head[12], head[13]
+	length := int(binary.BigEndian.Uint16(head[14:16]))
+
+	if verCmd>>4 != 2 {
+		return nil, ErrHeader
+	}
+
+	body := make([]byte, length)
+
+	_, err = io.ReadFull(r, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if verCmd&0xF == v2CmdLocal {
+		return nil, nil
+	}
+
+	if verCmd&0xF != v2CmdProxy {
+		return nil, ErrHeader
+	}
+
+	switch fam >> 4 {
+	case v2FamInet:
+		if length < 12 {
+			return nil, ErrHeader
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:4]),
+			Port: int(binary.BigEndian.Uint16(body[8:10])),
+		}, nil
+	case v2FamInet6:
+		if length < 36 {
+			return nil, ErrHeader
+		}
+
+		return &net.TCPAddr{
+			IP:   net.IP(body[0:16]),
+			Port: int(binary.BigEndian.Uint16(body[32:34])),
+		}, nil
+	}
+
+	// Unix and unspecified families carry no usable client address.
+	return nil, nil
+}
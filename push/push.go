@@ -0,0 +1,404 @@
+// Package push delivers tokens to consuming services as they change.
+//
+// Instead of polling GET /tokens, consuming service registers target URL
+// for service and receives the current access token whenever one is
+// created or refreshed. Each delivery is POSTed as JSON envelope
+//
+//	{"nonce": "<base64>", "ciphertext": "<base64>"}
+//
+// sealing payload with AES-256-GCM under SHA-256 of the target secret,
+// and X-Auth-Signature header carrying HMAC-SHA256 of the body under the
+// secret. Payload is
+//
+//	{"id", "event", "user_id", "service", "access_token", "token_type",
+//	 "expiry"}
+//
+// Refresh tokens never leave the service. Target acknowledges delivery
+// with any 2xx response. 429 and 5xx responses and network errors are
+// retried with exponential backoff until MaxAttempts, other responses
+// fail delivery at once. Failed deliveries may be redelivered through
+// admin API. Token is loaded when delivery runs, so late and repeated
+// deliveries carry the current token, never a stale one.
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	pushmodel "github.com/Zetkolink/auth/models/push"
+	"github.com/Zetkolink/auth/webhooks"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// EventTokenCreated token created by code exchange.
+	EventTokenCreated = "token.created"
+
+	// EventTokenRefreshed token refreshed at provider.
+	EventTokenRefreshed = "token.refreshed"
+
+	defaultPollInterval = 5
+	defaultMaxAttempts  = 8
+	defaultBaseBackoff  = 10
+	defaultMaxBackoff   = 3600
+	defaultTimeout      = 10
+)
+
+// Config type represents pusher configuration. Durations are in seconds,
+// Timeout bounds single delivery request.
+type Config struct {
+	PollInterval int `yaml:"pollInterval"`
+	MaxAttempts  int `yaml:"maxAttempts"`
+	BaseBackoff  int `yaml:"baseBackoff"`
+	MaxBackoff   int `yaml:"maxBackoff"`
+	Timeout      int
+}
+
+// Loader loads current token of user for service and external account.
+type Loader func(ctx context.Context, userID string, service string, account string) (*oauth2.Token, error)
+
+// Payload type represents token pushed to target, before sealing.
+type Payload struct {
+	ID          int64     `json:"id"`
+	Event       string    `json:"event"`
+	UserID      int       `json:"user_id"`
+	Service     string    `json:"service"`
+	Account     string    `json:"account,omitempty"`
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type,omitempty"`
+	Expiry      time.Time `json:"expiry,omitempty"`
+}
+
+// Envelope type represents sealed payload sent to target.
+type Envelope struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Pusher type represents background delivery worker.
+type Pusher struct {
+	config Config
+	model  *pushmodel.Model
+	loader Loader
+	client *http.Client
+	quit   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+type permanentError struct {
+	err error
+}
+
+// NewPusher method creates new pusher instance.
+func NewPusher(config Config, model *pushmodel.Model) *Pusher {
+	setDefault(&config.PollInterval, defaultPollInterval)
+	setDefault(&config.MaxAttempts, defaultMaxAttempts)
+	setDefault(&config.BaseBackoff, defaultBaseBackoff)
+	setDefault(&config.MaxBackoff, defaultMaxBackoff)
+	setDefault(&config.Timeout, defaultTimeout)
+
+	return &Pusher{
+		config: config,
+		model:  model,
+		client: &http.Client{
+			Timeout: time.Duration(config.Timeout) * time.Second,
+		},
+		quit: make(chan struct{}),
+	}
+}
+
+// SetLoader method sets loader pushed tokens are read with. Tokens model
+// queues events, so it is created after pusher.
+func (p *Pusher) SetLoader(loader Loader) {
+	p.loader = loader
+}
+
+// Enqueue method queues event of token of external account for targets
+// registered for service. Nil pusher queues nothing.
+func (p *Pusher) Enqueue(ctx context.Context, event string, userID int, service string, account string) {
+	if p == nil {
+		return
+	}
+
+	_, err := p.model.Enqueue(ctx, event, userID, service, account,
+		helpers.GetTenant(ctx))
+
+	if err != nil {
+		log.Printf("push: enqueue %s: %s", event, err)
+	}
+}
+
+// Cancel method gives up pending deliveries of deleted token of external
+// account. Nil pusher cancels nothing.
+func (p *Pusher) Cancel(ctx context.Context, userID int, service string, account string) {
+	if p == nil {
+		return
+	}
+
+	err := p.model.Cancel(ctx, userID, service, account, helpers.GetTenant(ctx),
+		"token deleted")
+
+	if err != nil {
+		log.Printf("push: cancel: %s", err)
+	}
+}
+
+// Start method runs queued deliveries as they become due.
+func (p *Pusher) Start() {
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(p.config.PollInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.quit:
+				return
+			case <-ticker.C:
+				p.drain(context.Background())
+			}
+		}
+	}()
+}
+
+// Stop method stops pusher, waiting for running delivery.
+func (p *Pusher) Stop() {
+	p.once.Do(func() { close(p.quit) })
+	p.wg.Wait()
+}
+
+// drain method runs due deliveries until none is left or pusher stops.
+func (p *Pusher) drain(ctx context.Context) {
+	timeout := time.Duration(p.config.Timeout) * time.Second
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+
+		// Lease outlives request timeout, so running delivery is not
+		// claimed twice.
+		delivery, err := p.model.Claim(ctx, 2*timeout)
+
+		if err != nil {
+			log.Println("push: " + err.Error())
+			return
+		}
+
+		if delivery == nil {
+			return
+		}
+
+		err = p.deliver(ctx, delivery)
+
+		switch {
+		case err == nil:
+			err = p.model.Ack(ctx, delivery.ID)
+		case isPermanent(err) || delivery.Attempts >= p.config.MaxAttempts:
+			log.Printf("push: delivery %d to %s failed: %s", delivery.ID,
+				delivery.URL, err)
+			err = p.model.Fail(ctx, delivery.ID, err.Error())
+		default:
+			err = p.model.Retry(ctx, delivery.ID, err.Error(),
+				time.Now().Add(p.backoff(delivery.Attempts)))
+		}
+
+		if err != nil {
+			log.Println("push: " + err.Error())
+		}
+	}
+}
+
+func (p *Pusher) deliver(ctx context.Context, delivery *pushmodel.Delivery) error {
+	token, err := p.loader(ctx, strconv.Itoa(delivery.UserID),
+		delivery.Service, delivery.Account)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := Seal(delivery.Secret, &Payload{
+		ID:          delivery.ID,
+		Event:       delivery.Event,
+		UserID:      delivery.UserID,
+		Service:     delivery.Service,
+		Account:     delivery.Account,
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		Expiry:      token.Expiry,
+	})
+
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL,
+		bytes.NewReader(body))
+
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhooks.EventHeader, delivery.Event)
+	req.Header.Set(webhooks.DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(webhooks.SignatureHeader, Sign(delivery.Secret, body))
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return err
+	}
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500:
+		return fmt.Errorf("push target responded %d", resp.StatusCode)
+	default:
+		return &permanentError{
+			err: fmt.Errorf("push target rejected %d", resp.StatusCode),
+		}
+	}
+}
+
+// backoff method returns jittered exponential delay before next attempt.
+func (p *Pusher) backoff(attempt int) time.Duration {
+	base := time.Duration(p.config.BaseBackoff) * time.Second
+	max := time.Duration(p.config.MaxBackoff) * time.Second
+	backoff := max
+
+	if attempt < 32 && base<<uint(attempt-1) < max {
+		backoff = base << uint(attempt-1)
+	}
+
+	half := backoff / 2
+
+	return half + time.Duration(mrand.Int63n(int64(half)+1))
+}
+
+// Seal function encrypts payload for target with secret and returns
+// envelope body.
+func Seal(secret string, payload *Payload) ([]byte, error) {
+	plain, err := json.Marshal(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&Envelope{
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plain, nil),
+	})
+}
+
+// Open function decrypts envelope body sealed with secret. Targets
+// written in Go may use it to read deliveries.
+func Open(secret string, body []byte) (*Payload, error) {
+	var envelope Envelope
+
+	err := json.Unmarshal(body, &envelope)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, errors.New("push: malformed envelope")
+	}
+
+	plain, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var payload Payload
+
+	err = json.Unmarshal(plain, &payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &payload, nil
+}
+
+// Sign function returns signature header value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAEAD(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func isPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}
@@ -0,0 +1,17 @@
+// Package reconcile keeps token rows consistent between two regions.
+//
+// In active-active deployments every region writes tokens into its own
+// database and the databases replicate to each other. Token rows are
+// resolved with last-writer-wins: each write stamps the row with updated_at
+// and the writing region, and upserts only replace a row whose
+// (updated_at, region) pair is not newer than the incoming one. Region
+// names break ties between writes with equal timestamps, so both sides
+// always pick the same winner.
+//
+// Replication may still leave rows diverged, e.g. when both regions refresh
+// the same token while the link is down and replication applies the stale
+// write last. The Reconciler periodically walks both databases in key order,
+// finds rows whose (updated_at, region) differ or which exist on one side
+// only, and copies the winning row over the losing one under the same
+// last-writer-wins guard. Both databases must share the tokens schema.
+package reconcile
@@ -0,0 +1,313 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/dblog"
+)
+
+const (
+	defaultInterval  = 300
+	defaultBatchSize = 1000
+)
+
+// Config type represents reconciler configuration. Interval is in seconds.
+type Config struct {
+	Region    string
+	PeerDsn   string `yaml:"peerDsn"`
+	Interval  int
+	BatchSize int `yaml:"batchSize"`
+}
+
+// Result type represents single reconciliation pass result.
+type Result struct {
+	Compared int
+	ToLocal  int
+	ToPeer   int
+}
+
+// Reconciler type represents token reconciliation job.
+type Reconciler struct {
+	local     *sql.DB
+	peer      *sql.DB
+	interval  time.Duration
+	batchSize int
+	quit      chan struct{}
+	once      sync.Once
+	wg        sync.WaitGroup
+}
+
+type key struct {
+	UserID  int
+	Service string
+	Account string
+}
+
+type version struct {
+	key
+	UpdatedAt time.Time
+	Region    string
+}
+
+// NewReconciler method creates new reconciler instance.
+func NewReconciler(config Config, local *sql.DB) (*Reconciler, error) {
+	peer, err := sql.Open("postgres", config.PeerDsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+
+	return &Reconciler{
+		local:     local,
+		peer:      peer,
+		interval:  time.Duration(config.Interval) * time.Second,
+		batchSize: config.BatchSize,
+		quit:      make(chan struct{}),
+	}, nil
+}
+
+// Start method runs periodic reconciliation.
+func (r *Reconciler) Start() {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+				res, err := r.Run(context.Background())
+
+				if err != nil {
+					log.Println("reconcile: " + err.Error())
+					continue
+				}
+
+				if res.ToLocal > 0 || res.ToPeer > 0 {
+					log.Printf("reconcile: compared %d, copied %d to local, %d to peer",
+						res.Compared, res.ToLocal, res.ToPeer)
+				}
+			}
+		}
+	}()
+}
+
+// Stop method stops periodic reconciliation.
+func (r *Reconciler) Stop() {
+	r.once.Do(func() { close(r.quit) })
+	r.wg.Wait()
+	_ = r.peer.Close()
+}
+
+// Run method performs single reconciliation pass.
+func (r *Reconciler) Run(ctx context.Context) (Result, error) {
+	var res Result
+	var last *key
+
+	for {
+		local, err := r.versions(ctx, r.local, last, nil, r.batchSize)
+
+		if err != nil {
+			return res, err
+		}
+
+		var upper *key
+
+		if len(local) == r.batchSize {
+			upper = &local[len(local)-1].key
+		}
+
+		peer, err := r.versions(ctx, r.peer, last, upper, 0)
+
+		if err != nil {
+			return res, err
+		}
+
+		err = r.merge(ctx, local, peer, &res)
+
+		if err != nil {
+			return res, err
+		}
+
+		if upper == nil {
+			return res, nil
+		}
+
+		last = upper
+	}
+}
+
+func (r *Reconciler) merge(ctx context.Context, local, peer []version, res *Result) error {
+	i, j := 0, 0
+
+	for i < len(local) || j < len(peer) {
+		var err error
+
+		switch {
+		case j == len(peer) || (i < len(local) && local[i].key.less(peer[j].key)):
+			err = copyRow(ctx, r.local, r.peer, local[i].key)
+			res.ToPeer++
+			i++
+		case i == len(local) || peer[j].key.less(local[i].key):
+			err = copyRow(ctx, r.peer, r.local, peer[j].key)
+			res.ToLocal++
+			j++
+		default:
+			l, p := local[i], peer[j]
+
+			if l.newer(p) {
+				err = copyRow(ctx, r.local, r.peer, l.key)
+				res.ToPeer++
+			} else if p.newer(l) {
+				err = copyRow(ctx, r.peer, r.local, p.key)
+				res.ToLocal++
+			}
+
+			i++
+			j++
+		}
+
+		res.Compared++
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reconciler) versions(ctx context.Context, db *sql.DB,
+	after *key, upTo *key, limit int) ([]version, error) {
+
+	var a, u key
+
+	if after != nil {
+		a = *after
+	}
+
+	if upTo != nil {
+		u = *upTo
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT
+									"user_id", "service", "account",
+									"updated_at", "region"
+									     FROM auth.tokens
+								WHERE ($1 OR (user_id, service, account) > ($2, $3, $4))
+								AND ($5 OR (user_id, service, account) <= ($6, $7, $8))
+								ORDER BY user_id, service, account
+								LIMIT NULLIF($9, 0)`,
+		after == nil, a.UserID, a.Service, a.Account,
+		upTo == nil, u.UserID, u.Service, u.Account, limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var list []version
+
+	for rows.Next() {
+		var v version
+
+		err = rows.Scan(&v.UserID, &v.Service, &v.Account, &v.UpdatedAt,
+			&v.Region)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, v)
+	}
+
+	return list, rows.Err()
+}
+
+// copyRow copies token row from src to dst unless dst holds a newer one.
+func copyRow(ctx context.Context, src, dst *sql.DB, k key) error {
+	var row []byte
+
+	err := src.QueryRowContext(ctx, `SELECT to_jsonb(t)
+									     FROM auth.tokens t
+								WHERE user_id = $1 AND service = $2
+								AND account = $3`,
+		k.UserID, k.Service, k.Account,
+	).Scan(&row)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := dst.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM auth.tokens t
+								USING jsonb_populate_record(NULL::auth.tokens, $1) r
+								WHERE t.user_id = r.user_id AND t.service = r.service
+								AND t.account = r.account
+								AND (t.updated_at, t.region) < (r.updated_at, r.region)`,
+		dblog.Secret(row),
+	)
+
+	if err == nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO auth.tokens
+								SELECT * FROM jsonb_populate_record(NULL::auth.tokens, $1)
+								ON CONFLICT (user_id, service, account) DO NOTHING`,
+			dblog.Secret(row),
+		)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (k key) less(o key) bool {
+	if k.UserID != o.UserID {
+		return k.UserID < o.UserID
+	}
+
+	if k.Service != o.Service {
+		return k.Service < o.Service
+	}
+
+	return k.Account < o.Account
+}
+
+func (v version) newer(o version) bool {
+	if !v.UpdatedAt.Equal(o.UpdatedAt) {
+		return v.UpdatedAt.After(o.UpdatedAt)
+	}
+
+	return v.Region > o.Region
+}
@@ -0,0 +1,201 @@
+// Package refresher refreshes tokens ahead of expiry, so consuming
+// services rarely get an expired access token.
+//
+// Every Interval the refresher picks up to BatchSize active refreshable
+// tokens whose access token expires within Window, soonest first, and
+// refreshes them with at most Concurrency provider calls at a time. Each
+// refresh is delayed by random jitter up to Jitter, spreading calls of
+// tokens that expire together. Refreshes are serialized with API ones by
+// the tokens model, so running several instances is safe. Outcomes are
+// counted in auth_refresh_ahead_total.
+package refresher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/Zetkolink/auth/models/tokenevents"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ResultRefreshed token refreshed.
+	ResultRefreshed = "refreshed"
+
+	// ResultFailed refresh failed, see tokens.RefreshError.
+	ResultFailed = "failed"
+
+	// ResultSkipped provider does not refresh tokens of service.
+	ResultSkipped = "skipped"
+
+	defaultInterval    = 60
+	defaultWindow      = 600
+	defaultConcurrency = 4
+	defaultJitter      = 30
+	defaultBatchSize   = 500
+	refreshTimeout     = time.Minute
+)
+
+var refreshed = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: metrics.Namespace + "_refresh_ahead_total",
+		Help: "Total number of tokens refreshed ahead of expiry, by result.",
+	},
+	[]string{"service", "result"},
+)
+
+// Config type represents refresher configuration. Interval, Window and
+// Jitter are in seconds.
+type Config struct {
+	Enabled     bool
+	Interval    int
+	Window      int
+	Concurrency int
+	Jitter      int
+	BatchSize   int `yaml:"batchSize"`
+}
+
+// Refresher type represents refresh-ahead worker.
+type Refresher struct {
+	config Config
+	model  *tokens.Model
+	quit   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func init() {
+	metrics.Registry.MustRegister(refreshed)
+}
+
+// NewRefresher method creates new refresher instance.
+func NewRefresher(config Config, model *tokens.Model) *Refresher {
+	setDefault(&config.Interval, defaultInterval)
+	setDefault(&config.Window, defaultWindow)
+	setDefault(&config.Concurrency, defaultConcurrency)
+	setDefault(&config.Jitter, defaultJitter)
+	setDefault(&config.BatchSize, defaultBatchSize)
+
+	return &Refresher{
+		config: config,
+		model:  model,
+		quit:   make(chan struct{}),
+	}
+}
+
+// Window method returns how long before expiry tokens are refreshed.
+func (r *Refresher) Window() time.Duration {
+	return time.Duration(r.config.Window) * time.Second
+}
+
+// Start method runs refresher.
+func (r *Refresher) Start() {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(r.config.Interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			r.run()
+
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop method stops refresher, waiting for running refreshes.
+func (r *Refresher) Stop() {
+	r.once.Do(func() { close(r.quit) })
+	r.wg.Wait()
+}
+
+// run method refreshes batch of expiring tokens. Nothing is refreshed
+// while service is read-only.
+func (r *Refresher) run() {
+	if helpers.IsReadOnly() {
+		return
+	}
+
+	keys, err := r.model.ExpiringKeys(context.Background(), "", r.Window(),
+		r.config.BatchSize)
+
+	if err != nil {
+		log.Println("refresher: " + err.Error())
+		return
+	}
+
+	sem := make(chan struct{}, r.config.Concurrency)
+	var wg sync.WaitGroup
+
+	for _, key := range keys {
+		select {
+		case <-r.quit:
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+
+		go func(key tokens.Key) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			r.refresh(key)
+		}(key)
+	}
+
+	wg.Wait()
+}
+
+// refresh method refreshes single token after jitter.
+func (r *Refresher) refresh(key tokens.Key) {
+	jitter := time.Duration(rand.Int63n(int64(r.config.Jitter) * int64(time.Second)))
+
+	select {
+	case <-r.quit:
+		return
+	case <-time.After(jitter):
+	}
+
+	ctx, cancel := context.WithTimeout(
+		tokenevents.WithActor(context.Background(), tokenevents.ActorRefresher),
+		refreshTimeout,
+	)
+	defer cancel()
+
+	_, err := r.model.Refresh(ctx, strconv.Itoa(key.UserID), key.Service,
+		key.Account)
+
+	switch {
+	case err == nil:
+		refreshed.WithLabelValues(key.Service, ResultRefreshed).Inc()
+	case errors.Is(err, tokens.ErrRefreshUnsupported):
+		refreshed.WithLabelValues(key.Service, ResultSkipped).Inc()
+	default:
+		refreshed.WithLabelValues(key.Service, ResultFailed).Inc()
+		log.Printf("refresher: %s for user %d: %s", key.Service,
+			key.UserID, err)
+	}
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}
@@ -0,0 +1,278 @@
+// Package retention deletes tokens outliving retention policy of their
+// service.
+//
+// Policies are configured per service, policy without service applying to
+// every service without own policy:
+//
+//	retention:
+//	  enabled: true
+//	  dryRun: true
+//	  policies:
+//	    - unusedDays: 180
+//	    - service: google
+//	      unusedDays: 90
+//	      deadDays: 30
+//
+// Every Interval the enforcer deletes, per policy, up to BatchSize tokens
+// not read for UnusedDays and tokens that have required user to connect
+// again for DeadDays. Zero days disable the rule. Grants are not revoked
+// at provider. In dry-run mode tokens are logged instead of deleted, and
+// tokens due under every policy are reported at /admin/retention either
+// way. Outcomes are counted in auth_retention_tokens_total.
+package retention
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/http/helpers"
+	"github.com/Zetkolink/auth/metrics"
+	"github.com/Zetkolink/auth/models/tokenevents"
+	"github.com/Zetkolink/auth/models/tokens"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// ResultDeleted token deleted.
+	ResultDeleted = "deleted"
+
+	// ResultDryRun token due, but not deleted in dry-run mode.
+	ResultDryRun = "dry_run"
+
+	// ResultFailed token not deleted on storage error.
+	ResultFailed = "failed"
+
+	defaultInterval  = 3600
+	defaultBatchSize = 500
+	runTimeout       = 10 * time.Minute
+	day              = 24 * time.Hour
+)
+
+var (
+	// ErrPolicy policies are misconfigured.
+	ErrPolicy = errors.New("retention policies must have distinct services and non-negative days")
+
+	enforced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: metrics.Namespace + "_retention_tokens_total",
+			Help: "Total number of tokens due under retention policy, by reason and result.",
+		},
+		[]string{"service", "reason", "result"},
+	)
+)
+
+// Config type represents retention configuration. Interval is in seconds.
+type Config struct {
+	Enabled   bool
+	DryRun    bool `yaml:"dryRun"`
+	Interval  int
+	BatchSize int `yaml:"batchSize"`
+	Policies  []Policy
+}
+
+// Policy type represents retention policy of service, empty service being
+// default one.
+type Policy struct {
+	Service    string `json:"service,omitempty"`
+	UnusedDays int    `json:"unused_days,omitempty" yaml:"unusedDays"`
+	DeadDays   int    `json:"dead_days,omitempty" yaml:"deadDays"`
+}
+
+// PolicyReport type represents tokens currently due under policy.
+type PolicyReport struct {
+	Policy
+	Due []*tokens.RetentionCount `json:"due"`
+}
+
+// Report type represents tokens currently due under every policy.
+type Report struct {
+	DryRun   bool            `json:"dry_run"`
+	Policies []*PolicyReport `json:"policies"`
+}
+
+// Enforcer type represents retention policy worker.
+type Enforcer struct {
+	config Config
+	model  *tokens.Model
+	quit   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func init() {
+	metrics.Registry.MustRegister(enforced)
+}
+
+// NewEnforcer method creates new enforcer instance.
+func NewEnforcer(config Config, model *tokens.Model) (*Enforcer, error) {
+	setDefault(&config.Interval, defaultInterval)
+	setDefault(&config.BatchSize, defaultBatchSize)
+
+	seen := make(map[string]struct{}, len(config.Policies))
+
+	for _, p := range config.Policies {
+		if _, ok := seen[p.Service]; ok || p.UnusedDays < 0 || p.DeadDays < 0 {
+			return nil, ErrPolicy
+		}
+
+		seen[p.Service] = struct{}{}
+	}
+
+	return &Enforcer{
+		config: config,
+		model:  model,
+		quit:   make(chan struct{}),
+	}, nil
+}
+
+// Start method runs enforcer.
+func (e *Enforcer) Start() {
+	e.wg.Add(1)
+
+	go func() {
+		defer e.wg.Done()
+
+		ticker := time.NewTicker(time.Duration(e.config.Interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			e.run()
+
+			select {
+			case <-e.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop method stops enforcer, waiting for running deletions.
+func (e *Enforcer) Stop() {
+	e.once.Do(func() { close(e.quit) })
+	e.wg.Wait()
+}
+
+// Report method returns number of tokens currently due under every
+// policy.
+func (e *Enforcer) Report(ctx context.Context) (*Report, error) {
+	report := &Report{
+		DryRun:   e.config.DryRun,
+		Policies: make([]*PolicyReport, 0, len(e.config.Policies)),
+	}
+
+	now := time.Now()
+
+	for _, p := range e.config.Policies {
+		due, err := e.model.CountExpired(ctx, e.rule(p, now))
+
+		if err != nil {
+			return nil, err
+		}
+
+		report.Policies = append(report.Policies, &PolicyReport{
+			Policy: p,
+			Due:    due,
+		})
+	}
+
+	return report, nil
+}
+
+// run method enforces every policy once. Nothing is deleted while service
+// is read-only.
+func (e *Enforcer) run() {
+	if helpers.IsReadOnly() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(
+		tokenevents.WithActor(context.Background(), tokenevents.ActorRetention),
+		runTimeout,
+	)
+	defer cancel()
+
+	now := time.Now()
+
+	for _, p := range e.config.Policies {
+		select {
+		case <-e.quit:
+			return
+		default:
+		}
+
+		if p.UnusedDays == 0 && p.DeadDays == 0 {
+			continue
+		}
+
+		e.enforce(ctx, e.rule(p, now))
+	}
+}
+
+// enforce method deletes batch of tokens due under rule, or only logs
+// them in dry-run mode.
+func (e *Enforcer) enforce(ctx context.Context, rule tokens.RetentionRule) {
+	list, err := e.model.ExpiredTokens(ctx, rule, e.config.BatchSize)
+
+	if err != nil {
+		log.Println("retention: " + err.Error())
+		return
+	}
+
+	for _, t := range list {
+		if e.config.DryRun {
+			enforced.WithLabelValues(t.Service, t.Reason, ResultDryRun).Inc()
+			log.Printf("retention: would delete %s token of user %d: %s",
+				t.Service, t.UserID, t.Reason)
+			continue
+		}
+
+		deleted, err := e.model.DeleteExpired(ctx, t, rule)
+
+		switch {
+		case err != nil:
+			enforced.WithLabelValues(t.Service, t.Reason, ResultFailed).Inc()
+			log.Printf("retention: %s token of user %d: %s", t.Service,
+				t.UserID, err)
+		case deleted:
+			enforced.WithLabelValues(t.Service, t.Reason, ResultDeleted).Inc()
+		}
+	}
+}
+
+// rule method returns token retention rule of policy as of now. Default
+// policy skips services having own policy.
+func (e *Enforcer) rule(p Policy, now time.Time) tokens.RetentionRule {
+	rule := tokens.RetentionRule{
+		Service: p.Service,
+	}
+
+	if p.Service == "" {
+		for _, other := range e.config.Policies {
+			if other.Service != "" {
+				rule.Exclude = append(rule.Exclude, other.Service)
+			}
+		}
+	}
+
+	if p.UnusedDays > 0 {
+		before := now.Add(-time.Duration(p.UnusedDays) * day)
+		rule.UnusedBefore = &before
+	}
+
+	if p.DeadDays > 0 {
+		before := now.Add(-time.Duration(p.DeadDays) * day)
+		rule.DeadBefore = &before
+	}
+
+	return rule
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}
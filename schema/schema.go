@@ -0,0 +1,241 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Zetkolink/auth/migrations"
+)
+
+const (
+	// OnDriftRefuse refuses to start on drift.
+	OnDriftRefuse = "refuse"
+
+	// OnDriftWarn logs drift report and starts.
+	OnDriftWarn = "warn"
+
+	// OnDriftReadOnly logs drift report and starts in read-only mode.
+	OnDriftReadOnly = "readonly"
+
+	schemaName = "auth"
+)
+
+// Expected lists columns the models read and write, per table.
+var Expected = map[string][]string{
+	"apps": {
+		"id", "service", "password", "password_fingerprint",
+		"callback_URL", "scopes",
+		"environment", "issuer", "auth_params", "expiry", "created_at",
+		"status", "auth_method", "signing_key", "signing_key_id",
+		"offline_access", "weight", "tenant", "previous_password",
+		"previous_password_expiry",
+	},
+	"tokens": {
+		"user_id", "token_type", "access_token", "expiry",
+		"refresh_token", "created_at", "service", "instance_url", "extra",
+		"updated_at", "region", "access_token_fingerprint", "identity",
+		"scopes", "metadata", "app_id", "status", "refresh_failures",
+		"refresh_error", "refresh_retry_at", "use_count", "last_used_at",
+		"account", "labels", "status_changed_at",
+	},
+	"identities": {
+		"user_id", "service", "account", "subject", "email", "name",
+		"fetched_at",
+	},
+	"token_events": {
+		"id", "user_id", "service", "kind", "actor", "outcome", "error",
+		"created_at", "account",
+	},
+	"app_migrations": {
+		"service", "from_app_id", "to_app_id", "started_at",
+	},
+	"exchanges": {
+		"id", "service", "user_id", "tenant", "code_verifier", "nonce",
+		"scopes", "account", "labels", "app_id",
+	},
+	"scripts": {
+		"id", "tenant", "event", "source", "created_at",
+	},
+	"push_targets": {
+		"id", "tenant", "service", "url", "secret", "created_at",
+	},
+	"push_deliveries": {
+		"id", "target_id", "event", "user_id", "service", "tenant",
+		"attempts", "status", "last_error", "run_at", "acked_at",
+		"created_at", "account",
+	},
+	"operations": {
+		"id", "kind", "tenant", "params", "status", "total", "processed",
+		"failed", "item_errors", "error", "cancel_requested", "created_at",
+		"started_at", "updated_at", "finished_at",
+	},
+	"hook_jobs": {
+		"id", "hook", "event", "user_id", "service", "tenant", "attempts",
+		"status", "last_error", "run_at", "created_at",
+	},
+	"consumed_states": {
+		"hash", "service", "user_id", "tenant", "consumed_at",
+	},
+	"audit_log": {
+		"id", "action", "severity", "tenant", "user_id", "service",
+		"fingerprint", "ip", "created_at",
+	},
+	"export_jobs": {
+		"id", "kind", "tenant", "params", "status", "rows", "error",
+		"created_at", "started_at", "finished_at", "expires_at",
+	},
+	"usage_daily": {
+		"day", "tenant", "service", "event", "reason", "count",
+	},
+	"webhook_dead_letters": {
+		"id", "event_id", "dedup_key", "event_type", "url", "payload",
+		"attempts", "error", "created_at",
+	},
+	"service_tokens": {
+		"service", "token_type", "access_token", "expiry", "created_at",
+	},
+	"providers": {
+		"service", "auth_url", "token_url", "scopes", "quirks",
+		"supports_refresh", "supports_revoke", "pkce_required",
+		"supports_token_exchange", "revocation_url", "introspection_url",
+		"validation_url", "created_at",
+	},
+}
+
+// Config type represents drift check configuration.
+type Config struct {
+	OnDrift string `yaml:"onDrift"`
+}
+
+// Report type represents schema drift report.
+type Report struct {
+	ExpectedVersion int
+	Version         int
+	MissingTables   []string
+	MissingColumns  map[string][]string
+}
+
+// Check method compares live database schema with expected one.
+func Check(ctx context.Context, db *sql.DB) (*Report, error) {
+	expected, err := migrations.Latest()
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		ExpectedVersion: expected,
+		MissingColumns:  make(map[string][]string),
+	}
+
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0)
+									FROM auth.schema_migrations`,
+	).Scan(&report.Version)
+
+	if err != nil {
+		report.Version = -1
+	}
+
+	live, err := columns(ctx, db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for table, cols := range Expected {
+		have, ok := live[table]
+
+		if !ok {
+			report.MissingTables = append(report.MissingTables, table)
+			continue
+		}
+
+		for _, col := range cols {
+			if _, ok := have[col]; !ok {
+				report.MissingColumns[table] = append(
+					report.MissingColumns[table], col,
+				)
+			}
+		}
+	}
+
+	sort.Strings(report.MissingTables)
+
+	return report, nil
+}
+
+// Drifted method reports whether live schema differs from expected one.
+func (r *Report) Drifted() bool {
+	return r.Version != r.ExpectedVersion ||
+		len(r.MissingTables) > 0 || len(r.MissingColumns) > 0
+}
+
+// String method returns human readable report.
+func (r *Report) String() string {
+	var b strings.Builder
+
+	switch {
+	case r.Version < 0:
+		fmt.Fprintf(&b, "schema version unknown (no auth.schema_migrations), expected %d",
+			r.ExpectedVersion)
+	default:
+		fmt.Fprintf(&b, "schema version %d, expected %d",
+			r.Version, r.ExpectedVersion)
+	}
+
+	for _, table := range r.MissingTables {
+		fmt.Fprintf(&b, "\n  missing table %s.%s", schemaName, table)
+	}
+
+	tables := make([]string, 0, len(r.MissingColumns))
+
+	for table := range r.MissingColumns {
+		tables = append(tables, table)
+	}
+
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		fmt.Fprintf(&b, "\n  missing columns in %s.%s: %s", schemaName,
+			table, strings.Join(r.MissingColumns[table], ", "))
+	}
+
+	return b.String()
+}
+
+func columns(ctx context.Context, db *sql.DB) (map[string]map[string]struct{}, error) {
+	rows, err := db.QueryContext(ctx, `SELECT table_name, column_name
+									FROM information_schema.columns
+								WHERE table_schema = $1`,
+		schemaName,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	live := make(map[string]map[string]struct{})
+
+	for rows.Next() {
+		var table, column string
+
+		err = rows.Scan(&table, &column)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if live[table] == nil {
+			live[table] = make(map[string]struct{})
+		}
+
+		live[table][column] = struct{}{}
+	}
+
+	return live, rows.Err()
+}
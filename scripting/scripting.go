@@ -0,0 +1,395 @@
+// Package scripting runs tenant Starlark scripts on token events.
+//
+// Operators of the prebuilt binary cannot register Go hooks, so tenants
+// may attach small Starlark scripts to events instead. Scripts run in a
+// sandbox: no file or module access, bounded execution steps and wall
+// time, and a limited API:
+//
+//	event                      dict with type, user_id, service, account, tenant
+//	                           and, on refresh failure, error and failure
+//	http.get(url, headers={})  returns struct(status, body)
+//	http.post(url, body="", headers={})
+//	set_metadata(key, value)   stores string label on the token
+//
+// Scripts run asynchronously on a bounded worker pool; events arriving
+// while every worker is busy are dropped and logged.
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	scriptsmodel "github.com/Zetkolink/auth/models/scripts"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// EventTokenCreated script runs after token is created.
+	EventTokenCreated = "on_token_created"
+
+	// EventRefreshFailed script runs after refresh fails.
+	EventRefreshFailed = "on_refresh_failed"
+
+	defaultWorkers  = 4
+	defaultTimeout  = 10
+	defaultMaxSteps = 1000000
+	maxHTTPCalls    = 5
+	maxBodySize     = 64 << 10
+	metadataLimit   = 256
+
+	threadContextKey = "context"
+)
+
+var (
+	// ErrHostNotAllowed script called host missing from allowed list.
+	ErrHostNotAllowed = errors.New("host not allowed")
+
+	// ErrTooManyCalls script exceeded HTTP call budget.
+	ErrTooManyCalls = errors.New("too many http calls")
+
+	// Scripts are short top level programs, so if and for are allowed
+	// outside functions.
+	fileOptions = &syntax.FileOptions{
+		TopLevelControl: true,
+		GlobalReassign:  true,
+	}
+
+	predeclaredNames = map[string]struct{}{
+		"event": {}, "http": {}, "set_metadata": {},
+	}
+)
+
+// Config type represents script engine configuration. Timeout is in
+// seconds and bounds single script run. Empty AllowedHosts lets scripts
+// call any host.
+type Config struct {
+	Workers      int
+	Timeout      int
+	MaxSteps     uint64   `yaml:"maxSteps"`
+	AllowedHosts []string `yaml:"allowedHosts"`
+}
+
+// Event type represents token event passed to scripts.
+type Event struct {
+	Type    string
+	UserID  int
+	Service string
+	Account string
+	Tenant  string
+	Error   string
+	Failure string
+}
+
+// MetadataWriter stores token metadata set by script.
+type MetadataWriter func(ctx context.Context, userID int, service string, account string, key string, value string) error
+
+// Engine type represents script runner.
+type Engine struct {
+	config   Config
+	model    *scriptsmodel.Model
+	metadata MetadataWriter
+	client   *http.Client
+	allowed  map[string]struct{}
+	slots    chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewEngine method creates new engine instance.
+func NewEngine(config Config, model *scriptsmodel.Model) *Engine {
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	if config.MaxSteps == 0 {
+		config.MaxSteps = defaultMaxSteps
+	}
+
+	allowed := make(map[string]struct{}, len(config.AllowedHosts))
+
+	for _, host := range config.AllowedHosts {
+		allowed[strings.ToLower(host)] = struct{}{}
+	}
+
+	e := &Engine{
+		config:  config,
+		model:   model,
+		allowed: allowed,
+		slots:   make(chan struct{}, config.Workers),
+	}
+
+	e.client = &http.Client{
+		Timeout: time.Duration(config.Timeout) * time.Second,
+		CheckRedirect: func(req *http.Request, _ []*http.Request) error {
+			return e.checkHost(req.URL)
+		},
+	}
+
+	return e
+}
+
+// SetMetadataWriter method sets writer of set_metadata. Tokens model
+// fires events, so it is created after engine.
+func (e *Engine) SetMetadataWriter(writer MetadataWriter) {
+	e.metadata = writer
+}
+
+// Check function compiles source, reporting syntax errors and unknown
+// names.
+func Check(source string) error {
+	_, _, err := starlark.SourceProgramOptions(fileOptions, "script.star",
+		source, func(name string) bool {
+			_, ok := predeclaredNames[name]
+			return ok
+		})
+
+	return err
+}
+
+// Fire method runs scripts tenant attached to event in background. Nil
+// engine runs nothing.
+func (e *Engine) Fire(event Event) {
+	if e == nil {
+		return
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+	default:
+		log.Printf("scripting: workers busy, %s dropped", event.Type)
+		return
+	}
+
+	e.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-e.slots
+			e.wg.Done()
+		}()
+
+		e.fire(event)
+	}()
+}
+
+// Stop method waits for running scripts.
+func (e *Engine) Stop() {
+	e.wg.Wait()
+}
+
+func (e *Engine) fire(event Event) {
+	timeout := time.Duration(e.config.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	scripts, err := e.model.ForEvent(ctx, event.Tenant, event.Type)
+
+	if err != nil {
+		log.Println("scripting: " + err.Error())
+		return
+	}
+
+	for _, script := range scripts {
+		err = e.run(ctx, script, event)
+
+		if err != nil {
+			log.Printf("scripting: script %s: %s", script.ID, err)
+		}
+	}
+}
+
+func (e *Engine) run(ctx context.Context, script *scriptsmodel.Script, event Event) error {
+	thread := &starlark.Thread{
+		Name: script.ID,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("scripting: script %s: %s", script.ID, msg)
+		},
+	}
+
+	thread.SetMaxExecutionSteps(e.config.MaxSteps)
+	thread.SetLocal(threadContextKey, ctx)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel("timeout")
+		case <-done:
+		}
+	}()
+
+	calls := 0
+	predeclared := starlark.StringDict{
+		"event": eventDict(event),
+		"http": &starlarkstruct.Module{
+			Name: "http",
+			Members: starlark.StringDict{
+				"get":  starlark.NewBuiltin("http.get", e.httpCall(http.MethodGet, &calls)),
+				"post": starlark.NewBuiltin("http.post", e.httpCall(http.MethodPost, &calls)),
+			},
+		},
+		"set_metadata": starlark.NewBuiltin("set_metadata", e.setMetadata(event)),
+	}
+
+	_, err := starlark.ExecFileOptions(fileOptions, thread, script.ID+".star",
+		script.Source, predeclared)
+
+	return err
+}
+
+func (e *Engine) httpCall(method string, calls *int) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var rawURL, body string
+		var headers *starlark.Dict
+		var err error
+
+		if method == http.MethodPost {
+			err = starlark.UnpackArgs(b.Name(), args, kwargs,
+				"url", &rawURL, "body?", &body, "headers?", &headers)
+		} else {
+			err = starlark.UnpackArgs(b.Name(), args, kwargs,
+				"url", &rawURL, "headers?", &headers)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if *calls >= maxHTTPCalls {
+			return nil, ErrTooManyCalls
+		}
+
+		*calls++
+
+		u, err := url.Parse(rawURL)
+
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("%s: invalid url %q", b.Name(), rawURL)
+		}
+
+		err = e.checkHost(u)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ctx := thread.Local(threadContextKey).(context.Context)
+		req, err := http.NewRequest(method, u.String(), strings.NewReader(body))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if headers != nil {
+			for _, item := range headers.Items() {
+				key, ok1 := starlark.AsString(item[0])
+				value, ok2 := starlark.AsString(item[1])
+
+				if !ok1 || !ok2 {
+					return nil, fmt.Errorf("%s: headers must be strings", b.Name())
+				}
+
+				req.Header.Set(key, value)
+			}
+		}
+
+		resp, err := e.client.Do(req.WithContext(ctx))
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"status": starlark.MakeInt(resp.StatusCode),
+			"body":   starlark.String(data),
+		}), nil
+	}
+}
+
+func (e *Engine) setMetadata(event Event) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key, value string
+
+		err := starlark.UnpackArgs(b.Name(), args, kwargs,
+			"key", &key, "value", &value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "" || len(key) > metadataLimit || len(value) > metadataLimit {
+			return nil, fmt.Errorf("%s: key and value must be 1-%d bytes",
+				b.Name(), metadataLimit)
+		}
+
+		if e.metadata == nil {
+			return starlark.None, nil
+		}
+
+		ctx := thread.Local(threadContextKey).(context.Context)
+		err = e.metadata(ctx, event.UserID, event.Service, event.Account,
+			key, value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return starlark.None, nil
+	}
+}
+
+// checkHost method rejects hosts missing from allowed list, redirects
+// included.
+func (e *Engine) checkHost(u *url.URL) error {
+	if len(e.allowed) == 0 {
+		return nil
+	}
+
+	if _, ok := e.allowed[strings.ToLower(u.Hostname())]; !ok {
+		return ErrHostNotAllowed
+	}
+
+	return nil
+}
+
+func eventDict(event Event) *starlark.Dict {
+	d := starlark.NewDict(6)
+
+	_ = d.SetKey(starlark.String("type"), starlark.String(event.Type))
+	_ = d.SetKey(starlark.String("user_id"), starlark.MakeInt(event.UserID))
+	_ = d.SetKey(starlark.String("service"), starlark.String(event.Service))
+	_ = d.SetKey(starlark.String("account"), starlark.String(event.Account))
+	_ = d.SetKey(starlark.String("tenant"), starlark.String(event.Tenant))
+
+	if event.Error != "" {
+		_ = d.SetKey(starlark.String("error"), starlark.String(event.Error))
+		_ = d.SetKey(starlark.String("failure"), starlark.String(event.Failure))
+	}
+
+	d.Freeze()
+
+	return d
+}
@@ -0,0 +1,153 @@
+// Package tokenuse records when tokens are read by clients, so grants
+// nobody uses any more can be found and cleaned up.
+//
+// Reads are counted in memory and flushed to use_count and last_used_at
+// of auth.tokens every FlushInterval in single statement, so recording
+// adds no storage round trip to the read path. Counts of reads since last
+// flush are lost if instance crashes.
+package tokenuse
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const defaultFlushInterval = 10
+
+// Config type represents recorder configuration. FlushInterval is in
+// seconds.
+type Config struct {
+	FlushInterval int `yaml:"flushInterval"`
+}
+
+// Recorder type represents buffered token use recorder.
+type Recorder struct {
+	db       *sql.DB
+	interval time.Duration
+	mu       sync.Mutex
+	pending  map[key]*use
+	quit     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+type key struct {
+	userID  int
+	service string
+	account string
+}
+
+type use struct {
+	count int64
+	last  time.Time
+}
+
+// NewRecorder method creates new recorder instance.
+func NewRecorder(config Config, db *sql.DB) *Recorder {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+
+	return &Recorder{
+		db:       db,
+		interval: time.Duration(config.FlushInterval) * time.Second,
+		pending:  make(map[key]*use),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start method runs flushing worker.
+func (r *Recorder) Start() {
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.quit:
+				r.flush()
+				return
+			case <-ticker.C:
+				r.flush()
+			}
+		}
+	}()
+}
+
+// Stop method stops flushing worker, flushing pending uses first.
+func (r *Recorder) Stop() {
+	r.once.Do(func() { close(r.quit) })
+	r.wg.Wait()
+}
+
+// Record method counts read of token. Nil recorder records nothing.
+func (r *Recorder) Record(userID int, service string, account string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key{userID: userID, service: service, account: account}
+	u, ok := r.pending[k]
+
+	if !ok {
+		u = &use{}
+		r.pending[k] = u
+	}
+
+	u.count++
+	u.last = time.Now()
+}
+
+func (r *Recorder) flush() {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[key]*use)
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	userIDs := make([]int64, 0, len(pending))
+	services := make([]string, 0, len(pending))
+	accounts := make([]string, 0, len(pending))
+	counts := make([]int64, 0, len(pending))
+	lasts := make([]string, 0, len(pending))
+
+	for k, u := range pending {
+		userIDs = append(userIDs, int64(k.userID))
+		services = append(services, k.service)
+		accounts = append(accounts, k.account)
+		counts = append(counts, u.count)
+		lasts = append(lasts, u.last.Format(time.RFC3339Nano))
+	}
+
+	_, err := r.db.ExecContext(context.Background(), `UPDATE auth.tokens t
+								SET use_count = t.use_count + u.count,
+									last_used_at = GREATEST(t.last_used_at, u.last)
+								FROM unnest($1::integer[], $2::text[],
+									$3::bigint[], $4::timestamptz[], $5::text[])
+									AS u(user_id, service, count, last, account)
+								WHERE t.user_id = u.user_id
+									AND t.service = u.service
+									AND t.account = u.account`,
+		pq.Array(userIDs), pq.Array(services), pq.Array(counts),
+		pq.Array(lasts), pq.Array(accounts),
+	)
+
+	if err != nil {
+		log.Printf("tokenuse: %d uses not recorded: %s", len(pending), err)
+	}
+}
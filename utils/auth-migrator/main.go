@@ -1,5 +1,34 @@
-package auth_migrator
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+
+	"github.com/Zetkolink/auth/migrations"
+	_ "github.com/lib/pq"
+)
 
 func main() {
+	dsn := flag.String("dsn", "", "postgres connection string")
+	flag.Parse()
+
+	db, err := sql.Open("postgres", *dsn)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer db.Close()
+
+	applied, err := migrations.Apply(context.Background(), db)
+
+	for _, m := range applied {
+		log.Printf("applied %s", m.Name)
+	}
 
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/Zetkolink/auth/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// burnRates lists multiwindow burn rate alerts (long window, short window).
+var burnRates = []burnRate{
+	{Long: "1h", Short: "5m", Factor: 14.4, Severity: "page"},
+	{Long: "6h", Short: "30m", Factor: 6, Severity: "page"},
+	{Long: "3d", Short: "6h", Factor: 1, Severity: "ticket"},
+}
+
+type burnRate struct {
+	Long     string
+	Short    string
+	Factor   float64
+	Severity string
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type objectives struct {
+	availability float64
+	latency      float64
+	latencyGoal  float64
+}
+
+func genMonitoring(args []string) error {
+	var obj objectives
+	var out string
+
+	fs := flag.NewFlagSet("gen-monitoring", flag.ExitOnError)
+	fs.Float64Var(&obj.availability, "availability", 0.999,
+		"availability objective")
+	fs.Float64Var(&obj.latency, "latency", 0.5,
+		"latency threshold in seconds, must be a histogram bucket")
+	fs.Float64Var(&obj.latencyGoal, "latency-objective", 0.99,
+		"share of requests faster than latency threshold")
+	fs.StringVar(&out, "out", "", "output file (stdout if empty)")
+
+	err := fs.Parse(args)
+
+	if err != nil {
+		return err
+	}
+
+	if !isBucket(obj.latency) {
+		return fmt.Errorf("latency %v is not one of buckets %v",
+			obj.latency, metrics.Buckets)
+	}
+
+	var w io.Writer = os.Stdout
+
+	if out != "" {
+		f, err := os.Create(out)
+
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		w = f
+	}
+
+	data, err := yaml.Marshal(buildRules(obj))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+func buildRules(obj objectives) ruleFile {
+	var file ruleFile
+
+	for _, slo := range metrics.SLOs {
+		file.Groups = append(file.Groups,
+			sliGroup(slo, obj),
+			alertGroup(slo, "availability", obj.availability),
+			alertGroup(slo, "latency", obj.latencyGoal),
+		)
+	}
+
+	return file
+}
+
+func sliGroup(slo metrics.SLO, obj objectives) ruleGroup {
+	group := ruleGroup{Name: fmt.Sprintf("auth-sli-%s", slo.Name)}
+	selector := fmt.Sprintf(`%s=%q,%s=%q`,
+		metrics.LabelRoute, slo.Route, metrics.LabelMethod, slo.Method)
+	le := strconv.FormatFloat(obj.latency, 'f', -1, 64)
+
+	for _, window := range windows() {
+		group.Rules = append(group.Rules,
+			rule{
+				Record: recordName(slo, "availability", window),
+				Expr: fmt.Sprintf(
+					`sum(rate(%s{%s,%s!~"5.."}[%s])) / sum(rate(%s{%s}[%s]))`,
+					metrics.RequestsTotal, selector, metrics.LabelCode, window,
+					metrics.RequestsTotal, selector, window,
+				),
+			},
+			rule{
+				Record: recordName(slo, "latency", window),
+				Expr: fmt.Sprintf(
+					`sum(rate(%s_bucket{%s,le="%s"}[%s])) / sum(rate(%s_count{%s}[%s]))`,
+					metrics.RequestDuration, selector, le, window,
+					metrics.RequestDuration, selector, window,
+				),
+			},
+		)
+	}
+
+	return group
+}
+
+func alertGroup(slo metrics.SLO, sli string, objective float64) ruleGroup {
+	group := ruleGroup{Name: fmt.Sprintf("auth-slo-%s-%s", slo.Name, sli)}
+	budget := 1 - objective
+
+	for _, br := range burnRates {
+		threshold := strconv.FormatFloat(br.Factor*budget, 'g', 6, 64)
+
+		group.Rules = append(group.Rules, rule{
+			Alert: fmt.Sprintf("Auth%s%sBudgetBurn", camel(slo.Name), camel(sli)),
+			Expr: fmt.Sprintf(
+				"(1 - %s) > %s and (1 - %s) > %s",
+				recordName(slo, sli, br.Long), threshold,
+				recordName(slo, sli, br.Short), threshold,
+			),
+			For: "2m",
+			Labels: map[string]string{
+				"severity": br.Severity,
+				"slo":      slo.Name,
+			},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf(
+					"%s %s error budget burning %vx over %s",
+					slo.Name, sli, br.Factor, br.Long,
+				),
+			},
+		})
+	}
+
+	return group
+}
+
+func windows() []string {
+	seen := make(map[string]struct{})
+	var res []string
+
+	for _, br := range burnRates {
+		for _, w := range []string{br.Short, br.Long} {
+			if _, ok := seen[w]; ok {
+				continue
+			}
+
+			seen[w] = struct{}{}
+			res = append(res, w)
+		}
+	}
+
+	return res
+}
+
+func recordName(slo metrics.SLO, sli string, window string) string {
+	return fmt.Sprintf("%s:sli_%s_%s:ratio_rate%s",
+		metrics.Namespace, slo.Name, sli, window)
+}
+
+func isBucket(v float64) bool {
+	for _, b := range metrics.Buckets {
+		if b == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func camel(s string) string {
+	res := make([]byte, 0, len(s))
+	upper := true
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if c == '_' {
+			upper = true
+			continue
+		}
+
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+
+		upper = false
+		res = append(res, c)
+	}
+
+	return string(res)
+}
@@ -0,0 +1,502 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Zetkolink/auth/breaker"
+	"github.com/Zetkolink/auth/http/helpers"
+)
+
+const (
+	// EventTokenCreated token created by code exchange.
+	EventTokenCreated = "token.created"
+
+	// EventTokenRefreshed token refreshed at provider.
+	EventTokenRefreshed = "token.refreshed"
+
+	// EventTokenRefreshRejected provider rejected refresh token, user
+	// has to authorize again.
+	EventTokenRefreshRejected = "token.refresh_rejected"
+
+	// EventTokenDeleted token removed from storage.
+	EventTokenDeleted = "token.deleted"
+
+	// SignatureHeader is the header carrying payload HMAC signature.
+	SignatureHeader = "X-Auth-Signature"
+
+	// EventHeader is the header carrying event type.
+	EventHeader = "X-Auth-Event"
+
+	// DeliveryHeader is the header carrying event identifier.
+	DeliveryHeader = "X-Auth-Delivery"
+
+	// DedupKeyHeader is the header carrying event deduplication key.
+	DedupKeyHeader = "X-Auth-Dedup-Key"
+
+	defaultWorkers          = 8
+	defaultQueueSize        = 1024
+	defaultPerDestination   = 2
+	defaultMaxAttempts      = 8
+	defaultBaseBackoff      = 1
+	defaultMaxBackoff       = 300
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 60
+	defaultTimeout          = 10
+	busyRetryDelay          = 100 * time.Millisecond
+	archiveTimeout          = 10 * time.Second
+)
+
+var (
+	// ErrQueueFull delivery queue is full.
+	ErrQueueFull = errors.New("webhook queue is full")
+
+	// ErrStopped dispatcher is stopped.
+	ErrStopped = errors.New("webhook dispatcher is stopped")
+)
+
+// Config type represents dispatcher configuration. Durations are in seconds.
+type Config struct {
+	Workers          int
+	QueueSize        int `yaml:"queueSize"`
+	PerDestination   int `yaml:"perDestination"`
+	MaxAttempts      int `yaml:"maxAttempts"`
+	BaseBackoff      int `yaml:"baseBackoff"`
+	MaxBackoff       int `yaml:"maxBackoff"`
+	BreakerThreshold int `yaml:"breakerThreshold"`
+	BreakerCooldown  int `yaml:"breakerCooldown"`
+	Timeout          int
+	Endpoints        []Endpoint
+
+	// Archive keeps outcome of every finished delivery in archive
+	// storage.
+	Archive bool
+}
+
+// Endpoint type represents subscriber endpoint.
+type Endpoint struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+// Event type represents emitted event. DedupKey is derived from the state
+// transition that caused the event and is the same for every delivery of it.
+type Event struct {
+	ID        string          `json:"id"`
+	DedupKey  string          `json:"dedup_key"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Delivery type represents delivery of event to endpoint.
+type Delivery struct {
+	Endpoint Endpoint
+	Event    Event
+	Attempt  int
+}
+
+// DeadLetters is the storage of deliveries given up on.
+type DeadLetters interface {
+	Put(ctx context.Context, delivery *Delivery, reason error) error
+}
+
+// Archive is the blob storage of finished deliveries.
+type Archive interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// archived type represents archived delivery outcome.
+type archived struct {
+	Event      Event     `json:"event"`
+	URL        string    `json:"url"`
+	Attempts   int       `json:"attempts"`
+	Delivered  bool      `json:"delivered"`
+	Error      string    `json:"error,omitempty"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Dispatcher type represents webhook delivery worker pool.
+type Dispatcher struct {
+	config  Config
+	client  *http.Client
+	dead    DeadLetters
+	archive Archive
+	queue   chan *Delivery
+	quit    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	dests   map[string]*destination
+}
+
+type destination struct {
+	slots   chan struct{}
+	breaker *breaker.Breaker
+}
+
+type permanentError struct {
+	err error
+}
+
+// NewDispatcher method creates new dispatcher instance. Archive is used
+// only when enabled in config and may be nil otherwise.
+func NewDispatcher(config Config, dead DeadLetters, archive Archive) *Dispatcher {
+	setDefault(&config.Workers, defaultWorkers)
+	setDefault(&config.QueueSize, defaultQueueSize)
+	setDefault(&config.PerDestination, defaultPerDestination)
+	setDefault(&config.MaxAttempts, defaultMaxAttempts)
+	setDefault(&config.BaseBackoff, defaultBaseBackoff)
+	setDefault(&config.MaxBackoff, defaultMaxBackoff)
+	setDefault(&config.BreakerThreshold, defaultBreakerThreshold)
+	setDefault(&config.BreakerCooldown, defaultBreakerCooldown)
+	setDefault(&config.Timeout, defaultTimeout)
+
+	if !config.Archive {
+		archive = nil
+	}
+
+	return &Dispatcher{
+		config: config,
+		client: &http.Client{
+			Timeout: time.Duration(config.Timeout) * time.Second,
+		},
+		dead:    dead,
+		archive: archive,
+		queue:   make(chan *Delivery, config.QueueSize),
+		quit:    make(chan struct{}),
+		dests:   make(map[string]*destination),
+	}
+}
+
+// Start method runs delivery workers.
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+
+		go func() {
+			defer d.wg.Done()
+			d.work()
+		}()
+	}
+}
+
+// Stop method stops delivery workers. Queued deliveries are dead-lettered.
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() { close(d.quit) })
+	d.wg.Wait()
+
+	for {
+		select {
+		case delivery := <-d.queue:
+			d.bury(delivery, ErrStopped)
+		default:
+			return
+		}
+	}
+}
+
+// Publish method enqueues event for every subscribed endpoint. It never
+// blocks and returns ErrQueueFull when the dispatcher is saturated.
+func (d *Dispatcher) Publish(eventType string, dedupKey string, data interface{}) error {
+	if d == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	id, err := helpers.RandomStr(32)
+
+	if err != nil {
+		return err
+	}
+
+	event := Event{
+		ID:        id,
+		DedupKey:  dedupKey,
+		Type:      eventType,
+		CreatedAt: time.Now(),
+		Data:      raw,
+	}
+
+	for _, endpoint := range d.config.Endpoints {
+		if !endpoint.subscribed(eventType) {
+			continue
+		}
+
+		select {
+		case d.queue <- &Delivery{Endpoint: endpoint, Event: event}:
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) work() {
+	for {
+		select {
+		case <-d.quit:
+			return
+		case delivery := <-d.queue:
+			d.handle(delivery)
+		}
+	}
+}
+
+func (d *Dispatcher) handle(delivery *Delivery) {
+	dest := d.destination(delivery.Endpoint.URL)
+
+	allowed, retryAt := dest.breaker.Allow(time.Now())
+
+	if !allowed {
+		if min := time.Now().Add(busyRetryDelay); retryAt.Before(min) {
+			retryAt = min
+		}
+
+		d.retryAt(delivery, retryAt)
+		return
+	}
+
+	select {
+	case dest.slots <- struct{}{}:
+	default:
+		// Destination is at its concurrency cap, let other
+		// destinations make progress in the meantime.
+		d.retryAt(delivery, time.Now().Add(busyRetryDelay))
+		return
+	}
+
+	delivery.Attempt++
+	err := d.deliver(delivery)
+
+	<-dest.slots
+
+	if err == nil {
+		dest.breaker.Success()
+		d.store(delivery, nil)
+		return
+	}
+
+	dest.breaker.Failure(time.Now())
+
+	if perr, ok := err.(*permanentError); ok {
+		d.bury(delivery, perr.err)
+		return
+	}
+
+	if delivery.Attempt >= d.config.MaxAttempts {
+		d.bury(delivery, err)
+		return
+	}
+
+	d.retryAt(delivery, time.Now().Add(d.backoff(delivery.Attempt)))
+}
+
+func (d *Dispatcher) deliver(delivery *Delivery) error {
+	body, err := json.Marshal(delivery.Event)
+
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost, delivery.Endpoint.URL, bytes.NewReader(body),
+	)
+
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event.Type)
+	req.Header.Set(DeliveryHeader, delivery.Event.ID)
+	req.Header.Set(DedupKeyHeader, delivery.Event.DedupKey)
+
+	if delivery.Endpoint.Secret != "" {
+		req.Header.Set(SignatureHeader, sign(delivery.Endpoint.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500:
+		return fmt.Errorf("webhook endpoint responded %d", resp.StatusCode)
+	default:
+		return &permanentError{
+			err: fmt.Errorf("webhook endpoint rejected %d", resp.StatusCode),
+		}
+	}
+}
+
+func (d *Dispatcher) retryAt(delivery *Delivery, at time.Time) {
+	time.AfterFunc(time.Until(at), func() {
+		select {
+		case d.queue <- delivery:
+		case <-d.quit:
+			d.bury(delivery, ErrStopped)
+		}
+	})
+}
+
+func (d *Dispatcher) bury(delivery *Delivery, reason error) {
+	d.store(delivery, reason)
+
+	if d.dead == nil {
+		log.Printf("webhook %s to %s dropped: %v",
+			delivery.Event.ID, delivery.Endpoint.URL, reason)
+		return
+	}
+
+	err := d.dead.Put(context.Background(), delivery, reason)
+
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// store method archives delivery outcome, failure is logged only.
+func (d *Dispatcher) store(delivery *Delivery, reason error) {
+	if d.archive == nil {
+		return
+	}
+
+	record := archived{
+		Event:      delivery.Event,
+		URL:        delivery.Endpoint.URL,
+		Attempts:   delivery.Attempt,
+		Delivered:  reason == nil,
+		ArchivedAt: time.Now(),
+	}
+
+	if reason != nil {
+		record.Error = reason.Error()
+	}
+
+	body, err := json.Marshal(record)
+
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(delivery.Endpoint.URL))
+	key := fmt.Sprintf("%s/%s-%s.json",
+		record.ArchivedAt.UTC().Format("2006/01/02"), delivery.Event.ID,
+		hex.EncodeToString(sum[:4]))
+
+	ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+	defer cancel()
+
+	err = d.archive.Put(ctx, key, bytes.NewReader(body), int64(len(body)))
+
+	if err != nil {
+		log.Printf("webhook %s archive: %v", delivery.Event.ID, err)
+	}
+}
+
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	base := time.Duration(d.config.BaseBackoff) * time.Second
+	max := time.Duration(d.config.MaxBackoff) * time.Second
+	backoff := max
+
+	if attempt < 32 && base<<uint(attempt-1) < max {
+		backoff = base << uint(attempt-1)
+	}
+
+	half := backoff / 2
+
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (d *Dispatcher) destination(url string) *destination {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dest, ok := d.dests[url]
+
+	if !ok {
+		dest = &destination{
+			slots: make(chan struct{}, d.config.PerDestination),
+			breaker: breaker.New(
+				d.config.BreakerThreshold,
+				time.Duration(d.config.BreakerCooldown)*time.Second,
+			),
+		}
+
+		d.dests[url] = dest
+	}
+
+	return dest
+}
+
+func (e Endpoint) subscribed(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// DedupKey method derives deduplication key from state transition parts.
+func DedupKey(eventType string, parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(eventType))
+
+	for _, part := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(part))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func setDefault(v *int, def int) {
+	if *v <= 0 {
+		*v = def
+	}
+}